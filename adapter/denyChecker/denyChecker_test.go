@@ -20,6 +20,7 @@ import (
 	rpc "github.com/googleapis/googleapis/google/rpc"
 
 	"istio.io/mixer/adapter/denyChecker/config"
+	"istio.io/mixer/pkg/adapter/conformance"
 	"istio.io/mixer/pkg/adapter/test"
 )
 
@@ -62,3 +63,7 @@ func TestAll(t *testing.T) {
 func TestInvariants(t *testing.T) {
 	test.AdapterInvariants(Register, t)
 }
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{})
+}
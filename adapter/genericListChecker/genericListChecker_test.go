@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"istio.io/mixer/adapter/genericListChecker/config"
+	"istio.io/mixer/pkg/adapter/conformance"
 	"istio.io/mixer/pkg/adapter/test"
 )
 
@@ -85,3 +86,7 @@ func TestAll(t *testing.T) {
 func TestInvariants(t *testing.T) {
 	test.AdapterInvariants(Register, t)
 }
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{})
+}
@@ -0,0 +1,266 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package geoIP provides an attributes generator that enriches requests with
+// geographical and network information looked up in a local MaxMind DB file.
+package geoIP
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ptypes "github.com/gogo/protobuf/types"
+
+	"istio.io/mixer/adapter/geoIP/config"
+	"istio.io/mixer/pkg/adapter"
+)
+
+type (
+	builder struct{ adapter.DefaultBuilder }
+
+	generator struct {
+		log           adapter.Logger
+		path          string
+		language      string
+		atomicDB      atomic.Value
+		closing       chan bool
+		closeOnce     sync.Once
+		refreshTicker *time.Ticker
+	}
+
+	dbState struct {
+		db      *mmdb
+		size    int64
+		modTime time.Time
+	}
+)
+
+// Names of the generator's input and outputs.
+const (
+	ipAddressInput = "ip_address"
+
+	countryOutput        = "country"
+	countryNameOutput    = "country_name"
+	regionOutput         = "region"
+	regionNameOutput     = "region_name"
+	cityOutput           = "city"
+	asnOutput            = "asn"
+	asOrganizationOutput = "as_organization"
+)
+
+var (
+	name = "geoIP"
+	desc = "Generates country, region, city and ASN attributes for an IP address using a MaxMind DB file."
+	conf = &config.Params{
+		DatabasePath:    "GeoLite2-City.mmdb",
+		RefreshInterval: &ptypes.Duration{Seconds: 60},
+		Language:        "en",
+	}
+)
+
+// Register records the builders exposed by this adapter.
+func Register(r adapter.Registrar) {
+	r.RegisterAttributesGeneratorBuilder(newBuilder())
+}
+
+func newBuilder() builder {
+	return builder{adapter.NewDefaultBuilder(name, desc, conf)}
+}
+
+func (builder) ValidateConfig(cfg adapter.Config) (ce *adapter.ConfigErrors) {
+	c := cfg.(*config.Params)
+
+	if c.DatabasePath == "" {
+		ce = ce.Appendf("DatabasePath", "database path must be specified")
+	}
+
+	refresh, err := ptypes.DurationFromProto(c.RefreshInterval)
+	if err != nil {
+		ce = ce.Append("RefreshInterval", err)
+	} else if refresh < time.Second {
+		ce = ce.Appendf("RefreshInterval", "refresh interval must be at least 1 second, it is %v", refresh)
+	}
+
+	return
+}
+
+func (builder) NewAttributesGenerator(env adapter.Env, c adapter.Config) (adapter.AttributesGenerator, error) {
+	cfg := c.(*config.Params)
+	refresh, _ := ptypes.DurationFromProto(cfg.RefreshInterval)
+	return newGenerator(env, cfg, time.NewTicker(refresh))
+}
+
+func newGenerator(env adapter.Env, c *config.Params, refreshTicker *time.Ticker) (*generator, error) {
+	g := &generator{
+		log:           env.Logger(),
+		path:          c.DatabasePath,
+		language:      c.Language,
+		closing:       make(chan bool),
+		refreshTicker: refreshTicker,
+	}
+	if g.language == "" {
+		g.language = "en"
+	}
+
+	// Load the database synchronously so we're ready to serve lookups immediately.
+	ds, err := g.load(dbState{})
+	if err != nil {
+		refreshTicker.Stop()
+		return nil, err
+	}
+	g.atomicDB.Store(ds)
+
+	// goroutine to periodically reload the database when it changes
+	env.ScheduleDaemon(g.refresher)
+
+	return g, nil
+}
+
+func (g *generator) Close() error {
+	g.closeOnce.Do(func() {
+		close(g.closing)
+		g.refreshTicker.Stop()
+	})
+	return nil
+}
+
+func (g *generator) Generate(inputs map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+
+	v, found := inputs[ipAddressInput]
+	if !found {
+		return out, nil
+	}
+
+	var ip net.IP
+	switch t := v.(type) {
+	case string:
+		ip = net.ParseIP(t)
+	case []byte:
+		if len(t) == net.IPv4len || len(t) == net.IPv6len {
+			ip = net.IP(t)
+		}
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("%v is not a valid IP address", v)
+	}
+
+	rec, err := g.getDBState().db.lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := rec.(map[string]interface{}); ok {
+		g.extract(r, out)
+	}
+	return out, nil
+}
+
+// extract copies the interesting bits of a GeoIP2/GeoLite2 record into out.
+func (g *generator) extract(rec map[string]interface{}, out map[string]interface{}) {
+	if country, ok := rec["country"].(map[string]interface{}); ok {
+		setString(out, countryOutput, country["iso_code"])
+		setString(out, countryNameOutput, g.localName(country))
+	}
+
+	// subdivisions are ordered from most general to most specific
+	if subs, ok := rec["subdivisions"].([]interface{}); ok && len(subs) > 0 {
+		if sub, ok := subs[len(subs)-1].(map[string]interface{}); ok {
+			setString(out, regionOutput, sub["iso_code"])
+			setString(out, regionNameOutput, g.localName(sub))
+		}
+	}
+
+	if city, ok := rec["city"].(map[string]interface{}); ok {
+		setString(out, cityOutput, g.localName(city))
+	}
+
+	switch asn := rec["autonomous_system_number"].(type) {
+	case uint32:
+		out[asnOutput] = int64(asn)
+	case uint64:
+		out[asnOutput] = int64(asn)
+	case uint16:
+		out[asnOutput] = int64(asn)
+	}
+	setString(out, asOrganizationOutput, rec["autonomous_system_organization"])
+}
+
+func (g *generator) localName(entity map[string]interface{}) interface{} {
+	if names, ok := entity["names"].(map[string]interface{}); ok {
+		return names[g.language]
+	}
+	return nil
+}
+
+func setString(out map[string]interface{}, key string, v interface{}) {
+	if s, ok := v.(string); ok && s != "" {
+		out[key] = s
+	}
+}
+
+// Typed accessor for the atomic database state
+func (g *generator) getDBState() dbState {
+	return g.atomicDB.Load().(dbState)
+}
+
+// Reloads the database on a fixed interval whenever the file changes
+func (g *generator) refresher() {
+	for {
+		select {
+		case <-g.refreshTicker.C:
+			g.refresh()
+
+		case <-g.closing:
+			return
+		}
+	}
+}
+
+func (g *generator) refresh() {
+	ds, err := g.load(g.getDBState())
+	if err != nil {
+		// keep serving from the database we already have
+		_ = g.log.Errorf("Could not reload %s: %v", g.path, err)
+		return
+	}
+	g.atomicDB.Store(ds)
+}
+
+// load reads and parses the database file, unless it is unchanged since current was loaded.
+func (g *generator) load(current dbState) (dbState, error) {
+	fi, err := os.Stat(g.path)
+	if err != nil {
+		return current, err
+	}
+	if current.db != nil && fi.Size() == current.size && fi.ModTime().Equal(current.modTime) {
+		return current, nil
+	}
+
+	buf, err := ioutil.ReadFile(g.path)
+	if err != nil {
+		return current, err
+	}
+	db, err := openMMDB(buf)
+	if err != nil {
+		return current, fmt.Errorf("could not parse %s: %v", g.path, err)
+	}
+
+	g.log.Infof("Loaded %s database from %s", db.dbType, g.path)
+	return dbState{db: db, size: fi.Size(), modTime: fi.ModTime()}, nil
+}
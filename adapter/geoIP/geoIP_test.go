@@ -0,0 +1,221 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoIP
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	ptypes "github.com/gogo/protobuf/types"
+
+	"istio.io/mixer/adapter/geoIP/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
+	"istio.io/mixer/pkg/adapter/test"
+)
+
+var cityRecord = map[string]interface{}{
+	"country": map[string]interface{}{
+		"iso_code": "CA",
+		"names":    map[string]interface{}{"en": "Canada", "fr": "Canada"},
+	},
+	"subdivisions": []interface{}{
+		map[string]interface{}{
+			"iso_code": "QC",
+			"names":    map[string]interface{}{"en": "Quebec", "fr": "Québec"},
+		},
+	},
+	"city": map[string]interface{}{
+		"names": map[string]interface{}{"en": "Montreal", "fr": "Montréal"},
+	},
+}
+
+var asnRecord = map[string]interface{}{
+	"autonomous_system_number":       uint32(64512),
+	"autonomous_system_organization": "Example Networks",
+}
+
+func writeDB(t *testing.T, file string, fixtures []fixture, modTime time.Time) {
+	if err := ioutil.WriteFile(file, writeMMDB(t, fixtures), 0644); err != nil {
+		t.Fatalf("Unable to write database: %v", err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatalf("Unable to set database time: %v", err)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoIP")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := path.Join(dir, "test.mmdb")
+	start := time.Now().Add(-time.Hour)
+	writeDB(t, file, []fixture{{"1.2.3.0/24", cityRecord}, {"5.6.0.0/16", asnRecord}}, start)
+
+	b := newBuilder()
+	cfg := &config.Params{DatabasePath: file, RefreshInterval: &ptypes.Duration{Seconds: 3600}}
+	if ce := b.ValidateConfig(cfg); ce != nil {
+		t.Fatalf("ValidateConfig() failed: %v", ce)
+	}
+
+	a, err := b.NewAttributesGenerator(test.NewEnv(t), cfg)
+	if err != nil {
+		t.Fatalf("Unable to create aspect: %v", err)
+	}
+	g := a.(*generator)
+
+	cases := []struct {
+		input interface{}
+		want  map[string]interface{}
+		fail  bool
+	}{
+		{"1.2.3.4", map[string]interface{}{
+			"country":      "CA",
+			"country_name": "Canada",
+			"region":       "QC",
+			"region_name":  "Quebec",
+			"city":         "Montreal",
+		}, false},
+		{[]byte{5, 6, 7, 8}, map[string]interface{}{
+			"asn":             int64(64512),
+			"as_organization": "Example Networks",
+		}, false},
+		{"9.9.9.9", map[string]interface{}{}, false},
+		{nil, map[string]interface{}{}, false},
+		{"XYZ", nil, true},
+		{[]byte{1, 2, 3}, nil, true},
+	}
+
+	for _, c := range cases {
+		inputs := map[string]interface{}{}
+		if c.input != nil {
+			inputs[ipAddressInput] = c.input
+		}
+		out, err := g.Generate(inputs)
+		if (err != nil) != c.fail {
+			t.Errorf("Generate(%v): got err '%v', expected failure %t", c.input, err, c.fail)
+			continue
+		}
+		if !c.fail && !reflect.DeepEqual(out, c.want) {
+			t.Errorf("Generate(%v) = %v, wanted %v", c.input, out, c.want)
+		}
+	}
+
+	// unchanged file, nothing is reloaded
+	before := g.getDBState().db
+	g.refresh()
+	if g.getDBState().db != before {
+		t.Error("refresh() reloaded an unchanged database")
+	}
+
+	// a new database is picked up
+	writeDB(t, file, []fixture{{"1.2.3.0/24", asnRecord}}, start.Add(time.Minute))
+	g.refresh()
+	out, err := g.Generate(map[string]interface{}{ipAddressInput: "1.2.3.4"})
+	if err != nil || out[asnOutput] != int64(64512) || out[countryOutput] != nil {
+		t.Errorf("Generate() after reload = %v, %v; wanted ASN record", out, err)
+	}
+
+	// a broken database is ignored
+	if err = ioutil.WriteFile(file, []byte("JUNK"), 0644); err != nil {
+		t.Fatalf("Unable to write database: %v", err)
+	}
+	g.refresh()
+	if _, err = g.Generate(map[string]interface{}{ipAddressInput: "1.2.3.4"}); err != nil {
+		t.Errorf("Generate() after failed reload failed: %v", err)
+	}
+
+	if err = a.Close(); err != nil {
+		t.Errorf("a.Close failed: %v", err)
+	}
+	if err = b.Close(); err != nil {
+		t.Errorf("b.Close failed: %v", err)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoIP")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := path.Join(dir, "test.mmdb")
+	writeDB(t, file, []fixture{{"1.2.3.0/24", cityRecord}}, time.Now())
+
+	cfg := &config.Params{DatabasePath: file, RefreshInterval: &ptypes.Duration{Seconds: 3600}, Language: "fr"}
+	a, err := newBuilder().NewAttributesGenerator(test.NewEnv(t), cfg)
+	if err != nil {
+		t.Fatalf("Unable to create aspect: %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	out, err := a.Generate(map[string]interface{}{ipAddressInput: "1.2.3.4"})
+	if err != nil || out[cityOutput] != "Montréal" || out[regionNameOutput] != "Québec" {
+		t.Errorf("Generate() = %v, %v; wanted french names", out, err)
+	}
+}
+
+func TestMissingDatabase(t *testing.T) {
+	cfg := &config.Params{DatabasePath: "/does/not/exist.mmdb", RefreshInterval: &ptypes.Duration{Seconds: 1}}
+	if _, err := newBuilder().NewAttributesGenerator(test.NewEnv(t), cfg); err == nil {
+		t.Error("NewAttributesGenerator() succeeded with a missing database, expected failure")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cases := []*config.Params{
+		{DatabasePath: "", RefreshInterval: &ptypes.Duration{Seconds: 1}},
+		{DatabasePath: "x.mmdb", RefreshInterval: &ptypes.Duration{Seconds: 0}},
+		{DatabasePath: "x.mmdb", RefreshInterval: &ptypes.Duration{Seconds: 1, Nanos: -1}},
+		{DatabasePath: "x.mmdb", RefreshInterval: &ptypes.Duration{Nanos: 500000000}},
+	}
+	b := newBuilder()
+	for i, c := range cases {
+		if err := b.ValidateConfig(c); err == nil {
+			t.Errorf("%d: ValidateConfig(%v) succeeded, expected failure", i, c)
+		}
+	}
+}
+
+func TestInvariants(t *testing.T) {
+	test.AdapterInvariants(Register, t)
+}
+
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoIP")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	file := path.Join(dir, "test.mmdb")
+	writeDB(t, file, []fixture{{"1.2.3.0/24", cityRecord}}, time.Now())
+
+	conformance.Run(t, Register, conformance.Options{
+		Configs: map[string]adapter.Config{
+			name: &config.Params{DatabasePath: file, RefreshInterval: &ptypes.Duration{Seconds: 3600}},
+		},
+		InvalidConfigs: map[string][]adapter.Config{
+			name: {&config.Params{DatabasePath: "", RefreshInterval: &ptypes.Duration{Seconds: 1}}},
+		},
+	})
+}
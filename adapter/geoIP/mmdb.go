@@ -0,0 +1,375 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoIP
+
+// A minimal reader for the MaxMind DB file format, as described in
+// http://maxmind.github.io/MaxMind-DB/. Only lookups are supported;
+// the whole database is held in memory.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// the metadata section is always within the last 128KiB of the file.
+const metadataMaxSize = 128 * 1024
+
+// size of the zero-filled separator between the search tree and the data section.
+const dataSectionSeparatorSize = 16
+
+// data section field types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+type (
+	mmdb struct {
+		buf        []byte
+		tree       []byte
+		data       []byte
+		nodeCount  uint
+		recordSize uint
+		ipVersion  uint
+		dbType     string
+
+		// node at which IPv4 lookups start in an IPv6 tree
+		ipv4Start uint
+	}
+
+	decoder struct {
+		buf []byte
+	}
+)
+
+// openMMDB parses the supplied MaxMind DB contents.
+func openMMDB(buf []byte) (*mmdb, error) {
+	start := len(buf) - metadataMaxSize
+	if start < 0 {
+		start = 0
+	}
+	idx := bytes.LastIndex(buf[start:], metadataMarker)
+	if idx < 0 {
+		return nil, errors.New("invalid MaxMind DB: metadata section not found")
+	}
+	metaStart := start + idx + len(metadataMarker)
+
+	md, _, err := decoder{buf[metaStart:]}.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %v", err)
+	}
+	meta, ok := md.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB metadata: not a map")
+	}
+
+	db := &mmdb{buf: buf}
+	if db.nodeCount, err = metaUint(meta, "node_count"); err != nil {
+		return nil, err
+	}
+	if db.recordSize, err = metaUint(meta, "record_size"); err != nil {
+		return nil, err
+	}
+	if db.ipVersion, err = metaUint(meta, "ip_version"); err != nil {
+		return nil, err
+	}
+	db.dbType, _ = meta["database_type"].(string)
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("invalid MaxMind DB: unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("invalid MaxMind DB: unsupported IP version %d", db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	dataStart := treeSize + dataSectionSeparatorSize
+	dataEnd := uint(start + idx)
+	if dataStart > dataEnd {
+		return nil, errors.New("invalid MaxMind DB: search tree exceeds file size")
+	}
+	db.tree = buf[:treeSize]
+	db.data = buf[dataStart:dataEnd]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.readNode(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+func metaUint(meta map[string]interface{}, key string) (uint, error) {
+	switch v := meta[key].(type) {
+	case uint64:
+		return uint(v), nil
+	case uint32:
+		return uint(v), nil
+	case uint16:
+		return uint(v), nil
+	}
+	return 0, fmt.Errorf("invalid MaxMind DB metadata: missing or invalid %s", key)
+}
+
+// lookup returns the record associated with ip, or nil if the database doesn't hold one.
+func (db *mmdb) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := 128
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, fmt.Errorf("cannot look up IPv6 address %s in an IPv4 database", ip)
+	}
+
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = db.readNode(node, bit)
+	}
+
+	if node == db.nodeCount {
+		// empty record
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("invalid MaxMind DB: search tree is deeper than the address")
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparatorSize
+	if offset >= uint(len(db.data)) {
+		return nil, errors.New("invalid MaxMind DB: record points outside the data section")
+	}
+	v, _, err := decoder{db.data}.decode(offset)
+	return v, err
+}
+
+// readNode returns the left (bit 0) or right (bit 1) record of the given node.
+func (db *mmdb) readNode(node uint, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(db.tree[node*8+bit*4:]))
+	}
+}
+
+// maxDepth bounds the nesting of maps, arrays and pointers, so that malformed
+// databases whose pointers loop fail to decode rather than overflow the stack.
+const maxDepth = 512
+
+// decode decodes the field at offset, returning its value and the offset of the next field.
+func (d decoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeAt(offset, 0)
+}
+
+func (d decoder) decodeAt(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("data nested deeper than %d levels", maxDepth)
+	}
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == typePointer {
+		ptr, next, err := d.decodePointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// pointers to pointers are invalid
+		if ptr < uint(len(d.buf)) && uint(d.buf[ptr]>>5) == typePointer {
+			return nil, 0, fmt.Errorf("pointer at %d points to another pointer", offset-1)
+		}
+		v, _, err := d.decodeAt(ptr, depth+1)
+		return v, next, err
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		ext := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			ext = ext<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + ext
+		case 30:
+			size = 285 + ext
+		default:
+			size = 65821 + ext
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decodeAt(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key of type %T, expecting string", k)
+			}
+			var v interface{}
+			if v, offset, err = d.decodeAt(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var v interface{}
+			var err error
+			if v, offset, err = d.decodeAt(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		v := make([]byte, size)
+		copy(v, b)
+		return v, offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid size %d for double", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid size %d for float", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16:
+		return uint16(unsigned(b)), offset, nil
+	case typeUint32:
+		return uint32(unsigned(b)), offset, nil
+	case typeUint64:
+		return unsigned(b), offset, nil
+	case typeInt32:
+		return int32(uint32(unsigned(b))), offset, nil
+	case typeUint128:
+		v := make([]byte, size)
+		copy(v, b)
+		return v, offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+func (d decoder) decodePointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint((ctrl>>3)&0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset : offset+n]
+
+	var ptr uint
+	if n == 4 {
+		ptr = uint(binary.BigEndian.Uint32(b))
+	} else {
+		ptr = uint(ctrl & 0x7)
+		for _, v := range b {
+			ptr = ptr<<8 | uint(v)
+		}
+	}
+
+	switch n {
+	case 2:
+		ptr += 2048
+	case 3:
+		ptr += 526336
+	}
+	return ptr, offset + n, nil
+}
+
+func unsigned(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
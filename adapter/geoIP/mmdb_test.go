@@ -0,0 +1,292 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoIP
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fixture describes a network and the record associated with it in a generated database.
+type fixture struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+// writeMMDB generates an IPv4 MaxMind DB with 24 bit records holding the given fixtures.
+func writeMMDB(t *testing.T, fixtures []fixture) []byte {
+	const empty = -1
+
+	// each node holds two records, either the index of a child node, empty or a data offset (data + node count)
+	type node struct {
+		rec  [2]int
+		data [2]bool
+	}
+	nodes := []*node{{rec: [2]int{empty, empty}}}
+
+	var data bytes.Buffer
+	for _, f := range fixtures {
+		_, ipnet, err := net.ParseCIDR(f.cidr)
+		if err != nil {
+			t.Fatalf("invalid fixture network %s: %v", f.cidr, err)
+		}
+		ip := ipnet.IP.To4()
+		ones, _ := ipnet.Mask.Size()
+
+		offset := data.Len()
+		encodeField(&data, f.record)
+
+		n := 0
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[n].rec[bit] = offset
+				nodes[n].data[bit] = true
+				break
+			}
+			if nodes[n].rec[bit] == empty {
+				nodes = append(nodes, &node{rec: [2]int{empty, empty}})
+				nodes[n].rec[bit] = len(nodes) - 1
+			}
+			n = nodes[n].rec[bit]
+		}
+	}
+
+	var out bytes.Buffer
+	count := len(nodes)
+	for _, n := range nodes {
+		for i, r := range n.rec {
+			v := count
+			if n.data[i] {
+				v = count + dataSectionSeparatorSize + r
+			} else if r != empty {
+				v = r
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	out.Write(make([]byte, dataSectionSeparatorSize))
+	out.Write(data.Bytes())
+	out.Write(metadataMarker)
+	encodeField(&out, map[string]interface{}{
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(4),
+		"database_type":               "Istio-Test",
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+	})
+	return out.Bytes()
+}
+
+func encodeControl(buf *bytes.Buffer, typ int, size int) {
+	var ext []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		ext = []byte{byte(size - 29)}
+		size = 29
+	default:
+		v := size - 285
+		ext = []byte{byte(v >> 8), byte(v)}
+		size = 30
+	}
+
+	if typ > 7 {
+		buf.WriteByte(byte(size))
+		buf.WriteByte(byte(typ - 7))
+	} else {
+		buf.WriteByte(byte(typ<<5 | size))
+	}
+	buf.Write(ext)
+}
+
+func encodeField(buf *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case string:
+		encodeControl(buf, typeString, len(t))
+		buf.WriteString(t)
+	case float64:
+		encodeControl(buf, typeDouble, 8)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(t))
+	case bool:
+		s := 0
+		if t {
+			s = 1
+		}
+		encodeControl(buf, typeBool, s)
+	case uint16:
+		encodeControl(buf, typeUint16, 2)
+		_ = binary.Write(buf, binary.BigEndian, t)
+	case uint32:
+		encodeControl(buf, typeUint32, 4)
+		_ = binary.Write(buf, binary.BigEndian, t)
+	case uint64:
+		encodeControl(buf, typeUint64, 8)
+		_ = binary.Write(buf, binary.BigEndian, t)
+	case int32:
+		encodeControl(buf, typeInt32, 4)
+		_ = binary.Write(buf, binary.BigEndian, t)
+	case []interface{}:
+		encodeControl(buf, typeArray, len(t))
+		for _, e := range t {
+			encodeField(buf, e)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		encodeControl(buf, typeMap, len(t))
+		for _, k := range keys {
+			encodeField(buf, k)
+			encodeField(buf, t[k])
+		}
+	default:
+		panic(fmt.Sprintf("unsupported fixture type %T", v))
+	}
+}
+
+func TestLookup(t *testing.T) {
+	rec1 := map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "US"},
+		"score":   float64(1.5),
+		"tags":    []interface{}{"a", true, int32(-3)},
+	}
+	rec2 := map[string]interface{}{
+		"autonomous_system_number": uint32(15169),
+		"big":                      uint64(1 << 40),
+	}
+	buf := writeMMDB(t, []fixture{
+		{"10.0.0.0/8", rec1},
+		{"192.168.1.0/24", rec2},
+	})
+
+	db, err := openMMDB(buf)
+	if err != nil {
+		t.Fatalf("openMMDB() failed: %v", err)
+	}
+	if db.dbType != "Istio-Test" {
+		t.Errorf("database type = %s, wanted Istio-Test", db.dbType)
+	}
+
+	cases := []struct {
+		ip   string
+		want interface{}
+	}{
+		{"10.1.2.3", rec1},
+		{"10.255.255.255", rec1},
+		{"192.168.1.77", rec2},
+		{"192.168.2.1", nil},
+		{"11.0.0.1", nil},
+		{"::ffff:10.0.0.1", rec1},
+	}
+	for _, c := range cases {
+		got, err := db.lookup(net.ParseIP(c.ip))
+		if err != nil {
+			t.Errorf("lookup(%s) failed: %v", c.ip, err)
+			continue
+		}
+		if c.want == nil {
+			if got != nil {
+				t.Errorf("lookup(%s) = %v, wanted nil", c.ip, got)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("lookup(%s) = %v, wanted %v", c.ip, got, c.want)
+		}
+	}
+
+	if _, err = db.lookup(net.ParseIP("2001:db8::1")); err == nil {
+		t.Error("lookup(IPv6) in an IPv4 database succeeded, wanted error")
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	cases := [][]byte{
+		nil,
+		[]byte("not a database"),
+		append(append([]byte{}, metadataMarker...), 0xff),
+	}
+	for i, c := range cases {
+		if _, err := openMMDB(c); err == nil {
+			t.Errorf("%d: openMMDB() succeeded, wanted error", i)
+		}
+	}
+}
+
+func TestDecodeLongString(t *testing.T) {
+	for _, n := range []int{28, 29, 300, 70000} {
+		s := string(bytes.Repeat([]byte{'x'}, n))
+		var buf bytes.Buffer
+		if n >= 65821 {
+			// three byte extended size
+			buf.WriteByte(byte(typeString<<5 | 31))
+			v := n - 65821
+			buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+			buf.WriteString(s)
+		} else {
+			encodeField(&buf, s)
+		}
+		got, _, err := decoder{buf.Bytes()}.decode(0)
+		if err != nil || got != s {
+			t.Errorf("decode(string of %d) = %d chars, %v", n, len(fmt.Sprint(got)), err)
+		}
+	}
+}
+
+func TestDecodePointer(t *testing.T) {
+	var buf bytes.Buffer
+	encodeField(&buf, "hello")
+	// map with a single key whose value is a pointer back to offset 0
+	buf.WriteByte(byte(typeMap<<5 | 1))
+	encodeField(&buf, "k")
+	buf.Write([]byte{byte(typePointer << 5), 0})
+
+	got, _, err := decoder{buf.Bytes()}.decode(6)
+	if err != nil {
+		t.Fatalf("decode() failed: %v", err)
+	}
+	if want := map[string]interface{}{"k": "hello"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decode() = %v, wanted %v", got, want)
+	}
+}
+
+func TestDecodePointerLoop(t *testing.T) {
+	// pointer to a pointer
+	var ptrs bytes.Buffer
+	ptrs.Write([]byte{byte(typePointer << 5), 2})
+	ptrs.Write([]byte{byte(typePointer << 5), 0})
+
+	// map with a single key whose value is a pointer back to the map
+	var loop bytes.Buffer
+	loop.WriteByte(byte(typeMap<<5 | 1))
+	encodeField(&loop, "k")
+	loop.Write([]byte{byte(typePointer << 5), 0})
+
+	for idx, buf := range [][]byte{ptrs.Bytes(), loop.Bytes()} {
+		if got, _, err := (decoder{buf}).decode(0); err == nil {
+			t.Errorf("[%d] decode() = %v; wanted an error", idx, got)
+		}
+	}
+}
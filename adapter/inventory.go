@@ -17,11 +17,15 @@ package adapter
 import (
 	"istio.io/mixer/adapter/denyChecker"
 	"istio.io/mixer/adapter/genericListChecker"
+	"istio.io/mixer/adapter/geoIP"
 	"istio.io/mixer/adapter/ipListChecker"
 	"istio.io/mixer/adapter/memQuota"
+	"istio.io/mixer/adapter/policyChecker"
 	"istio.io/mixer/adapter/prometheus"
+	"istio.io/mixer/adapter/remote"
 	"istio.io/mixer/adapter/statsd"
 	"istio.io/mixer/adapter/stdioLogger"
+	"istio.io/mixer/adapter/zipkin"
 	"istio.io/mixer/pkg/adapter"
 )
 
@@ -30,10 +34,14 @@ func Inventory() []adapter.RegisterFn {
 	return []adapter.RegisterFn{
 		denyChecker.Register,
 		genericListChecker.Register,
+		geoIP.Register,
 		ipListChecker.Register,
 		memQuota.Register,
+		policyChecker.Register,
 		prometheus.Register,
+		remote.Register,
 		statsd.Register,
 		stdioLogger.Register,
+		zipkin.Register,
 	}
 }
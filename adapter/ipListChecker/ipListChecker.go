@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -42,6 +43,7 @@ type (
 		atomicList    atomic.Value
 		client        http.Client
 		closing       chan bool
+		closeOnce     sync.Once
 		refreshTicker *time.Ticker
 		purgeTimer    *time.Timer
 		ttl           time.Duration
@@ -138,9 +140,11 @@ func newListCheckerWithTimers(env adapter.Env, c *config.Params,
 }
 
 func (l *listChecker) Close() error {
-	close(l.closing)
-	l.refreshTicker.Stop()
-	l.purgeTimer.Stop()
+	l.closeOnce.Do(func() {
+		close(l.closing)
+		l.refreshTicker.Stop()
+		l.purgeTimer.Stop()
+	})
 	return nil
 }
 
@@ -26,6 +26,7 @@ import (
 
 	"istio.io/mixer/adapter/ipListChecker/config"
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
 	"istio.io/mixer/pkg/adapter/test"
 )
 
@@ -331,6 +332,16 @@ func TestInvariants(t *testing.T) {
 	test.AdapterInvariants(Register, t)
 }
 
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{
+		InvalidConfigs: map[string][]adapter.Config{
+			name: {&config.Params{ProviderUrl: "Foo", RefreshInterval: toDuration(1), Ttl: toDuration(2)}},
+		},
+		// the list is fetched with keep-alive connections
+		IgnoreGoroutines: []string{"net/http.(*persistConn)"},
+	})
+}
+
 func toDuration(seconds int32) *ptypes.Duration {
 	return ptypes.DurationProto(time.Duration(seconds) * time.Second)
 }
@@ -58,6 +58,10 @@ type memQuota struct {
 	// used for reaping dedup ids
 	ticker *time.Ticker
 
+	// stops the reaping of dedup ids
+	closing   chan struct{}
+	closeOnce sync.Once
+
 	// indirection to support fast deterministic tests
 	getTime func() time.Time
 
@@ -135,15 +139,21 @@ func newAspectWithDedup(env adapter.Env, ticker *time.Ticker) (adapter.QuotasAsp
 		recentDedup: make(map[string]dedupState),
 		oldDedup:    make(map[string]dedupState),
 		ticker:      ticker,
+		closing:     make(chan struct{}),
 		getTime:     time.Now,
 		logger:      env.Logger(),
 	}
 
 	env.ScheduleDaemon(func() {
-		for range mq.ticker.C {
-			mq.Lock()
-			mq.reapDedup()
-			mq.Unlock()
+		for {
+			select {
+			case <-mq.ticker.C:
+				mq.Lock()
+				mq.reapDedup()
+				mq.Unlock()
+			case <-mq.closing:
+				return
+			}
 		}
 	})
 
@@ -151,7 +161,10 @@ func newAspectWithDedup(env adapter.Env, ticker *time.Ticker) (adapter.QuotasAsp
 }
 
 func (mq *memQuota) Close() error {
-	mq.ticker.Stop()
+	mq.closeOnce.Do(func() {
+		mq.ticker.Stop()
+		close(mq.closing)
+	})
 	return nil
 }
 
@@ -23,6 +23,7 @@ import (
 
 	"istio.io/mixer/adapter/memQuota/config"
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
 	"istio.io/mixer/pkg/adapter/test"
 )
 
@@ -370,3 +371,9 @@ func TestReaperTicker(t *testing.T) {
 func TestInvariants(t *testing.T) {
 	test.AdapterInvariants(Register, t)
 }
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{
+		InvalidConfigs: map[string][]adapter.Config{name: {&config.Params{MinDeduplicationDuration: &ptypes.Duration{}}}},
+	})
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policyChecker provides an implementation of the mixer authorization
+// aspect which evaluates an ordered list of allow/deny rules held in memory.
+package policyChecker
+
+import (
+	"fmt"
+	"strings"
+
+	"istio.io/mixer/adapter/policyChecker/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/expr"
+)
+
+type (
+	builder struct{ adapter.DefaultBuilder }
+
+	policy struct {
+		rules         []*config.Params_Rule
+		defaultEffect config.Params_Effect
+	}
+)
+
+var (
+	name = "policyChecker"
+	desc = "Authorizes requests against an ordered list of allow and deny rules"
+	conf = &config.Params{DefaultEffect: config.DENY}
+)
+
+// Register records the builders exposed by this adapter.
+func Register(r adapter.Registrar) {
+	r.RegisterAuthorizationBuilder(newBuilder())
+}
+
+func newBuilder() builder {
+	return builder{adapter.NewDefaultBuilder(name, desc, conf)}
+}
+
+func (builder) ValidateConfig(c adapter.Config) (ce *adapter.ConfigErrors) {
+	params := c.(*config.Params)
+
+	names := make(map[string]bool, len(params.Rules))
+	for i, r := range params.Rules {
+		field := fmt.Sprintf("Rules[%d]", i)
+
+		if r.Name == "" {
+			ce = ce.Appendf(field+".Name", "a rule name must be provided")
+		} else if names[r.Name] {
+			ce = ce.Appendf(field+".Name", "duplicate rule name '%s'", r.Name)
+		}
+		names[r.Name] = true
+
+		if len(r.Subjects) == 0 {
+			ce = ce.Appendf(field+".Subjects", "at least one pattern must be provided, use '*' to match any subject")
+		}
+		if len(r.Actions) == 0 {
+			ce = ce.Appendf(field+".Actions", "at least one pattern must be provided, use '*' to match any action")
+		}
+		if len(r.Resources) == 0 {
+			ce = ce.Appendf(field+".Resources", "at least one pattern must be provided, use '*' to match any resource")
+		}
+		if r.Condition != "" {
+			if _, err := expr.Parse(r.Condition); err != nil {
+				ce = ce.Appendf(field+".Condition", "invalid condition '%s': %v", r.Condition, err)
+			}
+		}
+		if _, found := config.Params_Effect_name[int32(r.Effect)]; !found {
+			ce = ce.Appendf(field+".Effect", "unknown effect %d", r.Effect)
+		}
+	}
+
+	if _, found := config.Params_Effect_name[int32(params.DefaultEffect)]; !found {
+		ce = ce.Appendf("DefaultEffect", "unknown effect %d", params.DefaultEffect)
+	}
+	return
+}
+
+func (builder) NewAuthorizationAspect(env adapter.Env, c adapter.Config) (adapter.AuthorizationAspect, error) {
+	params := c.(*config.Params)
+	return &policy{rules: params.Rules, defaultEffect: params.DefaultEffect}, nil
+}
+
+// Authorize returns the decision of the first rule applying to the request.
+func (p *policy) Authorize(req adapter.AuthorizationRequest, eval adapter.ConditionEvaluator) (adapter.AuthorizationDecision, error) {
+	for _, r := range p.rules {
+		if !matchAny(r.Subjects, req.Subject) || !matchAny(r.Actions, req.Action) || !matchAny(r.Resources, req.Resource) {
+			continue
+		}
+
+		if r.Condition != "" {
+			ok, err := eval(r.Condition)
+			if err != nil {
+				return adapter.AuthorizationDecision{}, fmt.Errorf("unable to evaluate the condition of rule '%s': %v", r.Name, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		return adapter.AuthorizationDecision{Allowed: r.Effect == config.ALLOW, Rule: r.Name}, nil
+	}
+
+	return adapter.AuthorizationDecision{Allowed: p.defaultEffect == config.ALLOW}, nil
+}
+
+func (p *policy) Close() error { return nil }
+
+// matchAny returns whether value matches any of the patterns.
+func matchAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if p == value || p == "*" {
+			return true
+		}
+		if strings.HasSuffix(p, "*") && strings.HasPrefix(value, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policyChecker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/adapter/policyChecker/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
+	"istio.io/mixer/pkg/adapter/test"
+)
+
+func TestInvariants(t *testing.T) {
+	test.AdapterInvariants(Register, t)
+}
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{
+		InvalidConfigs: map[string][]adapter.Config{name: {&config.Params{DefaultEffect: 7}}},
+	})
+}
+
+func rule(name string, effect config.Params_Effect, subject, action, resource string) *config.Params_Rule {
+	return &config.Params_Rule{
+		Name:      name,
+		Subjects:  []string{subject},
+		Actions:   []string{action},
+		Resources: []string{resource},
+		Effect:    effect,
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	withCondition := rule("r", config.ALLOW, "*", "*", "*")
+	withCondition.Condition = `source.name == "alice"`
+	badCondition := rule("r", config.ALLOW, "*", "*", "*")
+	badCondition.Condition = "source.name =="
+	noPatterns := &config.Params_Rule{Name: "r"}
+
+	cases := []struct {
+		conf      *config.Params
+		errString string
+	}{
+		{&config.Params{}, ""},
+		{&config.Params{Rules: []*config.Params_Rule{withCondition}}, ""},
+		{&config.Params{Rules: []*config.Params_Rule{rule("", config.ALLOW, "*", "*", "*")}}, "Rules[0].Name"},
+		{&config.Params{Rules: []*config.Params_Rule{withCondition, withCondition}}, "Rules[1].Name: duplicate rule name 'r'"},
+		{&config.Params{Rules: []*config.Params_Rule{noPatterns}}, "Rules[0].Subjects"},
+		{&config.Params{Rules: []*config.Params_Rule{noPatterns}}, "Rules[0].Actions"},
+		{&config.Params{Rules: []*config.Params_Rule{noPatterns}}, "Rules[0].Resources"},
+		{&config.Params{Rules: []*config.Params_Rule{badCondition}}, "Rules[0].Condition"},
+		{&config.Params{Rules: []*config.Params_Rule{rule("r", 7, "*", "*", "*")}}, "Rules[0].Effect"},
+		{&config.Params{DefaultEffect: 7}, "DefaultEffect"},
+	}
+
+	b := newBuilder()
+	for idx, c := range cases {
+		errString := ""
+		if err := b.ValidateConfig(c.conf); err != nil {
+			errString = err.Error()
+		}
+		if (c.errString == "") != (errString == "") || !strings.Contains(errString, c.errString) {
+			t.Errorf("[%d] b.ValidateConfig() = '%s'; want errString containing '%s'", idx, errString, c.errString)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	conditional := rule("weekday-writers", config.ALLOW, "writer-*", "POST", "/books*")
+	conditional.Condition = "weekday"
+
+	params := &config.Params{
+		Rules: []*config.Params_Rule{
+			rule("banned", config.DENY, "mallory", "*", "*"),
+			rule("admins", config.ALLOW, "admin", "*", "*"),
+			rule("readers", config.ALLOW, "*", "GET", "/books*"),
+			conditional,
+		},
+	}
+
+	asp, err := newBuilder().NewAuthorizationAspect(test.NewEnv(t), params)
+	if err != nil {
+		t.Fatalf("NewAuthorizationAspect() = _, %v; wanted no err", err)
+	}
+	defer func() { _ = asp.Close() }()
+
+	cases := []struct {
+		req     adapter.AuthorizationRequest
+		weekday bool
+		allowed bool
+		rule    string
+	}{
+		{adapter.AuthorizationRequest{Subject: "mallory", Action: "GET", Resource: "/books"}, true, false, "banned"},
+		{adapter.AuthorizationRequest{Subject: "admin", Action: "DELETE", Resource: "/shelves"}, true, true, "admins"},
+		{adapter.AuthorizationRequest{Subject: "alice", Action: "GET", Resource: "/books/1"}, true, true, "readers"},
+		{adapter.AuthorizationRequest{Subject: "alice", Action: "GET", Resource: "/shelves"}, true, false, ""},
+		{adapter.AuthorizationRequest{Subject: "writer-bob", Action: "POST", Resource: "/books"}, true, true, "weekday-writers"},
+		{adapter.AuthorizationRequest{Subject: "writer-bob", Action: "POST", Resource: "/books"}, false, false, ""},
+		{adapter.AuthorizationRequest{Subject: "bob", Action: "POST", Resource: "/books"}, true, false, ""},
+	}
+
+	for idx, c := range cases {
+		evaluated := ""
+		eval := func(condition string) (bool, error) {
+			evaluated = condition
+			return c.weekday, nil
+		}
+
+		d, err := asp.Authorize(c.req, eval)
+		if err != nil {
+			t.Errorf("[%d] Authorize() = _, %v; wanted no err", idx, err)
+			continue
+		}
+		if d.Allowed != c.allowed || d.Rule != c.rule {
+			t.Errorf("[%d] Authorize() = %v; wanted allowed %t by rule '%s'", idx, d, c.allowed, c.rule)
+		}
+		if evaluated != "" && c.req.Subject != "writer-bob" {
+			t.Errorf("[%d] condition '%s' evaluated for a request the rule doesn't match", idx, evaluated)
+		}
+	}
+
+	failing := func(string) (bool, error) { return false, errors.New("unknown attribute") }
+	req := adapter.AuthorizationRequest{Subject: "writer-bob", Action: "POST", Resource: "/books"}
+	if _, err := asp.Authorize(req, failing); err == nil || !strings.Contains(err.Error(), "weekday-writers") {
+		t.Errorf("Authorize() = _, %v; wanted err naming the rule", err)
+	}
+}
+
+func TestAuthorize_DefaultAllow(t *testing.T) {
+	params := &config.Params{
+		Rules:         []*config.Params_Rule{rule("no-deletes", config.DENY, "*", "DELETE", "*")},
+		DefaultEffect: config.ALLOW,
+	}
+	asp, _ := newBuilder().NewAuthorizationAspect(test.NewEnv(t), params)
+
+	never := func(string) (bool, error) { return false, nil }
+	if d, _ := asp.Authorize(adapter.AuthorizationRequest{Subject: "alice", Action: "GET", Resource: "/"}, never); !d.Allowed || d.Rule != "" {
+		t.Errorf("Authorize(GET) = %v; wanted allowed by default", d)
+	}
+	if d, _ := asp.Authorize(adapter.AuthorizationRequest{Subject: "alice", Action: "DELETE", Resource: "/"}, never); d.Allowed || d.Rule != "no-deletes" {
+		t.Errorf("Authorize(DELETE) = %v; wanted denied by no-deletes", d)
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		value    string
+		match    bool
+	}{
+		{[]string{"GET"}, "GET", true},
+		{[]string{"GET"}, "GETS", false},
+		{[]string{"POST", "GET"}, "GET", true},
+		{[]string{"*"}, "", true},
+		{[]string{"/books*"}, "/books/1", true},
+		{[]string{"/books*"}, "/book", false},
+		{nil, "GET", false},
+	}
+	for idx, c := range cases {
+		if got := matchAny(c.patterns, c.value); got != c.match {
+			t.Errorf("[%d] matchAny(%v, %s) = %t; wanted %t", idx, c.patterns, c.value, got, c.match)
+		}
+	}
+}
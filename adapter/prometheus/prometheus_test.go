@@ -24,6 +24,7 @@ import (
 
 	"istio.io/mixer/adapter/prometheus/config"
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
 	"istio.io/mixer/pkg/adapter/test"
 )
 
@@ -102,6 +103,10 @@ func TestInvariants(t *testing.T) {
 	test.AdapterInvariants(Register, t)
 }
 
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{})
+}
+
 func TestFactory_NewMetricsAspect(t *testing.T) {
 	f := newFactory(&testServer{})
 
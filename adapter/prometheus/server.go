@@ -54,13 +54,15 @@ func newServer(addr string) server {
 
 func (s *serverInst) Start(env adapter.Env) error {
 	var listener net.Listener
-	srv := &http.Server{Addr: s.addr}
+	// servers are started again once closed, they can't share the default mux
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.Handler())
+	srv := &http.Server{Addr: s.addr, Handler: mux}
 	listener, err := net.Listen("tcp", s.addr)
 	if err != nil {
 		return fmt.Errorf("could not start prometheus metrics server: %v", err)
 	}
 
-	http.Handle(metricsPath, promhttp.Handler())
 	env.ScheduleDaemon(func() {
 		env.Logger().Infof("serving prometheus metrics on %s", s.addr)
 		if err := srv.Serve(listener.(*net.TCPListener)); err != nil {
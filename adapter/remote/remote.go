@@ -0,0 +1,526 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remote provides an adapter which proxies lists, metrics, logs and
+// quotas aspects to adapters running out of process, in plugins serving the
+// protocol of package istio.io/mixer/pkg/adapter/plugin on the mixer's host.
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/types"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"istio.io/mixer/adapter/remote/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/plugin"
+)
+
+type (
+	builder struct {
+		adapter.DefaultBuilder
+
+		lock  sync.Mutex // guards conns
+		conns map[string]*conn
+	}
+
+	// conn is a connection to a plugin, shared by the aspects proxied to it.
+	conn struct {
+		address string
+		cc      *grpc.ClientConn
+		client  *plugin.Client
+		health  healthpb.HealthClient
+		refs    int // guarded by the builder's lock
+
+		lock       sync.Mutex // guards healthy and generation
+		healthy    bool
+		generation uint64 // bumped when the plugin becomes healthy again, as it may have restarted
+
+		closing   chan struct{}
+		closeOnce sync.Once
+	}
+
+	// aspect proxies calls to an aspect of a plugin, which it creates again
+	// when the plugin no longer has it.
+	aspect struct {
+		b       *builder
+		conn    *conn
+		log     adapter.Logger
+		kind    string
+		adapter string
+		params  string
+		metrics map[string]*adapter.MetricDefinition
+		quotas  map[string]*adapter.QuotaDefinition
+		timeout time.Duration
+
+		lock       sync.Mutex // guards id, generation and closed
+		id         string     // empty until created in the plugin
+		generation uint64     // of the connection when id was created
+		closed     bool
+
+		closeOnce sync.Once
+	}
+)
+
+var (
+	name = "remote"
+	desc = "Proxies aspects to an adapter running in a plugin process"
+)
+
+func newDefaultConfig() *config.Params {
+	return &config.Params{
+		Address:             "unix:///var/run/mixer/plugin.sock",
+		Timeout:             &types.Duration{Seconds: 1},
+		HealthCheckInterval: &types.Duration{Seconds: 5},
+	}
+}
+
+// Register records the builders exposed by this adapter.
+func Register(r adapter.Registrar) {
+	b := newBuilder()
+	r.RegisterListsBuilder(b)
+	r.RegisterMetricsBuilder(b)
+	r.RegisterApplicationLogsBuilder(b)
+	r.RegisterAccessLogsBuilder(b)
+	r.RegisterQuotasBuilder(b)
+}
+
+func newBuilder() *builder {
+	return &builder{
+		DefaultBuilder: adapter.NewDefaultBuilder(name, desc, nil),
+		conns:          make(map[string]*conn),
+	}
+}
+
+// DefaultConfig returns a new config on each call, as the params of remote
+// adapters of different plugins get decoded into their default configs.
+func (*builder) DefaultConfig() adapter.Config {
+	return newDefaultConfig()
+}
+
+func (b *builder) ValidateConfig(c adapter.Config) (ce *adapter.ConfigErrors) {
+	params := c.(*config.Params)
+
+	if err := plugin.ValidateAddress(params.Address); err != nil {
+		ce = ce.Append("Address", err)
+	}
+	timeout, err := types.DurationFromProto(params.Timeout)
+	if err != nil {
+		ce = ce.Append("Timeout", err)
+	} else if timeout <= 0 {
+		ce = ce.Appendf("Timeout", "timeout must be > 0")
+	}
+	interval, err := types.DurationFromProto(params.HealthCheckInterval)
+	if err != nil {
+		ce = ce.Append("HealthCheckInterval", err)
+	} else if interval <= 0 {
+		ce = ce.Appendf("HealthCheckInterval", "health check interval must be > 0")
+	}
+	ps, err := marshalParams(params.Params)
+	if err != nil {
+		ce = ce.Append("Params", err)
+	}
+	if ce != nil {
+		return
+	}
+	return b.validateRemote(params.Address, params.Adapter, ps, timeout)
+}
+
+// validateRemote validates params with the plugin, unless it is unreachable,
+// as plugins may be started after the mixer. The connection of the aspects of
+// the plugin is used when there is one, validation isn't attempted when no
+// plugin listens on the socket, so that it doesn't wait for the plugin.
+func (b *builder) validateRemote(address string, adapterName string, params string, timeout time.Duration) (ce *adapter.ConfigErrors) {
+	b.lock.Lock()
+	c, found := b.conns[address]
+	if found {
+		c.refs++
+	}
+	b.lock.Unlock()
+
+	var client *plugin.Client
+	if found {
+		defer b.release(c)
+		if healthy, _ := c.state(); !healthy {
+			glog.Warningf("Unable to validate params with the plugin at %s: the plugin is unhealthy", address)
+			return nil
+		}
+		client = c.client
+	} else {
+		if plugin.SocketMissing(address) {
+			glog.Warningf("Unable to validate params with the plugin at %s: nothing listens on the socket", address)
+			return nil
+		}
+		cc, err := plugin.Dial(address)
+		if err != nil {
+			glog.Warningf("Unable to validate params with the plugin at %s: %v", address, err)
+			return nil
+		}
+		defer func() { _ = cc.Close() }()
+		client = plugin.NewClient(cc)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rce, err := client.ValidateConfig(ctx, adapterName, "", params)
+	if err != nil {
+		if unavailable(err) {
+			glog.Warningf("Unable to validate params with the plugin at %s: %v", address, err)
+			return nil
+		}
+		return ce.Append("Adapter", err)
+	}
+	if rce == nil || rce.Multi == nil {
+		return nil
+	}
+	for _, e := range rce.Multi.Errors {
+		field := "Params"
+		if cerr, ok := e.(adapter.ConfigError); ok && cerr.Field != "" {
+			field += "." + cerr.Field
+			e = cerr.Underlying
+		}
+		ce = ce.Append(field, e)
+	}
+	return ce
+}
+
+// marshalParams returns the params of the adapter of the plugin in JSON.
+func marshalParams(params *types.Struct) (string, error) {
+	if params == nil {
+		return "", nil
+	}
+	return (&jsonpb.Marshaler{}).MarshalToString(params)
+}
+
+func unavailable(err error) bool {
+	code := grpc.Code(err)
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
+
+func (b *builder) NewListsAspect(env adapter.Env, c adapter.Config) (adapter.ListsAspect, error) {
+	return b.newAspect(env, c, plugin.ListsKind, nil, nil)
+}
+
+func (b *builder) NewMetricsAspect(env adapter.Env, c adapter.Config, metrics map[string]*adapter.MetricDefinition) (adapter.MetricsAspect, error) {
+	return b.newAspect(env, c, plugin.MetricsKind, metrics, nil)
+}
+
+func (b *builder) NewApplicationLogsAspect(env adapter.Env, c adapter.Config) (adapter.ApplicationLogsAspect, error) {
+	return b.newAspect(env, c, plugin.ApplicationLogsKind, nil, nil)
+}
+
+func (b *builder) NewAccessLogsAspect(env adapter.Env, c adapter.Config) (adapter.AccessLogsAspect, error) {
+	return b.newAspect(env, c, plugin.AccessLogsKind, nil, nil)
+}
+
+func (b *builder) NewQuotasAspect(env adapter.Env, c adapter.Config, quotas map[string]*adapter.QuotaDefinition) (adapter.QuotasAspect, error) {
+	return b.newAspect(env, c, plugin.QuotasKind, nil, quotas)
+}
+
+// newAspect returns an aspect of the plugin. When the plugin is unreachable,
+// the aspect gets created in the plugin by the first call to it instead.
+func (b *builder) newAspect(env adapter.Env, c adapter.Config, kind string,
+	metrics map[string]*adapter.MetricDefinition, quotas map[string]*adapter.QuotaDefinition) (*aspect, error) {
+	params := c.(*config.Params)
+	timeout, err := types.DurationFromProto(params.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := types.DurationFromProto(params.HealthCheckInterval)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := marshalParams(params.Params)
+	if err != nil {
+		return nil, err
+	}
+	cn, err := b.acquire(env, params.Address, interval, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &aspect{
+		b:       b,
+		conn:    cn,
+		log:     env.Logger(),
+		kind:    kind,
+		adapter: params.Adapter,
+		params:  ps,
+		metrics: metrics,
+		quotas:  quotas,
+		timeout: timeout,
+	}
+	_, generation := cn.state()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err = a.create(ctx, "", generation); err != nil {
+		if !unavailable(err) {
+			b.release(cn)
+			return nil, err
+		}
+		a.log.Warningf("Unable to create an aspect of the plugin at %s, retrying when used: %v", params.Address, err)
+	}
+	return a, nil
+}
+
+// acquire returns the connection to the plugin at address, dialing it and
+// scheduling its health checks when no aspect uses it yet.
+func (b *builder) acquire(env adapter.Env, address string, interval time.Duration, timeout time.Duration) (*conn, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if c, found := b.conns[address]; found {
+		c.refs++
+		return c, nil
+	}
+	cc, err := plugin.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		address: address,
+		cc:      cc,
+		client:  plugin.NewClient(cc),
+		health:  healthpb.NewHealthClient(cc),
+		refs:    1,
+		healthy: true,
+		closing: make(chan struct{}),
+	}
+	b.conns[address] = c
+	log := env.Logger()
+	env.ScheduleDaemon(func() { c.checkHealth(log, interval, timeout) })
+	return c, nil
+}
+
+// release closes the connection once no aspect uses it anymore.
+func (b *builder) release(c *conn) {
+	b.lock.Lock()
+	c.refs--
+	last := c.refs == 0
+	if last && b.conns[c.address] == c {
+		delete(b.conns, c.address)
+	}
+	b.lock.Unlock()
+
+	if last {
+		c.close()
+	}
+}
+
+func (b *builder) Close() error {
+	b.lock.Lock()
+	conns := b.conns
+	b.conns = make(map[string]*conn)
+	b.lock.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+	return nil
+}
+
+// state returns whether the plugin is healthy, and the generation of the connection.
+func (c *conn) state() (bool, uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.healthy, c.generation
+}
+
+// checkHealth checks the health of the plugin until the connection is closed.
+func (c *conn) checkHealth(log adapter.Logger, interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closing:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		resp, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{Service: plugin.AdapterServiceName})
+		cancel()
+		select {
+		case <-c.closing:
+			return
+		default:
+		}
+		if err == nil && resp.Status != healthpb.HealthCheckResponse_SERVING {
+			err = fmt.Errorf("plugin is %v", resp.Status)
+		}
+		c.setHealthy(log, err)
+	}
+}
+
+func (c *conn) setHealthy(log adapter.Logger, err error) {
+	healthy := err == nil
+	c.lock.Lock()
+	changed := c.healthy != healthy
+	c.healthy = healthy
+	if changed && healthy {
+		c.generation++
+	}
+	c.lock.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		log.Infof("Plugin at %s is healthy again", c.address)
+	} else {
+		log.Warningf("Plugin at %s is unhealthy: %v", c.address, err)
+	}
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+		_ = c.cc.Close()
+	})
+}
+
+// call calls fn with the id of the aspect in the plugin, creating the aspect
+// again when the plugin doesn't have it, as happens when the plugin restarts.
+// Calls fail fast while the plugin is unhealthy.
+func (a *aspect) call(fn func(ctx context.Context, id string) error) error {
+	healthy, generation := a.conn.state()
+	if !healthy {
+		return fmt.Errorf("plugin at %s is unhealthy", a.conn.address)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	a.lock.Lock()
+	id, idGeneration := a.id, a.generation
+	a.lock.Unlock()
+
+	var err error
+	if id == "" || idGeneration != generation {
+		if id, err = a.create(ctx, id, generation); err != nil {
+			return err
+		}
+	}
+	if err = fn(ctx, id); !plugin.IsUnknownAspect(err) {
+		return err
+	}
+	if id, err = a.create(ctx, id, generation); err != nil {
+		return err
+	}
+	return fn(ctx, id)
+}
+
+// create creates the aspect in the plugin, unless another call created it
+// since its stale id was read. The stale aspect gets closed, in case the
+// plugin still has it.
+func (a *aspect) create(ctx context.Context, stale string, generation uint64) (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.closed {
+		return "", errors.New("aspect is closed")
+	}
+	if a.id != "" && a.id != stale {
+		return a.id, nil
+	}
+	id, err := a.conn.client.NewAspect(ctx, a.adapter, a.kind, a.params, a.metrics, a.quotas)
+	if err != nil {
+		return "", err
+	}
+	a.id, a.generation = id, generation
+	if stale != "" {
+		_ = a.conn.client.CloseAspect(ctx, stale)
+	}
+	return id, nil
+}
+
+func (a *aspect) Close() (err error) {
+	a.closeOnce.Do(func() {
+		a.lock.Lock()
+		id := a.id
+		a.id = ""
+		a.closed = true
+		a.lock.Unlock()
+
+		if id != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+			if err = a.conn.client.CloseAspect(ctx, id); plugin.IsUnknownAspect(err) || unavailable(err) {
+				a.log.Warningf("Unable to close the aspect of the plugin at %s: %v", a.conn.address, err)
+				err = nil
+			}
+			cancel()
+		}
+		a.b.release(a.conn)
+	})
+	return
+}
+
+func (a *aspect) CheckList(symbol string) (bool, error) {
+	var found bool
+	err := a.call(func(ctx context.Context, id string) (err error) {
+		found, err = a.conn.client.CheckList(ctx, id, symbol)
+		return
+	})
+	return found, err
+}
+
+func (a *aspect) Record(values []adapter.Value) error {
+	return a.call(func(ctx context.Context, id string) error {
+		return a.conn.client.Record(ctx, id, values)
+	})
+}
+
+func (a *aspect) Log(entries []adapter.LogEntry) error {
+	return a.call(func(ctx context.Context, id string) error {
+		return a.conn.client.Log(ctx, id, entries)
+	})
+}
+
+func (a *aspect) LogAccess(entries []adapter.LogEntry) error {
+	return a.Log(entries)
+}
+
+func (a *aspect) Alloc(args adapter.QuotaArgs) (adapter.QuotaResult, error) {
+	return a.alloc(args, false)
+}
+
+func (a *aspect) AllocBestEffort(args adapter.QuotaArgs) (adapter.QuotaResult, error) {
+	return a.alloc(args, true)
+}
+
+func (a *aspect) alloc(args adapter.QuotaArgs, bestEffort bool) (adapter.QuotaResult, error) {
+	var qr adapter.QuotaResult
+	err := a.call(func(ctx context.Context, id string) (err error) {
+		qr, err = a.conn.client.Alloc(ctx, id, args, bestEffort)
+		return
+	})
+	return qr, err
+}
+
+func (a *aspect) ReleaseBestEffort(args adapter.QuotaArgs) (int64, error) {
+	var amount int64
+	err := a.call(func(ctx context.Context, id string) (err error) {
+		amount, err = a.conn.client.Release(ctx, id, args)
+		return
+	})
+	return amount, err
+}
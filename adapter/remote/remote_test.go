@@ -0,0 +1,416 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"google.golang.org/grpc"
+
+	"istio.io/mixer/adapter/remote/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
+	"istio.io/mixer/pkg/adapter/plugin"
+	"istio.io/mixer/pkg/adapter/test"
+)
+
+// fakeAdapter is served by the plugins of the tests. Its config is a struct,
+// which is invalid when its "invalid" field is true, and its lists hold the
+// symbol "present".
+type fakeAdapter struct {
+	lock     sync.Mutex
+	recorded []adapter.Value
+	logged   []adapter.LogEntry
+}
+
+func (*fakeAdapter) Name() string                  { return "fake" }
+func (*fakeAdapter) Description() string           { return "a fake adapter" }
+func (*fakeAdapter) DefaultConfig() adapter.Config { return &types.Struct{} }
+func (*fakeAdapter) Close() error                  { return nil }
+
+func (*fakeAdapter) ValidateConfig(c adapter.Config) (ce *adapter.ConfigErrors) {
+	if v, found := c.(*types.Struct).Fields["invalid"]; found && v.GetBoolValue() {
+		ce = ce.Appendf("invalid", "must be false")
+	}
+	return
+}
+
+func (a *fakeAdapter) NewListsAspect(adapter.Env, adapter.Config) (adapter.ListsAspect, error) {
+	return &fakeAspect{a}, nil
+}
+
+func (a *fakeAdapter) NewMetricsAspect(adapter.Env, adapter.Config, map[string]*adapter.MetricDefinition) (adapter.MetricsAspect, error) {
+	return &fakeAspect{a}, nil
+}
+
+func (a *fakeAdapter) NewApplicationLogsAspect(adapter.Env, adapter.Config) (adapter.ApplicationLogsAspect, error) {
+	return &fakeAspect{a}, nil
+}
+
+func (a *fakeAdapter) NewAccessLogsAspect(adapter.Env, adapter.Config) (adapter.AccessLogsAspect, error) {
+	return &fakeAspect{a}, nil
+}
+
+func (a *fakeAdapter) NewQuotasAspect(adapter.Env, adapter.Config, map[string]*adapter.QuotaDefinition) (adapter.QuotasAspect, error) {
+	return &fakeAspect{a}, nil
+}
+
+func (a *fakeAdapter) register(r adapter.Registrar) {
+	r.RegisterListsBuilder(a)
+	r.RegisterMetricsBuilder(a)
+	r.RegisterApplicationLogsBuilder(a)
+	r.RegisterAccessLogsBuilder(a)
+	r.RegisterQuotasBuilder(a)
+}
+
+type fakeAspect struct {
+	a *fakeAdapter
+}
+
+func (*fakeAspect) Close() error                          { return nil }
+func (*fakeAspect) CheckList(symbol string) (bool, error) { return symbol == "present", nil }
+
+func (f *fakeAspect) Record(values []adapter.Value) error {
+	f.a.lock.Lock()
+	f.a.recorded = append(f.a.recorded, values...)
+	f.a.lock.Unlock()
+	return nil
+}
+
+func (f *fakeAspect) Log(entries []adapter.LogEntry) error {
+	f.a.lock.Lock()
+	f.a.logged = append(f.a.logged, entries...)
+	f.a.lock.Unlock()
+	return nil
+}
+
+func (f *fakeAspect) LogAccess(entries []adapter.LogEntry) error {
+	return f.Log(entries)
+}
+
+func (*fakeAspect) Alloc(args adapter.QuotaArgs) (adapter.QuotaResult, error) {
+	return adapter.QuotaResult{Amount: args.QuotaAmount}, nil
+}
+
+func (*fakeAspect) AllocBestEffort(args adapter.QuotaArgs) (adapter.QuotaResult, error) {
+	return adapter.QuotaResult{Amount: 1}, nil
+}
+
+func (*fakeAspect) ReleaseBestEffort(args adapter.QuotaArgs) (int64, error) {
+	return args.QuotaAmount, nil
+}
+
+// fakePlugin serves a fake adapter on a unix socket.
+type fakePlugin struct {
+	address string
+	a       *fakeAdapter
+	gs      *grpc.Server
+	s       *plugin.Server
+}
+
+func newFakePlugin(t *testing.T, dir string) *fakePlugin {
+	p := &fakePlugin{address: "unix://" + filepath.Join(dir, "fake.sock"), a: &fakeAdapter{}}
+	p.start(t)
+	return p
+}
+
+func (p *fakePlugin) start(t *testing.T) {
+	lis, err := plugin.Listen(p.address)
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	p.s = plugin.NewServer(p.a.register)
+	p.gs = grpc.NewServer()
+	p.s.Register(p.gs)
+	go func() { _ = p.gs.Serve(lis) }()
+}
+
+func (p *fakePlugin) stop() {
+	p.gs.Stop()
+	_ = p.s.Close()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "remote")
+	if err != nil {
+		t.Fatalf("Unable to create a temp dir: %v", err)
+	}
+	return dir
+}
+
+func params(address string, adapterParams map[string]interface{}) *config.Params {
+	c := newDefaultConfig()
+	c.Address = address
+	c.HealthCheckInterval = &types.Duration{Nanos: int32(10 * time.Millisecond)}
+	if adapterParams != nil {
+		c.Params = &types.Struct{Fields: make(map[string]*types.Value)}
+		for k, v := range adapterParams {
+			c.Params.Fields[k] = &types.Value{Kind: &types.Value_BoolValue{BoolValue: v.(bool)}}
+		}
+	}
+	return c
+}
+
+var (
+	metrics = map[string]*adapter.MetricDefinition{
+		"requests": {Name: "requests", Kind: adapter.Counter, Labels: map[string]adapter.LabelType{"source": adapter.String}},
+	}
+	quotas = map[string]*adapter.QuotaDefinition{
+		"rate": {Name: "rate", MaxAmount: 10, Labels: map[string]adapter.LabelType{}},
+	}
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	p := newFakePlugin(t, dir)
+	defer p.stop()
+
+	unreachable := "unix://" + filepath.Join(dir, "none.sock")
+	cases := []struct {
+		params *config.Params
+		fields []string
+	}{
+		{params(p.address, nil), nil},
+		{params(p.address, map[string]interface{}{"invalid": false}), nil},
+		{params(p.address, map[string]interface{}{"invalid": true}), []string{"Params.invalid"}},
+		{&config.Params{Address: p.address, Adapter: "other", Timeout: &types.Duration{Seconds: 1},
+			HealthCheckInterval: &types.Duration{Seconds: 1}}, []string{"Adapter"}},
+		{params(unreachable, map[string]interface{}{"invalid": true}), nil},
+		{&config.Params{Address: "10.0.0.1:9091"}, []string{"Address", "Timeout", "HealthCheckInterval"}},
+	}
+	b := newBuilder()
+	for idx, c := range cases {
+		ce := b.ValidateConfig(c.params)
+		var fields []string
+		if ce != nil {
+			for _, e := range ce.Multi.Errors {
+				fields = append(fields, e.(adapter.ConfigError).Field)
+			}
+		}
+		if !reflect.DeepEqual(fields, c.fields) {
+			t.Errorf("[%d] ValidateConfig() = %v; wanted errors on %v", idx, ce, c.fields)
+		}
+	}
+}
+
+func TestValidateConfig_DoesNotWait(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	p := newFakePlugin(t, dir)
+	defer p.stop()
+
+	// a loopback port nothing listens on
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	closed := lis.Addr().String()
+	_ = lis.Close()
+
+	b := newBuilder()
+	start := time.Now()
+	if ce := b.ValidateConfig(params(closed, map[string]interface{}{"invalid": true})); ce != nil {
+		t.Errorf("ValidateConfig() = %v; wanted the unreachable plugin skipped", ce)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("ValidateConfig() took %v; wanted it not to wait for the timeout", elapsed)
+	}
+
+	// the connection of the aspects of the plugin is shared
+	lists, err := b.NewListsAspect(test.NewEnv(t), params(p.address, nil))
+	if err != nil {
+		t.Fatalf("NewListsAspect() failed: %v", err)
+	}
+	defer func() { _ = lists.Close() }()
+	if ce := b.ValidateConfig(params(p.address, map[string]interface{}{"invalid": true})); ce == nil {
+		t.Error("ValidateConfig() = nil; wanted the plugin's errors")
+	}
+	b.lock.Lock()
+	conns, refs := len(b.conns), b.conns[p.address].refs
+	b.lock.Unlock()
+	if conns != 1 || refs != 1 {
+		t.Errorf("Got %d connections with %d refs; wanted the aspect's connection only", conns, refs)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	p := newFakePlugin(t, dir)
+	defer p.stop()
+
+	b := newBuilder()
+	env := test.NewEnv(t)
+	c := params(p.address, map[string]interface{}{"invalid": false})
+
+	lists, err := b.NewListsAspect(env, c)
+	if err != nil {
+		t.Fatalf("NewListsAspect() failed: %v", err)
+	}
+	if found, err := lists.CheckList("present"); !found || err != nil {
+		t.Errorf("CheckList(present) = %t, %v; wanted true", found, err)
+	}
+	if found, err := lists.CheckList("absent"); found || err != nil {
+		t.Errorf("CheckList(absent) = %t, %v; wanted false", found, err)
+	}
+
+	m, err := b.NewMetricsAspect(env, c, metrics)
+	if err != nil {
+		t.Fatalf("NewMetricsAspect() failed: %v", err)
+	}
+	values := []adapter.Value{{
+		Definition:  metrics["requests"],
+		Labels:      map[string]interface{}{"source": "a"},
+		StartTime:   time.Unix(1, 0),
+		EndTime:     time.Unix(2, 0),
+		MetricValue: int64(1),
+	}}
+	if err = m.Record(values); err != nil || !reflect.DeepEqual(p.a.recorded, values) {
+		t.Errorf("Record() = %v and recorded %v; wanted %v", err, p.a.recorded, values)
+	}
+
+	logs, err := b.NewAccessLogsAspect(env, c)
+	if err != nil {
+		t.Fatalf("NewAccessLogsAspect() failed: %v", err)
+	}
+	entries := []adapter.LogEntry{{LogName: "access", Labels: map[string]interface{}{}, TextPayload: "GET /"}}
+	if err = logs.LogAccess(entries); err != nil || !reflect.DeepEqual(p.a.logged, entries) {
+		t.Errorf("LogAccess() = %v and logged %v; wanted %v", err, p.a.logged, entries)
+	}
+
+	q, err := b.NewQuotasAspect(env, c, quotas)
+	if err != nil {
+		t.Fatalf("NewQuotasAspect() failed: %v", err)
+	}
+	args := adapter.QuotaArgs{Definition: quotas["rate"], QuotaAmount: 3}
+	if qr, err := q.Alloc(args); qr.Amount != 3 || err != nil {
+		t.Errorf("Alloc() = %v, %v; wanted 3", qr, err)
+	}
+	if qr, err := q.AllocBestEffort(args); qr.Amount != 1 || err != nil {
+		t.Errorf("AllocBestEffort() = %v, %v; wanted 1", qr, err)
+	}
+	if amount, err := q.ReleaseBestEffort(args); amount != 3 || err != nil {
+		t.Errorf("ReleaseBestEffort() = %d, %v; wanted 3", amount, err)
+	}
+
+	if _, err = b.NewListsAspect(env, params(p.address, map[string]interface{}{"invalid": true})); err == nil {
+		t.Error("NewListsAspect(invalid) succeeded; wanted an error")
+	}
+
+	for _, a := range []adapter.Aspect{lists, m, logs, q} {
+		if err = a.Close(); err != nil {
+			t.Errorf("Close() failed: %v", err)
+		}
+	}
+	if len(b.conns) != 0 {
+		t.Errorf("%d connections are open once the aspects are closed; wanted 0", len(b.conns))
+	}
+	if _, err = lists.CheckList("present"); err == nil {
+		t.Error("CheckList() of a closed aspect succeeded; wanted an error")
+	}
+	_ = b.Close()
+}
+
+// eventually calls fn until it succeeds, or fails the test after a few seconds.
+func eventually(t *testing.T, what string, fn func() error) {
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if err = fn(); err == nil {
+			return
+		}
+	}
+	t.Fatalf("%s failed: %v", what, err)
+}
+
+func TestRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	p := newFakePlugin(t, dir)
+
+	b := newBuilder()
+	defer func() { _ = b.Close() }()
+	lists, err := b.NewListsAspect(test.NewEnv(t), params(p.address, nil))
+	if err != nil {
+		t.Fatalf("NewListsAspect() failed: %v", err)
+	}
+	defer func() { _ = lists.Close() }()
+	if _, err = lists.CheckList("present"); err != nil {
+		t.Fatalf("CheckList() failed: %v", err)
+	}
+
+	p.stop()
+	eventually(t, "failing fast", func() error {
+		if _, err := lists.CheckList("present"); err == nil || !strings.Contains(err.Error(), "unhealthy") {
+			return err
+		}
+		return nil
+	})
+
+	// the restarted plugin doesn't have the aspect, which gets created again
+	p.start(t)
+	defer p.stop()
+	eventually(t, "CheckList() after the restart", func() error {
+		_, err := lists.CheckList("present")
+		return err
+	})
+}
+
+func TestLateStart(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	address := "unix://" + filepath.Join(dir, "fake.sock")
+
+	b := newBuilder()
+	defer func() { _ = b.Close() }()
+	lists, err := b.NewListsAspect(test.NewEnv(t), params(address, nil))
+	if err != nil {
+		t.Fatalf("NewListsAspect() of an unreachable plugin failed: %v", err)
+	}
+	defer func() { _ = lists.Close() }()
+
+	p := newFakePlugin(t, dir)
+	defer p.stop()
+	eventually(t, "CheckList() once the plugin started", func() error {
+		_, err := lists.CheckList("present")
+		return err
+	})
+}
+
+func TestConformance(t *testing.T) {
+	dir := tempDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+	p := newFakePlugin(t, dir)
+	defer p.stop()
+
+	conformance.Run(t, Register, conformance.Options{
+		Configs: map[string]adapter.Config{name: params(p.address, nil)},
+		InvalidConfigs: map[string][]adapter.Config{name: {
+			params("example.com:9091", nil),
+			params(p.address, map[string]interface{}{"invalid": true}),
+		}},
+		Metrics: metrics,
+		Quotas:  quotas,
+	})
+}
@@ -27,6 +27,7 @@ import (
 
 	"istio.io/mixer/adapter/statsd/config"
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
 	"istio.io/mixer/pkg/adapter/test"
 )
 
@@ -34,6 +35,12 @@ func TestInvariants(t *testing.T) {
 	test.AdapterInvariants(Register, t)
 }
 
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{
+		InvalidConfigs: map[string][]adapter.Config{name: {&config.Params{SamplingRate: -1}}},
+	})
+}
+
 func TestNewBuilder(t *testing.T) {
 	b := newBuilder()
 	if err := b.Close(); err != nil {
@@ -24,6 +24,7 @@ import (
 
 	"istio.io/mixer/adapter/stdioLogger/config"
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
 	"istio.io/mixer/pkg/adapter/test"
 )
 
@@ -31,6 +32,10 @@ func TestAdapterInvariants(t *testing.T) {
 	test.AdapterInvariants(Register, t)
 }
 
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{})
+}
+
 func TestBuilder_NewLogger(t *testing.T) {
 	tests := []newAspectTests{
 		{&config.Params{}, defaultAspectImpl},
@@ -0,0 +1,292 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zipkin provides an implementation of the mixer spans aspect
+// that writes spans in the Zipkin v2 JSON format, either to a Zipkin
+// collector over HTTP or to a local file.
+package zipkin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/mixer/adapter/zipkin/config"
+	"istio.io/mixer/pkg/adapter"
+)
+
+type (
+	builder struct {
+		adapter.DefaultBuilder
+	}
+
+	// sink is where batches of spans, encoded as JSON arrays, get written.
+	sink interface {
+		io.Closer
+		write(batch []byte) error
+	}
+
+	reporter struct {
+		log         adapter.Logger
+		sink        sink
+		serviceName string
+		batchSize   int
+		ticker      *time.Ticker
+
+		lock    sync.Mutex // guards pending
+		pending []adapter.Span
+
+		writeLock sync.Mutex // serializes writes to the sink
+
+		closing   chan struct{}
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+
+	// span is a span in the Zipkin v2 JSON format.
+	span struct {
+		TraceID       string            `json:"traceId"`
+		ID            string            `json:"id"`
+		ParentID      string            `json:"parentId,omitempty"`
+		Name          string            `json:"name"`
+		Timestamp     int64             `json:"timestamp"`
+		Duration      int64             `json:"duration"`
+		LocalEndpoint endpoint          `json:"localEndpoint"`
+		Tags          map[string]string `json:"tags,omitempty"`
+	}
+
+	endpoint struct {
+		ServiceName string `json:"serviceName"`
+	}
+
+	httpSink struct {
+		url    string
+		client *http.Client
+	}
+
+	fileSink struct {
+		file *os.File
+	}
+)
+
+var (
+	name = "zipkin"
+	desc = "Writes spans in the Zipkin v2 JSON format to a collector or a file"
+)
+
+func newDefaultConfig() *config.Params {
+	return &config.Params{
+		Url:           "http://localhost:9411/api/v2/spans",
+		ServiceName:   "istio-mixer",
+		BatchSize:     100,
+		FlushDuration: &types.Duration{Seconds: 1},
+	}
+}
+
+// Register records the builders exposed by this adapter.
+func Register(r adapter.Registrar) {
+	r.RegisterSpansBuilder(newBuilder())
+}
+
+func newBuilder() *builder {
+	return &builder{adapter.NewDefaultBuilder(name, desc, nil)}
+}
+
+// DefaultConfig returns a new config on each call, as params get decoded
+// into the default config.
+func (*builder) DefaultConfig() adapter.Config {
+	return newDefaultConfig()
+}
+
+func (b *builder) ValidateConfig(c adapter.Config) (ce *adapter.ConfigErrors) {
+	params := c.(*config.Params)
+
+	if params.Url == "" && params.File == "" {
+		ce = ce.Appendf("Url", "one of url and file must be specified")
+	}
+	if params.ServiceName == "" {
+		ce = ce.Appendf("ServiceName", "a service name must be provided")
+	}
+	if params.BatchSize <= 0 {
+		ce = ce.Appendf("BatchSize", "batch size must be > 0")
+	}
+
+	flushDuration, err := types.DurationFromProto(params.FlushDuration)
+	if err != nil {
+		ce = ce.Append("FlushDuration", err)
+	} else if flushDuration <= 0 {
+		ce = ce.Appendf("FlushDuration", "flush duration must be > 0")
+	}
+	return
+}
+
+func (*builder) NewSpansAspect(env adapter.Env, cfg adapter.Config) (adapter.SpansAspect, error) {
+	params := cfg.(*config.Params)
+
+	// a file takes precedence over the url, which is set by default
+	var s sink
+	if params.File != "" {
+		f, err := os.OpenFile(params.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open span file %s: %v", params.File, err)
+		}
+		s = &fileSink{f}
+	} else {
+		s = &httpSink{url: params.Url, client: &http.Client{Timeout: 5 * time.Second}}
+	}
+
+	flushDuration, _ := types.DurationFromProto(params.FlushDuration)
+	return newReporter(env, s, params.ServiceName, int(params.BatchSize), time.NewTicker(flushDuration)), nil
+}
+
+func newReporter(env adapter.Env, s sink, serviceName string, batchSize int, ticker *time.Ticker) *reporter {
+	r := &reporter{
+		log:         env.Logger(),
+		sink:        s,
+		serviceName: serviceName,
+		batchSize:   batchSize,
+		ticker:      ticker,
+		closing:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	// goroutine to periodically write the buffered spans
+	env.ScheduleDaemon(r.flusher)
+
+	return r
+}
+
+// ReportSpans buffers spans, writing them out once a full batch is available.
+func (r *reporter) ReportSpans(spans []adapter.Span) error {
+	r.lock.Lock()
+	r.pending = append(r.pending, spans...)
+	var batch []adapter.Span
+	if len(r.pending) >= r.batchSize {
+		batch, r.pending = r.pending, nil
+	}
+	r.lock.Unlock()
+
+	return r.write(batch)
+}
+
+// Close writes the buffered spans and releases the sink.
+func (r *reporter) Close() (err error) {
+	r.closeOnce.Do(func() {
+		close(r.closing)
+		<-r.done
+		r.ticker.Stop()
+
+		err = r.flush()
+		if cerr := r.sink.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+func (r *reporter) flusher() {
+	defer close(r.done)
+	for {
+		select {
+		case <-r.ticker.C:
+			if err := r.flush(); err != nil {
+				r.log.Warningf("Unable to write spans: %v", err)
+			}
+		case <-r.closing:
+			return
+		}
+	}
+}
+
+// flush writes the pending spans.
+func (r *reporter) flush() error {
+	r.lock.Lock()
+	batch := r.pending
+	r.pending = nil
+	r.lock.Unlock()
+
+	return r.write(batch)
+}
+
+// write sends a batch of spans to the sink. The spans of a batch that can't be written are dropped.
+func (r *reporter) write(spans []adapter.Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	batch := make([]span, len(spans))
+	for i, s := range spans {
+		batch[i] = toZipkin(s, r.serviceName)
+	}
+
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+	return r.sink.write(b)
+}
+
+func toZipkin(s adapter.Span, serviceName string) span {
+	zs := span{
+		TraceID:       s.TraceID,
+		ID:            s.SpanID,
+		ParentID:      s.ParentSpanID,
+		Name:          s.Name,
+		Timestamp:     s.StartTime.UnixNano() / int64(time.Microsecond),
+		Duration:      int64(s.EndTime.Sub(s.StartTime) / time.Microsecond),
+		LocalEndpoint: endpoint{ServiceName: serviceName},
+	}
+
+	if len(s.Tags) > 0 {
+		zs.Tags = make(map[string]string, len(s.Tags))
+		for k, v := range s.Tags {
+			zs.Tags[k] = fmt.Sprint(v)
+		}
+	}
+	return zs
+}
+
+func (h *httpSink) write(batch []byte) error {
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("zipkin collector at %s returned %s", h.url, resp.Status)
+	}
+	return nil
+}
+
+func (h *httpSink) Close() error { return nil }
+
+func (f *fileSink) write(batch []byte) error {
+	_, err := f.file.Write(append(batch, '\n'))
+	return err
+}
+
+func (f *fileSink) Close() error { return f.file.Close() }
@@ -0,0 +1,325 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/mixer/adapter/zipkin/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/conformance"
+	"istio.io/mixer/pkg/adapter/test"
+)
+
+// fakeSink records the batches written to it.
+type fakeSink struct {
+	sync.Mutex
+	batches [][]span
+	err     error
+	closed  bool
+	written chan struct{}
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{written: make(chan struct{}, 10)}
+}
+
+func (f *fakeSink) write(b []byte) error {
+	var batch []span
+	if err := json.Unmarshal(b, &batch); err != nil {
+		return err
+	}
+	f.Lock()
+	f.batches = append(f.batches, batch)
+	f.Unlock()
+	f.written <- struct{}{}
+	return f.err
+}
+
+func (f *fakeSink) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeSink) count() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.batches)
+}
+
+func testSpan(id string) adapter.Span {
+	start := time.Unix(1000, 0)
+	return adapter.Span{
+		TraceID:   "463ac35c9f6413ad",
+		SpanID:    id,
+		Name:      "/books",
+		StartTime: start,
+		EndTime:   start.Add(1500 * time.Microsecond),
+		Tags:      map[string]interface{}{"http.status_code": int64(200)},
+	}
+}
+
+func TestInvariants(t *testing.T) {
+	test.AdapterInvariants(Register, t)
+}
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, Register, conformance.Options{
+		InvalidConfigs: map[string][]adapter.Config{name: {&config.Params{}}},
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := func() *config.Params {
+		return &config.Params{
+			Url:           "http://localhost:9411/api/v2/spans",
+			ServiceName:   "mixer",
+			BatchSize:     10,
+			FlushDuration: &types.Duration{Seconds: 1},
+		}
+	}
+
+	cases := []struct {
+		modify    func(*config.Params)
+		errString string
+	}{
+		{func(*config.Params) {}, ""},
+		{func(p *config.Params) { p.Url, p.File = "", "/tmp/spans.json" }, ""},
+		{func(p *config.Params) { p.Url = "" }, "Url"},
+		{func(p *config.Params) { p.File = "/tmp/spans.json" }, ""},
+		{func(p *config.Params) { p.Url, p.File = "", "" }, "Url"},
+		{func(p *config.Params) { p.ServiceName = "" }, "ServiceName"},
+		{func(p *config.Params) { p.BatchSize = 0 }, "BatchSize"},
+		{func(p *config.Params) { p.FlushDuration = &types.Duration{} }, "FlushDuration"},
+		{func(p *config.Params) { p.FlushDuration = &types.Duration{Seconds: -1} }, "FlushDuration"},
+		{func(p *config.Params) { p.FlushDuration = nil }, "FlushDuration"},
+	}
+
+	b := newBuilder()
+	for idx, c := range cases {
+		params := valid()
+		c.modify(params)
+
+		errString := ""
+		if err := b.ValidateConfig(params); err != nil {
+			errString = err.Error()
+		}
+		if (c.errString == "") != (errString == "") || !strings.Contains(errString, c.errString) {
+			t.Errorf("[%d] b.ValidateConfig() = '%s'; want errString containing '%s'", idx, errString, c.errString)
+		}
+	}
+
+	if err := b.ValidateConfig(b.DefaultConfig()); err != nil {
+		t.Errorf("b.ValidateConfig(b.DefaultConfig()) = %v; wanted no err", err)
+	}
+
+	if b.DefaultConfig() == b.DefaultConfig() {
+		t.Error("b.DefaultConfig() returned the same config twice; wanted a new one on each call")
+	}
+}
+
+func TestReporter_BatchSize(t *testing.T) {
+	sink := newFakeSink()
+	r := newReporter(test.NewEnv(t), sink, "mixer", 2, time.NewTicker(time.Hour))
+
+	if err := r.ReportSpans([]adapter.Span{testSpan("1")}); err != nil {
+		t.Fatalf("ReportSpans() = %v; wanted no err", err)
+	}
+	if n := sink.count(); n != 0 {
+		t.Errorf("Got %d batches before the batch was full; wanted 0", n)
+	}
+	if err := r.ReportSpans([]adapter.Span{testSpan("2"), testSpan("3")}); err != nil {
+		t.Fatalf("ReportSpans() = %v; wanted no err", err)
+	}
+	if n := sink.count(); n != 1 || len(sink.batches[0]) != 3 {
+		t.Errorf("Got batches %v; wanted a single batch of 3 spans", sink.batches)
+	}
+
+	if err := r.ReportSpans([]adapter.Span{testSpan("4")}); err != nil {
+		t.Fatalf("ReportSpans() = %v; wanted no err", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() = %v; wanted no err", err)
+	}
+	if n := sink.count(); n != 2 || len(sink.batches[1]) != 1 || sink.batches[1][0].ID != "4" {
+		t.Errorf("Got batches %v; wanted the pending span flushed on close", sink.batches)
+	}
+	if !sink.closed {
+		t.Error("Close() didn't close the sink")
+	}
+}
+
+func TestReporter_Ticker(t *testing.T) {
+	sink := newFakeSink()
+	r := newReporter(test.NewEnv(t), sink, "mixer", 100, time.NewTicker(time.Millisecond))
+	defer func() { _ = r.Close() }()
+
+	if err := r.ReportSpans([]adapter.Span{testSpan("1")}); err != nil {
+		t.Fatalf("ReportSpans() = %v; wanted no err", err)
+	}
+
+	select {
+	case <-sink.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Buffered span wasn't flushed by the ticker")
+	}
+}
+
+func TestReporter_Error(t *testing.T) {
+	sink := newFakeSink()
+	sink.err = errors.New("collector down")
+	r := newReporter(test.NewEnv(t), sink, "mixer", 1, time.NewTicker(time.Hour))
+
+	if err := r.ReportSpans([]adapter.Span{testSpan("1")}); err == nil || !strings.Contains(err.Error(), "collector down") {
+		t.Errorf("ReportSpans() = %v; wanted sink error", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Close() = %v; wanted no err once the failed batch was dropped", err)
+	}
+}
+
+func TestToZipkin(t *testing.T) {
+	s := testSpan("72485a3953bb6124")
+	s.ParentSpanID = "463ac35c9f6413ad"
+
+	want := span{
+		TraceID:       "463ac35c9f6413ad",
+		ID:            "72485a3953bb6124",
+		ParentID:      "463ac35c9f6413ad",
+		Name:          "/books",
+		Timestamp:     1000000000,
+		Duration:      1500,
+		LocalEndpoint: endpoint{ServiceName: "mixer"},
+		Tags:          map[string]string{"http.status_code": "200"},
+	}
+	if got := toZipkin(s, "mixer"); !reflect.DeepEqual(got, want) {
+		t.Errorf("toZipkin() = %v; wanted %v", got, want)
+	}
+
+	s.Tags = nil
+	b, _ := json.Marshal(toZipkin(s, "mixer"))
+	if strings.Contains(string(b), "tags") {
+		t.Errorf("Got %s; wanted no tags", b)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var lock sync.Mutex
+	var received []span
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var batch []span
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		received = append(received, batch...)
+		lock.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	params := &config.Params{Url: srv.URL, ServiceName: "mixer", BatchSize: 1, FlushDuration: &types.Duration{Seconds: 1}}
+	asp, err := newBuilder().NewSpansAspect(test.NewEnv(t), params)
+	if err != nil {
+		t.Fatalf("NewSpansAspect() = _, %v; wanted no err", err)
+	}
+	if err = asp.ReportSpans([]adapter.Span{testSpan("1")}); err != nil {
+		t.Errorf("ReportSpans() = %v; wanted no err", err)
+	}
+	if err = asp.Close(); err != nil {
+		t.Errorf("Close() = %v; wanted no err", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 1 || received[0].ID != "1" || received[0].LocalEndpoint.ServiceName != "mixer" {
+		t.Errorf("Collector received %v; wanted span 1", received)
+	}
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	bad := &httpSink{url: missing.URL, client: http.DefaultClient}
+	if err = bad.write([]byte("[]")); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("write() = %v; wanted 404 error", err)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zipkin")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	file := filepath.Join(dir, "spans.json")
+
+	// the file takes precedence over the default url
+	params := newDefaultConfig()
+	params.File, params.BatchSize, params.FlushDuration = file, 2, &types.Duration{Seconds: 60}
+	for i := 0; i < 2; i++ {
+		asp, err := newBuilder().NewSpansAspect(test.NewEnv(t), params)
+		if err != nil {
+			t.Fatalf("[%d] NewSpansAspect() = _, %v; wanted no err", i, err)
+		}
+		if err = asp.ReportSpans([]adapter.Span{testSpan("1"), testSpan("2"), testSpan("3")}); err != nil {
+			t.Errorf("[%d] ReportSpans() = %v; wanted no err", i, err)
+		}
+		if err = asp.Close(); err != nil {
+			t.Errorf("[%d] Close() = %v; wanted no err", i, err)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Unable to open span file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// each aspect appended a single batch of 3 spans
+	lines := 0
+	for s := bufio.NewScanner(f); s.Scan(); lines++ {
+		var batch []span
+		if err := json.Unmarshal(s.Bytes(), &batch); err != nil || len(batch) != 3 {
+			t.Errorf("Line %d = %s; wanted a batch of 3 spans", lines, s.Text())
+		}
+	}
+	if lines != 2 {
+		t.Errorf("Got %d lines; wanted 2", lines)
+	}
+
+	params.File = filepath.Join(dir, "missing", "spans.json")
+	if _, err := newBuilder().NewSpansAspect(test.NewEnv(t), params); err == nil {
+		t.Error("NewSpansAspect() = _, nil; wanted err for an unwritable file")
+	}
+}
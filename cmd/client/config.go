@@ -0,0 +1,147 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/mixer/pkg/admin"
+	"istio.io/mixer/pkg/config"
+)
+
+func configCmd(outf outFn, errorf errorFn) *cobra.Command {
+	adminAddress := "localhost:9093"
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Lists the configs installed in the mixer and rolls back to one of them",
+	}
+	cmd.PersistentFlags().StringVarP(&adminAddress, "admin", "", adminAddress,
+		"Address and port of the admin endpoints of the mixer")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "history",
+		Short: "Lists the configs retained by the mixer, most recently installed first",
+		Run: func(cmd *cobra.Command, args []string) {
+			var h []config.HistoryEntry
+			if err := adminGet(adminAddress, admin.HistoryPath, &h); err != nil {
+				errorf("%v", err)
+				return
+			}
+			outf("%s", formatHistory(h))
+		},
+	})
+
+	pin := false
+	rollback := &cobra.Command{
+		Use:   "rollback <id>",
+		Short: "Installs a config listed by 'config history' again",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				errorf("A single config id must be specified")
+				return
+			}
+			if _, err := strconv.Atoi(args[0]); err != nil {
+				errorf("Invalid config id '%s'", args[0])
+				return
+			}
+			msg, err := adminPost(adminAddress, admin.RollbackPath, url.Values{"id": {args[0]}, "pin": {strconv.FormatBool(pin)}})
+			if err != nil {
+				errorf("%v", err)
+				return
+			}
+			outf("%s", msg)
+		},
+	}
+	rollback.Flags().BoolVarP(&pin, "pin", "", false,
+		"Whether to keep the config installed when the config files change, until 'config unpin'")
+	cmd.AddCommand(rollback)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "unpin",
+		Short: "Makes the mixer install the content of its config files again after a pinned rollback",
+		Run: func(cmd *cobra.Command, args []string) {
+			msg, err := adminPost(adminAddress, admin.UnpinPath, nil)
+			if err != nil {
+				errorf("%v", err)
+				return
+			}
+			outf("%s", msg)
+		},
+	})
+
+	return cmd
+}
+
+func formatHistory(h []config.HistoryEntry) string {
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tINSTALLED\tGLOBAL REVISION\tSERVICE REVISION\tSERVICE SHA\tACTIVE")
+	for _, e := range h {
+		active := ""
+		if e.Active {
+			active = "*"
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.InstalledAt.Format(time.RFC3339), e.GlobalRevision,
+			e.ServiceRevision, e.ServiceSHA, active)
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+func adminGet(address string, path string, v interface{}) error {
+	resp, err := http.Get("http://" + address + path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+func adminPost(address string, path string, form url.Values) (string, error) {
+	resp, err := http.PostForm("http://"+address+path, form)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return string(body), nil
+}
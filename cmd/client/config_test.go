@@ -0,0 +1,72 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/mixer/pkg/admin"
+	"istio.io/mixer/pkg/config"
+)
+
+func TestFormatHistory(t *testing.T) {
+	installed := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+	got := formatHistory([]config.HistoryEntry{
+		{ID: 2, InstalledAt: installed, ServiceRevision: "2023", ServiceSHA: "bbb", Active: true},
+		{ID: 1, InstalledAt: installed, ServiceRevision: "2022", ServiceSHA: "aaa"},
+	})
+
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("formatHistory() = %q; wanted a header and 2 entries", got)
+	}
+	if !strings.Contains(lines[1], "2017-06-01T10:00:00Z") || !strings.Contains(lines[1], "bbb") || !strings.HasSuffix(lines[1], "*") {
+		t.Errorf("formatHistory() entry = %q; wanted the active config 2", lines[1])
+	}
+	if strings.HasSuffix(lines[2], "*") {
+		t.Errorf("formatHistory() entry = %q; wanted an inactive config", lines[2])
+	}
+}
+
+func TestAdminRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == admin.HistoryPath:
+			_, _ = w.Write([]byte(`[{"id": 3, "serviceRevision": "2022", "active": true}]`))
+		case r.URL.Path == admin.RollbackPath && r.Method == http.MethodPost && r.FormValue("id") == "3":
+			_, _ = w.Write([]byte("rolled back to config 3\n"))
+		default:
+			http.Error(w, "config 4 is not in the history", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	var h []config.HistoryEntry
+	if err := adminGet(address, admin.HistoryPath, &h); err != nil || len(h) != 1 || h[0].ID != 3 || !h[0].Active {
+		t.Errorf("adminGet() = %v, history %v; wanted active config 3", err, h)
+	}
+	if msg, err := adminPost(address, admin.RollbackPath, url.Values{"id": {"3"}}); err != nil || !strings.Contains(msg, "config 3") {
+		t.Errorf("adminPost() = %q, %v; wanted a rollback message", msg, err)
+	}
+	if _, err := adminPost(address, admin.RollbackPath, url.Values{"id": {"4"}}); err == nil || !strings.Contains(err.Error(), "not in the history") {
+		t.Errorf("adminPost() = _, %v; wanted the server's error", err)
+	}
+}
@@ -118,6 +118,8 @@ func withArgs(args []string, outf outFn, errorf errorFn) {
 	rootCmd.AddCommand(checkCmd(rootArgs, outf, errorf))
 	rootCmd.AddCommand(reportCmd(rootArgs, outf, errorf))
 	rootCmd.AddCommand(quotaCmd(rootArgs, outf, errorf))
+	rootCmd.AddCommand(replayCmd(rootArgs, outf, errorf))
+	rootCmd.AddCommand(configCmd(outf, errorf))
 
 	if err := rootCmd.Execute(); err != nil {
 		errorf(err.Error())
@@ -0,0 +1,215 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/spf13/cobra"
+
+	mixerpb "istio.io/api/mixer/v1"
+	"istio.io/mixer/pkg/capture"
+)
+
+func replayCmd(rootArgs *rootArgs, outf outFn, errorf errorFn) *cobra.Command {
+	speed := 1.0
+
+	cmd := &cobra.Command{
+		Use:   "replay <capture file>",
+		Short: "Replays traffic captured by a mixer and reports the responses that differ from the recorded ones.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				errorf("A single capture file must be specified")
+				return
+			}
+			replay(rootArgs, outf, errorf, args[0], speed)
+		},
+	}
+
+	cmd.PersistentFlags().Float64VarP(&speed, "speed", "", 1.0,
+		"Pace of the replay relative to the captured traffic, 0 replays as fast as possible")
+
+	return cmd
+}
+
+// replayer sends captured entries to the mixer, opening a stream per API method as needed.
+type replayer struct {
+	ctx    context.Context
+	client mixerpb.MixerClient
+	check  mixerpb.Mixer_CheckClient
+	report mixerpb.Mixer_ReportClient
+	quota  mixerpb.Mixer_QuotaClient
+}
+
+// send replays a single entry and returns the resulting status and quota amount.
+func (r *replayer) send(index int64, e *capture.Entry) (rpc.Status, int64, error) {
+	var err error
+
+	switch e.Method {
+	case capture.CheckMethod:
+		if r.check == nil {
+			if r.check, err = r.client.Check(r.ctx); err != nil {
+				return rpc.Status{}, 0, err
+			}
+		}
+		if err = r.check.Send(&mixerpb.CheckRequest{RequestIndex: index, AttributeUpdate: e.Attributes}); err != nil {
+			return rpc.Status{}, 0, err
+		}
+		var resp *mixerpb.CheckResponse
+		if resp, err = r.check.Recv(); err != nil {
+			return rpc.Status{}, 0, err
+		}
+		return resp.Result, 0, nil
+
+	case capture.ReportMethod:
+		if r.report == nil {
+			if r.report, err = r.client.Report(r.ctx); err != nil {
+				return rpc.Status{}, 0, err
+			}
+		}
+		if err = r.report.Send(&mixerpb.ReportRequest{RequestIndex: index, AttributeUpdate: e.Attributes}); err != nil {
+			return rpc.Status{}, 0, err
+		}
+		var resp *mixerpb.ReportResponse
+		if resp, err = r.report.Recv(); err != nil {
+			return rpc.Status{}, 0, err
+		}
+		return resp.Result, 0, nil
+
+	case capture.QuotaMethod:
+		if r.quota == nil {
+			if r.quota, err = r.client.Quota(r.ctx); err != nil {
+				return rpc.Status{}, 0, err
+			}
+		}
+		request := mixerpb.QuotaRequest{RequestIndex: index, AttributeUpdate: e.Attributes}
+		if e.Quota != nil {
+			request.Quota = e.Quota.Quota
+			request.Amount = e.Quota.Amount
+			request.DeduplicationId = e.Quota.DeduplicationID
+			request.BestEffort = e.Quota.BestEffort
+		}
+		if err = r.quota.Send(&request); err != nil {
+			return rpc.Status{}, 0, err
+		}
+		var resp *mixerpb.QuotaResponse
+		if resp, err = r.quota.Recv(); err != nil {
+			return rpc.Status{}, 0, err
+		}
+		return resp.Result, resp.Amount, nil
+	}
+
+	return rpc.Status{}, 0, fmt.Errorf("unknown method '%s'", e.Method)
+}
+
+func (r *replayer) close() error {
+	var err error
+	if r.check != nil {
+		err = r.check.CloseSend()
+	}
+	if r.report != nil {
+		if e := r.report.CloseSend(); err == nil {
+			err = e
+		}
+	}
+	if r.quota != nil {
+		if e := r.quota.CloseSend(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// replayDelay returns how long to wait before replaying an entry captured at 'at', given
+// when the replay started and when the first entry was captured.
+func replayDelay(start time.Time, first time.Time, at time.Time, speed float64, now time.Time) time.Duration {
+	if speed <= 0 {
+		return 0
+	}
+	due := start.Add(time.Duration(float64(at.Sub(first)) / speed))
+	if d := due.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func replay(rootArgs *rootArgs, outf outFn, errorf errorFn, file string, speed float64) {
+	f, err := os.Open(file)
+	if err != nil {
+		errorf("Unable to open capture: %v", err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	var cs *clientState
+	if cs, err = createAPIClient(rootArgs.mixerAddress, rootArgs.enableTracing); err != nil {
+		errorf("Unable to establish connection to %s", rootArgs.mixerAddress)
+		return
+	}
+	defer deleteAPIClient(cs)
+
+	span, ctx := cs.tracer.StartRootSpan(context.Background(), "mixc Replay", ext.SpanKindRPCClient)
+	_, ctx = cs.tracer.PropagateSpan(ctx, span)
+	defer span.Finish()
+
+	r := &replayer{ctx: ctx, client: cs.client}
+	rd := capture.NewReader(f)
+
+	var start, first time.Time
+	count := 0
+	diffs := 0
+	for {
+		var e *capture.Entry
+		if e, err = rd.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			errorf("Unable to read entry %d of the capture: %v", count, err)
+			break
+		}
+
+		if count == 0 {
+			start = time.Now()
+			first = e.Time
+		}
+		time.Sleep(replayDelay(start, first, e.Time, speed, time.Now()))
+
+		var result rpc.Status
+		var amount int64
+		if result, amount, err = r.send(int64(count), e); err != nil {
+			errorf("Failed to replay entry %d (%s): %v", count, e.Method, err)
+			break
+		}
+
+		if result.Code != e.Result.Code || amount != e.Amount {
+			diffs++
+			outf("Entry %d (%s captured at %s with config %s): recorded %s amount %d, replayed %s amount %d\n",
+				count, e.Method, e.Time.Format(time.RFC3339Nano), e.ConfigSHA,
+				decodeStatus(e.Result), e.Amount, decodeStatus(result), amount)
+		}
+		count++
+	}
+
+	if err = r.close(); err != nil {
+		errorf("Failed to close gRPC stream: %v", err)
+	}
+
+	outf("Replayed %d requests, %d differences\n", count, diffs)
+}
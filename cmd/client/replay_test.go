@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+	"google.golang.org/grpc"
+
+	mixerpb "istio.io/api/mixer/v1"
+	"istio.io/mixer/pkg/capture"
+)
+
+// fakeMixer denies all checks, accepts all reports and grants all quota requests.
+type fakeMixer struct{}
+
+func (fakeMixer) Check(stream mixerpb.Mixer_CheckServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		resp := &mixerpb.CheckResponse{RequestIndex: req.RequestIndex, Result: rpc.Status{Code: int32(rpc.PERMISSION_DENIED)}}
+		if err = stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (fakeMixer) Report(stream mixerpb.Mixer_ReportServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err = stream.Send(&mixerpb.ReportResponse{RequestIndex: req.RequestIndex}); err != nil {
+			return err
+		}
+	}
+}
+
+func (fakeMixer) Quota(stream mixerpb.Mixer_QuotaServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err = stream.Send(&mixerpb.QuotaResponse{RequestIndex: req.RequestIndex, Amount: req.Amount}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	gs := grpc.NewServer()
+	mixerpb.RegisterMixerServer(gs, fakeMixer{})
+	go func() { _ = gs.Serve(listener) }()
+	defer gs.Stop()
+
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := path.Join(dir, "capture.json")
+	rec, err := capture.NewFileRecorder(file, nil)
+	if err != nil {
+		t.Fatalf("Unable to create capture: %v", err)
+	}
+	now := time.Now()
+	attrs := mixerpb.Attributes{Dictionary: map[int32]string{0: "a"}, StringAttributes: map[int32]string{0: "A"}, ResetContext: true}
+	rec.Record(&capture.Entry{Method: capture.CheckMethod, Time: now, Attributes: attrs})
+	rec.Record(&capture.Entry{Method: capture.ReportMethod, Time: now, Attributes: attrs})
+	rec.Record(&capture.Entry{Method: capture.QuotaMethod, Time: now, Attributes: attrs,
+		Quota: &capture.QuotaArgs{Quota: "q", Amount: 3}, Amount: 3})
+	rec.Record(&capture.Entry{Method: capture.QuotaMethod, Time: now, Attributes: attrs,
+		Quota: &capture.QuotaArgs{Quota: "q", Amount: 3}, Amount: 1})
+	if err = rec.Close(); err != nil {
+		t.Fatalf("Unable to close capture: %v", err)
+	}
+
+	var out []string
+	withArgs([]string{"replay", file, "--mixer", listener.Addr().String(), "--speed", "0"},
+		func(format string, a ...interface{}) {
+			out = append(out, fmt.Sprintf(format, a...))
+		},
+		func(format string, a ...interface{}) {
+			t.Errorf("Unexpected error: %s", fmt.Sprintf(format, a...))
+		})
+
+	if len(out) != 3 {
+		t.Fatalf("Got output %v, expecting two differences and a summary", out)
+	}
+	if !strings.Contains(out[0], "Entry 0 (Check") || !strings.Contains(out[0], "replayed PERMISSION_DENIED") {
+		t.Errorf("Got '%s', expecting a Check difference", out[0])
+	}
+	if !strings.Contains(out[1], "Entry 3 (Quota") {
+		t.Errorf("Got '%s', expecting a Quota difference", out[1])
+	}
+	if out[2] != "Replayed 4 requests, 2 differences\n" {
+		t.Errorf("Got summary '%s'", out[2])
+	}
+}
+
+func TestReplayDelay(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := start.Add(-time.Hour)
+
+	cases := []struct {
+		at    time.Duration
+		speed float64
+		now   time.Duration
+		want  time.Duration
+	}{
+		{10 * time.Second, 1, 0, 10 * time.Second},
+		{10 * time.Second, 2, 0, 5 * time.Second},
+		{10 * time.Second, 1, 4 * time.Second, 6 * time.Second},
+		{10 * time.Second, 1, 20 * time.Second, 0},
+		{10 * time.Second, 0, 0, 0},
+	}
+	for _, c := range cases {
+		got := replayDelay(start, first, first.Add(c.at), c.speed, start.Add(c.now))
+		if got != c.want {
+			t.Errorf("replayDelay(at %v, speed %v, now %v) = %v, expecting %v", c.at, c.speed, c.now, got, c.want)
+		}
+	}
+}
@@ -21,11 +21,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gogo/protobuf/types"
 	rpc "github.com/googleapis/googleapis/google/rpc"
 	bt "github.com/opentracing/basictracer-go"
 	"google.golang.org/grpc"
 
 	mixerpb "istio.io/api/mixer/v1"
+	"istio.io/mixer/pkg/adapterManager/explain"
 	"istio.io/mixer/pkg/tracing"
 )
 
@@ -280,5 +282,35 @@ func decodeStatus(status rpc.Status) string {
 		result = result + " (" + status.Message + ")"
 	}
 
+	for _, d := range status.Details {
+		e := &explain.Explanation{}
+		if !types.Is(d, e) || types.UnmarshalAny(d, e) != nil {
+			continue
+		}
+		for _, a := range e.Aspects {
+			result += "\n  " + decodeAspectResult(a)
+		}
+	}
+
+	return result
+}
+
+// decodeAspectResult describes the result of an aspect, as explained by the mixer.
+func decodeAspectResult(a *explain.AspectResult) string {
+	var latency time.Duration
+	if a.Latency != nil {
+		latency, _ = types.DurationFromProto(a.Latency)
+	}
+
+	var st rpc.Status
+	if a.Status != nil {
+		st = *a.Status
+	}
+
+	result := fmt.Sprintf("%s/%s (%s) selected by [%s]: %s in %v",
+		a.Kind, a.Adapter, a.Impl, strings.Join(a.Selectors, " > "), decodeStatus(st), latency)
+	if a.Shadow {
+		result += " [shadow]"
+	}
 	return result
 }
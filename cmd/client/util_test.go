@@ -20,8 +20,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gogo/protobuf/types"
 	rpc "github.com/googleapis/googleapis/google/rpc"
 
+	"istio.io/mixer/pkg/adapterManager/explain"
 	"istio.io/mixer/pkg/attribute"
 )
 
@@ -180,3 +182,25 @@ func TestDecodeStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeStatus_Explanation(t *testing.T) {
+	denied := rpc.Status{Code: int32(rpc.PERMISSION_DENIED), Message: "10.0.0.1 rejected"}
+	e := &explain.Explanation{Aspects: []*explain.AspectResult{
+		{
+			Kind:      "lists",
+			Adapter:   "blacklist",
+			Impl:      "ipListChecker",
+			Selectors: []string{"true", "source.ip != \"\""},
+			Status:    &denied,
+			Latency:   types.DurationProto(2 * time.Millisecond),
+			Shadow:    true,
+		},
+	}}
+	any, _ := types.MarshalAny(e)
+
+	got := decodeStatus(rpc.Status{Code: int32(rpc.OK), Details: []*types.Any{any}})
+	want := "OK\n  lists/blacklist (ipListChecker) selected by [true > source.ip != \"\"]: PERMISSION_DENIED (10.0.0.1 rejected) in 2ms [shadow]"
+	if got != want {
+		t.Errorf("decodeStatus() = %q; wanted %q", got, want)
+	}
+}
@@ -20,10 +20,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/golang/glog"
 	bt "github.com/opentracing/basictracer-go"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
@@ -32,31 +36,63 @@ import (
 	mixerpb "istio.io/api/mixer/v1"
 	"istio.io/mixer/adapter"
 	"istio.io/mixer/pkg/adapterManager"
+	"istio.io/mixer/pkg/admin"
 	"istio.io/mixer/pkg/api"
+	"istio.io/mixer/pkg/api/configservice"
 	"istio.io/mixer/pkg/aspect"
+	"istio.io/mixer/pkg/capture"
 	"istio.io/mixer/pkg/config"
 	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/monitoring"
 	"istio.io/mixer/pkg/pool"
 	"istio.io/mixer/pkg/tracing"
 )
 
 type serverArgs struct {
 	port                  uint
+	adminPort             uint
 	maxMessageSize        uint
 	maxConcurrentStreams  uint
 	apiWorkerPoolSize     uint
 	adapterWorkerPoolSize uint
+	minWorkers            uint
+	workerIdleTimeoutSec  uint
 	singleThreaded        bool
 	compressedPayload     bool
 	enableTracing         bool
+	traceZipkinURL        string
+	traceJaegerAgent      string
+	traceSampleRate       float64
+	enableIntrospection   bool
+	enableProfiling       bool
+	enableConfigRollback  bool
 	serverCertFile        string
 	serverKeyFile         string
 	clientCertFiles       string
+	captureFile           string
 
 	// mixer manager args
 	serviceConfigFile      string
 	globalConfigFile       string
 	configFetchIntervalSec uint
+	canaryPercent          uint
+	canaryAttribute        string
+	canaryMinRequests      uint
+	canaryMaxErrorIncrease float64
+	configHistorySize      uint
+	configAuditLog         string
+	allowConfigRegression  bool
+	enableConfigPush       bool
+
+	shutdownTimeoutSec uint
+
+	// admission control args
+	maxInFlightCheck   uint
+	maxInFlightReport  uint
+	maxInFlightQuota   uint
+	prioritySelector   string
+	priorityReserve    float64
+	shedRetryDelayMsec uint
 }
 
 func serverCmd(outf outFn, errorf errorFn) *cobra.Command {
@@ -74,29 +110,79 @@ func serverCmd(outf outFn, errorf errorFn) *cobra.Command {
 		},
 	}
 	serverCmd.PersistentFlags().UintVarP(&sa.port, "port", "p", 9091, "TCP port to use for the mixer's gRPC API")
+	serverCmd.PersistentFlags().UintVarP(&sa.adminPort, "adminPort", "", 9093, "TCP port for the mixer's admin HTTP endpoints")
+	serverCmd.PersistentFlags().BoolVarP(&sa.enableIntrospection, "enableIntrospection", "", false, "Whether to serve the installed "+
+		"config and adapters on the admin port")
+	serverCmd.PersistentFlags().BoolVarP(&sa.enableProfiling, "enableProfiling", "", false, "Whether to serve pprof profiles on the admin port")
+	serverCmd.PersistentFlags().BoolVarP(&sa.enableConfigRollback, "enableConfigRollback", "", false, "Whether to allow rolling back "+
+		"the installed config to a previous one through the admin port")
 	serverCmd.PersistentFlags().UintVarP(&sa.maxMessageSize, "maxMessageSize", "", 1024*1024, "Maximum size of individual gRPC messages")
 	serverCmd.PersistentFlags().UintVarP(&sa.maxConcurrentStreams, "maxConcurrentStreams", "", 32, "Maximum supported number of concurrent gRPC streams")
 	serverCmd.PersistentFlags().UintVarP(&sa.apiWorkerPoolSize, "apiWorkerPoolSize", "", 1024, "Max # of goroutines in the API worker pool")
 	serverCmd.PersistentFlags().UintVarP(&sa.adapterWorkerPoolSize, "adapterWorkerPoolSize", "", 1024, "Max # of goroutines in the adapter worker pool")
+	serverCmd.PersistentFlags().UintVarP(&sa.minWorkers, "minWorkers", "", 16, "Min # of goroutines kept in each worker pool when idle")
+	serverCmd.PersistentFlags().UintVarP(&sa.workerIdleTimeoutSec, "workerIdleTimeout", "", 60, "Seconds an idle goroutine waits for work "+
+		"before leaving its worker pool")
 	serverCmd.PersistentFlags().BoolVarP(&sa.singleThreaded, "singleThreaded", "", false, "Whether to run the mixer in single-threaded mode (useful "+
 		"for debugging)")
 	serverCmd.PersistentFlags().BoolVarP(&sa.compressedPayload, "compressedPayload", "", false, "Whether to compress gRPC messages")
 	serverCmd.PersistentFlags().StringVarP(&sa.serverCertFile, "serverCertFile", "", "", "The TLS cert file")
 	serverCmd.PersistentFlags().StringVarP(&sa.serverKeyFile, "serverKeyFile", "", "", "The TLS key file")
 	serverCmd.PersistentFlags().StringVarP(&sa.clientCertFiles, "clientCertFiles", "", "", "A set of comma-separated client X509 cert files")
-	// TODO: implement an option to specify how traces are reported (hardcoded to report to stdout right now).
-	serverCmd.PersistentFlags().BoolVarP(&sa.enableTracing, "trace", "", false, "Whether to trace rpc executions")
+	serverCmd.PersistentFlags().BoolVarP(&sa.enableTracing, "trace", "", false, "Whether to trace rpc executions and write the spans to stdout")
+	serverCmd.PersistentFlags().StringVarP(&sa.traceZipkinURL, "traceZipkinURL", "", "", "URL to which spans are posted in the Zipkin v2 "+
+		"JSON format, e.g. http://zipkin:9411/api/v2/spans")
+	serverCmd.PersistentFlags().StringVarP(&sa.traceJaegerAgent, "traceJaegerAgent", "", "", "host:port of the Jaeger agent to which spans "+
+		"are sent in the Thrift binary format, e.g. localhost:6832")
+	serverCmd.PersistentFlags().Float64VarP(&sa.traceSampleRate, "traceSampleRate", "", 1, "Fraction of the traces started by the mixer "+
+		"that are sampled")
+	serverCmd.PersistentFlags().StringVarP(&sa.captureFile, "captureFile", "", "", "File to which API traffic is captured for later replay, "+
+		"capture is disabled when empty")
 
 	// mixer manager args
 
 	serverCmd.PersistentFlags().StringVarP(&sa.serviceConfigFile, "serviceConfigFile", "", "serviceConfig.yml", "Combined Service Config")
 	serverCmd.PersistentFlags().StringVarP(&sa.globalConfigFile, "globalConfigFile", "", "globalConfig.yml", "Global Config")
 	serverCmd.PersistentFlags().UintVarP(&sa.configFetchIntervalSec, "configFetchInterval", "", 5, "Config fetch interval in seconds")
+	serverCmd.PersistentFlags().UintVarP(&sa.canaryPercent, "canaryPercent", "", 0, "Percentage of requests resolved against a new "+
+		"config while it is a canary, new configs are installed for all requests at once when 0")
+	serverCmd.PersistentFlags().StringVarP(&sa.canaryAttribute, "canaryAttribute", "", "source.name", "Attribute hashed to route "+
+		"requests to a canary config, requests with the same value use the same config")
+	serverCmd.PersistentFlags().UintVarP(&sa.canaryMinRequests, "canaryMinRequests", "", 1000, "Number of requests a canary config "+
+		"serves before it is promoted or rolled back")
+	serverCmd.PersistentFlags().Float64VarP(&sa.canaryMaxErrorIncrease, "canaryMaxErrorIncrease", "", 0.01, "Fraction of requests by "+
+		"which the error rate of a canary config may exceed the installed config's before it is rolled back")
+	serverCmd.PersistentFlags().UintVarP(&sa.configHistorySize, "configHistorySize", "", 10, "Number of installed configs "+
+		"retained for rollbacks")
+	serverCmd.PersistentFlags().StringVarP(&sa.configAuditLog, "configAuditLog", "", "", "File to which a record of every "+
+		"installation and rollback of config is appended")
+	serverCmd.PersistentFlags().BoolVarP(&sa.allowConfigRegression, "allowConfigRegression", "", false, "Whether to install "+
+		"configs whose revision is older than the installed config's, for intentional rollbacks of the config files")
+	serverCmd.PersistentFlags().BoolVarP(&sa.enableConfigPush, "enableConfigPush", "", false, "Whether to serve the gRPC "+
+		"config service, which installs pushed configs in place of the config files")
+
+	serverCmd.PersistentFlags().UintVarP(&sa.shutdownTimeoutSec, "shutdownTimeout", "", 10, "Seconds to wait for open gRPC streams "+
+		"to complete on shutdown before closing them")
+
+	// admission control args
+
+	serverCmd.PersistentFlags().UintVarP(&sa.maxInFlightCheck, "maxInFlightCheck", "", 0, "Max # of Check requests processed at once, "+
+		"0 for no limit beyond the API worker pool")
+	serverCmd.PersistentFlags().UintVarP(&sa.maxInFlightReport, "maxInFlightReport", "", 0, "Max # of Report requests processed at once, "+
+		"0 for no limit beyond the API worker pool")
+	serverCmd.PersistentFlags().UintVarP(&sa.maxInFlightQuota, "maxInFlightQuota", "", 0, "Max # of Quota requests processed at once, "+
+		"0 for no limit beyond the API worker pool")
+	serverCmd.PersistentFlags().StringVarP(&sa.prioritySelector, "prioritySelector", "", "", "Attribute expression selecting the high "+
+		"priority requests, which are shed last")
+	serverCmd.PersistentFlags().Float64VarP(&sa.priorityReserve, "priorityReserve", "", 0.2, "Fraction of each in-flight limit reserved "+
+		"for high priority requests")
+	serverCmd.PersistentFlags().UintVarP(&sa.shedRetryDelayMsec, "shedRetryDelay", "", 100, "Milliseconds clients are told to wait "+
+		"before retrying a shed request")
 
 	return &serverCmd
 }
 
-func runServer(sa *serverArgs) error {
+func runServer(sa *serverArgs) (err error) {
 	apiPoolSize := int(sa.apiWorkerPoolSize)
 	if apiPoolSize <= 0 {
 		return fmt.Errorf("api worker pool size must be >= 0 and <= 2^31-1, got pool size %d", apiPoolSize)
@@ -107,13 +193,22 @@ func runServer(sa *serverArgs) error {
 		return fmt.Errorf("adapter worker pool size must be >= 0 and <= 2^31-1, got pool size %d", adapterPoolSize)
 	}
 
-	gp := pool.NewGoroutinePool(apiPoolSize, sa.singleThreaded)
-	gp.AddWorkers(apiPoolSize)
-	defer gp.Close()
+	minWorkers := int(sa.minWorkers)
+	if minWorkers < 0 {
+		return fmt.Errorf("min workers must be >= 0 and <= 2^31-1, got %d", minWorkers)
+	}
+
+	idleTimeout := time.Second * time.Duration(sa.workerIdleTimeoutSec)
+	gp := pool.NewAdaptiveGoroutinePool(apiPoolSize, minInt(minWorkers, apiPoolSize), apiPoolSize, idleTimeout, sa.singleThreaded)
+	adapterGP := pool.NewAdaptiveGoroutinePool(adapterPoolSize, minInt(minWorkers, adapterPoolSize), adapterPoolSize,
+		idleTimeout, sa.singleThreaded)
 
-	adapterGP := pool.NewGoroutinePool(adapterPoolSize, sa.singleThreaded)
-	adapterGP.AddWorkers(adapterPoolSize)
-	defer adapterGP.Close()
+	if err := monitoring.RegisterPool("api", gp); err != nil {
+		glog.Warningf("Unable to export metrics for the API worker pool: %v", err)
+	}
+	if err := monitoring.RegisterPool("adapter", adapterGP); err != nil {
+		glog.Warningf("Unable to export metrics for the adapter worker pool: %v", err)
+	}
 
 	// get aspect registry with proper aspect --> api mappings
 	eval := expr.NewCEXLEvaluator()
@@ -121,9 +216,77 @@ func runServer(sa *serverArgs) error {
 	configManager := config.NewManager(eval, adapterMgr.AspectValidatorFinder(), adapterMgr.BuilderValidatorFinder(),
 		adapterMgr.AdapterToAspectMapperFunc(),
 		sa.globalConfigFile, sa.serviceConfigFile, time.Second*time.Duration(sa.configFetchIntervalSec))
+	if err = configManager.SetCanaryPolicy(config.CanaryPolicy{
+		Percent:              uint32(sa.canaryPercent),
+		Attribute:            sa.canaryAttribute,
+		MinRequests:          int64(sa.canaryMinRequests),
+		MaxErrorRateIncrease: sa.canaryMaxErrorIncrease,
+	}); err != nil {
+		return err
+	}
+	if err = configManager.SetHistorySize(int(sa.configHistorySize)); err != nil {
+		return err
+	}
+	configManager.SetAllowRevisionRegression(sa.allowConfigRegression)
+	if sa.configAuditLog != "" {
+		auditLog, err := os.OpenFile(sa.configAuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open config audit log: %v", err)
+		}
+		defer func() { _ = auditLog.Close() }()
+		configManager.SetAuditLog(auditLog)
+	}
+
+	// the recorder and the exporters are closed once the teardown below drained the
+	// pools, as the requests still in flight record to them.
+	var recorder capture.Recorder
+	if sa.captureFile != "" {
+		if recorder, err = capture.NewFileRecorder(sa.captureFile, configManager.ConfigSHA); err != nil {
+			return fmt.Errorf("failed to open capture file: %v", err)
+		}
+		defer func() { _ = recorder.Close() }()
+	}
+
+	tracer, exporters, err := newTracer(sa)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, e := range exporters {
+			_ = e.Close()
+		}
+	}()
+
+	// tear down in dependency order: queued API work may still schedule adapter work,
+	// and both pools must be drained before the adapters get closed.
+	defer func() {
+		configManager.Close()
+		gp.Close()
+		adapterGP.Close()
+		if cerr := adapterMgr.Close(); cerr != nil {
+			glog.Errorf("Unable to close adapters: %v", cerr)
+			if err == nil {
+				err = fmt.Errorf("failed to close adapters: %v", cerr)
+			}
+		}
+	}()
 
 	handler := api.NewHandler(adapterMgr, adapterMgr.MethodMap())
 
+	admission, err := api.NewAdmissionController(api.AdmissionOptions{
+		MaxInFlight: map[aspect.APIMethod]int{
+			aspect.CheckMethod:  int(sa.maxInFlightCheck),
+			aspect.ReportMethod: int(sa.maxInFlightReport),
+			aspect.QuotaMethod:  int(sa.maxInFlightQuota),
+		},
+		PrioritySelector: sa.prioritySelector,
+		PriorityReserve:  sa.priorityReserve,
+		RetryDelay:       time.Millisecond * time.Duration(sa.shedRetryDelayMsec),
+	}, eval)
+	if err != nil {
+		return err
+	}
+
 	var serverCert *tls.Certificate
 	var clientCerts *x509.CertPool
 
@@ -152,6 +315,24 @@ func runServer(sa *serverArgs) error {
 		return err
 	}
 
+	defer func() { _ = listener.Close() }()
+
+	adminListener, err := net.Listen("tcp", fmt.Sprintf(":%d", uint16(sa.adminPort)))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = adminListener.Close() }()
+	adminHandler := admin.NewHandler(configManager, adapterMgr, admin.Options{
+		EnableIntrospection:  sa.enableIntrospection,
+		EnableProfiling:      sa.enableProfiling,
+		EnableConfigRollback: sa.enableConfigRollback,
+	})
+	go func() {
+		if err := http.Serve(adminListener, adminHandler); err != nil {
+			glog.Infof("Admin HTTP server stopped: %v", err)
+		}
+	}()
+
 	// construct the gRPC options
 
 	var grpcOptions []grpc.ServerOption
@@ -178,20 +359,92 @@ func runServer(sa *serverArgs) error {
 		grpcOptions = append(grpcOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
 
-	var tracer tracing.Tracer
-	if sa.enableTracing {
-		tracer = tracing.NewTracer(bt.New(tracing.IORecorder(os.Stdout)))
-	} else {
-		tracer = tracing.DisabledTracer()
-	}
-
+	// the adapter manager sizes the adapter pools before the handler starts using the new config
+	configManager.Register(adapterMgr)
 	configManager.Register(handler.(config.ChangeListener))
 	configManager.Start()
 
 	// get everything wired up
 	gs := grpc.NewServer(grpcOptions...)
-	s := api.NewGRPCServer(handler, tracer, gp)
+	s := api.NewGRPCServer(handler, tracer, gp, recorder, admission, configManager.Revisions)
 	mixerpb.RegisterMixerServer(gs, s)
+	if sa.enableConfigPush {
+		configservice.RegisterConfigServiceServer(gs, api.NewConfigServer(configManager))
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- gs.Serve(listener) }()
 
-	return gs.Serve(listener)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	select {
+	case err = <-serveErr:
+		glog.Errorf("gRPC server failed, shutting down: %v", err)
+	case sig := <-sigs:
+		glog.Infof("Received %v, shutting down", sig)
+	}
+	stopGRPC(gs, time.Second*time.Duration(sa.shutdownTimeoutSec))
+
+	return err
+}
+
+// stopGRPC stops accepting new streams and waits for the open ones to complete. Streams
+// still open once the timeout expires are closed. It returns once all stream handlers have
+// returned, so no more work gets scheduled on the pools.
+func stopGRPC(gs *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		glog.Warningf("gRPC streams still open after %v, closing them", timeout)
+		gs.Stop()
+		<-done
+	}
+}
+
+// newTracer sets up tracing as requested by the command line, returning the exporters to close on shutdown.
+func newTracer(sa *serverArgs) (tracing.Tracer, []tracing.Exporter, error) {
+	var recorders []bt.SpanRecorder
+	var exporters []tracing.Exporter
+
+	if sa.enableTracing {
+		recorders = append(recorders, tracing.IORecorder(os.Stdout))
+	}
+
+	if sa.traceZipkinURL != "" {
+		e := tracing.NewZipkinExporter(sa.traceZipkinURL, tracing.DefaultExporterOptions())
+		recorders = append(recorders, e)
+		exporters = append(exporters, e)
+	}
+
+	if sa.traceJaegerAgent != "" {
+		e, err := tracing.NewJaegerExporter(sa.traceJaegerAgent, tracing.DefaultExporterOptions())
+		if err != nil {
+			for _, e := range exporters {
+				_ = e.Close()
+			}
+			return tracing.Tracer{}, nil, err
+		}
+		recorders = append(recorders, e)
+		exporters = append(exporters, e)
+	}
+
+	if len(recorders) == 0 {
+		return tracing.DisabledTracer(), nil, nil
+	}
+	return tracing.NewRecordingTracer(tracing.MultiRecorder(recorders...), sa.traceSampleRate), exporters, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
 }
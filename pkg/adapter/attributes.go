@@ -0,0 +1,36 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+type (
+	// AttributesGenerator produces new attribute values from a set of input values.
+	// Generators run before configuration rules are resolved, so the attributes they
+	// produce can be used in selectors as well as by every other aspect.
+	AttributesGenerator interface {
+		Aspect
+
+		// Generate returns a set of named output values derived from the supplied
+		// named input values. Outputs that cannot be determined are simply omitted.
+		Generate(inputs map[string]interface{}) (map[string]interface{}, error)
+	}
+
+	// AttributesGeneratorBuilder builds instances of the AttributesGenerator aspect.
+	AttributesGeneratorBuilder interface {
+		Builder
+
+		// NewAttributesGenerator returns a new instance of the AttributesGenerator aspect.
+		NewAttributesGenerator(env Env, c Config) (AttributesGenerator, error)
+	}
+)
@@ -0,0 +1,58 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+type (
+	// AuthorizationAspect decides whether a request is allowed by a set of access policies.
+	AuthorizationAspect interface {
+		Aspect
+
+		// Authorize decides whether the subject of the request may perform its action on its resource.
+		// Policy conditions are evaluated against the attributes of the request using eval.
+		Authorize(req AuthorizationRequest, eval ConditionEvaluator) (AuthorizationDecision, error)
+	}
+
+	// AuthorizationRequest describes the access being authorized. It is synthesized
+	// by the mixer, based on mixer config and the attributes passed to Check().
+	AuthorizationRequest struct {
+		// Subject is the identity making the request.
+		Subject string
+		// Action is the operation being performed.
+		Action string
+		// Resource is the object the operation is performed on.
+		Resource string
+	}
+
+	// AuthorizationDecision is the outcome of an authorization.
+	AuthorizationDecision struct {
+		// Allowed is true when the request may proceed.
+		Allowed bool
+		// Rule names the policy rule that made the decision. It is empty when
+		// no rule matched and the policy's default applied.
+		Rule string
+	}
+
+	// ConditionEvaluator evaluates a boolean expression against the attributes
+	// of the request being authorized.
+	ConditionEvaluator func(condition string) (bool, error)
+
+	// AuthorizationBuilder builds instances of the Authorization aspect.
+	AuthorizationBuilder interface {
+		Builder
+
+		// NewAuthorizationAspect returns a new instance of the Authorization aspect.
+		NewAuthorizationAspect(env Env, c Config) (AuthorizationAspect, error)
+	}
+)
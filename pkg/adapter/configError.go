@@ -43,6 +43,9 @@ type ConfigErrors struct {
 type ConfigError struct {
 	Field      string
 	Underlying error
+	// Location is where the field is in the config source, as file:line:column, when known.
+	// The config system sets it, adapters leave it empty.
+	Location string
 }
 
 // Appendf adds a ConfigError to a multierror. This function is intended
@@ -55,7 +58,7 @@ func (e *ConfigErrors) Appendf(field, format string, args ...interface{}) *Confi
 		ce = &ConfigErrors{}
 	}
 
-	ce.Multi = me.Append(ce.Multi, ConfigError{field, fmt.Errorf(format, args...)})
+	ce.Multi = me.Append(ce.Multi, ConfigError{Field: field, Underlying: fmt.Errorf(format, args...)})
 	return ce
 }
 
@@ -69,12 +72,15 @@ func (e *ConfigErrors) Append(field string, err error) *ConfigErrors {
 		ce = &ConfigErrors{}
 	}
 
-	ce.Multi = me.Append(ce.Multi, ConfigError{field, err})
+	ce.Multi = me.Append(ce.Multi, ConfigError{Field: field, Underlying: err})
 	return ce
 }
 
 // Error returns a string representation of the configuration error.
 func (e ConfigError) Error() string {
+	if e.Location != "" {
+		return fmt.Sprintf("%s: %s: %s", e.Location, e.Field, e.Underlying)
+	}
 	return fmt.Sprintf("%s: %s", e.Field, e.Underlying)
 }
 
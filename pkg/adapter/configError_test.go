@@ -49,6 +49,13 @@ func TestConfigErrors(t *testing.T) {
 	t.Log(ce)
 }
 
+func TestConfigErrorLocation(t *testing.T) {
+	err := ConfigError{Field: "F0", Underlying: fmt.Errorf("format 0"), Location: "service.yml:3:5"}
+	if err.Error() != "service.yml:3:5: F0: format 0" {
+		t.Errorf("Error() returns '%s', expected the location first", err.Error())
+	}
+}
+
 func TestNil(t *testing.T) {
 	var ce *ConfigErrors
 	ce = ce.Appendf("Foo", "format %d", 0)
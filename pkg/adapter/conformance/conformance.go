@@ -0,0 +1,354 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance checks that an adapter obeys the contract of the adapter
+// package, so that third-party adapters can be verified before they're added
+// to a mixer's inventory. Adapters run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, Register, conformance.Options{})
+//	}
+//
+// For each builder the adapter registers, Run checks that:
+//
+//   - the builder has a name and a description
+//   - its default config is valid, and the given invalid configs are rejected with ConfigErrors
+//   - it creates an aspect of its kind, whose Close returns nil and can be called again
+//   - the functions aspects schedule through Env return once the aspects and
+//     their builder are closed
+//   - configs are validated, and aspects created and closed, concurrently
+//   - the builder's Close can be called again
+//
+// and finally that the adapter leaves no goroutine of its own running.
+// Adapters must schedule their goroutines through Env, goroutines they start
+// otherwise are expected to be gone once their aspects and builders are closed.
+//
+// Races are only reported when the tests run with the race detector. The
+// goroutine check expects no other test to run in parallel with Run.
+package conformance
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+const (
+	defaultConcurrency = 8
+	defaultTimeout     = 5 * time.Second
+)
+
+// goroutines of the Go runtime and standard library, started on demand.
+var ignoredGoroutines = []string{
+	"created by runtime.",
+	"created by os/signal.",
+}
+
+// Options tune the checks for an adapter.
+type Options struct {
+	// Configs replaces the default config of the named builders when creating
+	// aspects, for builders whose default config can't be used by tests.
+	Configs map[string]adapter.Config
+
+	// InvalidConfigs are configs that ValidateConfig of the named builders must reject.
+	InvalidConfigs map[string][]adapter.Config
+
+	// Metrics are the definitions passed to NewMetricsAspect.
+	Metrics map[string]*adapter.MetricDefinition
+
+	// Quotas are the definitions passed to NewQuotasAspect.
+	Quotas map[string]*adapter.QuotaDefinition
+
+	// Concurrency is the number of aspects of each builder created and closed
+	// concurrently, 8 when zero.
+	Concurrency int
+
+	// Timeout bounds the waits for scheduled functions and goroutines to
+	// stop once closed, 5s when zero.
+	Timeout time.Duration
+
+	// IgnoreGoroutines are substrings of the stacks of goroutines which are
+	// not leaks, such as idle connections kept by http.DefaultTransport.
+	IgnoreGoroutines []string
+}
+
+// reporter is the part of testing.T used by the checks.
+type reporter interface {
+	Errorf(format string, args ...interface{})
+	Logf(format string, args ...interface{})
+}
+
+// Run checks the builders registered by r, reporting violations of the contract as errors of t.
+func Run(t *testing.T, r adapter.RegisterFn, opts Options) {
+	run(t, r, opts)
+}
+
+type checker struct {
+	r    reporter
+	opts Options
+
+	lock sync.Mutex // guards envs
+	envs []*env
+}
+
+func run(rep reporter, r adapter.RegisterFn, opts Options) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	c := &checker{r: rep, opts: opts}
+	defer c.silence()
+
+	before := goroutines()
+
+	reg := &registrar{opts: &c.opts}
+	if err := safeCall(func() error { r(reg); return nil }); err != nil {
+		rep.Errorf("Register() => %v", err)
+		return
+	}
+	if len(reg.builders) == 0 {
+		rep.Errorf("Register() => adapter didn't register any builders")
+		return
+	}
+
+	// builders are closed once all of their kinds are checked
+	var builders []adapter.Builder
+	byName := make(map[string]adapter.Builder)
+	for _, b := range reg.builders {
+		name := b.b.Name()
+		if other, found := byName[name]; !found {
+			if name == "" {
+				rep.Errorf("Name() => all builders need names")
+			}
+			byName[name] = b.b
+			builders = append(builders, b.b)
+		} else if other != b.b {
+			rep.Errorf("Name() => builder name '%s' is registered by different builders", name)
+			builders = append(builders, b.b)
+		}
+		c.checkBuilder(b)
+	}
+
+	for _, b := range builders {
+		c.checkClose(b)
+	}
+
+	c.checkEnvs()
+	c.checkGoroutines(before)
+}
+
+func (c *checker) checkBuilder(b builder) {
+	name := b.b.Name()
+	if b.b.Description() == "" {
+		c.r.Errorf("Description() => builder '%s' doesn't provide a valid description", name)
+	}
+
+	var cfg adapter.Config
+	if err := safeCall(func() error { cfg = b.b.DefaultConfig(); return nil }); err != nil || cfg == nil {
+		c.r.Errorf("DefaultConfig() => builder '%s' doesn't provide a default configuration: %v", name, err)
+		return
+	}
+	if ce, err := c.validate(b.b, cfg); err != nil || ce != nil {
+		c.r.Errorf("ValidateConfig() => builder '%s' can't validate its default configuration: %v", name, firstErr(err, ce))
+	}
+
+	for idx, ic := range c.opts.InvalidConfigs[name] {
+		ce, err := c.validate(b.b, ic)
+		if err != nil {
+			c.r.Errorf("ValidateConfig() => builder '%s' can't validate invalid config [%d]: %v", name, idx, err)
+		} else if ce == nil || ce.Multi == nil || len(ce.Multi.Errors) == 0 {
+			c.r.Errorf("ValidateConfig() => builder '%s' accepts invalid config [%d] %v", name, idx, ic)
+		}
+	}
+
+	if ac, found := c.opts.Configs[name]; found {
+		cfg = ac
+	}
+	if !c.checkAspect(b, cfg, fmt.Sprintf("%s/%s", name, b.kind)) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			label := fmt.Sprintf("%s/%s#%d", name, b.kind, i)
+			if _, err := c.validate(b.b, cfg); err != nil {
+				c.r.Errorf("ValidateConfig() => builder '%s' fails when called concurrently: %v", name, err)
+			}
+			c.checkAspect(b, cfg, label)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// checkAspect creates an aspect of b and closes it, twice. It returns
+// whether the aspect could be created.
+func (c *checker) checkAspect(b builder, cfg adapter.Config, label string) bool {
+	name := b.b.Name()
+	e := c.newEnv(label)
+
+	var asp adapter.Aspect
+	err := safeCall(func() error {
+		var err error
+		asp, err = b.newAspect(e, cfg)
+		return err
+	})
+	if err != nil {
+		c.r.Errorf("NewAspect() => builder '%s' can't create a %s aspect: %v", name, b.kind, err)
+		return false
+	}
+	if asp == nil {
+		c.r.Errorf("NewAspect() => builder '%s' returned a nil %s aspect", name, b.kind)
+		return false
+	}
+
+	if err = safeCall(asp.Close); err != nil {
+		c.r.Errorf("Close() => %s aspect of builder '%s' fails to close: %v", b.kind, name, err)
+	}
+	if err = safeCall(func() error { _ = asp.Close(); return nil }); err != nil {
+		c.r.Errorf("Close() => %s aspect of builder '%s' can't be closed twice: %v", b.kind, name, err)
+	}
+	return true
+}
+
+func (c *checker) checkClose(b adapter.Builder) {
+	if err := safeCall(b.Close); err != nil {
+		c.r.Errorf("Close() => builder '%s' fails to close: %v", b.Name(), err)
+	}
+	if err := safeCall(func() error { _ = b.Close(); return nil }); err != nil {
+		c.r.Errorf("Close() => builder '%s' can't be closed twice: %v", b.Name(), err)
+	}
+}
+
+// checkEnvs reports the aspects whose scheduled functions are still running.
+// Functions are allowed to run until the builder of their aspect is closed, as
+// state shared by the aspects of a builder may be scheduled by any of them.
+func (c *checker) checkEnvs() {
+	deadline := time.Now().Add(c.opts.Timeout)
+	c.lock.Lock()
+	envs := c.envs
+	c.lock.Unlock()
+	for _, e := range envs {
+		if n := e.wait(deadline.Sub(time.Now())); n > 0 {
+			c.r.Errorf("Close() => aspect %s leaves %d function(s) scheduled through Env running once closed", e.label, n)
+		}
+	}
+}
+
+// checkGoroutines reports the goroutines started since before which are still running.
+func (c *checker) checkGoroutines(before map[string]string) {
+	var leaked []string
+	deadline := time.Now().Add(c.opts.Timeout)
+	for {
+		leaked = leaked[:0]
+		for id, stack := range goroutines() {
+			if _, found := before[id]; !found && !c.ignored(stack) {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, stack := range leaked {
+		c.r.Errorf("adapter leaves a goroutine running once closed:\n%s", stack)
+	}
+}
+
+func (c *checker) ignored(stack string) bool {
+	// scheduled through Env, reported by checkAspect
+	if strings.Contains(stack, "conformance.(*env).Schedule") {
+		return true
+	}
+	for _, ignored := range [][]string{ignoredGoroutines, c.opts.IgnoreGoroutines} {
+		for _, s := range ignored {
+			if strings.Contains(stack, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *checker) newEnv(label string) *env {
+	e := newEnv(c.r, label)
+	c.lock.Lock()
+	c.envs = append(c.envs, e)
+	c.lock.Unlock()
+	return e
+}
+
+// silence stops the envs from logging once the test is over.
+func (c *checker) silence() {
+	c.lock.Lock()
+	for _, e := range c.envs {
+		e.silence()
+	}
+	c.lock.Unlock()
+}
+
+func (c *checker) validate(b adapter.Builder, cfg adapter.Config) (ce *adapter.ConfigErrors, err error) {
+	err = safeCall(func() error { ce = b.ValidateConfig(cfg); return nil })
+	return ce, err
+}
+
+// safeCall calls fn, turning its panics into errors.
+func safeCall(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panicked with '%v'", r)
+		}
+	}()
+	return fn()
+}
+
+func firstErr(err error, ce *adapter.ConfigErrors) error {
+	if err != nil {
+		return err
+	}
+	return ce
+}
+
+// goroutines returns the stacks of the running goroutines by their ids.
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		// goroutine 7 [chan receive]:
+		f := strings.Fields(stack)
+		if len(f) > 1 && f[0] == "goroutine" {
+			stacks[f[1]] = stack
+		}
+	}
+	return stacks
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+type config struct {
+	invalid bool
+}
+
+func (*config) Reset()         {}
+func (*config) String() string { return "config" }
+func (*config) ProtoMessage()  {}
+
+// fakeBuilder builds denials aspects, misbehaving as told.
+type fakeBuilder struct {
+	name          string
+	desc          string
+	defaultConfig adapter.Config
+	acceptInvalid bool
+	newErr        error
+	leakDaemon    bool
+	nakedDaemon   bool
+	sharedDaemon  bool // runs until the builder is closed
+	closeOnce     bool
+
+	once    sync.Once
+	closing chan struct{}
+	stop    chan struct{} // stops the leaked goroutines once the test is over
+}
+
+func (b *fakeBuilder) Name() string                  { return b.name }
+func (b *fakeBuilder) Description() string           { return b.desc }
+func (b *fakeBuilder) DefaultConfig() adapter.Config { return b.defaultConfig }
+
+func (b *fakeBuilder) Close() error {
+	b.once.Do(func() { close(b.closing) })
+	return nil
+}
+
+func (b *fakeBuilder) ValidateConfig(c adapter.Config) (ce *adapter.ConfigErrors) {
+	if c.(*config).invalid && !b.acceptInvalid {
+		ce = ce.Appendf("invalid", "must be false")
+	}
+	return
+}
+
+func (b *fakeBuilder) NewDenialsAspect(env adapter.Env, c adapter.Config) (adapter.DenialsAspect, error) {
+	if b.newErr != nil {
+		return nil, b.newErr
+	}
+	d := &denier{closing: make(chan struct{})}
+	if b.closeOnce {
+		d.closed = make(chan struct{})
+	}
+	daemon := func() {
+		select {
+		case <-d.closing:
+		case <-b.stop:
+		}
+	}
+	if b.leakDaemon {
+		daemon = func() { <-b.stop }
+	}
+	if b.sharedDaemon {
+		daemon = func() {
+			select {
+			case <-b.closing:
+			case <-b.stop:
+			}
+		}
+	}
+	if b.nakedDaemon {
+		go daemon()
+	} else {
+		env.ScheduleDaemon(daemon)
+	}
+	return d, nil
+}
+
+type denier struct {
+	once    sync.Once
+	closing chan struct{}
+	closed  chan struct{} // closed on each Close when not nil
+}
+
+func (d *denier) Deny() rpc.Status { return rpc.Status{Code: int32(rpc.FAILED_PRECONDITION)} }
+
+func (d *denier) Close() error {
+	d.once.Do(func() { close(d.closing) })
+	if d.closed != nil {
+		close(d.closed)
+	}
+	return nil
+}
+
+// recorder records the errors reported by the checks.
+type recorder struct {
+	lock   sync.Mutex
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.lock.Lock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+	r.lock.Unlock()
+}
+
+func (r *recorder) Logf(format string, args ...interface{}) {}
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		b    *fakeBuilder
+		opts Options
+		want []string
+	}{
+		{&fakeBuilder{}, Options{}, nil},
+		{&fakeBuilder{name: ""}, Options{}, []string{"need names"}},
+		{&fakeBuilder{desc: ""}, Options{}, []string{"valid description"}},
+		{&fakeBuilder{defaultConfig: &config{invalid: true}}, Options{}, []string{"can't validate its default configuration"}},
+		{&fakeBuilder{}, Options{InvalidConfigs: map[string][]adapter.Config{"fake": {&config{invalid: true}}}}, nil},
+		{&fakeBuilder{acceptInvalid: true}, Options{InvalidConfigs: map[string][]adapter.Config{"fake": {&config{invalid: true}}}},
+			[]string{"accepts invalid config [0]"}},
+		{&fakeBuilder{newErr: errors.New("no backend")}, Options{}, []string{"can't create a denials aspect: no backend"}},
+		{&fakeBuilder{newErr: errors.New("no backend")}, Options{Configs: map[string]adapter.Config{"fake": &config{}}},
+			[]string{"no backend"}},
+		{&fakeBuilder{leakDaemon: true}, Options{}, []string{"leaves 1 function(s) scheduled through Env running once closed"}},
+		{&fakeBuilder{sharedDaemon: true}, Options{}, nil},
+		{&fakeBuilder{closeOnce: true}, Options{}, []string{"can't be closed twice: panicked with 'close of closed channel'"}},
+		{&fakeBuilder{nakedDaemon: true, leakDaemon: true}, Options{}, []string{"leaves a goroutine running", "conformance.(*fakeBuilder).NewDenialsAspect"}},
+		{&fakeBuilder{nakedDaemon: true, leakDaemon: true}, Options{IgnoreGoroutines: []string{"NewDenialsAspect"}}, nil},
+		{&fakeBuilder{nakedDaemon: true}, Options{}, nil},
+	}
+
+	for idx, c := range cases {
+		b := c.b
+		if b.name == "" && !strings.Contains(strings.Join(c.want, ""), "need names") {
+			b.name = "fake"
+		}
+		if b.desc == "" && !strings.Contains(strings.Join(c.want, ""), "description") {
+			b.desc = "a fake adapter"
+		}
+		if b.defaultConfig == nil {
+			b.defaultConfig = &config{}
+		}
+		b.closing, b.stop = make(chan struct{}), make(chan struct{})
+		c.opts.Concurrency = 2
+		c.opts.Timeout = 100 * time.Millisecond
+
+		rec := &recorder{}
+		run(rec, func(r adapter.Registrar) { r.RegisterDenialsBuilder(b) }, c.opts)
+		close(b.stop)
+
+		got := strings.Join(rec.errors, "\n")
+		if len(c.want) == 0 && got != "" {
+			t.Errorf("[%d] run() reported:\n%s\nwanted no errors", idx, got)
+		}
+		for _, w := range c.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("[%d] run() reported:\n%s\nwanted %q", idx, got, w)
+			}
+		}
+	}
+}
+
+func TestRun_NoBuilders(t *testing.T) {
+	rec := &recorder{}
+	run(rec, func(adapter.Registrar) {}, Options{})
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "didn't register any builders") {
+		t.Errorf("run() reported %v; wanted the missing builders", rec.errors)
+	}
+
+	rec = &recorder{}
+	run(rec, func(adapter.Registrar) { panic("oops") }, Options{})
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "Register() => panicked with 'oops'") {
+		t.Errorf("run() reported %v; wanted the panic", rec.errors)
+	}
+}
+
+func TestRun_DuplicateNames(t *testing.T) {
+	b1 := &fakeBuilder{name: "fake", desc: "fake", defaultConfig: &config{}, closing: make(chan struct{})}
+	b2 := &fakeBuilder{name: "fake", desc: "fake", defaultConfig: &config{}, closing: make(chan struct{})}
+	rec := &recorder{}
+	run(rec, func(r adapter.Registrar) {
+		r.RegisterDenialsBuilder(b1)
+		r.RegisterDenialsBuilder(b1)
+		r.RegisterDenialsBuilder(b2)
+	}, Options{Timeout: 100 * time.Millisecond})
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "registered by different builders") {
+		t.Errorf("run() reported %v; wanted the duplicate name", rec.errors)
+	}
+}
+
+func TestRun_Adapter(t *testing.T) {
+	Run(t, func(r adapter.Registrar) {
+		r.RegisterDenialsBuilder(&fakeBuilder{name: "fake", desc: "fake", defaultConfig: &config{}, closing: make(chan struct{})})
+	}, Options{})
+}
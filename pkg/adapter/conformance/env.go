@@ -0,0 +1,131 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// env is the environment of a single aspect. It tracks the functions the
+// aspect schedules, so that they can be checked to stop once it's closed.
+type env struct {
+	r     reporter
+	label string
+
+	lock     sync.Mutex // guards running and silenced
+	running  int
+	idle     *sync.Cond
+	silenced bool
+}
+
+func newEnv(r reporter, label string) *env {
+	e := &env{r: r, label: label}
+	e.idle = sync.NewCond(&e.lock)
+	return e
+}
+
+// Logger returns a logger that writes to the test's log.
+func (e *env) Logger() adapter.Logger {
+	return e
+}
+
+// ScheduleWork runs the given function asynchronously.
+func (e *env) ScheduleWork(fn adapter.WorkFunc) {
+	e.start()
+	go func() {
+		defer e.done()
+		fn()
+	}()
+}
+
+// ScheduleDaemon runs the given function asynchronously.
+func (e *env) ScheduleDaemon(fn adapter.DaemonFunc) {
+	e.start()
+	go func() {
+		defer e.done()
+		fn()
+	}()
+}
+
+func (e *env) start() {
+	e.lock.Lock()
+	e.running++
+	e.lock.Unlock()
+}
+
+func (e *env) done() {
+	e.lock.Lock()
+	e.running--
+	if e.running == 0 {
+		e.idle.Broadcast()
+	}
+	e.lock.Unlock()
+}
+
+// wait waits for the scheduled functions to return, at most for timeout. It
+// returns the number of functions still running.
+func (e *env) wait(timeout time.Duration) int {
+	t := time.AfterFunc(timeout, func() {
+		e.lock.Lock()
+		e.idle.Broadcast()
+		e.lock.Unlock()
+	})
+	defer t.Stop()
+
+	deadline := time.Now().Add(timeout)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for e.running > 0 && time.Now().Before(deadline) {
+		e.idle.Wait()
+	}
+	return e.running
+}
+
+// Infof logs the provided message.
+func (e *env) Infof(format string, args ...interface{}) {
+	e.log("I", format, args...)
+}
+
+// Warningf logs the provided message.
+func (e *env) Warningf(format string, args ...interface{}) {
+	e.log("W", format, args...)
+}
+
+// Errorf logs the provided message and returns it as an error.
+func (e *env) Errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s", e.log("E", format, args...))
+}
+
+// silence drops the messages logged from now on, as leaked daemons may log
+// once the test is over.
+func (e *env) silence() {
+	e.lock.Lock()
+	e.silenced = true
+	e.lock.Unlock()
+}
+
+func (e *env) log(severity string, format string, args ...interface{}) string {
+	s := fmt.Sprintf(format, args...)
+	e.lock.Lock()
+	if !e.silenced {
+		e.r.Logf("%s %s: %s", severity, e.label, s)
+	}
+	e.lock.Unlock()
+	return s
+}
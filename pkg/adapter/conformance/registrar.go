@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"istio.io/mixer/pkg/adapter"
+)
+
+// newAspectFn creates an aspect of a builder with the given config.
+type newAspectFn func(env adapter.Env, c adapter.Config) (adapter.Aspect, error)
+
+// builder is a registered builder along with the kind of aspects it creates.
+type builder struct {
+	kind      string
+	b         adapter.Builder
+	newAspect newAspectFn
+}
+
+// registrar collects the builders of an adapter, wrapping their kind specific
+// constructors so that they can be checked alike.
+type registrar struct {
+	opts     *Options
+	builders []builder
+}
+
+func (r *registrar) add(kind string, b adapter.Builder, fn newAspectFn) {
+	r.builders = append(r.builders, builder{kind, b, fn})
+}
+
+func (r *registrar) RegisterListsBuilder(b adapter.ListsBuilder) {
+	r.add("lists", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewListsAspect(env, c)
+	})
+}
+
+func (r *registrar) RegisterDenialsBuilder(b adapter.DenialsBuilder) {
+	r.add("denials", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewDenialsAspect(env, c)
+	})
+}
+
+func (r *registrar) RegisterApplicationLogsBuilder(b adapter.ApplicationLogsBuilder) {
+	r.add("application-logs", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewApplicationLogsAspect(env, c)
+	})
+}
+
+func (r *registrar) RegisterAccessLogsBuilder(b adapter.AccessLogsBuilder) {
+	r.add("access-logs", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewAccessLogsAspect(env, c)
+	})
+}
+
+func (r *registrar) RegisterQuotasBuilder(b adapter.QuotasBuilder) {
+	r.add("quotas", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewQuotasAspect(env, c, r.opts.Quotas)
+	})
+}
+
+func (r *registrar) RegisterMetricsBuilder(b adapter.MetricsBuilder) {
+	r.add("metrics", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewMetricsAspect(env, c, r.opts.Metrics)
+	})
+}
+
+func (r *registrar) RegisterAttributesGeneratorBuilder(b adapter.AttributesGeneratorBuilder) {
+	r.add("attributes", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewAttributesGenerator(env, c)
+	})
+}
+
+func (r *registrar) RegisterSpansBuilder(b adapter.SpansBuilder) {
+	r.add("spans", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewSpansAspect(env, c)
+	})
+}
+
+func (r *registrar) RegisterAuthorizationBuilder(b adapter.AuthorizationBuilder) {
+	r.add("authorization", b, func(env adapter.Env, c adapter.Config) (adapter.Aspect, error) {
+		return b.NewAuthorizationAspect(env, c)
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const unixScheme = "unix://"
+
+// maxBackoff caps the delay between attempts to reconnect to a plugin.
+const maxBackoff = 5 * time.Second
+
+// parseAddress returns the network and address to listen on, or dial, for
+// the given plugin address. Plugins run on the host of the mixer, so that
+// their addresses are unix sockets, unix:///path/of/socket, or loopback
+// addresses such as localhost:9091.
+func parseAddress(address string) (network string, addr string, err error) {
+	if strings.HasPrefix(address, unixScheme) {
+		path := strings.TrimPrefix(address, unixScheme)
+		if path == "" {
+			return "", "", fmt.Errorf("address %q has no socket path", address)
+		}
+		return "unix", path, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid address %q: %v", address, err)
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return "", "", fmt.Errorf("address %q is neither a unix socket nor a loopback address", address)
+		}
+	}
+	return "tcp", address, nil
+}
+
+// ValidateAddress returns an error when address isn't a valid plugin address.
+func ValidateAddress(address string) error {
+	_, _, err := parseAddress(address)
+	return err
+}
+
+// SocketMissing returns true when address is a unix socket that doesn't
+// exist, so that no plugin can be listening on it.
+func SocketMissing(address string) bool {
+	network, addr, err := parseAddress(address)
+	if err != nil || network != "unix" {
+		return false
+	}
+	fi, err := os.Stat(addr)
+	return err != nil || fi.Mode()&os.ModeSocket == 0
+}
+
+// Listen listens on the given plugin address. The socket left behind by a
+// previous run of the plugin is removed first.
+func Listen(address string) (net.Listener, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(addr)
+		}
+	}
+	return net.Listen(network, addr)
+}
+
+// Dial returns a connection to the plugin at address. As with grpc.Dial, the
+// connection is established in the background, and reestablished when lost.
+func Dial(address string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithBackoffMaxDelay(maxBackoff),
+		grpc.WithDialer(func(_ string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(network, addr, timeout)
+		}),
+	}, opts...)
+	return grpc.Dial(addr, opts...)
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	cases := []struct {
+		address string
+		network string
+		addr    string
+		ok      bool
+	}{
+		{"unix:///var/run/mixer/a.sock", "unix", "/var/run/mixer/a.sock", true},
+		{"unix://", "", "", false},
+		{"localhost:9091", "tcp", "localhost:9091", true},
+		{"127.0.0.1:9091", "tcp", "127.0.0.1:9091", true},
+		{"[::1]:9091", "tcp", "[::1]:9091", true},
+		{"10.0.0.1:9091", "", "", false},
+		{"example.com:9091", "", "", false},
+		{"localhost", "", "", false},
+		{"", "", "", false},
+	}
+	for idx, c := range cases {
+		network, addr, err := parseAddress(c.address)
+		if (err == nil) != c.ok {
+			t.Errorf("[%d] parseAddress(%q) error = %v; wanted ok %t", idx, c.address, err, c.ok)
+			continue
+		}
+		if network != c.network || addr != c.addr {
+			t.Errorf("[%d] parseAddress(%q) = %s, %s; wanted %s, %s", idx, c.address, network, addr, c.network, c.addr)
+		}
+		if verr := ValidateAddress(c.address); (verr == nil) != c.ok {
+			t.Errorf("[%d] ValidateAddress(%q) = %v; wanted ok %t", idx, c.address, verr, c.ok)
+		}
+	}
+}
+
+func TestSocketMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatalf("Unable to create a temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	socket := "unix://" + filepath.Join(dir, "a.sock")
+	file := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("Unable to write a file: %v", err)
+	}
+
+	if !SocketMissing(socket) || !SocketMissing("unix://"+file) {
+		t.Error("SocketMissing() = false; wanted true for paths that aren't sockets")
+	}
+	lis, err := Listen(socket)
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer func() { _ = lis.Close() }()
+	if SocketMissing(socket) {
+		t.Error("SocketMissing() = true; wanted false for a socket")
+	}
+	if SocketMissing("localhost:9091") {
+		t.Error("SocketMissing() = true; wanted false for a tcp address")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// Client calls a plugin in terms of the values adapters deal with.
+type Client struct {
+	ac AdapterClient
+}
+
+// NewClient returns a client of the plugin at the other end of cc.
+func NewClient(cc *grpc.ClientConn) *Client {
+	return &Client{NewAdapterClient(cc)}
+}
+
+// ValidateConfig validates params, in JSON, for the named adapter of the given kind.
+func (c *Client) ValidateConfig(ctx context.Context, adapterName string, kind string, params string) (*adapter.ConfigErrors, error) {
+	resp, err := c.ac.ValidateConfig(ctx, &ValidateConfigRequest{Adapter: adapterName, Kind: kind, Params: params})
+	if err != nil {
+		return nil, err
+	}
+	var ce *adapter.ConfigErrors
+	for _, e := range resp.Errors {
+		ce = ce.Appendf(e.Field, "%s", e.Message)
+	}
+	return ce, nil
+}
+
+// NewAspect creates an aspect of the named adapter, and returns its id.
+func (c *Client) NewAspect(ctx context.Context, adapterName string, kind string, params string,
+	metrics map[string]*adapter.MetricDefinition, quotas map[string]*adapter.QuotaDefinition) (string, error) {
+	resp, err := c.ac.NewAspect(ctx, &NewAspectRequest{
+		Adapter: adapterName,
+		Kind:    kind,
+		Params:  params,
+		Metrics: encodeMetricDefinitions(metrics),
+		Quotas:  encodeQuotaDefinitions(quotas),
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.AspectId, nil
+}
+
+// Record records metric values with a metrics aspect.
+func (c *Client) Record(ctx context.Context, id string, values []adapter.Value) error {
+	mv, err := encodeMetricValues(values)
+	if err != nil {
+		return err
+	}
+	_, err = c.ac.Record(ctx, &RecordRequest{AspectId: id, Values: mv})
+	return err
+}
+
+// Log logs entries with an application-logs or access-logs aspect.
+func (c *Client) Log(ctx context.Context, id string, entries []adapter.LogEntry) error {
+	le, err := encodeLogEntries(entries)
+	if err != nil {
+		return err
+	}
+	_, err = c.ac.Log(ctx, &LogRequest{AspectId: id, Entries: le})
+	return err
+}
+
+// CheckList checks a symbol with a lists aspect.
+func (c *Client) CheckList(ctx context.Context, id string, symbol string) (bool, error) {
+	resp, err := c.ac.CheckList(ctx, &CheckListRequest{AspectId: id, Symbol: symbol})
+	if err != nil {
+		return false, err
+	}
+	return resp.Present, nil
+}
+
+// Alloc allocates quota with a quotas aspect.
+func (c *Client) Alloc(ctx context.Context, id string, args adapter.QuotaArgs, bestEffort bool) (adapter.QuotaResult, error) {
+	req, err := encodeQuotaArgs(id, args, bestEffort)
+	if err != nil {
+		return adapter.QuotaResult{}, err
+	}
+	resp, err := c.ac.Alloc(ctx, req)
+	if err != nil {
+		return adapter.QuotaResult{}, err
+	}
+	return adapter.QuotaResult{Amount: resp.Amount, Expiration: time.Duration(resp.Expiration)}, nil
+}
+
+// Release releases quota with a quotas aspect.
+func (c *Client) Release(ctx context.Context, id string, args adapter.QuotaArgs) (int64, error) {
+	req, err := encodeQuotaArgs(id, args, true)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.ac.Release(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Amount, nil
+}
+
+// CloseAspect closes an aspect.
+func (c *Client) CloseAspect(ctx context.Context, id string) error {
+	_, err := c.ac.Close(ctx, &CloseRequest{AspectId: id})
+	return err
+}
+
+// IsUnknownAspect returns whether err reports that the plugin doesn't know
+// of an aspect, as happens after it restarts.
+func IsUnknownAspect(err error) bool {
+	return grpc.Code(err) == codes.NotFound
+}
@@ -0,0 +1,314 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// Conversions between the values adapters deal with and their wire form.
+
+func encodeValue(v interface{}) (*Value, error) {
+	switch t := v.(type) {
+	case string:
+		return &Value{Kind: STRING, StringValue: t}, nil
+	case int64:
+		return &Value{Kind: INT64, Int64Value: t}, nil
+	case int:
+		return &Value{Kind: INT64, Int64Value: int64(t)}, nil
+	case float64:
+		return &Value{Kind: DOUBLE, DoubleValue: t}, nil
+	case bool:
+		return &Value{Kind: BOOL, BoolValue: t}, nil
+	case []byte:
+		return &Value{Kind: BYTES, BytesValue: t}, nil
+	case time.Time:
+		return &Value{Kind: TIMESTAMP, Int64Value: t.UnixNano()}, nil
+	case time.Duration:
+		return &Value{Kind: DURATION, Int64Value: int64(t)}, nil
+	case map[string]string:
+		return &Value{Kind: STRING_MAP, StringMapValue: t}, nil
+	}
+	return nil, fmt.Errorf("values of type %T are not supported", v)
+}
+
+func decodeValue(v *Value) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch v.Kind {
+	case STRING:
+		return v.StringValue, nil
+	case INT64:
+		return v.Int64Value, nil
+	case DOUBLE:
+		return v.DoubleValue, nil
+	case BOOL:
+		return v.BoolValue, nil
+	case BYTES:
+		return v.BytesValue, nil
+	case TIMESTAMP:
+		return time.Unix(0, v.Int64Value), nil
+	case DURATION:
+		return time.Duration(v.Int64Value), nil
+	case STRING_MAP:
+		if v.StringMapValue == nil {
+			return map[string]string{}, nil
+		}
+		return v.StringMapValue, nil
+	}
+	return nil, fmt.Errorf("unknown value kind %d", v.Kind)
+}
+
+func encodeValues(values map[string]interface{}) (map[string]*Value, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]*Value, len(values))
+	for k, v := range values {
+		ev, err := encodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k, err)
+		}
+		m[k] = ev
+	}
+	return m, nil
+}
+
+func decodeValues(values map[string]*Value) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		dv, err := decodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k, err)
+		}
+		m[k] = dv
+	}
+	return m, nil
+}
+
+func encodeLabelTypes(labels map[string]adapter.LabelType) map[string]int32 {
+	if len(labels) == 0 {
+		return nil
+	}
+	m := make(map[string]int32, len(labels))
+	for k, v := range labels {
+		m[k] = int32(v)
+	}
+	return m
+}
+
+func decodeLabelTypes(labels map[string]int32) map[string]adapter.LabelType {
+	m := make(map[string]adapter.LabelType, len(labels))
+	for k, v := range labels {
+		m[k] = adapter.LabelType(v)
+	}
+	return m
+}
+
+func encodeMetricDefinitions(defs map[string]*adapter.MetricDefinition) []*MetricDefinition {
+	var out []*MetricDefinition
+	for _, d := range defs {
+		out = append(out, &MetricDefinition{
+			Name:        d.Name,
+			DisplayName: d.DisplayName,
+			Description: d.Description,
+			Kind:        int32(d.Kind),
+			Labels:      encodeLabelTypes(d.Labels),
+		})
+	}
+	return out
+}
+
+func decodeMetricDefinitions(defs []*MetricDefinition) map[string]*adapter.MetricDefinition {
+	out := make(map[string]*adapter.MetricDefinition, len(defs))
+	for _, d := range defs {
+		out[d.Name] = &adapter.MetricDefinition{
+			Name:        d.Name,
+			DisplayName: d.DisplayName,
+			Description: d.Description,
+			Kind:        adapter.MetricKind(d.Kind),
+			Labels:      decodeLabelTypes(d.Labels),
+		}
+	}
+	return out
+}
+
+func encodeQuotaDefinitions(defs map[string]*adapter.QuotaDefinition) []*QuotaDefinition {
+	var out []*QuotaDefinition
+	for _, d := range defs {
+		out = append(out, &QuotaDefinition{
+			Name:        d.Name,
+			DisplayName: d.DisplayName,
+			Description: d.Description,
+			MaxAmount:   d.MaxAmount,
+			Expiration:  int64(d.Expiration),
+			Labels:      encodeLabelTypes(d.Labels),
+		})
+	}
+	return out
+}
+
+func decodeQuotaDefinitions(defs []*QuotaDefinition) map[string]*adapter.QuotaDefinition {
+	out := make(map[string]*adapter.QuotaDefinition, len(defs))
+	for _, d := range defs {
+		out[d.Name] = &adapter.QuotaDefinition{
+			Name:        d.Name,
+			DisplayName: d.DisplayName,
+			Description: d.Description,
+			MaxAmount:   d.MaxAmount,
+			Expiration:  time.Duration(d.Expiration),
+			Labels:      decodeLabelTypes(d.Labels),
+		}
+	}
+	return out
+}
+
+func encodeMetricValues(values []adapter.Value) ([]*MetricValue, error) {
+	out := make([]*MetricValue, 0, len(values))
+	for i, v := range values {
+		if v.Definition == nil {
+			return nil, fmt.Errorf("value %d has no definition", i)
+		}
+		labels, err := encodeValues(v.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("labels of metric %s: %v", v.Definition.Name, err)
+		}
+		mv, err := encodeValue(v.MetricValue)
+		if err != nil {
+			return nil, fmt.Errorf("value of metric %s: %v", v.Definition.Name, err)
+		}
+		out = append(out, &MetricValue{
+			Definition: v.Definition.Name,
+			Labels:     labels,
+			StartTime:  v.StartTime.UnixNano(),
+			EndTime:    v.EndTime.UnixNano(),
+			Value:      mv,
+		})
+	}
+	return out, nil
+}
+
+func decodeMetricValues(values []*MetricValue, defs map[string]*adapter.MetricDefinition) ([]adapter.Value, error) {
+	out := make([]adapter.Value, 0, len(values))
+	for _, v := range values {
+		def, found := defs[v.Definition]
+		if !found {
+			return nil, fmt.Errorf("unknown metric %s", v.Definition)
+		}
+		labels, err := decodeValues(v.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("labels of metric %s: %v", v.Definition, err)
+		}
+		mv, err := decodeValue(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("value of metric %s: %v", v.Definition, err)
+		}
+		out = append(out, adapter.Value{
+			Definition:  def,
+			Labels:      labels,
+			StartTime:   time.Unix(0, v.StartTime),
+			EndTime:     time.Unix(0, v.EndTime),
+			MetricValue: mv,
+		})
+	}
+	return out, nil
+}
+
+func encodeLogEntries(entries []adapter.LogEntry) ([]*LogEntry, error) {
+	out := make([]*LogEntry, 0, len(entries))
+	for _, e := range entries {
+		labels, err := encodeValues(e.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("labels of log %s: %v", e.LogName, err)
+		}
+		payload, err := encodeValues(e.StructPayload)
+		if err != nil {
+			return nil, fmt.Errorf("payload of log %s: %v", e.LogName, err)
+		}
+		out = append(out, &LogEntry{
+			LogName:       e.LogName,
+			Labels:        labels,
+			Timestamp:     e.Timestamp,
+			Severity:      int32(e.Severity),
+			TextPayload:   e.TextPayload,
+			StructPayload: payload,
+		})
+	}
+	return out, nil
+}
+
+func decodeLogEntries(entries []*LogEntry) ([]adapter.LogEntry, error) {
+	out := make([]adapter.LogEntry, 0, len(entries))
+	for _, e := range entries {
+		labels, err := decodeValues(e.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("labels of log %s: %v", e.LogName, err)
+		}
+		var payload map[string]interface{}
+		if len(e.StructPayload) > 0 {
+			if payload, err = decodeValues(e.StructPayload); err != nil {
+				return nil, fmt.Errorf("payload of log %s: %v", e.LogName, err)
+			}
+		}
+		out = append(out, adapter.LogEntry{
+			LogName:       e.LogName,
+			Labels:        labels,
+			Timestamp:     e.Timestamp,
+			Severity:      adapter.Severity(e.Severity),
+			TextPayload:   e.TextPayload,
+			StructPayload: payload,
+		})
+	}
+	return out, nil
+}
+
+func encodeQuotaArgs(id string, args adapter.QuotaArgs, bestEffort bool) (*QuotaRequest, error) {
+	if args.Definition == nil {
+		return nil, fmt.Errorf("quota args have no definition")
+	}
+	labels, err := encodeValues(args.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("labels of quota %s: %v", args.Definition.Name, err)
+	}
+	return &QuotaRequest{
+		AspectId:        id,
+		Definition:      args.Definition.Name,
+		DeduplicationId: args.DeduplicationID,
+		Amount:          args.QuotaAmount,
+		Labels:          labels,
+		BestEffort:      bestEffort,
+	}, nil
+}
+
+func decodeQuotaArgs(req *QuotaRequest, defs map[string]*adapter.QuotaDefinition) (adapter.QuotaArgs, error) {
+	def, found := defs[req.Definition]
+	if !found {
+		return adapter.QuotaArgs{}, fmt.Errorf("unknown quota %s", req.Definition)
+	}
+	labels, err := decodeValues(req.Labels)
+	if err != nil {
+		return adapter.QuotaArgs{}, fmt.Errorf("labels of quota %s: %v", req.Definition, err)
+	}
+	return adapter.QuotaArgs{
+		Definition:      def,
+		DeduplicationID: req.DeduplicationId,
+		QuotaAmount:     req.Amount,
+		Labels:          labels,
+	}, nil
+}
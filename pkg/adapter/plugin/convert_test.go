@@ -0,0 +1,174 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+func TestValues(t *testing.T) {
+	now := time.Unix(1490000000, 123)
+	cases := []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{"abc", "abc"},
+		{int64(-3), int64(-3)},
+		{3, int64(3)},
+		{1.5, 1.5},
+		{true, true},
+		{[]byte{1, 2}, []byte{1, 2}},
+		{now, now},
+		{5 * time.Second, 5 * time.Second},
+		{map[string]string{"a": "b"}, map[string]string{"a": "b"}},
+		{map[string]string{}, map[string]string{}},
+	}
+	for idx, c := range cases {
+		v, err := encodeValue(c.in)
+		if err != nil {
+			t.Errorf("[%d] encodeValue(%v) failed: %v", idx, c.in, err)
+			continue
+		}
+		got, err := decodeValue(v)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("[%d] decodeValue(encodeValue(%v)) = %v, %v; wanted %v", idx, c.in, got, err, c.want)
+		}
+	}
+
+	if _, err := encodeValue(int32(1)); err == nil {
+		t.Error("encodeValue(int32) succeeded; wanted an error")
+	}
+	if _, err := decodeValue(&Value{Kind: 42}); err == nil {
+		t.Error("decodeValue(kind 42) succeeded; wanted an error")
+	}
+	if _, err := encodeValues(map[string]interface{}{"a": struct{}{}}); err == nil {
+		t.Error("encodeValues(struct) succeeded; wanted an error")
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	metrics := map[string]*adapter.MetricDefinition{
+		"requests": {
+			Name:        "requests",
+			DisplayName: "Requests",
+			Description: "number of requests",
+			Kind:        adapter.Counter,
+			Labels:      map[string]adapter.LabelType{"source": adapter.String, "code": adapter.Int64},
+		},
+		"latency": {Name: "latency", Kind: adapter.Gauge, Labels: map[string]adapter.LabelType{}},
+	}
+	if got := decodeMetricDefinitions(encodeMetricDefinitions(metrics)); !reflect.DeepEqual(got, metrics) {
+		t.Errorf("metric definitions = %v; wanted %v", got, metrics)
+	}
+
+	quotas := map[string]*adapter.QuotaDefinition{
+		"rate": {
+			Name:       "rate",
+			MaxAmount:  100,
+			Expiration: time.Minute,
+			Labels:     map[string]adapter.LabelType{"source": adapter.String},
+		},
+	}
+	if got := decodeQuotaDefinitions(encodeQuotaDefinitions(quotas)); !reflect.DeepEqual(got, quotas) {
+		t.Errorf("quota definitions = %v; wanted %v", got, quotas)
+	}
+}
+
+func TestMetricValues(t *testing.T) {
+	defs := map[string]*adapter.MetricDefinition{"requests": {Name: "requests", Kind: adapter.Counter}}
+	start := time.Unix(1490000000, 0)
+	values := []adapter.Value{{
+		Definition:  defs["requests"],
+		Labels:      map[string]interface{}{"source": "a", "code": int64(200)},
+		StartTime:   start,
+		EndTime:     start.Add(time.Second),
+		MetricValue: int64(3),
+	}}
+
+	mv, err := encodeMetricValues(values)
+	if err != nil {
+		t.Fatalf("encodeMetricValues() failed: %v", err)
+	}
+	got, err := decodeMetricValues(mv, defs)
+	if err != nil || !reflect.DeepEqual(got, values) {
+		t.Errorf("decodeMetricValues() = %v, %v; wanted %v", got, err, values)
+	}
+
+	if _, err = decodeMetricValues(mv, map[string]*adapter.MetricDefinition{}); err == nil {
+		t.Error("decodeMetricValues() of an unknown metric succeeded; wanted an error")
+	}
+	if _, err = encodeMetricValues([]adapter.Value{{MetricValue: int64(1)}}); err == nil {
+		t.Error("encodeMetricValues() without a definition succeeded; wanted an error")
+	}
+}
+
+func TestLogEntries(t *testing.T) {
+	entries := []adapter.LogEntry{
+		{
+			LogName:     "access",
+			Labels:      map[string]interface{}{"source": "a"},
+			Timestamp:   "2017-03-20T10:00:00Z",
+			Severity:    adapter.Warning,
+			TextPayload: "GET /",
+		},
+		{
+			LogName:       "app",
+			Labels:        map[string]interface{}{},
+			StructPayload: map[string]interface{}{"latency": 5 * time.Millisecond},
+		},
+	}
+
+	le, err := encodeLogEntries(entries)
+	if err != nil {
+		t.Fatalf("encodeLogEntries() failed: %v", err)
+	}
+	got, err := decodeLogEntries(le)
+	if err != nil || !reflect.DeepEqual(got, entries) {
+		t.Errorf("decodeLogEntries() = %v, %v; wanted %v", got, err, entries)
+	}
+}
+
+func TestQuotaArgs(t *testing.T) {
+	defs := map[string]*adapter.QuotaDefinition{"rate": {Name: "rate", MaxAmount: 10}}
+	args := adapter.QuotaArgs{
+		Definition:      defs["rate"],
+		DeduplicationID: "dedup",
+		QuotaAmount:     2,
+		Labels:          map[string]interface{}{"source": "a"},
+	}
+
+	req, err := encodeQuotaArgs("id", args, true)
+	if err != nil {
+		t.Fatalf("encodeQuotaArgs() failed: %v", err)
+	}
+	if req.AspectId != "id" || !req.BestEffort {
+		t.Errorf("encodeQuotaArgs() = %v; wanted the aspect id and best effort", req)
+	}
+	got, err := decodeQuotaArgs(req, defs)
+	if err != nil || !reflect.DeepEqual(got, args) {
+		t.Errorf("decodeQuotaArgs() = %v, %v; wanted %v", got, err, args)
+	}
+
+	if _, err = decodeQuotaArgs(req, map[string]*adapter.QuotaDefinition{}); err == nil {
+		t.Error("decodeQuotaArgs() of an unknown quota succeeded; wanted an error")
+	}
+	if _, err = encodeQuotaArgs("id", adapter.QuotaArgs{}, false); err == nil {
+		t.Error("encodeQuotaArgs() without a definition succeeded; wanted an error")
+	}
+}
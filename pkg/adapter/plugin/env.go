@@ -0,0 +1,69 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// env is the environment of the aspects served by a plugin, which runs their
+// functions on goroutines of their own, and logs with glog.
+type env struct {
+	aspect string
+}
+
+func newEnv(aspect string) adapter.Env {
+	return env{aspect}
+}
+
+func (e env) Logger() adapter.Logger {
+	return e
+}
+
+func (e env) ScheduleWork(fn adapter.WorkFunc) {
+	go e.run("worker", fn)
+}
+
+func (e env) ScheduleDaemon(fn adapter.DaemonFunc) {
+	go e.run("daemon", fn)
+}
+
+func (e env) run(what string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			_ = e.Errorf("Adapter %s failed: %v", what, r)
+		}
+	}()
+	fn()
+}
+
+func (e env) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, e.aspect+":"+fmt.Sprintf(format, args...))
+}
+
+func (e env) Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(1, e.aspect+":"+fmt.Sprintf(format, args...))
+}
+
+func (e env) Errorf(format string, args ...interface{}) error {
+	s := fmt.Sprintf(format, args...)
+	glog.ErrorDepth(1, e.aspect+":"+s)
+	return errors.New(s)
+}
@@ -0,0 +1,479 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// Server serves the builders of an adapter to mixers. Adapters register their
+// builders with it as they do with the mixer's inventory, and their aspects
+// then run in the plugin's process:
+//
+//	func main() {
+//		lis, err := plugin.Listen("unix:///var/run/mixer/myAdapter.sock")
+//		if err != nil {
+//			glog.Fatalf("Unable to listen: %v", err)
+//		}
+//		glog.Fatal(plugin.Serve(lis, myAdapter.Register))
+//	}
+//
+// Builders of kinds the protocol doesn't support are ignored.
+type Server struct {
+	svc    *service
+	health *health.Server
+}
+
+// NewServer returns a server of the builders registered by r.
+func NewServer(r adapter.RegisterFn) *Server {
+	reg := &registrar{}
+	r(reg)
+
+	h := health.NewServer()
+	h.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	h.SetServingStatus(AdapterServiceName, healthpb.HealthCheckResponse_SERVING)
+	return &Server{
+		svc: &service{
+			builders: reg.builders,
+			instance: newInstanceID(),
+			aspects:  make(map[string]*servedAspect),
+		},
+		health: h,
+	}
+}
+
+// Register registers the plugin service, and the health service, with a gRPC server.
+func (s *Server) Register(gs *grpc.Server) {
+	RegisterAdapterServer(gs, s.svc)
+	healthpb.RegisterHealthServer(gs, s.health)
+}
+
+// Close closes the aspects and builders being served, after which the server
+// reports itself as not serving.
+func (s *Server) Close() error {
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	s.health.SetServingStatus(AdapterServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	return s.svc.close()
+}
+
+// Serve serves the builders registered by r on lis, until lis fails.
+func Serve(lis net.Listener, r adapter.RegisterFn) error {
+	s := NewServer(r)
+	defer func() { _ = s.Close() }()
+	gs := grpc.NewServer()
+	s.Register(gs)
+	return gs.Serve(lis)
+}
+
+// Kinds of aspects the protocol supports.
+const (
+	ListsKind           = "lists"
+	MetricsKind         = "metrics"
+	ApplicationLogsKind = "application-logs"
+	AccessLogsKind      = "access-logs"
+	QuotasKind          = "quotas"
+)
+
+type newAspectFn func(env adapter.Env, c adapter.Config, metrics map[string]*adapter.MetricDefinition,
+	quotas map[string]*adapter.QuotaDefinition) (adapter.Aspect, error)
+
+type builder struct {
+	kind      string
+	b         adapter.Builder
+	newAspect newAspectFn
+}
+
+// registrar collects the builders the protocol supports.
+type registrar struct {
+	builders []builder
+}
+
+func (r *registrar) add(kind string, b adapter.Builder, fn newAspectFn) {
+	r.builders = append(r.builders, builder{kind, b, fn})
+}
+
+func (r *registrar) RegisterListsBuilder(b adapter.ListsBuilder) {
+	r.add(ListsKind, b, func(env adapter.Env, c adapter.Config, _ map[string]*adapter.MetricDefinition,
+		_ map[string]*adapter.QuotaDefinition) (adapter.Aspect, error) {
+		return b.NewListsAspect(env, c)
+	})
+}
+
+func (r *registrar) RegisterMetricsBuilder(b adapter.MetricsBuilder) {
+	r.add(MetricsKind, b, func(env adapter.Env, c adapter.Config, metrics map[string]*adapter.MetricDefinition,
+		_ map[string]*adapter.QuotaDefinition) (adapter.Aspect, error) {
+		return b.NewMetricsAspect(env, c, metrics)
+	})
+}
+
+func (r *registrar) RegisterApplicationLogsBuilder(b adapter.ApplicationLogsBuilder) {
+	r.add(ApplicationLogsKind, b, func(env adapter.Env, c adapter.Config, _ map[string]*adapter.MetricDefinition,
+		_ map[string]*adapter.QuotaDefinition) (adapter.Aspect, error) {
+		return b.NewApplicationLogsAspect(env, c)
+	})
+}
+
+func (r *registrar) RegisterAccessLogsBuilder(b adapter.AccessLogsBuilder) {
+	r.add(AccessLogsKind, b, func(env adapter.Env, c adapter.Config, _ map[string]*adapter.MetricDefinition,
+		_ map[string]*adapter.QuotaDefinition) (adapter.Aspect, error) {
+		return b.NewAccessLogsAspect(env, c)
+	})
+}
+
+func (r *registrar) RegisterQuotasBuilder(b adapter.QuotasBuilder) {
+	r.add(QuotasKind, b, func(env adapter.Env, c adapter.Config, _ map[string]*adapter.MetricDefinition,
+		quotas map[string]*adapter.QuotaDefinition) (adapter.Aspect, error) {
+		return b.NewQuotasAspect(env, c, quotas)
+	})
+}
+
+func (r *registrar) RegisterDenialsBuilder(b adapter.DenialsBuilder) {
+	glog.Warningf("Builder %s of denials can't be served by a plugin", b.Name())
+}
+
+func (r *registrar) RegisterAttributesGeneratorBuilder(b adapter.AttributesGeneratorBuilder) {
+	glog.Warningf("Builder %s of attributes can't be served by a plugin", b.Name())
+}
+
+func (r *registrar) RegisterSpansBuilder(b adapter.SpansBuilder) {
+	glog.Warningf("Builder %s of spans can't be served by a plugin", b.Name())
+}
+
+func (r *registrar) RegisterAuthorizationBuilder(b adapter.AuthorizationBuilder) {
+	glog.Warningf("Builder %s of authorization can't be served by a plugin", b.Name())
+}
+
+// servedAspect is an aspect created by a mixer.
+type servedAspect struct {
+	kind    string
+	asp     adapter.Aspect
+	metrics map[string]*adapter.MetricDefinition
+	quotas  map[string]*adapter.QuotaDefinition
+}
+
+// service implements the plugin service.
+type service struct {
+	builders []builder
+	instance string // prefix of the ids of aspects, unique to each server
+
+	lock    sync.Mutex // guards aspects, nextID and closed
+	aspects map[string]*servedAspect
+	nextID  uint64
+	closed  bool
+}
+
+// newInstanceID returns a random id, so that ids of aspects of a plugin that
+// restarted can't be confused with the ones it returned before.
+func newInstanceID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// findBuilder returns the builder with the given name, or the only one when
+// name is empty, of the given kind, or of any kind when kind is empty.
+func (s *service) findBuilder(name string, kind string) (builder, error) {
+	var found []builder
+	for _, b := range s.builders {
+		if (kind != "" && b.kind != kind) || (name != "" && b.b.Name() != name) {
+			continue
+		}
+		if len(found) > 0 && kind == "" && found[0].b == b.b {
+			continue // the same builder, of another kind
+		}
+		found = append(found, b)
+	}
+	what := kind
+	if what == "" {
+		what = "any kind"
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case len(found) > 1:
+		return builder{}, grpc.Errorf(codes.InvalidArgument, "the plugin has %d builders of %s, an adapter must be named", len(found), what)
+	case name == "":
+		return builder{}, grpc.Errorf(codes.InvalidArgument, "the plugin has no builder of %s", what)
+	}
+	return builder{}, grpc.Errorf(codes.InvalidArgument, "the plugin has no builder %s of %s", name, what)
+}
+
+// decodeParams decodes params, in JSON, into a copy of the default config of b.
+func decodeParams(b adapter.Builder, params string) (adapter.Config, error) {
+	cfg := proto.Clone(b.DefaultConfig())
+	if params == "" {
+		return cfg, nil
+	}
+	if err := jsonpb.UnmarshalString(params, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (s *service) ValidateConfig(_ context.Context, req *ValidateConfigRequest) (*ValidateConfigResponse, error) {
+	b, err := s.findBuilder(req.Adapter, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ValidateConfigResponse{}
+	cfg, err := decodeParams(b.b, req.Params)
+	if err != nil {
+		resp.Errors = append(resp.Errors, &ConfigError{Message: err.Error()})
+		return resp, nil
+	}
+
+	var ce *adapter.ConfigErrors
+	if err = safeCall(b.b.Name(), func() error { ce = b.b.ValidateConfig(cfg); return nil }); err != nil {
+		return nil, err
+	}
+	if ce == nil || ce.Multi == nil {
+		return resp, nil
+	}
+	for _, e := range ce.Multi.Errors {
+		if cerr, ok := e.(adapter.ConfigError); ok {
+			resp.Errors = append(resp.Errors, &ConfigError{Field: cerr.Field, Message: cerr.Underlying.Error()})
+			continue
+		}
+		resp.Errors = append(resp.Errors, &ConfigError{Message: e.Error()})
+	}
+	return resp, nil
+}
+
+func (s *service) NewAspect(_ context.Context, req *NewAspectRequest) (*NewAspectResponse, error) {
+	if req.Kind == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "aspects must have a kind")
+	}
+	b, err := s.findBuilder(req.Adapter, req.Kind)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := decodeParams(b.b, req.Params)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid params: %v", err)
+	}
+	var ce *adapter.ConfigErrors
+	if err = safeCall(b.b.Name(), func() error { ce = b.b.ValidateConfig(cfg); return nil }); err != nil {
+		return nil, err
+	}
+	if ce != nil && ce.Multi != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid params: %v", ce)
+	}
+
+	sa := &servedAspect{
+		kind:    b.kind,
+		metrics: decodeMetricDefinitions(req.Metrics),
+		quotas:  decodeQuotaDefinitions(req.Quotas),
+	}
+	env := newEnv(b.b.Name() + "/" + b.kind)
+	if err = safeCall(b.b.Name(), func() error {
+		var err error
+		sa.asp, err = b.newAspect(env, cfg, sa.metrics, sa.quotas)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		_ = sa.asp.Close()
+		return nil, grpc.Errorf(codes.Unavailable, "the plugin is closed")
+	}
+	s.nextID++
+	id := fmt.Sprintf("%s-%d", s.instance, s.nextID)
+	s.aspects[id] = sa
+	s.lock.Unlock()
+	return &NewAspectResponse{AspectId: id}, nil
+}
+
+// aspect returns the aspect with the given id, of the given kinds.
+func (s *service) aspect(id string, kinds ...string) (*servedAspect, error) {
+	s.lock.Lock()
+	sa, found := s.aspects[id]
+	s.lock.Unlock()
+	if !found {
+		return nil, grpc.Errorf(codes.NotFound, "unknown aspect %s", id)
+	}
+	for _, k := range kinds {
+		if sa.kind == k {
+			return sa, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.InvalidArgument, "aspect %s is of kind %s", id, sa.kind)
+}
+
+func (s *service) Record(_ context.Context, req *RecordRequest) (*RecordResponse, error) {
+	sa, err := s.aspect(req.AspectId, MetricsKind)
+	if err != nil {
+		return nil, err
+	}
+	values, err := decodeMetricValues(req.Values, sa.metrics)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err = safeCall(req.AspectId, func() error { return sa.asp.(adapter.MetricsAspect).Record(values) }); err != nil {
+		return nil, err
+	}
+	return &RecordResponse{}, nil
+}
+
+func (s *service) Log(_ context.Context, req *LogRequest) (*LogResponse, error) {
+	sa, err := s.aspect(req.AspectId, ApplicationLogsKind, AccessLogsKind)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := decodeLogEntries(req.Entries)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err = safeCall(req.AspectId, func() error {
+		if sa.kind == AccessLogsKind {
+			return sa.asp.(adapter.AccessLogsAspect).LogAccess(entries)
+		}
+		return sa.asp.(adapter.ApplicationLogsAspect).Log(entries)
+	}); err != nil {
+		return nil, err
+	}
+	return &LogResponse{}, nil
+}
+
+func (s *service) CheckList(_ context.Context, req *CheckListRequest) (*CheckListResponse, error) {
+	sa, err := s.aspect(req.AspectId, ListsKind)
+	if err != nil {
+		return nil, err
+	}
+	resp := &CheckListResponse{}
+	if err = safeCall(req.AspectId, func() error {
+		var err error
+		resp.Present, err = sa.asp.(adapter.ListsAspect).CheckList(req.Symbol)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *service) Alloc(_ context.Context, req *QuotaRequest) (*AllocResponse, error) {
+	sa, err := s.aspect(req.AspectId, QuotasKind)
+	if err != nil {
+		return nil, err
+	}
+	args, err := decodeQuotaArgs(req, sa.quotas)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	var qr adapter.QuotaResult
+	if err = safeCall(req.AspectId, func() error {
+		var err error
+		if req.BestEffort {
+			qr, err = sa.asp.(adapter.QuotasAspect).AllocBestEffort(args)
+		} else {
+			qr, err = sa.asp.(adapter.QuotasAspect).Alloc(args)
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &AllocResponse{Amount: qr.Amount, Expiration: int64(qr.Expiration)}, nil
+}
+
+func (s *service) Release(_ context.Context, req *QuotaRequest) (*ReleaseResponse, error) {
+	sa, err := s.aspect(req.AspectId, QuotasKind)
+	if err != nil {
+		return nil, err
+	}
+	args, err := decodeQuotaArgs(req, sa.quotas)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	resp := &ReleaseResponse{}
+	if err = safeCall(req.AspectId, func() error {
+		var err error
+		resp.Amount, err = sa.asp.(adapter.QuotasAspect).ReleaseBestEffort(args)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *service) Close(_ context.Context, req *CloseRequest) (*CloseResponse, error) {
+	s.lock.Lock()
+	sa, found := s.aspects[req.AspectId]
+	delete(s.aspects, req.AspectId)
+	s.lock.Unlock()
+	if !found {
+		return nil, grpc.Errorf(codes.NotFound, "unknown aspect %s", req.AspectId)
+	}
+	if err := safeCall(req.AspectId, sa.asp.Close); err != nil {
+		return nil, err
+	}
+	return &CloseResponse{}, nil
+}
+
+// close closes the aspects, then the builders.
+func (s *service) close() error {
+	s.lock.Lock()
+	aspects := s.aspects
+	s.aspects = make(map[string]*servedAspect)
+	s.closed = true
+	s.lock.Unlock()
+
+	var err error
+	for id, sa := range aspects {
+		if cerr := safeCall(id, sa.asp.Close); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	closed := make(map[adapter.Builder]bool)
+	for _, b := range s.builders {
+		if closed[b.b] {
+			continue
+		}
+		closed[b.b] = true
+		if cerr := safeCall(b.b.Name(), b.b.Close); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// safeCall calls adapter code, turning its errors and panics into errors
+// with the UNKNOWN code.
+func safeCall(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = grpc.Errorf(codes.Unknown, "adapter '%s' panicked with '%v'", name, r)
+		}
+	}()
+	if err = fn(); err != nil {
+		return grpc.Errorf(codes.Unknown, "%v", err)
+	}
+	return nil
+}
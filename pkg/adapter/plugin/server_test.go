@@ -0,0 +1,324 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ptypes "github.com/gogo/protobuf/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// fakeAdapter builds aspects of every kind the protocol supports. Their
+// config is a struct, which is invalid when its "invalid" field is true.
+type fakeAdapter struct {
+	lock     sync.Mutex
+	recorded []adapter.Value
+	logged   []adapter.LogEntry
+	accessed []adapter.LogEntry
+	closed   int
+}
+
+func (*fakeAdapter) Name() string                  { return "fake" }
+func (*fakeAdapter) Description() string           { return "a fake adapter" }
+func (*fakeAdapter) DefaultConfig() adapter.Config { return &ptypes.Struct{} }
+func (*fakeAdapter) Close() error                  { return nil }
+
+func (*fakeAdapter) ValidateConfig(c adapter.Config) (ce *adapter.ConfigErrors) {
+	if v, found := c.(*ptypes.Struct).Fields["invalid"]; found && v.GetBoolValue() {
+		ce = ce.Appendf("invalid", "must be false")
+	}
+	return
+}
+
+func (a *fakeAdapter) NewListsAspect(adapter.Env, adapter.Config) (adapter.ListsAspect, error) {
+	return &fakeAspect{a: a}, nil
+}
+
+func (a *fakeAdapter) NewMetricsAspect(_ adapter.Env, _ adapter.Config, metrics map[string]*adapter.MetricDefinition) (adapter.MetricsAspect, error) {
+	if len(metrics) == 0 {
+		return nil, errors.New("no metrics")
+	}
+	return &fakeAspect{a: a}, nil
+}
+
+func (a *fakeAdapter) NewApplicationLogsAspect(adapter.Env, adapter.Config) (adapter.ApplicationLogsAspect, error) {
+	return &fakeAspect{a: a}, nil
+}
+
+func (a *fakeAdapter) NewAccessLogsAspect(adapter.Env, adapter.Config) (adapter.AccessLogsAspect, error) {
+	return &fakeAspect{a: a}, nil
+}
+
+func (a *fakeAdapter) NewQuotasAspect(_ adapter.Env, _ adapter.Config, quotas map[string]*adapter.QuotaDefinition) (adapter.QuotasAspect, error) {
+	return &fakeAspect{a: a}, nil
+}
+
+func (a *fakeAdapter) register(r adapter.Registrar) {
+	r.RegisterListsBuilder(a)
+	r.RegisterMetricsBuilder(a)
+	r.RegisterApplicationLogsBuilder(a)
+	r.RegisterAccessLogsBuilder(a)
+	r.RegisterQuotasBuilder(a)
+}
+
+type fakeAspect struct {
+	a *fakeAdapter
+}
+
+func (f *fakeAspect) Close() error {
+	f.a.lock.Lock()
+	f.a.closed++
+	f.a.lock.Unlock()
+	return nil
+}
+
+func (f *fakeAspect) CheckList(symbol string) (bool, error) {
+	switch symbol {
+	case "error":
+		return false, errors.New("lookup failed")
+	case "panic":
+		panic("lookup panicked")
+	}
+	return symbol == "present", nil
+}
+
+func (f *fakeAspect) Record(values []adapter.Value) error {
+	f.a.lock.Lock()
+	f.a.recorded = append(f.a.recorded, values...)
+	f.a.lock.Unlock()
+	return nil
+}
+
+func (f *fakeAspect) Log(entries []adapter.LogEntry) error {
+	f.a.lock.Lock()
+	f.a.logged = append(f.a.logged, entries...)
+	f.a.lock.Unlock()
+	return nil
+}
+
+func (f *fakeAspect) LogAccess(entries []adapter.LogEntry) error {
+	f.a.lock.Lock()
+	f.a.accessed = append(f.a.accessed, entries...)
+	f.a.lock.Unlock()
+	return nil
+}
+
+func (f *fakeAspect) Alloc(args adapter.QuotaArgs) (adapter.QuotaResult, error) {
+	return adapter.QuotaResult{Amount: args.QuotaAmount, Expiration: args.Definition.Expiration}, nil
+}
+
+func (f *fakeAspect) AllocBestEffort(args adapter.QuotaArgs) (adapter.QuotaResult, error) {
+	return adapter.QuotaResult{Amount: args.QuotaAmount - 1}, nil
+}
+
+func (f *fakeAspect) ReleaseBestEffort(args adapter.QuotaArgs) (int64, error) {
+	return args.QuotaAmount, nil
+}
+
+// startServer serves the fake adapter on a unix socket, and returns a
+// connection to it along with a function stopping both.
+func startServer(t *testing.T, a *fakeAdapter) (*grpc.ClientConn, *Server, func()) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatalf("Unable to create a temp dir: %v", err)
+	}
+	address := "unix://" + filepath.Join(dir, "fake.sock")
+	lis, err := Listen(address)
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	s := NewServer(a.register)
+	gs := grpc.NewServer()
+	s.Register(gs)
+	go func() { _ = gs.Serve(lis) }()
+
+	conn, err := Dial(address)
+	if err != nil {
+		t.Fatalf("Unable to dial: %v", err)
+	}
+	return conn, s, func() {
+		_ = conn.Close()
+		gs.Stop()
+		_ = s.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestServer(t *testing.T) {
+	a := &fakeAdapter{}
+	conn, _, stop := startServer(t, a)
+	defer stop()
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if ce, err := c.ValidateConfig(ctx, "fake", ListsKind, `{"invalid": false}`); ce != nil || err != nil {
+		t.Errorf("ValidateConfig(valid) = %v, %v; wanted no errors", ce, err)
+	}
+	ce, err := c.ValidateConfig(ctx, "", ListsKind, `{"invalid": true}`)
+	if err != nil || ce == nil || len(ce.Multi.Errors) != 1 || ce.Multi.Errors[0].(adapter.ConfigError).Field != "invalid" {
+		t.Errorf("ValidateConfig(invalid) = %v, %v; wanted an error on field invalid", ce, err)
+	}
+	if ce, err = c.ValidateConfig(ctx, "fake", ListsKind, `{"invalid": `); err != nil || ce == nil {
+		t.Errorf("ValidateConfig(malformed) = %v, %v; wanted config errors", ce, err)
+	}
+	if ce, err = c.ValidateConfig(ctx, "fake", "", `{"invalid": true}`); err != nil || ce == nil {
+		t.Errorf("ValidateConfig(any kind) = %v, %v; wanted config errors", ce, err)
+	}
+	if _, err = c.ValidateConfig(ctx, "other", ListsKind, ""); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("ValidateConfig(other) error = %v; wanted an invalid argument", err)
+	}
+	if _, err = c.NewAspect(ctx, "fake", ListsKind, `{"invalid": true}`, nil, nil); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("NewAspect(invalid) error = %v; wanted an invalid argument", err)
+	}
+	if _, err = c.NewAspect(ctx, "fake", "", "", nil, nil); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("NewAspect(no kind) error = %v; wanted an invalid argument", err)
+	}
+	if _, err = c.NewAspect(ctx, "fake", MetricsKind, "", nil, nil); grpc.Code(err) != codes.Unknown {
+		t.Errorf("NewAspect(metrics without definitions) error = %v; wanted the adapter's error", err)
+	}
+
+	// lists
+	lists, err := c.NewAspect(ctx, "fake", ListsKind, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewAspect(lists) failed: %v", err)
+	}
+	if found, err := c.CheckList(ctx, lists, "present"); !found || err != nil {
+		t.Errorf("CheckList(present) = %t, %v; wanted true", found, err)
+	}
+	if found, err := c.CheckList(ctx, lists, "absent"); found || err != nil {
+		t.Errorf("CheckList(absent) = %t, %v; wanted false", found, err)
+	}
+	if _, err = c.CheckList(ctx, lists, "error"); grpc.Code(err) != codes.Unknown || !strings.Contains(err.Error(), "lookup failed") {
+		t.Errorf("CheckList(error) error = %v; wanted the adapter's error", err)
+	}
+	if _, err = c.CheckList(ctx, lists, "panic"); grpc.Code(err) != codes.Unknown || !strings.Contains(err.Error(), "panicked") {
+		t.Errorf("CheckList(panic) error = %v; wanted the adapter's panic", err)
+	}
+	if err = c.Log(ctx, lists, nil); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("Log(lists aspect) error = %v; wanted an invalid argument", err)
+	}
+
+	// metrics
+	defs := map[string]*adapter.MetricDefinition{"requests": {Name: "requests", Kind: adapter.Counter, Labels: map[string]adapter.LabelType{}}}
+	metrics, err := c.NewAspect(ctx, "fake", MetricsKind, "", defs, nil)
+	if err != nil {
+		t.Fatalf("NewAspect(metrics) failed: %v", err)
+	}
+	values := []adapter.Value{{
+		Definition:  defs["requests"],
+		Labels:      map[string]interface{}{},
+		StartTime:   time.Unix(1, 0),
+		EndTime:     time.Unix(2, 0),
+		MetricValue: int64(1),
+	}}
+	if err = c.Record(ctx, metrics, values); err != nil || !reflect.DeepEqual(a.recorded, values) {
+		t.Errorf("Record() = %v and recorded %v; wanted %v", err, a.recorded, values)
+	}
+
+	// logs
+	entries := []adapter.LogEntry{{LogName: "log", Labels: map[string]interface{}{}, TextPayload: "text"}}
+	appLogs, err := c.NewAspect(ctx, "", ApplicationLogsKind, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewAspect(application-logs) failed: %v", err)
+	}
+	if err = c.Log(ctx, appLogs, entries); err != nil || !reflect.DeepEqual(a.logged, entries) {
+		t.Errorf("Log() = %v and logged %v; wanted %v", err, a.logged, entries)
+	}
+	accessLogs, err := c.NewAspect(ctx, "", AccessLogsKind, "", nil, nil)
+	if err != nil {
+		t.Fatalf("NewAspect(access-logs) failed: %v", err)
+	}
+	if err = c.Log(ctx, accessLogs, entries); err != nil || !reflect.DeepEqual(a.accessed, entries) {
+		t.Errorf("Log(access) = %v and logged %v; wanted %v", err, a.accessed, entries)
+	}
+
+	// quotas
+	qdefs := map[string]*adapter.QuotaDefinition{"rate": {Name: "rate", MaxAmount: 10, Expiration: time.Second, Labels: map[string]adapter.LabelType{}}}
+	quotas, err := c.NewAspect(ctx, "fake", QuotasKind, "", nil, qdefs)
+	if err != nil {
+		t.Fatalf("NewAspect(quotas) failed: %v", err)
+	}
+	args := adapter.QuotaArgs{Definition: qdefs["rate"], QuotaAmount: 3}
+	if qr, err := c.Alloc(ctx, quotas, args, false); err != nil || qr != (adapter.QuotaResult{Amount: 3, Expiration: time.Second}) {
+		t.Errorf("Alloc() = %v, %v; wanted 3 for a second", qr, err)
+	}
+	if qr, err := c.Alloc(ctx, quotas, args, true); err != nil || qr.Amount != 2 {
+		t.Errorf("Alloc(best effort) = %v, %v; wanted 2", qr, err)
+	}
+	if amount, err := c.Release(ctx, quotas, args); err != nil || amount != 3 {
+		t.Errorf("Release() = %d, %v; wanted 3", amount, err)
+	}
+	args.Definition = &adapter.QuotaDefinition{Name: "unknown"}
+	if _, err = c.Alloc(ctx, quotas, args, false); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("Alloc(unknown quota) error = %v; wanted an invalid argument", err)
+	}
+
+	// close
+	if err = c.CloseAspect(ctx, lists); err != nil || a.closed != 1 {
+		t.Errorf("CloseAspect() = %v and closed %d aspects; wanted 1", err, a.closed)
+	}
+	if err = c.CloseAspect(ctx, lists); !IsUnknownAspect(err) {
+		t.Errorf("CloseAspect() again error = %v; wanted an unknown aspect", err)
+	}
+	if _, err = c.CheckList(ctx, lists, "present"); !IsUnknownAspect(err) {
+		t.Errorf("CheckList() of a closed aspect error = %v; wanted an unknown aspect", err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	a := &fakeAdapter{}
+	conn, s, stop := startServer(t, a)
+	defer stop()
+	c := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	health := healthpb.NewHealthClient(conn)
+	resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: AdapterServiceName})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Check() = %v, %v; wanted serving", resp, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = c.NewAspect(ctx, "fake", ListsKind, "", nil, nil); err != nil {
+			t.Fatalf("NewAspect() failed: %v", err)
+		}
+	}
+	if err = s.Close(); err != nil || a.closed != 2 {
+		t.Errorf("Close() = %v and closed %d aspects; wanted 2", err, a.closed)
+	}
+	resp, err = health.Check(ctx, &healthpb.HealthCheckRequest{Service: AdapterServiceName})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Check() after Close() = %v, %v; wanted not serving", resp, err)
+	}
+	if _, err = c.NewAspect(ctx, "fake", ListsKind, "", nil, nil); grpc.Code(err) != codes.Unavailable || a.closed != 3 {
+		t.Errorf("NewAspect() after Close() error = %v; wanted unavailable", err)
+	}
+}
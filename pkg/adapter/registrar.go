@@ -33,6 +33,15 @@ type Registrar interface {
 
 	// RegisterMetricsBuilder registers a new Metrics builder.
 	RegisterMetricsBuilder(MetricsBuilder)
+
+	// RegisterAttributesGeneratorBuilder registers a new AttributesGenerator builder.
+	RegisterAttributesGeneratorBuilder(AttributesGeneratorBuilder)
+
+	// RegisterSpansBuilder registers a new Spans builder.
+	RegisterSpansBuilder(SpansBuilder)
+
+	// RegisterAuthorizationBuilder registers a new Authorization builder.
+	RegisterAuthorizationBuilder(AuthorizationBuilder)
 }
 
 // RegisterFn is a function the mixer invokes to trigger adapters to register
@@ -0,0 +1,58 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"time"
+)
+
+type (
+	// SpansAspect handles distributed tracing spans within the mixer.
+	SpansAspect interface {
+		Aspect
+
+		// ReportSpans directs a backend adapter to process a batch of
+		// spans derived from potentially several Report() calls.
+		ReportSpans([]Span) error
+	}
+
+	// Span holds a single span of a distributed trace. It is synthesized
+	// by the mixer, based on mixer config and the attributes passed to Report().
+	Span struct {
+		// TraceID identifies the trace the span belongs to.
+		TraceID string
+		// SpanID identifies the span within its trace.
+		SpanID string
+		// ParentSpanID identifies the parent of the span, it is empty for root spans.
+		ParentSpanID string
+		// Name is the name of the operation covered by the span.
+		Name string
+		// StartTime marks the beginning of the operation.
+		StartTime time.Time
+		// EndTime marks the end of the operation.
+		EndTime time.Time
+		// Tags provide metadata about the operation. They are generated
+		// from the set of attributes provided by Report().
+		Tags map[string]interface{}
+	}
+
+	// SpansBuilder builds instances of the Spans aspect.
+	SpansBuilder interface {
+		Builder
+
+		// NewSpansAspect returns a new instance of the Spans aspect.
+		NewSpansAspect(env Env, c Config) (SpansAspect, error)
+	}
+)
@@ -29,6 +29,9 @@ type fakeRegistrar struct {
 	accessLoggers []adapter.AccessLogsBuilder
 	quotas        []adapter.QuotasBuilder
 	metrics       []adapter.MetricsBuilder
+	attributes    []adapter.AttributesGeneratorBuilder
+	spans         []adapter.SpansBuilder
+	authorization []adapter.AuthorizationBuilder
 }
 
 func (r *fakeRegistrar) RegisterListsBuilder(b adapter.ListsBuilder) {
@@ -55,6 +58,18 @@ func (r *fakeRegistrar) RegisterMetricsBuilder(b adapter.MetricsBuilder) {
 	r.metrics = append(r.metrics, b)
 }
 
+func (r *fakeRegistrar) RegisterAttributesGeneratorBuilder(b adapter.AttributesGeneratorBuilder) {
+	r.attributes = append(r.attributes, b)
+}
+
+func (r *fakeRegistrar) RegisterSpansBuilder(b adapter.SpansBuilder) {
+	r.spans = append(r.spans, b)
+}
+
+func (r *fakeRegistrar) RegisterAuthorizationBuilder(b adapter.AuthorizationBuilder) {
+	r.authorization = append(r.authorization, b)
+}
+
 // AdapterInvariants ensures that adapters implement expected semantics.
 func AdapterInvariants(r adapter.RegisterFn, t *gt.T) {
 	fr := &fakeRegistrar{}
@@ -90,6 +105,21 @@ func AdapterInvariants(r adapter.RegisterFn, t *gt.T) {
 		testBuilder(b, t)
 	}
 
+	count += len(fr.attributes)
+	for _, b := range fr.attributes {
+		testBuilder(b, t)
+	}
+
+	count += len(fr.spans)
+	for _, b := range fr.spans {
+		testBuilder(b, t)
+	}
+
+	count += len(fr.authorization)
+	for _, b := range fr.authorization {
+		testBuilder(b, t)
+	}
+
 	if count == 0 {
 		t.Error("Register() => adapter didn't register any builders")
 	}
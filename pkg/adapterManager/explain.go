@@ -0,0 +1,54 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapterManager
+
+import (
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/mixer/pkg/adapterManager/explain"
+	"istio.io/mixer/pkg/attribute"
+)
+
+// debugAttribute is the bool attribute callers set to get an explanation of the outcome
+// of their requests in the details of the returned status.
+const debugAttribute = "mixer.debug"
+
+// wantsExplanation returns whether the caller asked for an explanation of the outcome of its request.
+func wantsExplanation(requestBag *attribute.MutableBag) bool {
+	if requestBag == nil {
+		return false
+	}
+	v, _ := requestBag.Get(debugAttribute)
+	debug, _ := v.(bool)
+	return debug
+}
+
+// explanation describes the results of the aspects evaluated for a request.
+func explanation(results []result) *explain.Explanation {
+	e := &explain.Explanation{Aspects: make([]*explain.AspectResult, len(results))}
+	for i, r := range results {
+		st := r.out.Status
+		e.Aspects[i] = &explain.AspectResult{
+			Kind:      r.cfg.Aspect.GetKind(),
+			Adapter:   r.cfg.Builder.GetName(),
+			Impl:      r.cfg.Builder.GetImpl(),
+			Selectors: r.cfg.Selectors,
+			Status:    &st,
+			Latency:   types.DurationProto(r.latency),
+			Shadow:    r.cfg.Shadow,
+		}
+	}
+	return e
+}
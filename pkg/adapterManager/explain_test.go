@@ -0,0 +1,144 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapterManager
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/pkg/adapterManager/explain"
+	"istio.io/mixer/pkg/aspect"
+	"istio.io/mixer/pkg/attribute"
+	configpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/pool"
+	"istio.io/mixer/pkg/status"
+)
+
+func TestWantsExplanation(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		want  bool
+	}{
+		{nil, false},
+		{true, true},
+		{false, false},
+		{"true", false},
+	}
+
+	for idx, c := range cases {
+		bag := attribute.GetMutableBag(nil)
+		if c.value != nil {
+			bag.Set(debugAttribute, c.value)
+		}
+		if got := wantsExplanation(bag); got != c.want {
+			t.Errorf("[%d] wantsExplanation(%v) = %t; wanted %t", idx, c.value, got, c.want)
+		}
+	}
+
+	if wantsExplanation(nil) {
+		t.Error("wantsExplanation(nil) = true; wanted false")
+	}
+}
+
+func TestCombineResults_Explain(t *testing.T) {
+	lists := &configpb.Combined{
+		Builder:   &configpb.Adapter{Name: "blacklist", Impl: "ipListChecker"},
+		Aspect:    &configpb.Aspect{Kind: aspect.ListsKindName},
+		Selectors: []string{"true", `target.service == "books"`},
+	}
+	denials := &configpb.Combined{
+		Builder:   &configpb.Adapter{Name: "default", Impl: "denyChecker"},
+		Aspect:    &configpb.Aspect{Kind: aspect.DenialsKindName},
+		Selectors: []string{"true"},
+		Shadow:    true,
+	}
+	results := []result{
+		{cfg: lists, out: aspect.Output{Status: status.WithPermissionDenied("10.0.0.1 rejected")}, latency: 2 * time.Millisecond},
+		{cfg: denials, out: aspect.Output{Status: status.New(rpc.FAILED_PRECONDITION)}, latency: time.Millisecond},
+	}
+
+	if o := combineResults(results, false); len(o.Status.Details) != 0 {
+		t.Errorf("combineResults(_, false) = %v; wanted no details", o.Status)
+	}
+
+	o := combineResults(results, true)
+	if o.Status.Code != int32(rpc.PERMISSION_DENIED) || len(o.Status.Details) != 1 {
+		t.Fatalf("combineResults(_, true) = %v; wanted PERMISSION_DENIED with details", o.Status)
+	}
+
+	e := &explain.Explanation{}
+	if err := types.UnmarshalAny(o.Status.Details[0], e); err != nil {
+		t.Fatalf("Unable to unmarshal the details: %v", err)
+	}
+	denied := status.WithPermissionDenied("10.0.0.1 rejected")
+	failed := status.New(rpc.FAILED_PRECONDITION)
+	want := &explain.Explanation{Aspects: []*explain.AspectResult{
+		{
+			Kind:      aspect.ListsKindName,
+			Adapter:   "blacklist",
+			Impl:      "ipListChecker",
+			Selectors: []string{"true", `target.service == "books"`},
+			Status:    &denied,
+			Latency:   types.DurationProto(2 * time.Millisecond),
+		},
+		{
+			Kind:      aspect.DenialsKindName,
+			Adapter:   "default",
+			Impl:      "denyChecker",
+			Selectors: []string{"true"},
+			Status:    &failed,
+			Latency:   types.DurationProto(time.Millisecond),
+			Shadow:    true,
+		},
+	}}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("Got explanation %v; wanted %v", e, want)
+	}
+}
+
+func TestExecute_Explain(t *testing.T) {
+	mngr := newTestManager(aspect.DenialsKindName, false, func() aspect.Output {
+		return aspect.Output{Status: status.WithPermissionDenied("denied")}
+	})
+	mreg := map[aspect.Kind]aspect.Manager{aspect.DenialsKind: mngr}
+	breg := &fakeBuilderReg{adp: mngr.instance, found: true}
+
+	gp := pool.NewGoroutinePool(1, true)
+	defer gp.Close()
+	agp := pool.NewGoroutinePool(1, true)
+	defer agp.Close()
+	m := newManager(breg, mreg, nil, nil, gp, agp)
+
+	cfg := []*configpb.Combined{
+		{Builder: &configpb.Adapter{Name: "deny"}, Aspect: &configpb.Aspect{Kind: aspect.DenialsKindName}, Selectors: []string{"true"}},
+	}
+
+	requestBag := attribute.GetMutableBag(nil)
+	requestBag.Set(debugAttribute, true)
+	o := m.Execute(context.Background(), cfg, requestBag, attribute.GetMutableBag(nil), nil)
+
+	e := &explain.Explanation{}
+	if len(o.Status.Details) != 1 || types.UnmarshalAny(o.Status.Details[0], e) != nil {
+		t.Fatalf("Execute() = %v; wanted an explanation in the details", o.Status)
+	}
+	if len(e.Aspects) != 1 || e.Aspects[0].Adapter != "deny" || e.Aspects[0].Status.Code != int32(rpc.PERMISSION_DENIED) {
+		t.Errorf("Got explanation %v; wanted the denial of the deny adapter", e)
+	}
+}
@@ -18,12 +18,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha1"
-	"encoding/gob"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	rpc "github.com/googleapis/googleapis/google/rpc"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
 
 	"istio.io/mixer/pkg/adapter"
 	"istio.io/mixer/pkg/aspect"
@@ -31,8 +36,18 @@ import (
 	"istio.io/mixer/pkg/config"
 	configpb "istio.io/mixer/pkg/config/proto"
 	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/monitoring"
 	"istio.io/mixer/pkg/pool"
 	"istio.io/mixer/pkg/status"
+	"istio.io/mixer/pkg/tracing"
+)
+
+// Tags set on the spans of adapter invocations.
+const (
+	aspectKindTag  = "aspect.kind"
+	adapterTag     = "adapter.name"
+	adapterImplTag = "adapter.impl"
+	statusTag      = "status.code"
 )
 
 // Manager manages all aspects - provides uniform interface to
@@ -46,8 +61,22 @@ type Manager struct {
 	adapterGP *pool.GoroutinePool
 
 	// protects cache
-	lock        sync.RWMutex
-	aspectCache map[cacheKey]aspect.Wrapper
+	lock         sync.RWMutex
+	aspectCache  map[cacheKey]aspect.Wrapper
+	aspectConfig map[cacheKey]*configpb.Combined
+
+	// protects the pools dedicated to adapters
+	poolLock  sync.Mutex
+	poolSizes map[string]*config.PoolConfig
+	pools     map[string]*pool.GoroutinePool
+}
+
+// poolIdleTimeout is how long the extra workers of the pools dedicated to adapters wait for work before retiring.
+const poolIdleTimeout = time.Minute
+
+// poolSizer is implemented by config resolvers that size the pools dedicated to adapters.
+type poolSizer interface {
+	AdapterPools() map[string]*config.PoolConfig
 }
 
 // builderFinder finds a builder by name.
@@ -58,6 +87,9 @@ type builderFinder interface {
 
 	// SupportedKinds returns kinds supported by a builder.
 	SupportedKinds(name string) []string
+
+	// Builders returns all known builders, indexed by name.
+	Builders() BuildersByName
 }
 
 // cacheKey is used to cache fully constructed aspects
@@ -77,8 +109,9 @@ func newCacheKey(kind aspect.Kind, cfg *configpb.Combined) (*cacheKey, error) {
 
 	//TODO pre-compute shas and store with params
 	b := pool.GetBuffer()
-	// use gob encoding so that we don't rely on proto marshal
-	enc := gob.NewEncoder(b)
+	// use json encoding so that we don't rely on proto marshal. Unlike gob, it
+	// writes maps in key order, so that equal params always get the same key.
+	enc := json.NewEncoder(b)
 
 	if cfg.Builder.GetParams() != nil {
 		if err := enc.Encode(cfg.Builder.GetParams()); err != nil {
@@ -110,13 +143,15 @@ func newManager(r builderFinder, m map[aspect.Kind]aspect.Manager, exp expr.Eval
 	am map[aspect.APIMethod]config.AspectSet, gp *pool.GoroutinePool, adapterGP *pool.GoroutinePool) *Manager {
 
 	return &Manager{
-		builders:    r,
-		managers:    m,
-		mapper:      exp,
-		methodMap:   am,
-		aspectCache: make(map[cacheKey]aspect.Wrapper),
-		gp:          gp,
-		adapterGP:   adapterGP,
+		builders:     r,
+		managers:     m,
+		mapper:       exp,
+		methodMap:    am,
+		aspectCache:  make(map[cacheKey]aspect.Wrapper),
+		aspectConfig: make(map[cacheKey]*configpb.Combined),
+		pools:        make(map[string]*pool.GoroutinePool),
+		gp:           gp,
+		adapterGP:    adapterGP,
 	}
 }
 
@@ -140,8 +175,9 @@ func (m *Manager) Execute(ctx context.Context, cfgs []*configpb.Combined,
 			childRequestBag := requestBag.Child()
 			childResponseBag := responseBag.Child()
 
+			start := time.Now()
 			out := m.execute(ctx, c, childRequestBag, childResponseBag, ma)
-			resultChan <- result{c, out, childResponseBag}
+			resultChan <- result{c, out, childResponseBag, time.Since(start)}
 
 			childRequestBag.Done()
 		})
@@ -175,15 +211,20 @@ func (m *Manager) Execute(ctx context.Context, cfgs []*configpb.Combined,
 		b.Done()
 	}
 
-	return combineResults(results)
+	return combineResults(results, wantsExplanation(requestBag))
 }
 
-// Combines a bunch of distinct result structs and turns 'em into one single Output struct
-func combineResults(results []result) aspect.Output {
+// Combines a bunch of distinct result structs and turns 'em into one single Output struct.
+// When explain is true, the status of the output details the result of each aspect.
+func combineResults(results []result, explain bool) aspect.Output {
 	var buf *bytes.Buffer
 	code := rpc.OK
 
 	for _, r := range results {
+		if r.cfg.Shadow {
+			recordShadowResult(r)
+			continue
+		}
 		if !r.out.IsOK() {
 			if buf == nil {
 				buf = pool.GetBuffer()
@@ -201,25 +242,51 @@ func combineResults(results []result) aspect.Output {
 		s = status.WithMessage(code, buf.String())
 		pool.PutBuffer(buf)
 	}
+	if explain {
+		s = status.WithDetails(s, explanation(results))
+	}
 
 	// Note that we don't try to merge multiple responses together and just
-	// take the response from the first result.
+	// take the response from the first result. Aspects in shadow mode never
+	// affect the request, so their responses are skipped.
 	var resp aspect.APIMethodResp
-	if len(results) > 0 {
-		resp = results[0].out.Response
-
-		if len(results) > 1 {
-			glog.Infof("Ignoring potential responses for %d aspects", len(results)-1)
+	enforced := 0
+	for _, r := range results {
+		if r.cfg.Shadow {
+			continue
+		}
+		if enforced == 0 {
+			resp = r.out.Response
 		}
+		enforced++
+	}
+	if enforced > 1 {
+		glog.Infof("Ignoring potential responses for %d aspects", enforced-1)
 	}
 	return aspect.Output{Status: s, Response: resp}
 }
 
+// recordShadowResult records the outcome of an aspect in shadow mode, which never fails the request.
+func recordShadowResult(r result) {
+	code := rpc.Code(r.out.Status.Code)
+	shadowCount.WithLabelValues(r.cfg.Aspect.GetKind(), r.cfg.Builder.GetName(), ruleOf(r.cfg), code.String()).Inc()
+	if !r.out.IsOK() {
+		glog.Infof("shadow aspect %s would have failed the request with %s: %s", config.Redact(r.cfg.String()), code, r.out.Message())
+	}
+}
+
+// ruleOf identifies the rule which selected an aspect by the selectors of
+// its nested rules, outermost first.
+func ruleOf(cfg *configpb.Combined) string {
+	return strings.Join(cfg.Selectors, " / ")
+}
+
 // result holds the values returned by the execution of an adapter
 type result struct {
 	cfg         *configpb.Combined
 	out         aspect.Output
 	responseBag *attribute.MutableBag
+	latency     time.Duration
 }
 
 // execute performs action described in the combined config using the attribute bag
@@ -243,11 +310,28 @@ func (m *Manager) execute(ctx context.Context, cfg *configpb.Combined, requestBa
 		return aspect.Output{Status: status.WithError(fmt.Errorf("could not find registered adapter %#v", cfg.Builder.Impl))}
 	}
 
+	span, ctx := tracing.StartChildSpan(ctx, kind.String())
+	span.SetTag(aspectKindTag, kind.String())
+	span.SetTag(adapterTag, cfg.Builder.GetName())
+	span.SetTag(adapterImplTag, adp.Name())
+
 	// Both cacheGet and asp.Execute call adapter-supplied code, so we need to guard against both panicking.
+	start := time.Now()
 	defer func() {
 		if r := recover(); r != nil {
+			panicCount.WithLabelValues(kind.String(), adp.Name()).Inc()
 			out = aspect.Output{Status: status.WithError(fmt.Errorf("adapter '%s' panicked with '%v'", adp.Name(), r))}
 		}
+		code := rpc.Code(out.Status.Code).String()
+		executionDuration.WithLabelValues(kind.String(), adp.Name()).Observe(time.Since(start).Seconds())
+		executionCount.WithLabelValues(kind.String(), adp.Name(), code).Inc()
+
+		span.SetTag(statusTag, code)
+		if !out.IsOK() {
+			ext.Error.Set(span, true)
+			span.LogFields(log.String("message", out.Message()))
+		}
+		span.Finish()
 	}()
 
 	asp, err := m.cacheGet(cfg, mgr, adp)
@@ -257,8 +341,15 @@ func (m *Manager) execute(ctx context.Context, cfg *configpb.Combined, requestBa
 
 	// TODO: plumb  ctx through asp.Execute
 	_ = ctx
-	// TODO: act on asp.Output
-	return asp.Execute(requestBag, m.mapper, ma)
+	out = asp.Execute(requestBag, m.mapper, ma)
+
+	// attributes produced while preprocessing are handed back through the response bag
+	if resp, ok := out.Response.(*aspect.PreprocessMethodResp); ok {
+		for k, v := range resp.Attrs {
+			responseBag.Set(k, v)
+		}
+	}
+	return out
 }
 
 // cacheGet gets an aspect wrapper from the cache, use adapter.Manager to construct an object in case of a cache miss
@@ -276,7 +367,7 @@ func (m *Manager) cacheGet(cfg *configpb.Combined, mgr aspect.Manager, builder a
 	}
 
 	// create an aspect
-	env := newEnv(builder.Name(), m.adapterGP)
+	env := newEnv(builder.Name(), m.adapterPool(builder.Name()))
 	asp, err = mgr.NewAspect(cfg, builder, env)
 	if err != nil {
 		return nil, err
@@ -291,6 +382,7 @@ func (m *Manager) cacheGet(cfg *configpb.Combined, mgr aspect.Manager, builder a
 	} else {
 		// your are the first one, save your aspect
 		m.aspectCache[*key] = asp
+		m.aspectConfig[*key] = cfg
 	}
 
 	m.lock.Unlock()
@@ -298,6 +390,57 @@ func (m *Manager) cacheGet(cfg *configpb.Combined, mgr aspect.Manager, builder a
 	return asp, nil
 }
 
+// adapterPool returns the worker pool for an adapter, creating it if the adapter
+// is configured to get a dedicated pool.
+func (m *Manager) adapterPool(impl string) *pool.GoroutinePool {
+	m.poolLock.Lock()
+	defer m.poolLock.Unlock()
+
+	if gp, found := m.pools[impl]; found {
+		return gp
+	}
+
+	pc, found := m.poolSizes[impl]
+	if !found {
+		return m.adapterGP
+	}
+
+	gp := pool.NewAdaptiveGoroutinePool(pc.QueueDepth, pc.MinWorkers, pc.MaxWorkers, poolIdleTimeout, m.adapterGP.SingleThreaded())
+	if err := monitoring.RegisterPool("adapter:"+impl, gp); err != nil {
+		glog.Warningf("Unable to export metrics for the worker pool of adapter %s: %v", impl, err)
+	}
+	m.pools[impl] = gp
+	return gp
+}
+
+// ConfigChange records the sizes of the pools dedicated to adapters, resizing the existing ones.
+// Queue depths only apply to pools created after the change, as do new dedicated pools.
+func (m *Manager) ConfigChange(cfg config.Resolver) {
+	m.sizePools(cfg)
+}
+
+// sizePools applies the pool sizes of cfg, returning the ones it replaced, if cfg has any.
+func (m *Manager) sizePools(cfg config.Resolver) (previous map[string]*config.PoolConfig, resized bool) {
+	ps, ok := cfg.(poolSizer)
+	if !ok {
+		return nil, false
+	}
+	return m.setPoolSizes(ps.AdapterPools()), true
+}
+
+func (m *Manager) setPoolSizes(sizes map[string]*config.PoolConfig) (previous map[string]*config.PoolConfig) {
+	m.poolLock.Lock()
+	previous = m.poolSizes
+	m.poolSizes = sizes
+	for impl, gp := range m.pools {
+		if pc, found := sizes[impl]; found {
+			gp.SetWorkerLimits(pc.MinWorkers, pc.MaxWorkers)
+		}
+	}
+	m.poolLock.Unlock()
+	return previous
+}
+
 func closeWrapper(asp aspect.Wrapper) {
 	if err := asp.Close(); err != nil {
 		glog.Warningf("Error closing aspect: %v: %v", asp, err)
@@ -335,6 +478,38 @@ func (m *Manager) MethodMap() map[aspect.APIMethod]config.AspectSet {
 	return m.methodMap
 }
 
+// Close drains the pools dedicated to adapters, then closes all cached aspects and all registered builders.
+// The manager must not be used to execute aspects afterwards.
+func (m *Manager) Close() error {
+	var result *multierror.Error
+
+	// let the adapters finish their queued work first
+	m.poolLock.Lock()
+	for _, gp := range m.pools {
+		gp.Close()
+	}
+	m.pools = make(map[string]*pool.GoroutinePool)
+	m.poolLock.Unlock()
+
+	m.lock.Lock()
+	for key, asp := range m.aspectCache {
+		if err := asp.Close(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("unable to close aspect %s/%s: %v", key.kind, key.impl, err))
+		}
+	}
+	m.aspectCache = make(map[cacheKey]aspect.Wrapper)
+	m.aspectConfig = make(map[cacheKey]*configpb.Combined)
+	m.lock.Unlock()
+
+	for name, bi := range m.builders.Builders() {
+		if err := bi.Builder.Close(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("unable to close builder %s: %v", name, err))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
 // ProcessBindings returns a fully constructed manager map and aspectSet.
 func ProcessBindings(managers aspect.ManagerInventory) (map[aspect.Kind]aspect.Manager, map[aspect.APIMethod]config.AspectSet) {
 	r := make(map[aspect.Kind]aspect.Manager)
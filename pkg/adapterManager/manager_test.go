@@ -22,6 +22,10 @@ import (
 	"time"
 
 	rpc "github.com/googleapis/googleapis/google/rpc"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 
 	"istio.io/mixer/pkg/adapter"
 	"istio.io/mixer/pkg/aspect"
@@ -45,6 +49,7 @@ type (
 		kind   aspect.Kind
 		w      *fakewrapper
 		called int8
+		env    adapter.Env
 	}
 
 	fakeevaluator struct {
@@ -63,22 +68,28 @@ type (
 	}
 
 	fakewrapper struct {
-		called int8
+		called   int8
+		closed   int
+		closeErr error
 	}
 
 	fakeadp struct {
-		name string
+		name     string
+		closed   int
+		closeErr error
 		adapter.Builder
 	}
 )
 
-func (f *fakeadp) Name() string { return f.name }
+func (f *fakeadp) Name() string        { return f.name }
+func (f *fakeadp) Description() string { return f.name + " description" }
+func (f *fakeadp) Close() error        { f.closed++; return f.closeErr }
 
 func (f *fakewrapper) Execute(attrs attribute.Bag, mapper expr.Evaluator, ma aspect.APIMethodArgs) (output aspect.Output) {
 	f.called++
 	return
 }
-func (f *fakewrapper) Close() error { return nil }
+func (f *fakewrapper) Close() error { f.closed++; return f.closeErr }
 
 func (m *fakemgr) Kind() aspect.Kind {
 	return m.kind
@@ -116,6 +127,7 @@ func (testAspect) Description() string                                 { return
 
 func (m *fakemgr) NewAspect(cfg *configpb.Combined, adp adapter.Builder, env adapter.Env) (aspect.Wrapper, error) {
 	m.called++
+	m.env = env
 	if m.w == nil {
 		return nil, errors.New("unable to create aspect")
 	}
@@ -131,6 +143,10 @@ func (m *fakeBuilderReg) SupportedKinds(name string) []string {
 	return m.kinds
 }
 
+func (m *fakeBuilderReg) Builders() BuildersByName {
+	return BuildersByName{m.adp.Name(): &builderInfo{Builder: m.adp, Kinds: m.kinds}}
+}
+
 type ttable struct {
 	mgrFound  bool
 	kindFound bool
@@ -178,8 +194,8 @@ func TestManager(t *testing.T) {
 		{true, false, "could not find registered adapter", nil, []*configpb.Combined{goodcfg}},
 		{true, true, "", &fakewrapper{}, []*configpb.Combined{goodcfg}},
 		{true, true, "", nil, []*configpb.Combined{goodcfg}},
-		{true, true, "can't handle type", nil, []*configpb.Combined{badcfg1}},
-		{true, true, "can't handle type", nil, []*configpb.Combined{badcfg2}},
+		{true, true, "unsupported type", nil, []*configpb.Combined{badcfg1}},
+		{true, true, "unsupported type", nil, []*configpb.Combined{badcfg2}},
 	}
 
 	for idx, tt := range ttt {
@@ -228,6 +244,39 @@ func TestManager(t *testing.T) {
 	}
 }
 
+func TestCacheKey(t *testing.T) {
+	type labelsParams struct {
+		Labels map[string]string
+	}
+	combined := func(labels map[string]string) *configpb.Combined {
+		return &configpb.Combined{
+			Builder: &configpb.Adapter{Impl: "impl", Params: &labelsParams{}},
+			Aspect:  &configpb.Aspect{Kind: "metrics", Params: &labelsParams{Labels: labels}},
+		}
+	}
+	labels := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6"}
+
+	want, err := newCacheKey(aspect.MetricsKind, combined(labels))
+	if err != nil {
+		t.Fatalf("newCacheKey() failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		// equal params held by different rules get the same key
+		copied := make(map[string]string, len(labels))
+		for k, v := range labels {
+			copied[k] = v
+		}
+		if got, _ := newCacheKey(aspect.MetricsKind, combined(copied)); *got != *want {
+			t.Fatalf("newCacheKey() = %v; wanted %v for equal params", got, want)
+		}
+	}
+
+	labels["a"] = "changed"
+	if got, _ := newCacheKey(aspect.MetricsKind, combined(labels)); *got == *want {
+		t.Error("newCacheKey() returned the same key for different params")
+	}
+}
+
 func TestManager_BulkExecute(t *testing.T) {
 	goodcfg := &configpb.Combined{
 		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: &rpc.Status{}},
@@ -251,8 +300,8 @@ func TestManager_BulkExecute(t *testing.T) {
 		{"", []*configpb.Combined{}},
 		{"", []*configpb.Combined{goodcfg}},
 		{"", []*configpb.Combined{goodcfg, goodcfg}},
-		{"can't handle type", []*configpb.Combined{badcfg1, goodcfg}},
-		{"can't handle type", []*configpb.Combined{goodcfg, badcfg2}},
+		{"unsupported type", []*configpb.Combined{badcfg1, goodcfg}},
+		{"unsupported type", []*configpb.Combined{goodcfg, badcfg2}},
 	}
 
 	requestBag := attribute.GetMutableBag(nil)
@@ -357,7 +406,7 @@ func TestExecute(t *testing.T) {
 		m := newManager(breg, mreg, nil, nil, gp, agp)
 
 		cfg := []*configpb.Combined{
-			{&configpb.Adapter{Name: c.name}, &configpb.Aspect{Kind: c.name}},
+			{Builder: &configpb.Adapter{Name: c.name}, Aspect: &configpb.Aspect{Kind: c.name}},
 		}
 
 		o := m.Execute(context.Background(), cfg, nil, nil, nil)
@@ -391,7 +440,7 @@ func TestExecute_Cancellation(t *testing.T) {
 	cancel()
 
 	cfg := []*configpb.Combined{
-		{&configpb.Adapter{Name: ""}, &configpb.Aspect{Kind: ""}},
+		{Builder: &configpb.Adapter{Name: ""}, Aspect: &configpb.Aspect{Kind: ""}},
 	}
 	if out := handler.Execute(ctx, cfg, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil); out.IsOK() {
 		t.Error("handler.Execute(canceledContext, ...) = _, nil; wanted any err")
@@ -432,8 +481,8 @@ func TestExecute_TimeoutWaitingForResults(t *testing.T) {
 	}()
 
 	cfg := []*configpb.Combined{{
-		&configpb.Adapter{Name: name},
-		&configpb.Aspect{Kind: name},
+		Builder: &configpb.Adapter{Name: name},
+		Aspect:  &configpb.Aspect{Kind: name},
 	}}
 	if out := m.Execute(ctx, cfg, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil); out.IsOK() {
 		t.Error("handler.Execute(canceledContext, ...) = _, nil; wanted any err")
@@ -443,3 +492,318 @@ func TestExecute_TimeoutWaitingForResults(t *testing.T) {
 	gp.Close()
 	agp.Close()
 }
+
+type panickyWrapper struct{}
+
+func (panickyWrapper) Execute(attribute.Bag, expr.Evaluator, aspect.APIMethodArgs) aspect.Output {
+	panic("panic")
+}
+func (panickyWrapper) Close() error { return nil }
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		t.Fatalf("Unable to read counter: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestCombineResults_Shadow(t *testing.T) {
+	enforced := &configpb.Combined{
+		Builder: &configpb.Adapter{Name: "enforced"},
+		Aspect:  &configpb.Aspect{Kind: aspect.ListsKindName},
+	}
+	shadow := &configpb.Combined{
+		Builder:   &configpb.Adapter{Name: "shadowBlacklist"},
+		Aspect:    &configpb.Aspect{Kind: aspect.ListsKindName},
+		Shadow:    true,
+		Selectors: []string{"true", `source.name == "a"`},
+	}
+	// another rule shadowing the same adapter
+	other := &configpb.Combined{
+		Builder:   &configpb.Adapter{Name: "shadowBlacklist"},
+		Aspect:    &configpb.Aspect{Kind: aspect.ListsKindName},
+		Shadow:    true,
+		Selectors: []string{"true", `source.name == "b"`},
+	}
+	denied := aspect.Output{Status: status.WithPermissionDenied("10.0.0.1 rejected")}
+	ok := aspect.Output{Status: status.OK}
+
+	cases := []struct {
+		results  []result
+		wantCode rpc.Code
+		denials  float64
+	}{
+		{[]result{{cfg: shadow, out: denied}}, rpc.OK, 1},
+		{[]result{{cfg: shadow, out: ok}, {cfg: enforced, out: ok}}, rpc.OK, 1},
+		{[]result{{cfg: shadow, out: denied}, {cfg: enforced, out: denied}}, rpc.PERMISSION_DENIED, 2},
+		{[]result{{cfg: other, out: denied}}, rpc.OK, 2},
+	}
+
+	rule := `true / source.name == "a"`
+	for idx, c := range cases {
+		o := combineResults(c.results, false)
+		if rpc.Code(o.Status.Code) != c.wantCode {
+			t.Errorf("[%d] combineResults() = %v; wanted code %v", idx, o.Status, c.wantCode)
+		}
+		if strings.Contains(o.Message(), "shadowBlacklist") {
+			t.Errorf("[%d] combineResults() = %v; wanted no mention of the shadow aspect", idx, o.Status)
+		}
+		if got := counterValue(t, shadowCount.WithLabelValues(aspect.ListsKindName, "shadowBlacklist", rule, rpc.PERMISSION_DENIED.String())); got != c.denials {
+			t.Errorf("[%d] Got %v shadow denials, expecting %v", idx, got, c.denials)
+		}
+	}
+
+	if got := counterValue(t, shadowCount.WithLabelValues(aspect.ListsKindName, "shadowBlacklist", rule, rpc.OK.String())); got != 1 {
+		t.Errorf("Got %v shadow successes, expecting 1", got)
+	}
+	otherRule := `true / source.name == "b"`
+	if got := counterValue(t, shadowCount.WithLabelValues(aspect.ListsKindName, "shadowBlacklist", otherRule, rpc.PERMISSION_DENIED.String())); got != 1 {
+		t.Errorf("Got %v shadow denials of the other rule, expecting 1", got)
+	}
+}
+
+func TestCombineResults_ShadowResponse(t *testing.T) {
+	shadow := &configpb.Combined{
+		Builder: &configpb.Adapter{Name: "shadowQuota"},
+		Aspect:  &configpb.Aspect{Kind: aspect.QuotasKindName},
+		Shadow:  true,
+	}
+	enforced := &configpb.Combined{
+		Builder: &configpb.Adapter{Name: "quota"},
+		Aspect:  &configpb.Aspect{Kind: aspect.QuotasKindName},
+	}
+	shadowResp := &aspect.QuotaMethodResp{Amount: 100}
+	enforcedResp := &aspect.QuotaMethodResp{Amount: 1}
+
+	cases := []struct {
+		results []result
+		want    aspect.APIMethodResp
+	}{
+		{[]result{{cfg: shadow, out: aspect.Output{Status: status.OK, Response: shadowResp}}}, nil},
+		{[]result{
+			{cfg: shadow, out: aspect.Output{Status: status.OK, Response: shadowResp}},
+			{cfg: enforced, out: aspect.Output{Status: status.OK, Response: enforcedResp}},
+		}, enforcedResp},
+	}
+
+	for idx, c := range cases {
+		if o := combineResults(c.results, false); o.Response != c.want {
+			t.Errorf("[%d] combineResults().Response = %v; wanted %v", idx, o.Response, c.want)
+		}
+	}
+}
+
+func TestManager_Metrics(t *testing.T) {
+	cfg := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Kind: aspect.DenialsKindName, Impl: "metrics", Params: &rpc.Status{}},
+	}
+	kind := aspect.DenialsKind.String()
+
+	cases := []struct {
+		adapter string
+		wrapper aspect.Wrapper
+		code    rpc.Code
+		panics  float64
+	}{
+		{"metricsOK", &fakewrapper{}, rpc.OK, 0},
+		{"metricsPanic", panickyWrapper{}, rpc.INTERNAL, 1},
+	}
+
+	for idx, c := range cases {
+		r := &fakeBuilderReg{&fakeadp{name: c.adapter}, true, []string{kind}}
+		mreg := map[aspect.Kind]aspect.Manager{aspect.DenialsKind: &fakemgr{kind: aspect.DenialsKind}}
+
+		gp := pool.NewGoroutinePool(1, true)
+		agp := pool.NewGoroutinePool(1, true)
+		m := newManager(r, mreg, &fakeevaluator{}, nil, gp, agp)
+
+		// seed the cache so the wrapper under test gets executed
+		key, _ := newCacheKey(aspect.DenialsKind, cfg)
+		m.aspectCache[*key] = c.wrapper
+
+		_ = m.Execute(context.Background(), []*configpb.Combined{cfg}, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil)
+
+		if got := counterValue(t, executionCount.WithLabelValues(kind, c.adapter, c.code.String())); got != 1 {
+			t.Errorf("[%d] Got %v executions with code %v, expecting 1", idx, got, c.code)
+		}
+		if got := counterValue(t, panicCount.WithLabelValues(kind, c.adapter)); got != c.panics {
+			t.Errorf("[%d] Got %v panics, expecting %v", idx, got, c.panics)
+		}
+
+		gp.Close()
+		agp.Close()
+	}
+}
+
+func TestManager_Tracing(t *testing.T) {
+	cfg := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Name: "deny", Kind: aspect.DenialsKindName, Impl: "denyImpl", Params: &rpc.Status{}},
+	}
+	kind := aspect.DenialsKind.String()
+
+	cases := []struct {
+		wrapper aspect.Wrapper
+		code    rpc.Code
+		isError bool
+	}{
+		{&fakewrapper{}, rpc.OK, false},
+		{panickyWrapper{}, rpc.INTERNAL, true},
+	}
+
+	for idx, c := range cases {
+		r := &fakeBuilderReg{&fakeadp{name: "denyImpl"}, true, []string{kind}}
+		mreg := map[aspect.Kind]aspect.Manager{aspect.DenialsKind: &fakemgr{kind: aspect.DenialsKind}}
+
+		gp := pool.NewGoroutinePool(1, true)
+		agp := pool.NewGoroutinePool(1, true)
+		m := newManager(r, mreg, &fakeevaluator{}, nil, gp, agp)
+
+		key, _ := newCacheKey(aspect.DenialsKind, cfg)
+		m.aspectCache[*key] = c.wrapper
+
+		tracer := mocktracer.New()
+		parent := tracer.StartSpan("parent")
+		ctx := ot.ContextWithSpan(context.Background(), parent)
+
+		_ = m.Execute(ctx, []*configpb.Combined{cfg}, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil)
+
+		spans := tracer.FinishedSpans()
+		if len(spans) != 1 {
+			t.Fatalf("[%d] Got %d finished spans, expecting 1", idx, len(spans))
+		}
+
+		span := spans[0]
+		if span.OperationName != kind || span.ParentID != parent.(*mocktracer.MockSpan).SpanContext.SpanID {
+			t.Errorf("[%d] Got span %s with parent %d, expecting a child span named %s", idx, span.OperationName, span.ParentID, kind)
+		}
+
+		want := map[string]interface{}{
+			aspectKindTag:  kind,
+			adapterTag:     "deny",
+			adapterImplTag: "denyImpl",
+			statusTag:      c.code.String(),
+		}
+		for k, v := range want {
+			if got := span.Tag(k); got != v {
+				t.Errorf("[%d] Got tag %s=%v, expecting %v", idx, k, got, v)
+			}
+		}
+		if isError := span.Tag("error") == true; isError != c.isError {
+			t.Errorf("[%d] Got error tag %v, expecting %v", idx, isError, c.isError)
+		}
+
+		gp.Close()
+		agp.Close()
+	}
+}
+
+func TestManager_Close(t *testing.T) {
+	cfg := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Kind: aspect.DenialsKindName, Impl: "k1impl1", Params: &rpc.Status{}},
+	}
+
+	cases := []struct {
+		wrapperErr error
+		builderErr error
+		errString  string
+	}{
+		{nil, nil, ""},
+		{errors.New("wrapper failure"), nil, "wrapper failure"},
+		{nil, errors.New("builder failure"), "builder failure"},
+	}
+
+	for idx, c := range cases {
+		w := &fakewrapper{closeErr: c.wrapperErr}
+		r := getReg(true)
+		adp := r.adp.(*fakeadp)
+		adp.closeErr = c.builderErr
+
+		gp := pool.NewGoroutinePool(1, true)
+		agp := pool.NewGoroutinePool(1, true)
+		m := newManager(r, newFakeMgrReg(w), &fakeevaluator{}, nil, gp, agp)
+
+		if out := m.Execute(context.Background(), []*configpb.Combined{cfg}, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil); !out.IsOK() {
+			t.Fatalf("[%d] Execute() failed: %v", idx, out.Message())
+		}
+
+		err := m.Close()
+		if c.errString == "" && err != nil {
+			t.Errorf("[%d] Close() failed: %v", idx, err)
+		} else if c.errString != "" && (err == nil || !strings.Contains(err.Error(), c.errString)) {
+			t.Errorf("[%d] Close() returned %v, expecting '%s'", idx, err, c.errString)
+		}
+
+		if w.closed != 1 || adp.closed != 1 {
+			t.Errorf("[%d] Got %d aspect and %d builder closes, expecting 1 each", idx, w.closed, adp.closed)
+		}
+		if len(m.Aspects()) != 0 {
+			t.Errorf("[%d] Aspects still cached after Close()", idx)
+		}
+
+		gp.Close()
+		agp.Close()
+	}
+}
+
+type fakePoolSizer struct {
+	config.Resolver
+	pools map[string]*config.PoolConfig
+}
+
+func (f fakePoolSizer) AdapterPools() map[string]*config.PoolConfig { return f.pools }
+
+func TestManager_AdapterPools(t *testing.T) {
+	cfg := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Kind: aspect.DenialsKindName, Impl: "k1impl1", Params: &rpc.Status{}},
+	}
+
+	cases := []struct {
+		pools     map[string]*config.PoolConfig
+		dedicated bool
+	}{
+		{nil, false},
+		{map[string]*config.PoolConfig{"other": {Impl: "other", QueueDepth: 4, MinWorkers: 1, MaxWorkers: 2}}, false},
+		{map[string]*config.PoolConfig{"k1impl1": {Impl: "k1impl1", QueueDepth: 4, MinWorkers: 1, MaxWorkers: 2}}, true},
+	}
+
+	for idx, c := range cases {
+		mreg := newFakeMgrReg(&fakewrapper{})
+		gp := pool.NewGoroutinePool(1, false)
+		agp := pool.NewGoroutinePool(1, false)
+		m := newManager(getReg(true), mreg, &fakeevaluator{}, nil, gp, agp)
+
+		m.ConfigChange(fakePoolSizer{pools: c.pools})
+		if out := m.Execute(context.Background(), []*configpb.Combined{cfg}, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil); !out.IsOK() {
+			t.Fatalf("[%d] Execute() failed: %v", idx, out.Message())
+		}
+
+		e := mreg[aspect.DenialsKind].(*fakemgr).env.(env)
+		if c.dedicated {
+			dedicated := m.pools["k1impl1"]
+			if dedicated == nil || e.gp != dedicated {
+				t.Errorf("[%d] Aspect didn't get a dedicated pool", idx)
+			} else {
+				// resizing applies to the existing pool
+				m.ConfigChange(fakePoolSizer{pools: map[string]*config.PoolConfig{
+					"k1impl1": {Impl: "k1impl1", QueueDepth: 4, MinWorkers: 3, MaxWorkers: 4},
+				}})
+				if dedicated.Workers() != 3 {
+					t.Errorf("[%d] Got %d workers after resizing, expecting 3", idx, dedicated.Workers())
+				}
+			}
+		} else if e.gp != agp {
+			t.Errorf("[%d] Aspect didn't get the shared adapter pool", idx)
+		}
+
+		if err := m.Close(); err != nil {
+			t.Errorf("[%d] Close() failed: %v", idx, err)
+		}
+		gp.Close()
+		agp.Close()
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapterManager
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/mixer/pkg/monitoring"
+)
+
+// Labels used by the adapter metrics.
+const (
+	kindLabel    = "kind"
+	adapterLabel = "adapter"
+	codeLabel    = "code"
+	outcomeLabel = "outcome"
+	ruleLabel    = "rule"
+)
+
+var (
+	executionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "adapter",
+		Name:      "execution_duration_seconds",
+		Help:      "Time spent executing aspects, by aspect kind and adapter.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{kindLabel, adapterLabel})
+
+	executionCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "adapter",
+		Name:      "executions_total",
+		Help:      "Number of aspect executions, by aspect kind, adapter and response code.",
+	}, []string{kindLabel, adapterLabel, codeLabel})
+
+	panicCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "adapter",
+		Name:      "panics_total",
+		Help:      "Number of panics raised by adapters.",
+	}, []string{kindLabel, adapterLabel})
+
+	shadowCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "adapter",
+		Name:      "shadow_results_total",
+		Help:      "Number of results of aspects in shadow mode, by aspect kind, adapter, rule and the response code they would have returned.",
+	}, []string{kindLabel, adapterLabel, ruleLabel, outcomeLabel})
+
+	warmUpCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "adapter",
+		Name:      "warm_up_builds_total",
+		Help:      "Number of aspects built ahead of the installation of a config, by aspect kind, adapter and outcome.",
+	}, []string{kindLabel, adapterLabel, outcomeLabel})
+)
+
+func init() {
+	monitoring.MustRegister(executionDuration, executionCount, panicCount, shadowCount, warmUpCount)
+}
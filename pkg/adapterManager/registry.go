@@ -76,6 +76,11 @@ func (r *registry) SupportedKinds(name string) []string {
 	return bi.Kinds
 }
 
+// Builders returns all registered builders, indexed by name.
+func (r *registry) Builders() BuildersByName {
+	return r.builders
+}
+
 // RegisterListsBuilder registers a new ListChecker builder.
 func (r *registry) RegisterListsBuilder(b adapter.ListsBuilder) {
 	r.insert(aspect.ListsKind, b)
@@ -106,6 +111,21 @@ func (r *registry) RegisterMetricsBuilder(b adapter.MetricsBuilder) {
 	r.insert(aspect.MetricsKind, b)
 }
 
+// RegisterAttributesGeneratorBuilder registers a new AttributesGenerator builder.
+func (r *registry) RegisterAttributesGeneratorBuilder(b adapter.AttributesGeneratorBuilder) {
+	r.insert(aspect.AttributesKind, b)
+}
+
+// RegisterSpansBuilder registers a new Spans builder.
+func (r *registry) RegisterSpansBuilder(b adapter.SpansBuilder) {
+	r.insert(aspect.SpansKind, b)
+}
+
+// RegisterAuthorizationBuilder registers a new Authorization builder.
+func (r *registry) RegisterAuthorizationBuilder(b adapter.AuthorizationBuilder) {
+	r.insert(aspect.AuthorizationKind, b)
+}
+
 func (r *registry) insert(k aspect.Kind, b adapter.Builder) {
 	kind := k.String()
 	ok := true
@@ -166,6 +166,66 @@ func TestRegisterMetrics(t *testing.T) {
 	}
 }
 
+type attributesBuilder struct{ testBuilder }
+
+func (attributesBuilder) NewAttributesGenerator(adapter.Env, adapter.Config) (adapter.AttributesGenerator, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestRegisterAttributesGenerator(t *testing.T) {
+	reg := newRegistry(nil)
+	builder := attributesBuilder{testBuilder{name: "foo"}}
+
+	reg.RegisterAttributesGeneratorBuilder(builder)
+	impl, _ := reg.FindBuilder(builder.Name())
+	if impl != builder {
+		t.Errorf("Got :%#v, want: %#v", impl, builder)
+	}
+	if kinds := reg.SupportedKinds(builder.Name()); len(kinds) != 1 || kinds[0] != aspect.AttributesKindName {
+		t.Errorf("SupportedKinds: got %v, want [%s]", kinds, aspect.AttributesKindName)
+	}
+}
+
+type spansBuilder struct{ testBuilder }
+
+func (spansBuilder) NewSpansAspect(adapter.Env, adapter.Config) (adapter.SpansAspect, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestRegisterSpans(t *testing.T) {
+	reg := newRegistry(nil)
+	builder := spansBuilder{testBuilder{name: "foo"}}
+
+	reg.RegisterSpansBuilder(builder)
+	impl, _ := reg.FindBuilder(builder.Name())
+	if impl != builder {
+		t.Errorf("Got :%#v, want: %#v", impl, builder)
+	}
+	if kinds := reg.SupportedKinds(builder.Name()); len(kinds) != 1 || kinds[0] != aspect.SpansKindName {
+		t.Errorf("SupportedKinds: got %v, want [%s]", kinds, aspect.SpansKindName)
+	}
+}
+
+type authorizationBuilder struct{ testBuilder }
+
+func (authorizationBuilder) NewAuthorizationAspect(adapter.Env, adapter.Config) (adapter.AuthorizationAspect, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestRegisterAuthorization(t *testing.T) {
+	reg := newRegistry(nil)
+	builder := authorizationBuilder{testBuilder{name: "foo"}}
+
+	reg.RegisterAuthorizationBuilder(builder)
+	impl, _ := reg.FindBuilder(builder.Name())
+	if impl != builder {
+		t.Errorf("Got :%#v, want: %#v", impl, builder)
+	}
+	if kinds := reg.SupportedKinds(builder.Name()); len(kinds) != 1 || kinds[0] != aspect.AuthorizationKindName {
+		t.Errorf("SupportedKinds: got %v, want [%s]", kinds, aspect.AuthorizationKindName)
+	}
+}
+
 func TestCollision(t *testing.T) {
 	reg := newRegistry(nil)
 	name := "some name that they both have"
@@ -0,0 +1,125 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapterManager
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"istio.io/mixer/pkg/config"
+)
+
+// BuilderStatus describes a registered adapter builder.
+type BuilderStatus struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Kinds       []string `json:"kinds"`
+}
+
+// AspectStatus describes a live aspect held in the manager's cache.
+// The secrets the builder params refer to are redacted.
+type AspectStatus struct {
+	Kind          string      `json:"kind"`
+	Impl          string      `json:"impl"`
+	Adapter       string      `json:"adapter"`
+	BuilderParams interface{} `json:"builderParams"`
+	AspectParams  interface{} `json:"aspectParams"`
+}
+
+// Builders returns the registered builders, sorted by name.
+func (m *Manager) Builders() []BuilderStatus {
+	builders := m.builders.Builders()
+	result := make([]BuilderStatus, 0, len(builders))
+	for name, bi := range builders {
+		result = append(result, BuilderStatus{Name: name, Description: bi.Builder.Description(), Kinds: bi.Kinds})
+	}
+	sort.Sort(byBuilderName(result))
+	return result
+}
+
+// Aspects returns the aspects currently cached, along with the params they were created with.
+func (m *Manager) Aspects() []AspectStatus {
+	m.lock.RLock()
+	result := make([]AspectStatus, 0, len(m.aspectConfig))
+	for key, cfg := range m.aspectConfig {
+		result = append(result, AspectStatus{
+			Kind:          key.kind.String(),
+			Impl:          key.impl,
+			Adapter:       cfg.Builder.GetName(),
+			BuilderParams: redactParams(cfg.Builder.GetParams()),
+			AspectParams:  cfg.Aspect.GetParams(),
+		})
+	}
+	m.lock.RUnlock()
+
+	sort.Sort(byAspect(result))
+	return result
+}
+
+// redactParams returns the JSON form of params with the values of secrets redacted.
+// Strings are redacted once decoded, as their encoded form may escape parts of secrets.
+func redactParams(params interface{}) interface{} {
+	if params == nil {
+		return nil
+	}
+	var v interface{}
+	b, err := json.Marshal(params)
+	if err == nil {
+		err = json.Unmarshal(b, &v)
+	}
+	if err != nil {
+		glog.Warningf("Unable to redact adapter params: %v", err)
+		return nil
+	}
+	return redactValue(v)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, e := range vv {
+			vv[k] = redactValue(e)
+		}
+	case []interface{}:
+		for i, e := range vv {
+			vv[i] = redactValue(e)
+		}
+	case string:
+		return config.Redact(vv)
+	}
+	return v
+}
+
+type byBuilderName []BuilderStatus
+
+func (b byBuilderName) Len() int           { return len(b) }
+func (b byBuilderName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byBuilderName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+
+type byAspect []AspectStatus
+
+func (a byAspect) Len() int      { return len(a) }
+func (a byAspect) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byAspect) Less(i, j int) bool {
+	if a[i].Kind != a[j].Kind {
+		return a[i].Kind < a[j].Kind
+	}
+	if a[i].Impl != a[j].Impl {
+		return a[i].Impl < a[j].Impl
+	}
+	return a[i].Adapter < a[j].Adapter
+}
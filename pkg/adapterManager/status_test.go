@@ -0,0 +1,132 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapterManager
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/aspect"
+	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/config"
+	configpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/pool"
+)
+
+func TestStatus(t *testing.T) {
+	aspectParams := &rpc.Status{Code: int32(rpc.PERMISSION_DENIED)}
+	cfg := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: aspectParams},
+		Builder: &configpb.Adapter{Name: "deny", Kind: aspect.DenialsKindName, Impl: "k1impl1", Params: &rpc.Status{}},
+	}
+
+	gp := pool.NewGoroutinePool(1, true)
+	agp := pool.NewGoroutinePool(1, true)
+	defer gp.Close()
+	defer agp.Close()
+	m := newManager(getReg(true), newFakeMgrReg(&fakewrapper{}), &fakeevaluator{}, nil, gp, agp)
+
+	wantBuilders := []BuilderStatus{{Name: "k1impl1", Description: "k1impl1 description", Kinds: []string{aspect.DenialsKindName}}}
+	if got := m.Builders(); !reflect.DeepEqual(got, wantBuilders) {
+		t.Errorf("Builders() = %v, expecting %v", got, wantBuilders)
+	}
+
+	if got := m.Aspects(); len(got) != 0 {
+		t.Errorf("Aspects() = %v before any execution, expecting none", got)
+	}
+
+	out := m.Execute(context.Background(), []*configpb.Combined{cfg}, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil)
+	if !out.IsOK() {
+		t.Fatalf("Execute() failed: %v", out.Message())
+	}
+
+	wantAspects := []AspectStatus{{
+		Kind:          aspect.DenialsKindName,
+		Impl:          "k1impl1",
+		Adapter:       "deny",
+		BuilderParams: map[string]interface{}{},
+		AspectParams:  aspectParams,
+	}}
+	if got := m.Aspects(); !reflect.DeepEqual(got, wantAspects) {
+		t.Errorf("Aspects() = %v, expecting %v", got, wantAspects)
+	}
+}
+
+// secretBuilder is a builder whose params hold a message, which may refer to a secret.
+type secretBuilder struct {
+	*fakeadp
+}
+
+func (secretBuilder) DefaultConfig() adapter.Config                         { return &rpc.Status{} }
+func (secretBuilder) ValidateConfig(c adapter.Config) *adapter.ConfigErrors { return nil }
+
+func TestStatus_RedactsSecrets(t *testing.T) {
+	const secret = "s3cr3t<&>"
+	if err := os.Setenv("ADAPTERZ_TEST_SECRET", secret); err != nil {
+		t.Fatalf("Unable to set the secret: %v", err)
+	}
+	defer func() { _ = os.Unsetenv("ADAPTERZ_TEST_SECRET") }()
+
+	gp := pool.NewGoroutinePool(1, true)
+	agp := pool.NewGoroutinePool(1, true)
+	defer gp.Close()
+	defer agp.Close()
+	r := &fakeBuilderReg{secretBuilder{&fakeadp{name: "k1impl1"}}, true, []string{aspect.DenialsKindName}}
+	m := newManager(r, newFakeMgrReg(&fakewrapper{}), &fakeevaluator{}, nil, gp, agp)
+
+	// resolving the secret the way the config manager does registers it for redaction
+	gc := `
+subject: namespace:ns
+revision: "1"
+adapters:
+- name: deny
+  kind: denials
+  impl: k1impl1
+  params:
+    message: ${env:ADAPTERZ_TEST_SECRET}
+`
+	v := config.NewValidator(m.AspectValidatorFinder(), m.BuilderValidatorFinder(), m.AdapterToAspectMapperFunc(), true, &fakeevaluator{})
+	if _, ce := v.Validate("subject: namespace:ns\nrevision: \"1\"\n", gc); ce != nil {
+		t.Fatalf("Validate() = %v", ce)
+	}
+
+	cfg := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Name: "deny", Kind: aspect.DenialsKindName, Impl: "k1impl1", Params: &rpc.Status{Message: "key=" + secret}},
+	}
+	out := m.Execute(context.Background(), []*configpb.Combined{cfg}, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil)
+	if !out.IsOK() {
+		t.Fatalf("Execute() failed: %v", out.Message())
+	}
+
+	b, err := json.Marshal(m.Aspects())
+	if err != nil {
+		t.Fatalf("Unable to marshal the aspects: %v", err)
+	}
+	if strings.Contains(string(b), "s3cr3t") {
+		t.Errorf("Aspects() = %s; wanted the secret redacted", b)
+	}
+	want := map[string]interface{}{"message": "key=<redacted>"}
+	if got := m.Aspects()[0].BuilderParams; !reflect.DeepEqual(got, want) {
+		t.Errorf("BuilderParams = %v; wanted %v", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapterManager
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/mixer/pkg/aspect"
+	"istio.io/mixer/pkg/config"
+	configpb "istio.io/mixer/pkg/config/proto"
+)
+
+// Outcomes of warm-up builds.
+const (
+	warmUpBuilt  = "built"
+	warmUpFailed = "failed"
+)
+
+// aspectLister is implemented by config resolvers that list every aspect they may resolve to.
+type aspectLister interface {
+	Aspects() []*configpb.Combined
+}
+
+// warmUpBuild is an aspect built by WarmUp.
+type warmUpBuild struct {
+	cfg *configpb.Combined
+	key cacheKey
+	err error
+}
+
+// WarmUp builds the aspects cfg may resolve to that aren't cached yet, so that
+// requests don't pay for their construction once cfg is installed. It fails,
+// closes the aspects it built and restores the pool sizes, when an aspect
+// can't be built. Aspects in
+// shadow mode never fail requests, their build errors are only logged.
+func (m *Manager) WarmUp(cfg config.Resolver) error {
+	al, ok := cfg.(aspectLister)
+	if !ok {
+		return nil
+	}
+	// aspects get the pool of their adapter when built, the sizes of the
+	// installed config are restored if cfg can't be installed
+	previous, resized := m.sizePools(cfg)
+
+	var builds []*warmUpBuild
+	seen := make(map[cacheKey]bool)
+	for _, c := range al.Aspects() {
+		b := &warmUpBuild{cfg: c}
+		builds = append(builds, b)
+		kind, found := aspect.ParseKind(c.Aspect.GetKind())
+		if !found {
+			b.err = fmt.Errorf("invalid aspect %#v", c.Aspect.GetKind())
+			continue
+		}
+		key, err := newCacheKey(kind, c)
+		if err != nil {
+			b.err = err
+			continue
+		}
+		m.lock.RLock()
+		_, cached := m.aspectCache[*key]
+		m.lock.RUnlock()
+		if cached || seen[*key] {
+			builds = builds[:len(builds)-1]
+			continue
+		}
+		seen[*key] = true
+		b.key = *key
+	}
+
+	var wg sync.WaitGroup
+	for _, b := range builds {
+		if b.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(b *warmUpBuild) {
+			defer wg.Done()
+			b.err = m.build(b.cfg)
+		}(b)
+	}
+	wg.Wait()
+
+	var result *multierror.Error
+	for _, b := range builds {
+		kind, adapterName := b.cfg.Aspect.GetKind(), b.cfg.Builder.GetName()
+		if b.err == nil {
+			warmUpCount.WithLabelValues(kind, adapterName, warmUpBuilt).Inc()
+			continue
+		}
+		warmUpCount.WithLabelValues(kind, adapterName, warmUpFailed).Inc()
+		if b.cfg.Shadow {
+			glog.Warningf("Unable to build shadow aspect %s of adapter %s: %v", kind, adapterName, b.err)
+			continue
+		}
+		result = multierror.Append(result, fmt.Errorf("unable to build aspect %s of adapter %s: %v", kind, adapterName, b.err))
+	}
+	if result == nil {
+		return nil
+	}
+
+	// cfg won't be installed, nothing would use the aspects built for it
+	if resized {
+		m.setPoolSizes(previous)
+	}
+	var unused []aspect.Wrapper
+	m.lock.Lock()
+	for _, b := range builds {
+		if asp, found := m.aspectCache[b.key]; found && b.err == nil {
+			delete(m.aspectCache, b.key)
+			delete(m.aspectConfig, b.key)
+			unused = append(unused, asp)
+		}
+	}
+	m.lock.Unlock()
+	for _, asp := range unused {
+		closeWrapper(asp)
+	}
+	return result
+}
+
+// build builds the aspect of cfg into the cache.
+func (m *Manager) build(cfg *configpb.Combined) (err error) {
+	kind, _ := aspect.ParseKind(cfg.Aspect.GetKind())
+	mgr, found := m.managers[kind]
+	if !found {
+		return fmt.Errorf("could not find aspect manager %#v", cfg.Aspect.GetKind())
+	}
+	adp, found := m.builders.FindBuilder(cfg.Builder.GetImpl())
+	if !found {
+		return fmt.Errorf("could not find registered adapter %#v", cfg.Builder.GetImpl())
+	}
+
+	// cacheGet calls adapter-supplied code
+	defer func() {
+		if r := recover(); r != nil {
+			panicCount.WithLabelValues(kind.String(), adp.Name()).Inc()
+			err = fmt.Errorf("adapter '%s' panicked with '%v'", adp.Name(), r)
+		}
+	}()
+	_, err = m.cacheGet(cfg, mgr, adp)
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapterManager
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/pkg/aspect"
+	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/config"
+	configpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/pool"
+)
+
+type fakeAspectLister struct {
+	config.Resolver
+	aspects []*configpb.Combined
+}
+
+func (f fakeAspectLister) Aspects() []*configpb.Combined { return f.aspects }
+
+func TestManager_WarmUp(t *testing.T) {
+	denials := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Name: "a1", Kind: aspect.DenialsKindName, Impl: "k1impl1", Params: &rpc.Status{}},
+	}
+	// the access logs manager of newFakeMgrReg fails to build aspects
+	accessLogs := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.AccessLogsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Name: "a2", Kind: aspect.AccessLogsKindName, Impl: "k1impl1", Params: &rpc.Status{}},
+	}
+	shadowAccessLogs := *accessLogs
+	shadowAccessLogs.Shadow = true
+
+	cases := []struct {
+		cfg       config.Resolver
+		errString string
+		builds    int8
+		cached    int
+	}{
+		{fakeAspectLister{}, "", 0, 0},
+		{fakePoolSizer{}, "", 0, 0},
+		{fakeAspectLister{aspects: []*configpb.Combined{denials, denials}}, "", 1, 1},
+		{fakeAspectLister{aspects: []*configpb.Combined{denials, &shadowAccessLogs}}, "", 1, 1},
+		{fakeAspectLister{aspects: []*configpb.Combined{denials, accessLogs}},
+			"unable to build aspect access-logs of adapter a2: unable to create aspect", 1, 0},
+		{fakeAspectLister{aspects: []*configpb.Combined{{Aspect: &configpb.Aspect{Kind: "unknown"}}}},
+			`invalid aspect "unknown"`, 0, 0},
+	}
+
+	for idx, c := range cases {
+		w := &fakewrapper{}
+		mreg := newFakeMgrReg(w)
+		gp := pool.NewGoroutinePool(1, true)
+		agp := pool.NewGoroutinePool(1, true)
+		m := newManager(getReg(true), mreg, &fakeevaluator{}, nil, gp, agp)
+
+		err := m.WarmUp(c.cfg)
+		if c.errString == "" && err != nil {
+			t.Errorf("[%d] WarmUp() = %v; wanted no err", idx, err)
+		} else if c.errString != "" && (err == nil || !strings.Contains(err.Error(), c.errString)) {
+			t.Errorf("[%d] WarmUp() = %v; wanted err containing '%s'", idx, err, c.errString)
+		}
+
+		mgr := mreg[aspect.DenialsKind].(*fakemgr)
+		if mgr.called != c.builds || len(m.Aspects()) != c.cached {
+			t.Errorf("[%d] Got %d builds and %d cached aspects, expecting %d and %d", idx, mgr.called, len(m.Aspects()), c.builds, c.cached)
+		}
+		if c.builds > int8(c.cached) && w.closed != 1 {
+			t.Errorf("[%d] Aspect closed %d times, expecting the aspect of the failed config closed", idx, w.closed)
+		}
+
+		// requests use the aspects built ahead
+		if c.cached > 0 {
+			out := m.Execute(context.Background(), []*configpb.Combined{denials}, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), nil)
+			if !out.IsOK() || mgr.called != c.builds {
+				t.Errorf("[%d] Execute() = %v with %d builds; wanted the aspect built by WarmUp", idx, out.Message(), mgr.called)
+			}
+		}
+
+		if err = m.Close(); err != nil {
+			t.Errorf("[%d] Close() failed: %v", idx, err)
+		}
+		gp.Close()
+		agp.Close()
+	}
+}
+
+type fakePooledAspectLister struct {
+	fakeAspectLister
+	pools map[string]*config.PoolConfig
+}
+
+func (f fakePooledAspectLister) AdapterPools() map[string]*config.PoolConfig { return f.pools }
+
+func TestManager_WarmUpPoolSizes(t *testing.T) {
+	denials := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.DenialsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Name: "a1", Kind: aspect.DenialsKindName, Impl: "k1impl1", Params: &rpc.Status{}},
+	}
+	// the access logs manager of newFakeMgrReg fails to build aspects
+	accessLogs := &configpb.Combined{
+		Aspect:  &configpb.Aspect{Kind: aspect.AccessLogsKindName, Params: &rpc.Status{}},
+		Builder: &configpb.Adapter{Name: "a2", Kind: aspect.AccessLogsKindName, Impl: "k1impl1", Params: &rpc.Status{}},
+	}
+	installed := map[string]*config.PoolConfig{"k1impl1": {Impl: "k1impl1", QueueDepth: 4, MinWorkers: 1, MaxWorkers: 2}}
+	next := map[string]*config.PoolConfig{"k1impl1": {Impl: "k1impl1", QueueDepth: 4, MinWorkers: 3, MaxWorkers: 4}}
+
+	gp := pool.NewGoroutinePool(1, true)
+	agp := pool.NewGoroutinePool(1, true)
+	defer gp.Close()
+	defer agp.Close()
+	m := newManager(getReg(true), newFakeMgrReg(&fakewrapper{}), &fakeevaluator{}, nil, gp, agp)
+	defer func() { _ = m.Close() }()
+	m.ConfigChange(fakePoolSizer{pools: installed})
+
+	failing := fakePooledAspectLister{fakeAspectLister{aspects: []*configpb.Combined{denials, accessLogs}}, next}
+	if err := m.WarmUp(failing); err == nil {
+		t.Fatal("WarmUp() = nil; wanted err")
+	}
+	if !reflect.DeepEqual(m.poolSizes, installed) {
+		t.Errorf("poolSizes = %v after a failed warm-up; wanted the installed sizes %v", m.poolSizes, installed)
+	}
+
+	succeeding := fakePooledAspectLister{fakeAspectLister{aspects: []*configpb.Combined{denials}}, next}
+	if err := m.WarmUp(succeeding); err != nil {
+		t.Fatalf("WarmUp() = %v", err)
+	}
+	if !reflect.DeepEqual(m.poolSizes, next) {
+		t.Errorf("poolSizes = %v; wanted the sizes of the warmed up config %v", m.poolSizes, next)
+	}
+}
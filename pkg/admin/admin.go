@@ -0,0 +1,212 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin implements the mixer's administrative HTTP endpoints.
+//
+// The health and metrics endpoints are always served. The introspection
+// endpoints expose config content and adapter params, and the profiling
+// endpoints expose runtime internals, so both must be enabled explicitly.
+// So must the config rollback endpoints, which change the installed config.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
+	"github.com/golang/glog"
+
+	"istio.io/mixer/pkg/adapterManager"
+	"istio.io/mixer/pkg/config"
+	"istio.io/mixer/pkg/monitoring"
+)
+
+// Paths of the admin endpoints.
+const (
+	HealthzPath   = "/healthz"
+	ReadyzPath    = "/readyz"
+	RevisionzPath = "/revisionz"
+	ConfigzPath   = "/configz"
+	HistoryPath   = "/configz/history"
+	RollbackPath  = "/configz/rollback"
+	UnpinPath     = "/configz/unpin"
+	AdapterzPath  = "/adapterz"
+	PprofPath     = "/debug/pprof/"
+)
+
+type (
+	// ConfigStatus reports on the config installed in the mixer.
+	ConfigStatus interface {
+		// Ready returns true once a valid config has been installed.
+		Ready() bool
+
+		// Status returns the installed config.
+		Status() config.Status
+	}
+
+	// ConfigHistory gives access to the configs previously installed in the mixer.
+	ConfigHistory interface {
+		// History returns the retained configs, most recently installed first.
+		History() []config.HistoryEntry
+
+		// Rollback installs a retained config again, pinning it if pin is true.
+		Rollback(id int, pin bool) error
+
+		// Unpin resumes installing the content of the config files.
+		Unpin() error
+	}
+
+	// AdapterStatus reports on the adapters known to the mixer.
+	AdapterStatus interface {
+		// Builders returns the registered builders.
+		Builders() []adapterManager.BuilderStatus
+
+		// Aspects returns the live aspects.
+		Aspects() []adapterManager.AspectStatus
+	}
+
+	// Options controls which of the optional endpoints are served.
+	Options struct {
+		// EnableIntrospection serves /configz and /adapterz.
+		EnableIntrospection bool
+
+		// EnableProfiling serves the pprof endpoints under /debug/pprof/.
+		EnableProfiling bool
+
+		// EnableConfigRollback serves the POST endpoints rolling back the installed config.
+		EnableConfigRollback bool
+	}
+)
+
+// adapterz is the document served on /adapterz.
+type adapterz struct {
+	Builders []adapterManager.BuilderStatus `json:"builders"`
+	Aspects  []adapterManager.AspectStatus  `json:"aspects"`
+}
+
+// NewHandler returns an HTTP handler serving the admin endpoints.
+// The config history endpoints are only served when cs implements ConfigHistory.
+func NewHandler(cs ConfigStatus, as AdapterStatus, o Options) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle(monitoring.MetricsPath, monitoring.Handler())
+
+	mux.HandleFunc(HealthzPath, func(w http.ResponseWriter, r *http.Request) {
+		writeText(w, http.StatusOK, "ok")
+	})
+
+	mux.HandleFunc(ReadyzPath, func(w http.ResponseWriter, r *http.Request) {
+		if !cs.Ready() {
+			writeText(w, http.StatusServiceUnavailable, "no valid config installed")
+			return
+		}
+		writeText(w, http.StatusOK, "ok")
+	})
+
+	// the revisions don't reveal any config content, so they're always served
+	mux.HandleFunc(RevisionzPath, func(w http.ResponseWriter, r *http.Request) {
+		st := cs.Status()
+		writeJSON(w, config.Revisions{Global: st.GlobalRevision, Service: st.ServiceRevision})
+	})
+
+	if o.EnableIntrospection {
+		mux.HandleFunc(ConfigzPath, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, cs.Status())
+		})
+
+		mux.HandleFunc(AdapterzPath, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, adapterz{Builders: as.Builders(), Aspects: as.Aspects()})
+		})
+	}
+
+	ch, hasHistory := cs.(ConfigHistory)
+	if o.EnableIntrospection && hasHistory {
+		mux.HandleFunc(HistoryPath, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, ch.History())
+		})
+	}
+
+	if o.EnableConfigRollback && hasHistory {
+		mux.HandleFunc(RollbackPath, post(func(r *http.Request) (string, error) {
+			id, err := strconv.Atoi(r.FormValue("id"))
+			if err != nil {
+				return "", fmt.Errorf("invalid config id '%s'", r.FormValue("id"))
+			}
+			pin := r.FormValue("pin") == "true"
+			if err = ch.Rollback(id, pin); err != nil {
+				return "", err
+			}
+			if pin {
+				return fmt.Sprintf("rolled back to config %d and pinned it", id), nil
+			}
+			return fmt.Sprintf("rolled back to config %d", id), nil
+		}))
+
+		mux.HandleFunc(UnpinPath, post(func(r *http.Request) (string, error) {
+			if err := ch.Unpin(); err != nil {
+				return "", err
+			}
+			return "unpinned config, the config files will be installed again", nil
+		}))
+	}
+
+	if o.EnableProfiling {
+		mux.HandleFunc(PprofPath, pprof.Index)
+		mux.HandleFunc(PprofPath+"cmdline", pprof.Cmdline)
+		mux.HandleFunc(PprofPath+"profile", pprof.Profile)
+		mux.HandleFunc(PprofPath+"symbol", pprof.Symbol)
+		mux.HandleFunc(PprofPath+"trace", pprof.Trace)
+	}
+
+	return mux
+}
+
+// post returns a handler running f for POST requests, replying with the message or error f returns.
+func post(f func(r *http.Request) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeText(w, http.StatusMethodNotAllowed, "only POST is supported")
+			return
+		}
+		msg, err := f(r)
+		if err != nil {
+			writeText(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeText(w, http.StatusOK, msg)
+	}
+}
+
+func writeText(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	if _, err := w.Write([]byte(msg + "\n")); err != nil {
+		glog.Warningf("Unable to write admin response: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeText(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		glog.Warningf("Unable to write admin response: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/adapterManager"
+	"istio.io/mixer/pkg/config"
+)
+
+type fakeStatus struct {
+	ready bool
+}
+
+func (f *fakeStatus) Ready() bool { return f.ready }
+func (f *fakeStatus) Status() config.Status {
+	return config.Status{GlobalSHA: "gsha", ServiceSHA: "ssha", ServiceRevision: "2022", LastError: "bad config"}
+}
+func (f *fakeStatus) Builders() []adapterManager.BuilderStatus {
+	return []adapterManager.BuilderStatus{{Name: "denyChecker", Kinds: []string{"denials"}}}
+}
+func (f *fakeStatus) Aspects() []adapterManager.AspectStatus {
+	return []adapterManager.AspectStatus{{Kind: "denials", Impl: "denyChecker", Adapter: "deny"}}
+}
+
+func get(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Unable to fetch %s: %v", url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	fs := &fakeStatus{}
+	cases := []struct {
+		options Options
+		ready   bool
+		path    string
+		code    int
+		body    string
+	}{
+		{Options{}, false, HealthzPath, http.StatusOK, "ok"},
+		{Options{}, false, ReadyzPath, http.StatusServiceUnavailable, "no valid config"},
+		{Options{}, true, ReadyzPath, http.StatusOK, "ok"},
+		{Options{}, true, RevisionzPath, http.StatusOK, `"serviceRevision": "2022"`},
+		{Options{}, true, ConfigzPath, http.StatusNotFound, ""},
+		{Options{}, true, AdapterzPath, http.StatusNotFound, ""},
+		{Options{}, true, PprofPath, http.StatusNotFound, ""},
+		{Options{EnableIntrospection: true}, true, ConfigzPath, http.StatusOK, `"serviceRevision": "2022"`},
+		{Options{EnableIntrospection: true}, true, ConfigzPath, http.StatusOK, `"lastError": "bad config"`},
+		{Options{EnableIntrospection: true}, true, AdapterzPath, http.StatusOK, `"adapter": "deny"`},
+		{Options{EnableIntrospection: true}, true, AdapterzPath, http.StatusOK, `"name": "denyChecker"`},
+		{Options{EnableProfiling: true}, true, PprofPath, http.StatusOK, "goroutine"},
+		{Options{}, true, "/metrics", http.StatusOK, ""},
+	}
+
+	for idx, c := range cases {
+		fs.ready = c.ready
+		srv := httptest.NewServer(NewHandler(fs, fs, c.options))
+		code, body := get(t, srv.URL+c.path)
+		srv.Close()
+
+		if code != c.code {
+			t.Errorf("[%d] %s returned %d, expecting %d", idx, c.path, code, c.code)
+		}
+		if !strings.Contains(body, c.body) {
+			t.Errorf("[%d] %s returned '%s', expecting it to contain '%s'", idx, c.path, body, c.body)
+		}
+	}
+}
+
+type fakeHistory struct {
+	fakeStatus
+	rolledBack int
+	pinned     bool
+}
+
+func (f *fakeHistory) History() []config.HistoryEntry {
+	return []config.HistoryEntry{{ID: 2, ServiceRevision: "2023", Active: true}, {ID: 1, ServiceRevision: "2022"}}
+}
+
+func (f *fakeHistory) Rollback(id int, pin bool) error {
+	if id > 2 {
+		return errors.New("unknown config")
+	}
+	f.rolledBack, f.pinned = id, pin
+	return nil
+}
+
+func (f *fakeHistory) Unpin() error {
+	if !f.pinned {
+		return errors.New("not pinned")
+	}
+	f.pinned = false
+	return nil
+}
+
+func TestConfigHistory(t *testing.T) {
+	cases := []struct {
+		options Options
+		method  string
+		path    string
+		code    int
+		body    string
+	}{
+		{Options{}, http.MethodGet, HistoryPath, http.StatusNotFound, ""},
+		{Options{EnableIntrospection: true}, http.MethodGet, HistoryPath, http.StatusOK, `"serviceRevision": "2023"`},
+		{Options{EnableIntrospection: true}, http.MethodPost, RollbackPath + "?id=1", http.StatusNotFound, ""},
+		{Options{EnableConfigRollback: true}, http.MethodGet, RollbackPath + "?id=1", http.StatusMethodNotAllowed, "POST"},
+		{Options{EnableConfigRollback: true}, http.MethodPost, RollbackPath + "?id=x", http.StatusBadRequest, "invalid config id"},
+		{Options{EnableConfigRollback: true}, http.MethodPost, RollbackPath + "?id=3", http.StatusBadRequest, "unknown config"},
+		{Options{EnableConfigRollback: true}, http.MethodPost, RollbackPath + "?id=1", http.StatusOK, "rolled back to config 1"},
+		{Options{EnableConfigRollback: true}, http.MethodPost, UnpinPath, http.StatusBadRequest, "not pinned"},
+		{Options{EnableConfigRollback: true}, http.MethodPost, RollbackPath + "?id=1&pin=true", http.StatusOK, "pinned"},
+		{Options{EnableConfigRollback: true}, http.MethodPost, UnpinPath, http.StatusOK, "unpinned"},
+	}
+
+	fh := &fakeHistory{}
+	for idx, c := range cases {
+		srv := httptest.NewServer(NewHandler(fh, fh, c.options))
+		req, _ := http.NewRequest(c.method, srv.URL+c.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("[%d] Unable to %s %s: %v", idx, c.method, c.path, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		srv.Close()
+
+		if resp.StatusCode != c.code {
+			t.Errorf("[%d] %s %s returned %d, expecting %d", idx, c.method, c.path, resp.StatusCode, c.code)
+		}
+		if !strings.Contains(string(body), c.body) {
+			t.Errorf("[%d] %s %s returned '%s', expecting it to contain '%s'", idx, c.method, c.path, body, c.body)
+		}
+	}
+
+	if fh.rolledBack != 1 || fh.pinned {
+		t.Errorf("Rolled back to %d, pinned = %t; expecting 1 and unpinned", fh.rolledBack, fh.pinned)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/mixer/pkg/aspect"
+	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/expr"
+)
+
+// AdmissionOptions controls which requests are processed when the mixer is overloaded.
+type AdmissionOptions struct {
+	// MaxInFlight limits the number of requests of each method being processed at once.
+	// Methods without a limit, or with a limit of 0, are only bounded by the worker pool.
+	// Giving Report a lower limit than Check keeps reporting from starving precondition checks.
+	MaxInFlight map[aspect.APIMethod]int
+
+	// PrioritySelector is a predicate on the request attributes that identifies high
+	// priority requests. All requests have the same priority when it is empty.
+	PrioritySelector string
+
+	// PriorityReserve is the fraction of each limit that only high priority requests may use.
+	PriorityReserve float64
+
+	// RetryDelay is the delay suggested to the clients of the requests that get shed.
+	RetryDelay time.Duration
+}
+
+// AdmissionController decides whether requests are processed or shed, based on the number
+// of requests in flight for each API method.
+type AdmissionController struct {
+	limits     map[aspect.APIMethod]int64 // limits for regular requests
+	highLimits map[aspect.APIMethod]int64 // limits for high priority requests
+	inFlight   map[aspect.APIMethod]*int64
+	selector   string
+	eval       expr.Evaluator
+	retryDelay time.Duration
+}
+
+// NewAdmissionController creates an AdmissionController, validating the priority selector with eval.
+func NewAdmissionController(o AdmissionOptions, eval expr.Evaluator) (*AdmissionController, error) {
+	if o.PriorityReserve < 0 || o.PriorityReserve > 1 {
+		return nil, fmt.Errorf("priority reserve must be between 0 and 1, got %v", o.PriorityReserve)
+	}
+
+	if o.PrioritySelector != "" {
+		if err := eval.Validate(o.PrioritySelector); err != nil {
+			return nil, fmt.Errorf("invalid priority selector '%s': %v", o.PrioritySelector, err)
+		}
+	}
+
+	ac := &AdmissionController{
+		limits:     make(map[aspect.APIMethod]int64),
+		highLimits: make(map[aspect.APIMethod]int64),
+		inFlight:   make(map[aspect.APIMethod]*int64),
+		selector:   o.PrioritySelector,
+		eval:       eval,
+		retryDelay: o.RetryDelay,
+	}
+
+	for method, limit := range o.MaxInFlight {
+		if limit < 0 {
+			return nil, fmt.Errorf("in-flight limit for %s must be >= 0, got %d", method, limit)
+		} else if limit == 0 {
+			continue
+		}
+
+		ac.highLimits[method] = int64(limit)
+		if o.PrioritySelector != "" {
+			ac.limits[method] = int64(limit) - int64(float64(limit)*o.PriorityReserve)
+		} else {
+			ac.limits[method] = int64(limit)
+		}
+		ac.inFlight[method] = new(int64)
+	}
+
+	return ac, nil
+}
+
+// admit reserves a processing slot for a request, returning false if the request
+// must be shed instead. Admitted requests must be released once processed.
+func (ac *AdmissionController) admit(method aspect.APIMethod, bag attribute.Bag) bool {
+	if ac == nil {
+		return true
+	}
+
+	inFlight, found := ac.inFlight[method]
+	if !found {
+		return true
+	}
+
+	limit := ac.limits[method]
+	if ac.highPriority(bag) {
+		limit = ac.highLimits[method]
+	}
+
+	if atomic.AddInt64(inFlight, 1) > limit {
+		atomic.AddInt64(inFlight, -1)
+		return false
+	}
+	return true
+}
+
+// release frees the processing slot of an admitted request.
+func (ac *AdmissionController) release(method aspect.APIMethod) {
+	if ac == nil {
+		return
+	}
+
+	if inFlight, found := ac.inFlight[method]; found {
+		atomic.AddInt64(inFlight, -1)
+	}
+}
+
+func (ac *AdmissionController) highPriority(bag attribute.Bag) bool {
+	if ac.selector == "" {
+		return false
+	}
+
+	high, err := ac.eval.EvalPredicate(ac.selector, bag)
+	if err != nil {
+		// requests missing the attributes used by the selector are regular ones
+		if glog.V(2) {
+			glog.Infof("Unable to evaluate priority selector: %v", err)
+		}
+		return false
+	}
+	return high
+}
+
+// retryAfter returns the delay clients of shed requests should wait before retrying.
+func (ac *AdmissionController) retryAfter() time.Duration {
+	if ac == nil {
+		return 0
+	}
+	return ac.retryDelay
+}
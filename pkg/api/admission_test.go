@@ -0,0 +1,105 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/aspect"
+	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/expr"
+)
+
+func TestNewAdmissionController(t *testing.T) {
+	cases := []struct {
+		options   AdmissionOptions
+		errString string
+	}{
+		{AdmissionOptions{}, ""},
+		{AdmissionOptions{PrioritySelector: `source.name == "system"`, PriorityReserve: 0.5}, ""},
+		{AdmissionOptions{PriorityReserve: 1.5}, "priority reserve"},
+		{AdmissionOptions{PrioritySelector: "source.name == "}, "invalid priority selector"},
+		{AdmissionOptions{MaxInFlight: map[aspect.APIMethod]int{aspect.ReportMethod: -1}}, "must be >= 0"},
+	}
+
+	for idx, c := range cases {
+		_, err := NewAdmissionController(c.options, expr.NewCEXLEvaluator())
+		if c.errString == "" && err != nil {
+			t.Errorf("[%d] NewAdmissionController() failed: %v", idx, err)
+		} else if c.errString != "" && (err == nil || !strings.Contains(err.Error(), c.errString)) {
+			t.Errorf("[%d] NewAdmissionController() returned %v, expecting '%s'", idx, err, c.errString)
+		}
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	ac, err := NewAdmissionController(AdmissionOptions{
+		MaxInFlight: map[aspect.APIMethod]int{
+			aspect.CheckMethod:  0,
+			aspect.ReportMethod: 4,
+		},
+		PrioritySelector: `source.name == "system"`,
+		PriorityReserve:  0.5,
+	}, expr.NewCEXLEvaluator())
+	if err != nil {
+		t.Fatalf("NewAdmissionController() failed: %v", err)
+	}
+
+	regular := attribute.GetMutableBag(nil)
+	regular.Set("source.name", "user")
+	high := attribute.GetMutableBag(nil)
+	high.Set("source.name", "system")
+
+	// regular requests may only use the unreserved half of the limit
+	for i := 0; i < 2; i++ {
+		if !ac.admit(aspect.ReportMethod, regular) {
+			t.Errorf("Regular request %d was shed, expecting it to be admitted", i)
+		}
+	}
+	if ac.admit(aspect.ReportMethod, regular) {
+		t.Error("Regular request admitted beyond its share of the limit")
+	}
+
+	// high priority ones get the rest
+	for i := 0; i < 2; i++ {
+		if !ac.admit(aspect.ReportMethod, high) {
+			t.Errorf("High priority request %d was shed, expecting it to be admitted", i)
+		}
+	}
+	if ac.admit(aspect.ReportMethod, high) {
+		t.Error("High priority request admitted beyond the limit")
+	}
+
+	// releasing a slot lets one more request in
+	ac.release(aspect.ReportMethod)
+	if !ac.admit(aspect.ReportMethod, high) {
+		t.Error("High priority request shed after a slot was released")
+	}
+
+	// methods without limits are never shed
+	for i := 0; i < 10; i++ {
+		if !ac.admit(aspect.CheckMethod, regular) {
+			t.Fatal("Check request shed, expecting it to be admitted")
+		}
+	}
+
+	// a nil controller admits everything
+	var none *AdmissionController
+	if !none.admit(aspect.ReportMethod, regular) {
+		t.Error("Nil controller shed a request")
+	}
+	none.release(aspect.ReportMethod)
+}
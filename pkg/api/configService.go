@@ -0,0 +1,84 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/api/configservice"
+	"istio.io/mixer/pkg/config"
+)
+
+// ConfigPusher installs pushed configs, it is implemented by config.Manager.
+type ConfigPusher interface {
+	Push(globalConfig string, serviceConfig string, base config.Revisions) (config.Revisions, *adapter.ConfigErrors, error)
+	Status() config.Status
+}
+
+// configServer implements the config service defined in configservice/configservice.proto.
+type configServer struct {
+	pusher ConfigPusher
+}
+
+// NewConfigServer returns a config service installing configs through pusher.
+func NewConfigServer(pusher ConfigPusher) configservice.ConfigServiceServer {
+	return &configServer{pusher}
+}
+
+func (s *configServer) SetConfig(ctx context.Context, req *configservice.SetConfigRequest) (*configservice.SetConfigResponse, error) {
+	base := config.Revisions{Global: req.BaseGlobalRevision, Service: req.BaseServiceRevision}
+	installed, ce, err := s.pusher.Push(req.GlobalConfig, req.ServiceConfig, base)
+	switch err.(type) {
+	case nil:
+	case *config.RevisionConflictError:
+		return nil, grpc.Errorf(codes.Aborted, "%v", err)
+	case *config.RevisionRegressionError:
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%v", err)
+	default:
+		return nil, grpc.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	resp := &configservice.SetConfigResponse{GlobalRevision: installed.Global, ServiceRevision: installed.Service}
+	if ce == nil {
+		return resp, nil
+	}
+	for _, e := range ce.Multi.Errors {
+		// errors of adapter params may quote their secrets
+		if cerr, ok := e.(adapter.ConfigError); ok {
+			resp.Errors = append(resp.Errors, &configservice.ConfigError{
+				Field:    cerr.Field,
+				Location: cerr.Location,
+				Message:  config.Redact(cerr.Underlying.Error()),
+			})
+			continue
+		}
+		resp.Errors = append(resp.Errors, &configservice.ConfigError{Message: config.Redact(e.Error())})
+	}
+	return resp, nil
+}
+
+func (s *configServer) GetConfig(context.Context, *configservice.GetConfigRequest) (*configservice.GetConfigResponse, error) {
+	st := s.pusher.Status()
+	return &configservice.GetConfigResponse{
+		GlobalConfig:    st.GlobalConfig,
+		ServiceConfig:   st.ServiceConfig,
+		GlobalRevision:  st.GlobalRevision,
+		ServiceRevision: st.ServiceRevision,
+	}, nil
+}
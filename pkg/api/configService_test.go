@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/api/configservice"
+	"istio.io/mixer/pkg/config"
+)
+
+type fakePusher struct {
+	installed config.Revisions
+	ce        *adapter.ConfigErrors
+	err       error
+
+	pushed []string
+	base   config.Revisions
+}
+
+func (f *fakePusher) Push(gc string, sc string, base config.Revisions) (config.Revisions, *adapter.ConfigErrors, error) {
+	f.pushed = []string{gc, sc}
+	f.base = base
+	return f.installed, f.ce, f.err
+}
+
+func (f *fakePusher) Status() config.Status {
+	return config.Status{GlobalConfig: "gc", ServiceConfig: "sc", GlobalRevision: f.installed.Global, ServiceRevision: f.installed.Service}
+}
+
+func TestConfigService(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:29993")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	pusher := &fakePusher{installed: config.Revisions{Global: "1", Service: "2"}}
+	gs := grpc.NewServer()
+	configservice.RegisterConfigServiceServer(gs, NewConfigServer(pusher))
+	go func() { _ = gs.Serve(listener) }()
+	defer gs.Stop()
+
+	conn, err := grpc.Dial("localhost:29993", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Unable to dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	client := configservice.NewConfigServiceClient(conn)
+	ctx := context.Background()
+
+	req := &configservice.SetConfigRequest{GlobalConfig: "g", ServiceConfig: "s", BaseGlobalRevision: "1", BaseServiceRevision: "1"}
+	resp, err := client.SetConfig(ctx, req)
+	if err != nil || len(resp.Errors) != 0 || resp.GlobalRevision != "1" || resp.ServiceRevision != "2" {
+		t.Errorf("SetConfig() = %v, %v; wanted the installed revisions", resp, err)
+	}
+	if !reflect.DeepEqual(pusher.pushed, []string{"g", "s"}) || pusher.base != (config.Revisions{Global: "1", Service: "1"}) {
+		t.Errorf("Push(%v, %v); wanted the request's configs and base revisions", pusher.pushed, pusher.base)
+	}
+
+	var ce *adapter.ConfigErrors
+	ce = ce.Appendf("Selector", "invalid expression")
+	ce.Multi.Errors[0] = adapter.ConfigError{Field: "Selector", Underlying: errors.New("invalid expression"), Location: "4:3"}
+	pusher.ce = ce
+	resp, err = client.SetConfig(ctx, req)
+	want := []*configservice.ConfigError{{Field: "Selector", Location: "4:3", Message: "invalid expression"}}
+	if err != nil || !reflect.DeepEqual(resp.Errors, want) {
+		t.Errorf("SetConfig() = %v, %v; wanted the validation errors", resp, err)
+	}
+	pusher.ce = nil
+
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{&config.RevisionConflictError{}, codes.Aborted},
+		{&config.RevisionRegressionError{}, codes.FailedPrecondition},
+		{errors.New("config manager is closed"), codes.Unavailable},
+	}
+	for idx, c := range cases {
+		pusher.err = c.err
+		if _, err = client.SetConfig(ctx, req); grpc.Code(err) != c.code {
+			t.Errorf("[%d] SetConfig() = %v; wanted code %s", idx, err, c.code)
+		}
+	}
+
+	got, err := client.GetConfig(ctx, &configservice.GetConfigRequest{})
+	if err != nil || got.GlobalConfig != "gc" || got.ServiceConfig != "sc" || got.ServiceRevision != "2" {
+		t.Errorf("GetConfig() = %v, %v; wanted the installed config", got, err)
+	}
+}
@@ -27,40 +27,66 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"path"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
 	rpc "github.com/googleapis/googleapis/google/rpc"
 	"github.com/opentracing/opentracing-go/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	mixerpb "istio.io/api/mixer/v1"
+	"istio.io/mixer/pkg/aspect"
 	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/capture"
+	"istio.io/mixer/pkg/config"
 	"istio.io/mixer/pkg/pool"
 	"istio.io/mixer/pkg/status"
 	"istio.io/mixer/pkg/tracing"
 )
 
+// Keys of the stream metadata carrying the revisions of the installed configs.
+const (
+	GlobalRevisionKey  = "mixer-global-revision"
+	ServiceRevisionKey = "mixer-service-revision"
+)
+
 // grpcServer holds the state for the gRPC API server.
 type grpcServer struct {
-	handlers Handler
-	attrMgr  attribute.Manager
-	tracer   tracing.Tracer
-	gp       *pool.GoroutinePool
+	handlers  Handler
+	attrMgr   attribute.Manager
+	tracer    tracing.Tracer
+	gp        *pool.GoroutinePool
+	recorder  capture.Recorder
+	admission *AdmissionController
+	revisions func() config.Revisions
 
 	// replaceable sendMsg so we can inject errors in tests
 	sendMsg func(grpc.Stream, proto.Message) error
 }
 
 // NewGRPCServer creates a gRPC serving stack.
-func NewGRPCServer(handlers Handler, tracer tracing.Tracer, gp *pool.GoroutinePool) mixerpb.MixerServer {
+//
+// When recorder is not nil, every request is captured along with its response.
+// When admission is not nil, it decides which requests are shed under load. Requests
+// are also shed whenever the worker pool's queue is full.
+// When revisions is not nil, the revisions of the installed configs are sent in the header
+// of every stream, and again in its trailer as the config may have changed in the meantime.
+func NewGRPCServer(handlers Handler, tracer tracing.Tracer, gp *pool.GoroutinePool, recorder capture.Recorder,
+	admission *AdmissionController, revisions func() config.Revisions) mixerpb.MixerServer {
 	return &grpcServer{
-		handlers: handlers,
-		attrMgr:  attribute.NewManager(),
-		tracer:   tracer,
-		gp:       gp,
+		handlers:  handlers,
+		attrMgr:   attribute.NewManager(),
+		tracer:    tracer,
+		gp:        gp,
+		recorder:  recorder,
+		admission: admission,
+		revisions: revisions,
 		sendMsg: func(stream grpc.Stream, m proto.Message) error {
 			return stream.SendMsg(m)
 		},
@@ -69,7 +95,7 @@ func NewGRPCServer(handlers Handler, tracer tracing.Tracer, gp *pool.GoroutinePo
 
 // dispatcher does all the nitty-gritty details of handling the mixer's low-level API
 // protocol and dispatching to the right API handler.
-func (s *grpcServer) dispatcher(stream grpc.Stream, methodName string,
+func (s *grpcServer) dispatcher(stream grpc.ServerStream, methodName string, apiMethod aspect.APIMethod,
 	getState func() (request proto.Message, response proto.Message, requestAttrs *mixerpb.Attributes, responseAttrs *mixerpb.Attributes, result *rpc.Status),
 	worker func(ctx context.Context, requestBag *attribute.MutableBag, responseBag *attribute.MutableBag,
 		request proto.Message, response proto.Message)) error {
@@ -84,6 +110,17 @@ func (s *grpcServer) dispatcher(stream grpc.Stream, methodName string,
 	root, ctx := s.tracer.StartRootSpan(stream.Context(), methodName)
 	defer root.Finish()
 
+	method := path.Base(methodName)
+	activeStreams.WithLabelValues(method).Inc()
+	defer activeStreams.WithLabelValues(method).Dec()
+
+	if s.revisions != nil {
+		if err := stream.SetHeader(revisionMetadata(s.revisions())); err != nil {
+			glog.Warningf("Unable to set the config revisions in the stream header: %v", err)
+		}
+		defer func() { stream.SetTrailer(revisionMetadata(s.revisions())) }()
+	}
+
 	// ensure pending stuff is done before leaving
 	wg := sync.WaitGroup{}
 	defer wg.Wait()
@@ -118,16 +155,33 @@ func (s *grpcServer) dispatcher(stream grpc.Stream, methodName string,
 			continue
 		}
 
+		// capture the request before any processing alters the bag
+		var entry *capture.Entry
+		if s.recorder != nil {
+			entry = &capture.Entry{Method: method, Time: time.Now()}
+			requestBag.ToProto(&entry.Attributes)
+		}
+
+		if !s.admission.admit(apiMethod, requestBag) {
+			s.shed(stream, sendLock, method, shedLimit, request, response, result)
+			requestBag.Done()
+			continue
+		}
+
 		// throw the message into the work queue
 		wg.Add(1)
-		s.gp.ScheduleWork(func() {
+		scheduled := s.gp.TrySchedule(func() {
 			span, ctx2 := s.tracer.StartSpanFromContext(ctx, "RequestProcessing")
 			span.LogFields(log.Object("gRPC request", request))
 
 			responseBag := attribute.GetMutableBag(nil)
 
 			// do the actual work for the message
+			start := time.Now()
 			worker(ctx2, requestBag, responseBag, request, response)
+			requestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+			requestCount.WithLabelValues(method, rpc.Code(result.Code).String()).Inc()
+			s.admission.release(apiMethod)
 
 			sendLock.Lock()
 			tracker.GetResponseAttributes(responseBag, responseAttrs)
@@ -138,6 +192,10 @@ func (s *grpcServer) dispatcher(stream grpc.Stream, methodName string,
 				glog.Errorf("Unable to send gRPC response message: %v", err)
 			}
 
+			if entry != nil {
+				s.record(entry, request, response, result)
+			}
+
 			requestBag.Done()
 			responseBag.Done()
 
@@ -146,12 +204,79 @@ func (s *grpcServer) dispatcher(stream grpc.Stream, methodName string,
 
 			wg.Done()
 		})
+
+		if !scheduled {
+			wg.Done()
+			s.admission.release(apiMethod)
+			s.shed(stream, sendLock, method, shedQueueFull, request, response, result)
+			requestBag.Done()
+		}
 	}
 }
 
+// shed answers a request with RESOURCE_EXHAUSTED without processing it.
+func (s *grpcServer) shed(stream grpc.Stream, sendLock *sync.Mutex, method string, reason string,
+	request proto.Message, response proto.Message, result *rpc.Status) {
+	shedCount.WithLabelValues(method, reason).Inc()
+	requestCount.WithLabelValues(method, rpc.RESOURCE_EXHAUSTED.String()).Inc()
+
+	msg := fmt.Sprintf("%s request shed due to overload (%s)", method, reason)
+	if delay := s.admission.retryAfter(); delay > 0 {
+		*result = status.ResourceExhaustedWithDetails(msg, status.NewRetryInfo(delay))
+	} else {
+		*result = status.WithResourceExhausted(msg)
+	}
+
+	if req, ok := request.(interface {
+		GetRequestIndex() int64
+	}); ok {
+		switch resp := response.(type) {
+		case *mixerpb.CheckResponse:
+			resp.RequestIndex = req.GetRequestIndex()
+		case *mixerpb.ReportResponse:
+			resp.RequestIndex = req.GetRequestIndex()
+		case *mixerpb.QuotaResponse:
+			resp.RequestIndex = req.GetRequestIndex()
+		}
+	}
+
+	sendLock.Lock()
+	err := s.sendMsg(stream, response)
+	sendLock.Unlock()
+
+	if err != nil {
+		glog.Errorf("Unable to send gRPC response message: %v", err)
+	}
+}
+
+// revisionMetadata returns the metadata reporting the revisions of the installed config to clients.
+func revisionMetadata(r config.Revisions) metadata.MD {
+	return metadata.Pairs(GlobalRevisionKey, r.Global, ServiceRevisionKey, r.Service)
+}
+
+// record completes a captured entry with the outcome of the request and hands it to the recorder.
+func (s *grpcServer) record(entry *capture.Entry, request proto.Message, response proto.Message, result *rpc.Status) {
+	entry.Result = *result
+
+	if req, ok := request.(*mixerpb.QuotaRequest); ok {
+		entry.Quota = &capture.QuotaArgs{
+			Quota:           req.Quota,
+			Amount:          req.Amount,
+			DeduplicationID: req.DeduplicationId,
+			BestEffort:      req.BestEffort,
+		}
+	}
+
+	if resp, ok := response.(*mixerpb.QuotaResponse); ok {
+		entry.Amount = resp.Amount
+	}
+
+	s.recorder.Record(entry)
+}
+
 // Check is the entry point for the external Check method
 func (s *grpcServer) Check(stream mixerpb.Mixer_CheckServer) error {
-	return s.dispatcher(stream, "/istio.mixer.v1.Mixer/Check",
+	return s.dispatcher(stream, "/istio.mixer.v1.Mixer/Check", aspect.CheckMethod,
 		func() (proto.Message, proto.Message, *mixerpb.Attributes, *mixerpb.Attributes, *rpc.Status) {
 			request := &mixerpb.CheckRequest{}
 			response := &mixerpb.CheckResponse{}
@@ -165,7 +290,7 @@ func (s *grpcServer) Check(stream mixerpb.Mixer_CheckServer) error {
 
 // Report is the entry point for the external Report method
 func (s *grpcServer) Report(stream mixerpb.Mixer_ReportServer) error {
-	return s.dispatcher(stream, "/istio.mixer.v1.Mixer/Report",
+	return s.dispatcher(stream, "/istio.mixer.v1.Mixer/Report", aspect.ReportMethod,
 		func() (proto.Message, proto.Message, *mixerpb.Attributes, *mixerpb.Attributes, *rpc.Status) {
 			request := &mixerpb.ReportRequest{}
 			response := &mixerpb.ReportResponse{}
@@ -179,7 +304,7 @@ func (s *grpcServer) Report(stream mixerpb.Mixer_ReportServer) error {
 
 // Quota is the entry point for the external Quota method
 func (s *grpcServer) Quota(stream mixerpb.Mixer_QuotaServer) error {
-	return s.dispatcher(stream, "/istio.mixer.v1.Mixer/Quota",
+	return s.dispatcher(stream, "/istio.mixer.v1.Mixer/Quota", aspect.QuotaMethod,
 		func() (proto.Message, proto.Message, *mixerpb.Attributes, *mixerpb.Attributes, *rpc.Status) {
 			request := &mixerpb.QuotaRequest{}
 			response := &mixerpb.QuotaResponse{}
@@ -22,13 +22,19 @@ import (
 	"net"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/gogo/protobuf/types"
 	"github.com/golang/protobuf/proto"
 	rpc "github.com/googleapis/googleapis/google/rpc"
 	"google.golang.org/grpc"
 
 	mixerpb "istio.io/api/mixer/v1"
+	"istio.io/mixer/pkg/aspect"
 	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/capture"
+	"istio.io/mixer/pkg/config"
+	"istio.io/mixer/pkg/expr"
 	"istio.io/mixer/pkg/pool"
 	"istio.io/mixer/pkg/status"
 	"istio.io/mixer/pkg/tracing"
@@ -45,6 +51,9 @@ type testState struct {
 	gs         *grpc.Server
 	gp         *pool.GoroutinePool
 	s          *grpcServer
+
+	// when not nil, Check blocks until it is closed
+	block chan bool
 }
 
 func (ts *testState) createGRPCServer(port uint16) error {
@@ -64,7 +73,7 @@ func (ts *testState) createGRPCServer(port uint16) error {
 	ts.gp = pool.NewGoroutinePool(128, false)
 	ts.gp.AddWorkers(32)
 
-	ts.s = NewGRPCServer(ts, tracing.DisabledTracer(), ts.gp).(*grpcServer)
+	ts.s = NewGRPCServer(ts, tracing.DisabledTracer(), ts.gp, nil, nil, nil).(*grpcServer)
 	mixerpb.RegisterMixerServer(ts.gs, ts.s)
 
 	go func() {
@@ -119,6 +128,9 @@ func (ts *testState) cleanupTestState() {
 
 func (ts *testState) Check(ctx context.Context, bag *attribute.MutableBag, output *attribute.MutableBag,
 	request *mixerpb.CheckRequest, response *mixerpb.CheckResponse) {
+	if ts.block != nil {
+		<-ts.block
+	}
 	response.RequestIndex = request.RequestIndex
 	response.Result = status.New(rpc.UNIMPLEMENTED)
 }
@@ -486,3 +498,173 @@ func TestBrokenStream(t *testing.T) {
 
 	wg.Wait()
 }
+
+type fakeRecorder struct {
+	entries chan *capture.Entry
+}
+
+func (r *fakeRecorder) Record(e *capture.Entry) { r.entries <- e }
+func (r *fakeRecorder) Close() error            { return nil }
+
+func TestRecord(t *testing.T) {
+	ts, err := prepTestState(29996)
+	if err != nil {
+		t.Errorf("unable to prep test state %v", err)
+		return
+	}
+	defer ts.cleanupTestState()
+
+	rec := &fakeRecorder{entries: make(chan *capture.Entry, 2)}
+	ts.s.recorder = rec
+
+	stream, err := ts.client.Quota(context.Background())
+	if err != nil {
+		t.Errorf("Quota failed %v", err)
+		return
+	}
+
+	// the second request only carries a delta, the capture must hold the full set
+	requests := []mixerpb.QuotaRequest{
+		{
+			Quota:  "q1",
+			Amount: 2,
+			AttributeUpdate: mixerpb.Attributes{
+				Dictionary:       map[int32]string{1: "a", 2: "b"},
+				StringAttributes: map[int32]string{1: "A"},
+				Int64Attributes:  map[int32]int64{2: 42},
+			},
+		},
+		{
+			Quota:           "q2",
+			DeduplicationId: "dedup",
+			AttributeUpdate: mixerpb.Attributes{StringAttributes: map[int32]string{1: "AA"}},
+		},
+	}
+
+	for i, request := range requests {
+		if err = stream.Send(&request); err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if _, err = stream.Recv(); err != nil {
+			t.Fatalf("Failed to receive a response : %v", err)
+		}
+
+		e := <-rec.entries
+		if e.Method != "Quota" || e.Quota == nil || e.Quota.Quota != request.Quota || e.Quota.DeduplicationID != request.DeduplicationId {
+			t.Errorf("%d: Got entry %+v, expecting a Quota entry for %s", i, e, request.Quota)
+		}
+		if e.Result.Code != int32(rpc.UNIMPLEMENTED) {
+			t.Errorf("%d: Got result %v, expecting UNIMPLEMENTED", i, e.Result)
+		}
+
+		at := attribute.NewManager().NewTracker()
+		b, err := at.ApplyRequestAttributes(&e.Attributes)
+		if err != nil {
+			t.Fatalf("%d: Unable to decode captured attributes: %v", i, err)
+		}
+		if v, _ := b.Get("b"); v != int64(42) {
+			t.Errorf("%d: Got b=%v in captured attributes, expecting 42", i, v)
+		}
+		b.Done()
+		at.Done()
+	}
+
+	if err = stream.CloseSend(); err != nil {
+		t.Errorf("Failed to close gRPC stream: %v", err)
+	}
+}
+
+func TestShedding(t *testing.T) {
+	ts, err := prepTestState(29995)
+	if err != nil {
+		t.Errorf("unable to prep test state %v", err)
+		return
+	}
+	defer ts.cleanupTestState()
+
+	ac, err := NewAdmissionController(AdmissionOptions{
+		MaxInFlight: map[aspect.APIMethod]int{aspect.CheckMethod: 1},
+		RetryDelay:  time.Second,
+	}, expr.NewCEXLEvaluator())
+	if err != nil {
+		t.Fatalf("NewAdmissionController() failed: %v", err)
+	}
+	ts.s.admission = ac
+	ts.block = make(chan bool)
+
+	stream, err := ts.client.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check failed %v", err)
+	}
+
+	// the first request holds the only slot, so the second one gets shed
+	for _, index := range []int64{testRequestID0, testRequestID1} {
+		if err = stream.Send(&mixerpb.CheckRequest{RequestIndex: index}); err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+	}
+
+	response, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed to receive a response: %v", err)
+	}
+	if response.RequestIndex != testRequestID1 || response.Result.Code != int32(rpc.RESOURCE_EXHAUSTED) {
+		t.Errorf("Got response %v, expecting request %d to be shed", response, testRequestID1)
+	}
+	retry := &rpc.RetryInfo{}
+	if len(response.Result.Details) != 1 || types.UnmarshalAny(response.Result.Details[0], retry) != nil {
+		t.Errorf("Got details %v, expecting retry info", response.Result.Details)
+	}
+
+	close(ts.block)
+	if response, err = stream.Recv(); err != nil {
+		t.Fatalf("Failed to receive a response: %v", err)
+	}
+	if response.RequestIndex != testRequestID0 || response.Result.Code != int32(rpc.UNIMPLEMENTED) {
+		t.Errorf("Got response %v, expecting request %d to be processed", response, testRequestID0)
+	}
+
+	if err = stream.CloseSend(); err != nil {
+		t.Errorf("Failed to close gRPC stream: %v", err)
+	}
+}
+
+func TestRevisionMetadata(t *testing.T) {
+	ts, err := prepTestState(29994)
+	if err != nil {
+		t.Errorf("unable to prep test state %v", err)
+		return
+	}
+	defer ts.cleanupTestState()
+
+	ts.s.revisions = func() config.Revisions { return config.Revisions{Global: "7", Service: "2022"} }
+
+	stream, err := ts.client.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check failed %v", err)
+	}
+	if err = stream.Send(&mixerpb.CheckRequest{RequestIndex: testRequestID0}); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if _, err = stream.Recv(); err != nil {
+		t.Fatalf("Failed to receive a response: %v", err)
+	}
+
+	header, err := stream.Header()
+	if err != nil {
+		t.Fatalf("Header() failed: %v", err)
+	}
+	if g, s := header[GlobalRevisionKey], header[ServiceRevisionKey]; len(g) != 1 || g[0] != "7" || len(s) != 1 || s[0] != "2022" {
+		t.Errorf("Got header %v, expecting global revision 7 and service revision 2022", header)
+	}
+
+	if err = stream.CloseSend(); err != nil {
+		t.Errorf("Failed to close gRPC stream: %v", err)
+	}
+	if _, err = stream.Recv(); err != io.EOF {
+		t.Fatalf("Recv() = %v, expecting EOF", err)
+	}
+	if trailer := stream.Trailer(); len(trailer[ServiceRevisionKey]) != 1 || trailer[ServiceRevisionKey][0] != "2022" {
+		t.Errorf("Got trailer %v, expecting service revision 2022", trailer)
+	}
+}
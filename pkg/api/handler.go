@@ -23,6 +23,8 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
 
 	mixerpb "istio.io/api/mixer/v1"
 	"istio.io/mixer/pkg/aspect"
@@ -30,8 +32,12 @@ import (
 	"istio.io/mixer/pkg/config"
 	cpb "istio.io/mixer/pkg/config/proto"
 	"istio.io/mixer/pkg/status"
+	"istio.io/mixer/pkg/tracing"
 )
 
+// aspectCountTag is set on config resolution spans to the number of aspects resolved.
+const aspectCountTag = "aspect.count"
+
 // Handler holds pointers to the functions that implement
 // request-level processing for all public APIs.
 type Handler interface {
@@ -93,18 +99,71 @@ func (h *handlerState) execute(ctx context.Context, requestBag *attribute.Mutabl
 		return aspect.Output{Status: status.WithInternal(msg)}
 	}
 
+	// attributes generated here can be used by the selectors of the rules resolved below
+	if err := h.preprocess(ctx, cfg, requestBag); err != nil {
+		glog.Warningf("Unable to generate attributes for %s: %v", method, err)
+	}
+
+	span, _ := tracing.StartChildSpan(ctx, "Resolve")
+	start := time.Now()
 	cfgs, err := cfg.Resolve(requestBag, h.methodMap[method])
+	resolveDuration.WithLabelValues(method.String()).Observe(time.Since(start).Seconds())
 	if err != nil {
+		resolveErrors.WithLabelValues(method.String()).Inc()
 		msg := fmt.Sprintf("unable to resolve config: %v", err)
 		glog.Error(msg)
-		return aspect.Output{Status: status.WithInternal(msg)}
+		ext.Error.Set(span, true)
+		span.LogFields(log.String("message", msg))
+		span.Finish()
+		return recordOutcome(cfg, requestBag, aspect.Output{Status: status.WithInternal(msg)})
 	}
+	span.SetTag(aspectCountTag, len(cfgs))
+	span.Finish()
 
 	if glog.V(2) {
-		glog.Infof("Resolved [%d] ==> %v ", len(cfgs), cfgs)
+		glog.Infof("Resolved [%d] ==> %s ", len(cfgs), config.Redact(fmt.Sprint(cfgs)))
+	}
+
+	return recordOutcome(cfg, requestBag, h.aspectExecutor.Execute(ctx, cfgs, requestBag, responseBag, ma))
+}
+
+// recordOutcome lets cfg know the status of a request it resolved config for, when it wants to.
+func recordOutcome(cfg config.Resolver, requestBag *attribute.MutableBag, o aspect.Output) aspect.Output {
+	if r, ok := cfg.(config.OutcomeRecorder); ok {
+		r.RecordOutcome(requestBag, o.Status.Code)
 	}
+	return o
+}
 
-	return h.aspectExecutor.Execute(ctx, cfgs, requestBag, responseBag, ma)
+// preprocess runs the aspects configured for the preprocess method and adds the attributes
+// they produce to requestBag.
+func (h *handlerState) preprocess(ctx context.Context, cfg config.Resolver, requestBag *attribute.MutableBag) error {
+	aspects := h.methodMap[aspect.PreprocessMethod]
+	if len(aspects) == 0 {
+		return nil
+	}
+
+	cfgs, err := cfg.Resolve(requestBag, aspects)
+	if err != nil {
+		return err
+	}
+	if len(cfgs) == 0 {
+		return nil
+	}
+
+	generated := attribute.GetMutableBag(nil)
+	defer generated.Done()
+
+	o := h.aspectExecutor.Execute(ctx, cfgs, requestBag, generated, &aspect.PreprocessMethodArgs{})
+
+	// keep whatever was generated, even if some of the generators failed
+	if err = requestBag.Merge([]*attribute.MutableBag{generated}); err != nil {
+		return err
+	}
+	if !o.IsOK() {
+		return fmt.Errorf("%s", o.Message())
+	}
+	return nil
 }
 
 // Check performs 'check' function corresponding to the mixer api.
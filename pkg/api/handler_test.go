@@ -23,6 +23,8 @@ import (
 	"testing"
 
 	rpc "github.com/googleapis/googleapis/google/rpc"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 
 	mixerpb "istio.io/api/mixer/v1"
 	"istio.io/mixer/pkg/aspect"
@@ -41,6 +43,16 @@ func (f *fakeresolver) Resolve(bag attribute.Bag, aspectSet config.AspectSet) ([
 	return f.ret, f.err
 }
 
+// recordingResolver records the outcomes of the requests it resolved config for.
+type recordingResolver struct {
+	fakeresolver
+	codes []int32
+}
+
+func (r *recordingResolver) RecordOutcome(bag attribute.Bag, code int32) {
+	r.codes = append(r.codes, code)
+}
+
 type fakeExecutor struct {
 	body func() aspect.Output
 }
@@ -64,6 +76,24 @@ func TestAspectManagerErrorsPropagated(t *testing.T) {
 	}
 }
 
+func TestRecordOutcome(t *testing.T) {
+	f := &fakeExecutor{func() aspect.Output {
+		return aspect.Output{Status: status.WithPermissionDenied("denied")}
+	}}
+	h := NewHandler(f, map[aspect.APIMethod]config.AspectSet{}).(*handlerState)
+	r := &recordingResolver{fakeresolver: fakeresolver{[]*cpb.Combined{nil}, nil}}
+	h.ConfigChange(r)
+
+	_ = h.execute(context.Background(), attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), aspect.CheckMethod, nil)
+	r.err = errors.New("unresolvable")
+	_ = h.execute(context.Background(), attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), aspect.CheckMethod, nil)
+
+	want := []int32{int32(rpc.PERMISSION_DENIED), int32(rpc.INTERNAL)}
+	if fmt.Sprint(r.codes) != fmt.Sprint(want) {
+		t.Errorf("Recorded outcomes %v; wanted %v", r.codes, want)
+	}
+}
+
 func TestHandler(t *testing.T) {
 	bag := attribute.GetMutableBag(nil)
 	output := attribute.GetMutableBag(nil)
@@ -142,6 +172,78 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+func TestResolveSpan(t *testing.T) {
+	f := &fakeExecutor{func() aspect.Output { return aspect.Output{Status: status.OK} }}
+
+	cases := []struct {
+		resolver *fakeresolver
+		count    interface{}
+		isError  bool
+	}{
+		{&fakeresolver{[]*cpb.Combined{nil, nil}, nil}, 2, false},
+		{&fakeresolver{nil, errors.New("RESOLVER")}, nil, true},
+	}
+
+	for i, c := range cases {
+		h := NewHandler(f, map[aspect.APIMethod]config.AspectSet{}).(*handlerState)
+		h.ConfigChange(c.resolver)
+
+		tracer := mocktracer.New()
+		ctx := ot.ContextWithSpan(context.Background(), tracer.StartSpan("parent"))
+		h.execute(ctx, attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), aspect.CheckMethod, nil)
+
+		spans := tracer.FinishedSpans()
+		if len(spans) != 1 || spans[0].OperationName != "Resolve" {
+			t.Fatalf("[%d] Got spans %v, expecting a single Resolve span", i, spans)
+		}
+		if count := spans[0].Tag(aspectCountTag); count != c.count {
+			t.Errorf("[%d] Got %s=%v, expecting %v", i, aspectCountTag, count, c.count)
+		}
+		if isError := spans[0].Tag("error") == true; isError != c.isError {
+			t.Errorf("[%d] Got error tag %v, expecting %v", i, isError, c.isError)
+		}
+	}
+}
+
+type preprocessExecutor struct {
+	methods []aspect.APIMethodArgs
+	country interface{}
+}
+
+func (e *preprocessExecutor) Execute(ctx context.Context, cfgs []*cpb.Combined, requestBag *attribute.MutableBag, responseBag *attribute.MutableBag,
+	ma aspect.APIMethodArgs) aspect.Output {
+	e.methods = append(e.methods, ma)
+	if _, ok := ma.(*aspect.PreprocessMethodArgs); ok {
+		responseBag.Set("source.country", "CA")
+		return aspect.Output{Status: status.OK}
+	}
+	e.country, _ = requestBag.Get("source.country")
+	return aspect.Output{Status: status.OK}
+}
+
+func TestPreprocess(t *testing.T) {
+	e := &preprocessExecutor{}
+	h := NewHandler(e, map[aspect.APIMethod]config.AspectSet{
+		aspect.PreprocessMethod: {aspect.AttributesKindName: true},
+		aspect.CheckMethod:      {aspect.DenialsKindName: true},
+	}).(*handlerState)
+	h.ConfigChange(&fakeresolver{[]*cpb.Combined{nil}, nil})
+
+	o := h.execute(context.Background(), attribute.GetMutableBag(nil), attribute.GetMutableBag(nil), aspect.CheckMethod, &aspect.CheckMethodArgs{})
+	if !o.IsOK() {
+		t.Errorf("execute() = %v; wanted OK", o.Status)
+	}
+	if len(e.methods) != 2 {
+		t.Fatalf("Execute() called %d times, wanted 2", len(e.methods))
+	}
+	if _, ok := e.methods[0].(*aspect.PreprocessMethodArgs); !ok {
+		t.Errorf("first Execute() got %#v, wanted preprocess args", e.methods[0])
+	}
+	if e.country != "CA" {
+		t.Errorf("generated attribute = %v, wanted CA", e.country)
+	}
+}
+
 func init() {
 	// bump up the log level so log-only logic runs during the tests, for correctness and coverage.
 	_ = flag.Lookup("v").Value.Set("99")
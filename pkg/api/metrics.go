@@ -0,0 +1,84 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/mixer/pkg/monitoring"
+)
+
+// Labels used by the API metrics.
+const (
+	methodLabel = "method"
+	codeLabel   = "code"
+	reasonLabel = "reason"
+)
+
+// Reasons for shedding requests.
+const (
+	shedLimit     = "limit"
+	shedQueueFull = "queue_full"
+)
+
+var (
+	requestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "api",
+		Name:      "requests_total",
+		Help:      "Number of API requests processed, by method and response code.",
+	}, []string{methodLabel, codeLabel})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "api",
+		Name:      "request_duration_seconds",
+		Help:      "Time spent processing API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{methodLabel})
+
+	activeStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "api",
+		Name:      "streams",
+		Help:      "Number of open gRPC streams.",
+	}, []string{methodLabel})
+
+	resolveDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "api",
+		Name:      "resolve_duration_seconds",
+		Help:      "Time spent resolving the config applicable to a request.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1},
+	}, []string{methodLabel})
+
+	resolveErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "api",
+		Name:      "resolve_errors_total",
+		Help:      "Number of requests for which config resolution failed.",
+	}, []string{methodLabel})
+
+	shedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "api",
+		Name:      "shed_requests_total",
+		Help:      "Number of requests rejected without processing due to overload, by method and reason.",
+	}, []string{methodLabel, reasonLabel})
+)
+
+func init() {
+	monitoring.MustRegister(requestCount, requestDuration, activeStreams, resolveDuration, resolveErrors, shedCount)
+}
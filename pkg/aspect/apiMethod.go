@@ -24,6 +24,10 @@ const (
 	CheckMethod APIMethod = iota
 	ReportMethod
 	QuotaMethod
+
+	// PreprocessMethod is not exposed by the API. It runs ahead of every
+	// other method to generate attributes used during rule resolution.
+	PreprocessMethod
 )
 
 // Name of all support API methods
@@ -31,12 +35,16 @@ const (
 	CheckMethodName  = "Check"
 	ReportMethodName = "Report"
 	QuotaMethodName  = "Quota"
+
+	PreprocessMethodName = "Preprocess"
 )
 
 var apiMethodToString = map[APIMethod]string{
 	CheckMethod:  CheckMethodName,
 	ReportMethod: ReportMethodName,
 	QuotaMethod:  QuotaMethodName,
+
+	PreprocessMethod: PreprocessMethodName,
 }
 
 // String returns the string representation of the method, or "" if an unknown method is given.
@@ -102,4 +110,17 @@ type (
 		// The total amount of quota returned, may be less than requested.
 		Amount int64
 	}
+
+	// PreprocessMethodArgs is supplied by invocations of the Preprocess method.
+	PreprocessMethodArgs struct {
+		APIMethodArgs
+	}
+
+	// PreprocessMethodResp is returned by invocations of the Preprocess method.
+	PreprocessMethodResp struct {
+		APIMethodResp
+
+		// Attrs holds the attribute values produced by the aspect, keyed by attribute name.
+		Attrs map[string]interface{}
+	}
 )
@@ -26,6 +26,7 @@ func TestMethodNames(t *testing.T) {
 		{CheckMethod, "Check"},
 		{ReportMethod, "Report"},
 		{QuotaMethod, "Quota"},
+		{PreprocessMethod, "Preprocess"},
 	}
 
 	for _, c := range cases {
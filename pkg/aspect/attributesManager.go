@@ -0,0 +1,105 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aspect
+
+import (
+	"github.com/golang/glog"
+
+	"istio.io/mixer/pkg/adapter"
+	aconfig "istio.io/mixer/pkg/aspect/config"
+	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/config"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/status"
+)
+
+type (
+	attributesManager struct{}
+
+	attributesWrapper struct {
+		aspect   adapter.AttributesGenerator
+		inputs   map[string]string // input name -> expression
+		bindings map[string]string // attribute name -> output name
+	}
+)
+
+// newAttributesManager returns a manager for the attributes aspect.
+func newAttributesManager() Manager {
+	return attributesManager{}
+}
+
+// NewAspect creates an attributes generator aspect.
+func (attributesManager) NewAspect(cfg *cpb.Combined, ga adapter.Builder, env adapter.Env) (Wrapper, error) {
+	params := cfg.Aspect.Params.(*aconfig.AttributesGeneratorParams)
+
+	asp, err := ga.(adapter.AttributesGeneratorBuilder).NewAttributesGenerator(env, cfg.Builder.Params.(adapter.Config))
+	if err != nil {
+		return nil, err
+	}
+
+	return &attributesWrapper{
+		aspect:   asp,
+		inputs:   params.InputExpressions,
+		bindings: params.AttributeBindings,
+	}, nil
+}
+
+func (attributesManager) Kind() Kind { return AttributesKind }
+
+func (attributesManager) DefaultConfig() config.AspectParams {
+	return &aconfig.AttributesGeneratorParams{}
+}
+
+func (attributesManager) ValidateConfig(c config.AspectParams) (ce *adapter.ConfigErrors) {
+	cfg := c.(*aconfig.AttributesGeneratorParams)
+	for attr, out := range cfg.AttributeBindings {
+		if attr == "" || out == "" {
+			ce = ce.Appendf("AttributeBindings", "invalid binding of attribute '%s' to output '%s'", attr, out)
+		}
+	}
+	return
+}
+
+func (w *attributesWrapper) Execute(attrs attribute.Bag, mapper expr.Evaluator, ma APIMethodArgs) Output {
+	// Inputs that can't be evaluated (typically because the attribute is absent
+	// from the request) are left out; it's up to the adapter to decide whether
+	// it can still produce anything useful.
+	inputs := make(map[string]interface{}, len(w.inputs))
+	for name, ex := range w.inputs {
+		val, err := mapper.Eval(ex, attrs)
+		if err != nil {
+			glog.V(2).Infof("skipping attributes generator input '%s': %v", name, err)
+			continue
+		}
+		inputs[name] = val
+	}
+
+	out, err := w.aspect.Generate(inputs)
+	if err != nil {
+		return Output{Status: status.WithError(err)}
+	}
+
+	generated := make(map[string]interface{}, len(w.bindings))
+	for attr, name := range w.bindings {
+		if val, found := out[name]; found {
+			generated[attr] = val
+		}
+	}
+
+	return Output{Status: status.OK, Response: &PreprocessMethodResp{Attrs: generated}}
+}
+
+func (w *attributesWrapper) Close() error { return w.aspect.Close() }
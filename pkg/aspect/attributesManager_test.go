@@ -0,0 +1,170 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aspect
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"istio.io/mixer/pkg/adapter"
+	atest "istio.io/mixer/pkg/adapter/test"
+	aconfig "istio.io/mixer/pkg/aspect/config"
+	"istio.io/mixer/pkg/aspect/test"
+	"istio.io/mixer/pkg/attribute"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/status"
+)
+
+type fakeAttributesGenerator struct {
+	adapter.Aspect
+	closed bool
+	inputs map[string]interface{}
+	body   func(map[string]interface{}) (map[string]interface{}, error)
+}
+
+func (g *fakeAttributesGenerator) Close() error {
+	g.closed = true
+	return nil
+}
+
+func (g *fakeAttributesGenerator) Generate(inputs map[string]interface{}) (map[string]interface{}, error) {
+	g.inputs = inputs
+	return g.body(inputs)
+}
+
+type fakeAttributesBuilder struct {
+	adapter.Builder
+	body func() (adapter.AttributesGenerator, error)
+}
+
+func (b *fakeAttributesBuilder) NewAttributesGenerator(env adapter.Env, c adapter.Config) (adapter.AttributesGenerator, error) {
+	return b.body()
+}
+
+func newAttributesConfig(inputs, bindings map[string]string) *cpb.Combined {
+	return &cpb.Combined{
+		Aspect: &cpb.Aspect{Params: &aconfig.AttributesGeneratorParams{
+			InputExpressions:  inputs,
+			AttributeBindings: bindings,
+		}},
+		// the params we use here don't matter because we're faking the aspect
+		Builder: &cpb.Adapter{Params: &aconfig.AttributesGeneratorParams{}},
+	}
+}
+
+func TestAttributesManager(t *testing.T) {
+	m := newAttributesManager()
+	if m.Kind() != AttributesKind {
+		t.Errorf("m.Kind() = %s wanted %s", m.Kind(), AttributesKind)
+	}
+	if err := m.ValidateConfig(m.DefaultConfig()); err != nil {
+		t.Errorf("m.ValidateConfig(m.DefaultConfig()) = %v; wanted no err", err)
+	}
+	bad := &aconfig.AttributesGeneratorParams{AttributeBindings: map[string]string{"source.country": ""}}
+	if err := m.ValidateConfig(bad); err == nil {
+		t.Error("m.ValidateConfig(bad) = nil; wanted err")
+	}
+}
+
+func TestAttributesManager_NewAspect(t *testing.T) {
+	gen := &fakeAttributesGenerator{}
+	builder := &fakeAttributesBuilder{body: func() (adapter.AttributesGenerator, error) { return gen, nil }}
+	w, err := newAttributesManager().NewAspect(newAttributesConfig(nil, nil), builder, atest.NewEnv(t))
+	if err != nil {
+		t.Fatalf("NewAspect() = _, %v; wanted no err", err)
+	}
+	if err = w.Close(); err != nil || !gen.closed {
+		t.Errorf("w.Close() = %v, closed = %t; wanted no err and closed aspect", err, gen.closed)
+	}
+
+	builder.body = func() (adapter.AttributesGenerator, error) { return nil, errors.New("expected") }
+	if _, err = newAttributesManager().NewAspect(newAttributesConfig(nil, nil), builder, atest.NewEnv(t)); err == nil {
+		t.Error("NewAspect() = _, nil; wanted err")
+	}
+}
+
+func TestAttributesWrapper_Execute(t *testing.T) {
+	eval := test.NewFakeEval(func(exp string, _ attribute.Bag) (interface{}, error) {
+		if exp == "source.ip" {
+			return "10.0.0.1", nil
+		}
+		return nil, errors.New("unknown attribute")
+	})
+
+	cases := []struct {
+		name     string
+		inputs   map[string]string
+		bindings map[string]string
+		out      map[string]interface{}
+		err      error
+		want     map[string]interface{}
+		wantIn   map[string]interface{}
+	}{
+		{"bound outputs",
+			map[string]string{"ip_address": "source.ip"},
+			map[string]string{"source.country": "country_code", "source.city": "city"},
+			map[string]interface{}{"country_code": "US", "city": "Seattle", "asn": int64(15169)},
+			nil,
+			map[string]interface{}{"source.country": "US", "source.city": "Seattle"},
+			map[string]interface{}{"ip_address": "10.0.0.1"}},
+		{"missing output",
+			map[string]string{"ip_address": "source.ip"},
+			map[string]string{"source.country": "country_code"},
+			map[string]interface{}{},
+			nil,
+			map[string]interface{}{},
+			map[string]interface{}{"ip_address": "10.0.0.1"}},
+		{"unevaluable input",
+			map[string]string{"ip_address": "origin.ip"},
+			map[string]string{"source.country": "country_code"},
+			map[string]interface{}{},
+			nil,
+			map[string]interface{}{},
+			map[string]interface{}{}},
+		{"generator error",
+			map[string]string{"ip_address": "source.ip"},
+			map[string]string{"source.country": "country_code"},
+			nil,
+			errors.New("lookup failed"),
+			nil,
+			map[string]interface{}{"ip_address": "10.0.0.1"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gen := &fakeAttributesGenerator{body: func(map[string]interface{}) (map[string]interface{}, error) { return c.out, c.err }}
+			w := &attributesWrapper{aspect: gen, inputs: c.inputs, bindings: c.bindings}
+
+			out := w.Execute(test.NewBag(), eval, &PreprocessMethodArgs{})
+			if !reflect.DeepEqual(gen.inputs, c.wantIn) {
+				t.Errorf("Generate() got inputs %v, wanted %v", gen.inputs, c.wantIn)
+			}
+			if c.err != nil {
+				if status.IsOK(out.Status) {
+					t.Errorf("Execute() = %v; wanted error status", out.Status)
+				}
+				return
+			}
+			if !status.IsOK(out.Status) {
+				t.Fatalf("Execute() = %v; wanted OK", out.Status)
+			}
+			resp := out.Response.(*PreprocessMethodResp)
+			if !reflect.DeepEqual(resp.Attrs, c.want) {
+				t.Errorf("Execute() generated %v, wanted %v", resp.Attrs, c.want)
+			}
+		})
+	}
+}
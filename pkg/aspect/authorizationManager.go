@@ -0,0 +1,136 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aspect
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"istio.io/mixer/pkg/adapter"
+	aconfig "istio.io/mixer/pkg/aspect/config"
+	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/config"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/status"
+)
+
+type (
+	authorizationManager struct{}
+
+	authorizationWrapper struct {
+		name   string
+		aspect adapter.AuthorizationAspect
+		params *aconfig.AuthorizationParams
+	}
+)
+
+// newAuthorizationManager returns a manager for the authorization aspect.
+func newAuthorizationManager() Manager {
+	return authorizationManager{}
+}
+
+// NewAspect creates an authorization aspect.
+func (authorizationManager) NewAspect(c *cpb.Combined, a adapter.Builder, env adapter.Env) (Wrapper, error) {
+	asp, err := a.(adapter.AuthorizationBuilder).NewAuthorizationAspect(env, c.Builder.Params.(adapter.Config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct authorization aspect with config '%v' and err: %s", c, err)
+	}
+
+	return &authorizationWrapper{
+		name:   a.Name(),
+		aspect: asp,
+		params: c.Aspect.Params.(*aconfig.AuthorizationParams),
+	}, nil
+}
+
+func (authorizationManager) Kind() Kind { return AuthorizationKind }
+
+func (authorizationManager) DefaultConfig() config.AspectParams {
+	return &aconfig.AuthorizationParams{
+		Subject:  "source.name",
+		Action:   "api.method",
+		Resource: "api.name",
+	}
+}
+
+func (authorizationManager) ValidateConfig(c config.AspectParams) (ce *adapter.ConfigErrors) {
+	cfg := c.(*aconfig.AuthorizationParams)
+	if cfg.Subject == "" {
+		ce = ce.Appendf("Subject", "an expression must be provided")
+	}
+	if cfg.Action == "" {
+		ce = ce.Appendf("Action", "an expression must be provided")
+	}
+	if cfg.Resource == "" {
+		ce = ce.Appendf("Resource", "an expression must be provided")
+	}
+	return
+}
+
+func (w *authorizationWrapper) Execute(attrs attribute.Bag, mapper expr.Evaluator, ma APIMethodArgs) Output {
+	req, err := w.request(attrs, mapper)
+	if err != nil {
+		return Output{Status: status.WithError(err)}
+	}
+
+	eval := func(condition string) (bool, error) {
+		return mapper.EvalPredicate(condition, attrs)
+	}
+	d, err := w.aspect.Authorize(req, eval)
+	if err != nil {
+		return Output{Status: status.WithError(fmt.Errorf("failed to authorize request with err: %s", err))}
+	}
+
+	if d.Allowed {
+		if glog.V(4) {
+			glog.V(4).Infof("%s allowed to %s %s by %s", req.Subject, req.Action, req.Resource, describeRule(d.Rule))
+		}
+		return Output{Status: status.OK}
+	}
+
+	msg := fmt.Sprintf("%s is not allowed to %s %s: denied by %s", req.Subject, req.Action, req.Resource, describeRule(d.Rule))
+	if w.params.DryRun {
+		glog.Infof("dry run of authorization adapter '%s': %s", w.name, msg)
+		return Output{Status: status.OK}
+	}
+	return Output{Status: status.WithPermissionDenied(msg)}
+}
+
+// request evaluates the configured expressions to describe the access being authorized.
+func (w *authorizationWrapper) request(attrs attribute.Bag, mapper expr.Evaluator) (req adapter.AuthorizationRequest, err error) {
+	if req.Subject, err = evalName(w.params.Subject, attrs, mapper); err != nil {
+		return req, fmt.Errorf("failed to eval subject with err: %s", err)
+	}
+	if req.Action, err = evalName(w.params.Action, attrs, mapper); err != nil {
+		return req, fmt.Errorf("failed to eval action with err: %s", err)
+	}
+	if req.Resource, err = evalName(w.params.Resource, attrs, mapper); err != nil {
+		return req, fmt.Errorf("failed to eval resource with err: %s", err)
+	}
+	return req, nil
+}
+
+func (w *authorizationWrapper) Close() error {
+	return w.aspect.Close()
+}
+
+func describeRule(rule string) string {
+	if rule == "" {
+		return "the default policy"
+	}
+	return fmt.Sprintf("rule '%s'", rule)
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aspect
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/pkg/adapter"
+	atest "istio.io/mixer/pkg/adapter/test"
+	aconfig "istio.io/mixer/pkg/aspect/config"
+	"istio.io/mixer/pkg/attribute"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/expr"
+)
+
+type fakeAuthorizationAspect struct {
+	adapter.Aspect
+	closed    bool
+	condition string
+	decision  adapter.AuthorizationDecision
+	err       error
+	req       adapter.AuthorizationRequest
+}
+
+func (a *fakeAuthorizationAspect) Close() error {
+	a.closed = true
+	return nil
+}
+
+func (a *fakeAuthorizationAspect) Authorize(req adapter.AuthorizationRequest, eval adapter.ConditionEvaluator) (adapter.AuthorizationDecision, error) {
+	a.req = req
+	if a.condition != "" {
+		ok, err := eval(a.condition)
+		if err != nil {
+			return adapter.AuthorizationDecision{}, err
+		}
+		a.decision.Allowed = ok
+	}
+	return a.decision, a.err
+}
+
+type fakeAuthorizationBuilder struct {
+	adapter.Builder
+	body func() (adapter.AuthorizationAspect, error)
+}
+
+func (b *fakeAuthorizationBuilder) Name() string { return "fakeAuthorization" }
+
+func (b *fakeAuthorizationBuilder) NewAuthorizationAspect(env adapter.Env, c adapter.Config) (adapter.AuthorizationAspect, error) {
+	return b.body()
+}
+
+func TestAuthorizationManager(t *testing.T) {
+	m := newAuthorizationManager()
+	if m.Kind() != AuthorizationKind {
+		t.Errorf("m.Kind() = %s wanted %s", m.Kind(), AuthorizationKind)
+	}
+	if err := m.ValidateConfig(m.DefaultConfig()); err != nil {
+		t.Errorf("m.ValidateConfig(m.DefaultConfig()) = %v; wanted no err", err)
+	}
+
+	err := m.ValidateConfig(&aconfig.AuthorizationParams{})
+	if err == nil {
+		t.Fatal("m.ValidateConfig(empty) = nil; wanted err")
+	}
+	for _, field := range []string{"Subject", "Action", "Resource"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("m.ValidateConfig(empty) = %v; wanted err about %s", err, field)
+		}
+	}
+}
+
+func TestAuthorizationManager_NewAspect(t *testing.T) {
+	c := &cpb.Combined{
+		Aspect: &cpb.Aspect{Params: newAuthorizationManager().DefaultConfig()},
+		// the params we use here don't matter because we're faking the aspect
+		Builder: &cpb.Adapter{Params: &aconfig.AuthorizationParams{}},
+	}
+
+	asp := &fakeAuthorizationAspect{}
+	builder := &fakeAuthorizationBuilder{body: func() (adapter.AuthorizationAspect, error) { return asp, nil }}
+	w, err := newAuthorizationManager().NewAspect(c, builder, atest.NewEnv(t))
+	if err != nil {
+		t.Fatalf("NewAspect() = _, %v; wanted no err", err)
+	}
+	if err = w.Close(); err != nil || !asp.closed {
+		t.Errorf("w.Close() = %v, closed = %t; wanted no err and closed aspect", err, asp.closed)
+	}
+
+	builder.body = func() (adapter.AuthorizationAspect, error) { return nil, errors.New("expected") }
+	if _, err = newAuthorizationManager().NewAspect(c, builder, atest.NewEnv(t)); err == nil {
+		t.Error("NewAspect() = _, nil; wanted err")
+	}
+}
+
+func TestAuthorizationWrapper_Execute(t *testing.T) {
+	bag := attribute.GetMutableBag(nil)
+	bag.Set("source.name", "alice")
+	bag.Set("api.method", "GET")
+	bag.Set("api.name", "/books")
+
+	params := &aconfig.AuthorizationParams{Subject: "source.name", Action: "api.method", Resource: "api.name"}
+	dryRun := &aconfig.AuthorizationParams{Subject: "source.name", Action: "api.method", Resource: "api.name", DryRun: true}
+	badSubject := &aconfig.AuthorizationParams{Subject: "source.missing", Action: "api.method", Resource: "api.name"}
+
+	cases := []struct {
+		params *aconfig.AuthorizationParams
+		aspect *fakeAuthorizationAspect
+		code   rpc.Code
+		msg    string
+	}{
+		{params, &fakeAuthorizationAspect{decision: adapter.AuthorizationDecision{Allowed: true, Rule: "readers"}}, rpc.OK, ""},
+		{params, &fakeAuthorizationAspect{decision: adapter.AuthorizationDecision{Rule: "no-writes"}},
+			rpc.PERMISSION_DENIED, "alice is not allowed to GET /books: denied by rule 'no-writes'"},
+		{params, &fakeAuthorizationAspect{}, rpc.PERMISSION_DENIED, "denied by the default policy"},
+		{params, &fakeAuthorizationAspect{condition: `source.name == "alice"`}, rpc.OK, ""},
+		{params, &fakeAuthorizationAspect{condition: `source.name == "bob"`}, rpc.PERMISSION_DENIED, "not allowed"},
+		{params, &fakeAuthorizationAspect{condition: `source.name ==`}, rpc.INTERNAL, "failed to authorize"},
+		{params, &fakeAuthorizationAspect{err: errors.New("policy store down")}, rpc.INTERNAL, "policy store down"},
+		{dryRun, &fakeAuthorizationAspect{decision: adapter.AuthorizationDecision{Rule: "no-writes"}}, rpc.OK, ""},
+		{badSubject, &fakeAuthorizationAspect{}, rpc.INTERNAL, "failed to eval subject"},
+	}
+
+	for idx, c := range cases {
+		w := &authorizationWrapper{name: "fakeAuthorization", aspect: c.aspect, params: c.params}
+		out := w.Execute(bag, expr.NewCEXLEvaluator(), &CheckMethodArgs{})
+
+		if out.Status.Code != int32(c.code) || !strings.Contains(out.Message(), c.msg) {
+			t.Errorf("[%d] Execute() = %v; wanted code %s and message containing '%s'", idx, out.Status, c.code, c.msg)
+		}
+	}
+
+	asp := &fakeAuthorizationAspect{decision: adapter.AuthorizationDecision{Allowed: true}}
+	w := &authorizationWrapper{name: "fakeAuthorization", aspect: asp, params: params}
+	w.Execute(bag, expr.NewCEXLEvaluator(), &CheckMethodArgs{})
+	if want := (adapter.AuthorizationRequest{Subject: "alice", Action: "GET", Resource: "/books"}); asp.req != want {
+		t.Errorf("Authorize() got request %v; wanted %v", asp.req, want)
+	}
+}
@@ -15,25 +15,38 @@
 package aspect
 
 import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/gogo/protobuf/types"
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
 	"istio.io/mixer/pkg/adapter"
 	aconfig "istio.io/mixer/pkg/aspect/config"
 	"istio.io/mixer/pkg/attribute"
 	"istio.io/mixer/pkg/config"
 	cpb "istio.io/mixer/pkg/config/proto"
 	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/status"
 )
 
 type (
-	denialsManager struct{}
+	denialsManager struct {
+		validator expr.Validator // checks the template expressions
+	}
 
 	denialsWrapper struct {
-		aspect adapter.DenialsAspect
+		aspect        adapter.DenialsAspect
+		params        *aconfig.DenialsParams
+		template      *template.Template // nil when the adapter's message is kept
+		templateExprs map[string]string  // template variable -> expression
 	}
 )
 
 // newDenialsManager returns a manager for the denials aspect.
 func newDenialsManager() Manager {
-	return denialsManager{}
+	return denialsManager{validator: expr.NewCEXLEvaluator()}
 }
 
 // NewAspect creates a denyChecker aspect.
@@ -42,21 +55,97 @@ func (denialsManager) NewAspect(cfg *cpb.Combined, ga adapter.Builder, env adapt
 	var asp adapter.DenialsAspect
 	var err error
 
+	params := cfg.Aspect.Params.(*aconfig.DenialsParams)
+
+	var tmpl *template.Template
+	if params.MessageTemplate != "" {
+		if tmpl, err = template.New("denialMessage").Option("missingkey=error").Parse(params.MessageTemplate); err != nil {
+			return nil, fmt.Errorf("failed to parse message template '%s' with err: %s", params.MessageTemplate, err)
+		}
+	}
+
 	if asp, err = aa.NewDenialsAspect(env, cfg.Builder.Params.(config.AspectParams)); err != nil {
 		return nil, err
 	}
 
 	return &denialsWrapper{
-		aspect: asp,
+		aspect:        asp,
+		params:        params,
+		template:      tmpl,
+		templateExprs: params.TemplateExpressions,
 	}, nil
 }
 
-func (denialsManager) Kind() Kind                                                      { return DenialsKind }
-func (denialsManager) DefaultConfig() config.AspectParams                              { return &aconfig.DenialsParams{} }
-func (denialsManager) ValidateConfig(c config.AspectParams) (ce *adapter.ConfigErrors) { return }
+func (denialsManager) Kind() Kind                         { return DenialsKind }
+func (denialsManager) DefaultConfig() config.AspectParams { return &aconfig.DenialsParams{} }
+
+func (m denialsManager) ValidateConfig(c config.AspectParams) (ce *adapter.ConfigErrors) {
+	cfg := c.(*aconfig.DenialsParams)
+
+	if _, found := rpc.Code_name[cfg.Code]; !found {
+		ce = ce.Appendf("Code", "unknown status code %d", cfg.Code)
+	}
+	if cfg.MessageTemplate != "" {
+		if _, err := template.New("denialMessage").Option("missingkey=error").Parse(cfg.MessageTemplate); err != nil {
+			ce = ce.Appendf("MessageTemplate", "failed to parse template '%s' with err: %s", cfg.MessageTemplate, err)
+		}
+	}
+	for name, ex := range cfg.TemplateExpressions {
+		if ex == "" {
+			ce = ce.Appendf("TemplateExpressions", "no expression provided for template variable '%s'", name)
+		} else if err := m.validator.Validate(ex); err != nil {
+			ce = ce.Appendf("TemplateExpressions", "invalid expression '%s' for template variable '%s': %v", ex, name, err)
+		}
+	}
+	if cfg.RetryDelay != nil {
+		if d, err := types.DurationFromProto(cfg.RetryDelay); err != nil {
+			ce = ce.Append("RetryDelay", err)
+		} else if d < 0 {
+			ce = ce.Appendf("RetryDelay", "retry delay must be >= 0, got %v", d)
+		}
+	}
+	for idx, l := range cfg.HelpLinks {
+		if l.Url == "" {
+			ce = ce.Appendf(fmt.Sprintf("HelpLinks[%d]", idx), "a url must be provided")
+		}
+	}
+	return
+}
 
 func (a *denialsWrapper) Execute(attrs attribute.Bag, mapper expr.Evaluator, ma APIMethodArgs) Output {
-	return Output{Status: a.aspect.Deny()}
+	s := a.aspect.Deny()
+
+	if a.params.Code != int32(rpc.OK) {
+		s.Code = a.params.Code
+	}
+
+	if a.template != nil {
+		templateVals, err := evalAll(a.templateExprs, attrs, mapper)
+		if err != nil {
+			return Output{Status: status.WithError(fmt.Errorf("failed to eval template expressions for denial message with err: %s", err))}
+		}
+
+		buf := new(bytes.Buffer)
+		if err := a.template.Execute(buf, templateVals); err != nil {
+			return Output{Status: status.WithError(fmt.Errorf("failed to execute denial message template with err: %s", err))}
+		}
+		s.Message = buf.String()
+	}
+
+	if a.params.RetryDelay != nil {
+		d, _ := types.DurationFromProto(a.params.RetryDelay)
+		s = status.WithDetails(s, status.NewRetryInfo(d))
+	}
+
+	if len(a.params.HelpLinks) > 0 {
+		help := &rpc.Help{Links: make([]*rpc.Help_Link, len(a.params.HelpLinks))}
+		for i, l := range a.params.HelpLinks {
+			help.Links[i] = &rpc.Help_Link{Description: l.Description, Url: l.Url}
+		}
+		s = status.WithDetails(s, help)
+	}
+
+	return Output{Status: s}
 }
 
 func (a *denialsWrapper) Close() error { return a.aspect.Close() }
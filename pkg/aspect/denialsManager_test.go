@@ -14,8 +14,182 @@
 
 package aspect
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/pkg/adapter"
+	atest "istio.io/mixer/pkg/adapter/test"
+	aconfig "istio.io/mixer/pkg/aspect/config"
+	"istio.io/mixer/pkg/aspect/test"
+	"istio.io/mixer/pkg/attribute"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/status"
+)
+
+type fakeDenialsAspect struct {
+	adapter.Aspect
+	closed bool
+	status rpc.Status
+}
+
+func (a *fakeDenialsAspect) Close() error {
+	a.closed = true
+	return nil
+}
+
+func (a *fakeDenialsAspect) Deny() rpc.Status { return a.status }
+
+type fakeDenialsBuilder struct {
+	adapter.Builder
+	body func() (adapter.DenialsAspect, error)
+}
+
+func (b *fakeDenialsBuilder) NewDenialsAspect(env adapter.Env, c adapter.Config) (adapter.DenialsAspect, error) {
+	return b.body()
+}
 
 func TestDenyCheckerManager(t *testing.T) {
-	_ = newDenialsManager()
+	m := newDenialsManager()
+	if m.Kind() != DenialsKind {
+		t.Errorf("m.Kind() = %s wanted %s", m.Kind(), DenialsKind)
+	}
+	if err := m.ValidateConfig(m.DefaultConfig()); err != nil {
+		t.Errorf("m.ValidateConfig(m.DefaultConfig()) = %v; wanted no err", err)
+	}
+
+	cases := []struct {
+		params    *aconfig.DenialsParams
+		errString string
+	}{
+		{&aconfig.DenialsParams{Code: int32(rpc.UNAVAILABLE), MessageTemplate: "{{.service}} is down", RetryDelay: &types.Duration{Seconds: 60},
+			TemplateExpressions: map[string]string{"service": "target.service"}}, ""},
+		{&aconfig.DenialsParams{Code: 1234}, "Code"},
+		{&aconfig.DenialsParams{MessageTemplate: "{{.service"}, "MessageTemplate"},
+		{&aconfig.DenialsParams{TemplateExpressions: map[string]string{"service": ""}}, "TemplateExpressions"},
+		{&aconfig.DenialsParams{TemplateExpressions: map[string]string{"service": "target.service |"}}, "TemplateExpressions"},
+		{&aconfig.DenialsParams{RetryDelay: &types.Duration{Seconds: -1}}, "RetryDelay"},
+		{&aconfig.DenialsParams{HelpLinks: []*aconfig.DenialsParams_HelpLink{{Description: "no url"}}}, "HelpLinks[0]"},
+	}
+	for idx, c := range cases {
+		errString := ""
+		if err := m.ValidateConfig(c.params); err != nil {
+			errString = err.Error()
+		}
+		if (c.errString == "") != (errString == "") || !strings.Contains(errString, c.errString) {
+			t.Errorf("[%d] m.ValidateConfig() = '%s'; wanted errString containing '%s'", idx, errString, c.errString)
+		}
+	}
+}
+
+func TestDenialsManager_NewAspect(t *testing.T) {
+	c := &cpb.Combined{
+		Aspect:  &cpb.Aspect{Params: &aconfig.DenialsParams{MessageTemplate: "{{.service}} is down"}},
+		Builder: &cpb.Adapter{Params: &aconfig.DenialsParams{}},
+	}
+
+	asp := &fakeDenialsAspect{}
+	builder := &fakeDenialsBuilder{body: func() (adapter.DenialsAspect, error) { return asp, nil }}
+	w, err := newDenialsManager().NewAspect(c, builder, atest.NewEnv(t))
+	if err != nil {
+		t.Fatalf("NewAspect() = _, %v; wanted no err", err)
+	}
+	if err = w.Close(); err != nil || !asp.closed {
+		t.Errorf("w.Close() = %v, closed = %t; wanted no err and closed aspect", err, asp.closed)
+	}
+
+	builder.body = func() (adapter.DenialsAspect, error) { return nil, errors.New("expected") }
+	if _, err = newDenialsManager().NewAspect(c, builder, atest.NewEnv(t)); err == nil {
+		t.Error("NewAspect() = _, nil; wanted err")
+	}
+
+	c.Aspect.Params = &aconfig.DenialsParams{MessageTemplate: "{{.service"}
+	if _, err = newDenialsManager().NewAspect(c, builder, atest.NewEnv(t)); err == nil || !strings.Contains(err.Error(), "template") {
+		t.Errorf("NewAspect() = _, %v; wanted template err", err)
+	}
+}
+
+func TestDenialsWrapper_Execute(t *testing.T) {
+	eval := test.NewFakeEval(func(exp string, _ attribute.Bag) (interface{}, error) {
+		if exp == "target.service" {
+			return "books", nil
+		}
+		return nil, errors.New("unknown attribute")
+	})
+	adapterStatus := rpc.Status{Code: int32(rpc.FAILED_PRECONDITION), Message: "denied"}
+
+	cases := []struct {
+		params  *aconfig.DenialsParams
+		code    rpc.Code
+		message string
+		details int
+	}{
+		{&aconfig.DenialsParams{}, rpc.FAILED_PRECONDITION, "denied", 0},
+		{&aconfig.DenialsParams{Code: int32(rpc.UNAVAILABLE)}, rpc.UNAVAILABLE, "denied", 0},
+		{&aconfig.DenialsParams{
+			Code:                int32(rpc.UNAVAILABLE),
+			MessageTemplate:     "{{.service}} is down for maintenance",
+			TemplateExpressions: map[string]string{"service": "target.service"},
+			RetryDelay:          &types.Duration{Seconds: 600},
+			HelpLinks:           []*aconfig.DenialsParams_HelpLink{{Description: "schedule", Url: "https://status.example.com"}},
+		}, rpc.UNAVAILABLE, "books is down for maintenance", 2},
+		{&aconfig.DenialsParams{
+			MessageTemplate:     "{{.service}} is down",
+			TemplateExpressions: map[string]string{"service": "target.missing"},
+		}, rpc.INTERNAL, "failed to eval template expressions", 0},
+		{&aconfig.DenialsParams{MessageTemplate: "{{.service}} is down"}, rpc.INTERNAL, "failed to execute", 0},
+	}
+
+	for idx, c := range cases {
+		cfg := &cpb.Combined{Aspect: &cpb.Aspect{Params: c.params}, Builder: &cpb.Adapter{Params: &aconfig.DenialsParams{}}}
+		builder := &fakeDenialsBuilder{body: func() (adapter.DenialsAspect, error) { return &fakeDenialsAspect{status: adapterStatus}, nil }}
+		w, err := newDenialsManager().NewAspect(cfg, builder, atest.NewEnv(t))
+		if err != nil {
+			t.Fatalf("[%d] NewAspect() = _, %v; wanted no err", idx, err)
+		}
+
+		out := w.Execute(test.NewBag(), eval, &CheckMethodArgs{})
+		if out.Status.Code != int32(c.code) || !strings.Contains(out.Message(), c.message) || len(out.Status.Details) != c.details {
+			t.Errorf("[%d] Execute() = %v; wanted code %s, message containing '%s' and %d details", idx, out.Status, c.code, c.message, c.details)
+		}
+	}
+
+	// the adapter's status is left untouched
+	if adapterStatus.Code != int32(rpc.FAILED_PRECONDITION) || adapterStatus.Message != "denied" {
+		t.Errorf("Adapter status was modified to %v", adapterStatus)
+	}
+}
+
+func TestDenialsWrapper_ExecuteDetails(t *testing.T) {
+	params := &aconfig.DenialsParams{
+		RetryDelay: &types.Duration{Seconds: 30},
+		HelpLinks:  []*aconfig.DenialsParams_HelpLink{{Description: "schedule", Url: "https://status.example.com"}},
+	}
+	w := &denialsWrapper{aspect: &fakeDenialsAspect{status: status.New(rpc.UNAVAILABLE)}, params: params}
+
+	out := w.Execute(test.NewBag(), test.NewIDEval(), &CheckMethodArgs{})
+	if len(out.Status.Details) != 2 {
+		t.Fatalf("Execute() = %v; wanted 2 details", out.Status)
+	}
+
+	var ri rpc.RetryInfo
+	if err := types.UnmarshalAny(out.Status.Details[0], &ri); err != nil {
+		t.Fatalf("Unable to unmarshal RetryInfo: %v", err)
+	}
+	if d, _ := types.DurationFromProto(ri.RetryDelay); d != 30*time.Second {
+		t.Errorf("Got retry delay %v; wanted 30s", d)
+	}
+
+	var help rpc.Help
+	if err := types.UnmarshalAny(out.Status.Details[1], &help); err != nil {
+		t.Fatalf("Unable to unmarshal Help: %v", err)
+	}
+	if len(help.Links) != 1 || help.Links[0].Url != "https://status.example.com" || help.Links[0].Description != "schedule" {
+		t.Errorf("Got help %v; wanted the maintenance schedule", help)
+	}
 }
@@ -23,6 +23,7 @@ func Inventory() ManagerInventory {
 		CheckMethod: {
 			newDenialsManager(),
 			newListsManager(),
+			newAuthorizationManager(),
 			newQuotasManager(), // TODO: Remove once proxy uses the Quota method
 		},
 
@@ -30,10 +31,15 @@ func Inventory() ManagerInventory {
 			newApplicationLogsManager(),
 			newAccessLogsManager(),
 			newMetricsManager(),
+			newSpansManager(),
 		},
 
 		QuotaMethod: {
 			newQuotasManager(),
 		},
+
+		PreprocessMethod: {
+			newAttributesManager(),
+		},
 	}
 }
@@ -34,4 +34,8 @@ func TestInventory(t *testing.T) {
 	if len(inventory[QuotaMethod]) == 0 {
 		t.Error("Expecting some managers for QuotaMethod, got 0")
 	}
+
+	if len(inventory[PreprocessMethod]) == 0 {
+		t.Error("Expecting some managers for PreprocessMethod, got 0")
+	}
 }
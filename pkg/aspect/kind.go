@@ -25,6 +25,9 @@ const (
 	ListsKind
 	MetricsKind
 	QuotasKind
+	AttributesKind
+	SpansKind
+	AuthorizationKind
 )
 
 // Name of all supported aspect kinds.
@@ -35,6 +38,9 @@ const (
 	ListsKindName           = "lists"
 	MetricsKindName         = "metrics"
 	QuotasKindName          = "quotas"
+	AttributesKindName      = "attributes"
+	SpansKindName           = "spans"
+	AuthorizationKindName   = "authorization"
 )
 
 // kindToString maps from kinds to their names.
@@ -45,6 +51,9 @@ var kindToString = map[Kind]string{
 	ListsKind:           ListsKindName,
 	MetricsKind:         MetricsKindName,
 	QuotasKind:          QuotasKindName,
+	AttributesKind:      AttributesKindName,
+	SpansKind:           SpansKindName,
+	AuthorizationKind:   AuthorizationKindName,
 }
 
 // stringToKinds maps from kind name to kind enum.
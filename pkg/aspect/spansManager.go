@@ -0,0 +1,192 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aspect
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/mixer/pkg/adapter"
+	aconfig "istio.io/mixer/pkg/aspect/config"
+	"istio.io/mixer/pkg/attribute"
+	"istio.io/mixer/pkg/config"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/status"
+)
+
+type (
+	spansManager struct{}
+
+	spansWrapper struct {
+		name   string
+		aspect adapter.SpansAspect
+		params *aconfig.SpansParams
+	}
+)
+
+// newSpansManager returns a manager for the spans aspect.
+func newSpansManager() Manager {
+	return spansManager{}
+}
+
+// NewAspect creates a spans aspect.
+func (spansManager) NewAspect(c *cpb.Combined, a adapter.Builder, env adapter.Env) (Wrapper, error) {
+	params := c.Aspect.Params.(*aconfig.SpansParams)
+
+	asp, err := a.(adapter.SpansBuilder).NewSpansAspect(env, c.Builder.Params.(adapter.Config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct spans aspect with config '%v' and err: %s", c, err)
+	}
+
+	return &spansWrapper{
+		name:   a.Name(),
+		aspect: asp,
+		params: params,
+	}, nil
+}
+
+func (spansManager) Kind() Kind                         { return SpansKind }
+func (spansManager) DefaultConfig() config.AspectParams { return &aconfig.SpansParams{} }
+
+func (spansManager) ValidateConfig(c config.AspectParams) (ce *adapter.ConfigErrors) {
+	cfg := c.(*aconfig.SpansParams)
+
+	required := []struct {
+		field string
+		value string
+	}{
+		{"TraceId", cfg.TraceId},
+		{"SpanId", cfg.SpanId},
+		{"SpanName", cfg.SpanName},
+		{"StartTime", cfg.StartTime},
+		{"EndTime", cfg.EndTime},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			ce = ce.Appendf(r.field, "an expression must be provided")
+		}
+	}
+
+	for tag, ex := range cfg.Tags {
+		if tag == "" || ex == "" {
+			ce = ce.Appendf("Tags", "invalid binding of tag '%s' to expression '%s'", tag, ex)
+		}
+	}
+	return
+}
+
+func (w *spansWrapper) Execute(attrs attribute.Bag, mapper expr.Evaluator, ma APIMethodArgs) Output {
+	span, err := w.span(attrs, mapper)
+	if err != nil {
+		return Output{Status: status.WithError(err)}
+	}
+
+	if err = w.aspect.ReportSpans([]adapter.Span{span}); err != nil {
+		return Output{Status: status.WithError(fmt.Errorf("failed to report span with err: %s", err))}
+	}
+
+	if glog.V(4) {
+		glog.V(4).Infof("completed execution of spans adapter '%s' for span %s", w.name, span.SpanID)
+	}
+	return Output{Status: status.OK}
+}
+
+// span evaluates the configured expressions to produce the span of a request.
+func (w *spansWrapper) span(attrs attribute.Bag, mapper expr.Evaluator) (span adapter.Span, err error) {
+	if span.TraceID, err = evalID(w.params.TraceId, attrs, mapper); err != nil {
+		return span, fmt.Errorf("failed to eval trace id with err: %s", err)
+	}
+	if span.SpanID, err = evalID(w.params.SpanId, attrs, mapper); err != nil {
+		return span, fmt.Errorf("failed to eval span id with err: %s", err)
+	}
+	if span.TraceID == "" || span.SpanID == "" {
+		return span, fmt.Errorf("trace id '%s' and span id '%s' must not be empty", span.TraceID, span.SpanID)
+	}
+	if w.params.ParentSpanId != "" {
+		// an empty parent id denotes a root span
+		if span.ParentSpanID, err = evalID(w.params.ParentSpanId, attrs, mapper); err != nil {
+			return span, fmt.Errorf("failed to eval parent span id with err: %s", err)
+		}
+	}
+	if span.Name, err = evalName(w.params.SpanName, attrs, mapper); err != nil {
+		return span, fmt.Errorf("failed to eval span name with err: %s", err)
+	}
+	if span.StartTime, err = evalTime(w.params.StartTime, attrs, mapper); err != nil {
+		return span, fmt.Errorf("failed to eval start time with err: %s", err)
+	}
+	if span.EndTime, err = evalTime(w.params.EndTime, attrs, mapper); err != nil {
+		return span, fmt.Errorf("failed to eval end time with err: %s", err)
+	}
+
+	span.Tags = make(map[string]interface{}, len(w.params.Tags))
+	for tag, ex := range w.params.Tags {
+		val, err := mapper.Eval(ex, attrs)
+		if err != nil {
+			glog.V(2).Infof("skipping span tag '%s': %v", tag, err)
+			continue
+		}
+		span.Tags[tag] = val
+	}
+	return span, nil
+}
+
+func (w *spansWrapper) Close() error {
+	return w.aspect.Close()
+}
+
+// evalID evaluates an id expression, formatting integer ids as 16 hex digits.
+func evalID(ex string, attrs attribute.Bag, mapper expr.Evaluator) (string, error) {
+	val, err := mapper.Eval(ex, attrs)
+	if err != nil {
+		return "", err
+	}
+
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return fmt.Sprintf("%016x", uint64(v)), nil
+	}
+	return "", fmt.Errorf("expression '%s' evaluated to %T, expected a string or an int64", ex, val)
+}
+
+func evalName(ex string, attrs attribute.Bag, mapper expr.Evaluator) (string, error) {
+	val, err := mapper.Eval(ex, attrs)
+	if err != nil {
+		return "", err
+	}
+
+	name, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("expression '%s' evaluated to %T, expected a string", ex, val)
+	}
+	return name, nil
+}
+
+func evalTime(ex string, attrs attribute.Bag, mapper expr.Evaluator) (time.Time, error) {
+	val, err := mapper.Eval(ex, attrs)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t, ok := val.(time.Time)
+	if !ok {
+		return time.Time{}, fmt.Errorf("expression '%s' evaluated to %T, expected a timestamp", ex, val)
+	}
+	return t, nil
+}
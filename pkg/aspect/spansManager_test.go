@@ -0,0 +1,198 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aspect
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+	atest "istio.io/mixer/pkg/adapter/test"
+	aconfig "istio.io/mixer/pkg/aspect/config"
+	"istio.io/mixer/pkg/aspect/test"
+	"istio.io/mixer/pkg/attribute"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/status"
+)
+
+type fakeSpansAspect struct {
+	adapter.Aspect
+	closed bool
+	spans  []adapter.Span
+	err    error
+}
+
+func (a *fakeSpansAspect) Close() error {
+	a.closed = true
+	return nil
+}
+
+func (a *fakeSpansAspect) ReportSpans(spans []adapter.Span) error {
+	a.spans = append(a.spans, spans...)
+	return a.err
+}
+
+type fakeSpansBuilder struct {
+	adapter.Builder
+	body func() (adapter.SpansAspect, error)
+}
+
+func (b *fakeSpansBuilder) Name() string { return "fakeSpans" }
+
+func (b *fakeSpansBuilder) NewSpansAspect(env adapter.Env, c adapter.Config) (adapter.SpansAspect, error) {
+	return b.body()
+}
+
+func validSpansParams() *aconfig.SpansParams {
+	return &aconfig.SpansParams{
+		TraceId:      "request.trace_id",
+		SpanId:       "request.span_id",
+		ParentSpanId: "request.parent_span_id",
+		SpanName:     "request.path",
+		StartTime:    "request.time",
+		EndTime:      "response.time",
+		Tags:         map[string]string{"http.status_code": "response.code", "user": "source.user"},
+	}
+}
+
+func TestSpansManager(t *testing.T) {
+	m := newSpansManager()
+	if m.Kind() != SpansKind {
+		t.Errorf("m.Kind() = %s wanted %s", m.Kind(), SpansKind)
+	}
+
+	if err := m.ValidateConfig(validSpansParams()); err != nil {
+		t.Errorf("m.ValidateConfig(valid) = %v; wanted no err", err)
+	}
+
+	noParent := validSpansParams()
+	noParent.ParentSpanId = ""
+	if err := m.ValidateConfig(noParent); err != nil {
+		t.Errorf("m.ValidateConfig(noParent) = %v; wanted no err", err)
+	}
+
+	// the default config doesn't say where to find spans
+	err := m.ValidateConfig(m.DefaultConfig())
+	if err == nil {
+		t.Fatal("m.ValidateConfig(m.DefaultConfig()) = nil; wanted err")
+	}
+	for _, field := range []string{"TraceId", "SpanId", "SpanName", "StartTime", "EndTime"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("m.ValidateConfig(m.DefaultConfig()) = %v; wanted err about %s", err, field)
+		}
+	}
+
+	badTag := validSpansParams()
+	badTag.Tags["empty"] = ""
+	if err := m.ValidateConfig(badTag); err == nil {
+		t.Error("m.ValidateConfig(badTag) = nil; wanted err")
+	}
+}
+
+func TestSpansManager_NewAspect(t *testing.T) {
+	c := &cpb.Combined{
+		Aspect: &cpb.Aspect{Params: validSpansParams()},
+		// the params we use here don't matter because we're faking the aspect
+		Builder: &cpb.Adapter{Params: &aconfig.SpansParams{}},
+	}
+
+	asp := &fakeSpansAspect{}
+	builder := &fakeSpansBuilder{body: func() (adapter.SpansAspect, error) { return asp, nil }}
+	w, err := newSpansManager().NewAspect(c, builder, atest.NewEnv(t))
+	if err != nil {
+		t.Fatalf("NewAspect() = _, %v; wanted no err", err)
+	}
+	if err = w.Close(); err != nil || !asp.closed {
+		t.Errorf("w.Close() = %v, closed = %t; wanted no err and closed aspect", err, asp.closed)
+	}
+
+	builder.body = func() (adapter.SpansAspect, error) { return nil, errors.New("expected") }
+	if _, err = newSpansManager().NewAspect(c, builder, atest.NewEnv(t)); err == nil {
+		t.Error("NewAspect() = _, nil; wanted err")
+	}
+}
+
+func TestSpansWrapper_Execute(t *testing.T) {
+	start := time.Unix(1000, 0)
+	end := start.Add(time.Second)
+
+	values := map[string]interface{}{
+		"request.trace_id":       "463ac35c9f6413ad",
+		"request.span_id":        int64(0x72485a3953bb6124),
+		"request.parent_span_id": "",
+		"request.path":           "/books",
+		"request.time":           start,
+		"response.time":          end,
+		"response.code":          int64(200),
+		"request.size":           "not a time",
+	}
+	eval := test.NewFakeEval(func(exp string, _ attribute.Bag) (interface{}, error) {
+		if v, found := values[exp]; found {
+			return v, nil
+		}
+		return nil, errors.New("unknown attribute")
+	})
+
+	want := adapter.Span{
+		TraceID:   "463ac35c9f6413ad",
+		SpanID:    "72485a3953bb6124",
+		Name:      "/books",
+		StartTime: start,
+		EndTime:   end,
+		Tags:      map[string]interface{}{"http.status_code": int64(200)},
+	}
+
+	asp := &fakeSpansAspect{}
+	w := &spansWrapper{name: "fakeSpans", aspect: asp, params: validSpansParams()}
+	if out := w.Execute(test.NewBag(), eval, &ReportMethodArgs{}); !status.IsOK(out.Status) {
+		t.Fatalf("Execute() = %v; wanted OK", out.Status)
+	}
+	if len(asp.spans) != 1 || !reflect.DeepEqual(asp.spans[0], want) {
+		t.Errorf("Reported spans %v; wanted [%v]", asp.spans, want)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(*aconfig.SpansParams)
+		err    string
+	}{
+		{"missing trace id", func(p *aconfig.SpansParams) { p.TraceId = "request.missing" }, "trace id"},
+		{"empty span id", func(p *aconfig.SpansParams) { p.SpanId = "request.parent_span_id" }, "must not be empty"},
+		{"bad id type", func(p *aconfig.SpansParams) { p.SpanId = "request.time" }, "span id"},
+		{"bad parent", func(p *aconfig.SpansParams) { p.ParentSpanId = "request.missing" }, "parent span id"},
+		{"bad name", func(p *aconfig.SpansParams) { p.SpanName = "request.missing" }, "span name"},
+		{"bad start", func(p *aconfig.SpansParams) { p.StartTime = "request.size" }, "start time"},
+		{"bad end", func(p *aconfig.SpansParams) { p.EndTime = "request.missing" }, "end time"},
+	}
+
+	for idx, c := range cases {
+		params := validSpansParams()
+		c.modify(params)
+		w := &spansWrapper{name: "fakeSpans", aspect: &fakeSpansAspect{}, params: params}
+
+		out := w.Execute(test.NewBag(), eval, &ReportMethodArgs{})
+		if status.IsOK(out.Status) || !strings.Contains(out.Message(), c.err) {
+			t.Errorf("[%d] %s: Execute() = %v; wanted error containing '%s'", idx, c.name, out.Status, c.err)
+		}
+	}
+
+	failing := &spansWrapper{name: "fakeSpans", aspect: &fakeSpansAspect{err: errors.New("backend down")}, params: validSpansParams()}
+	if out := failing.Execute(test.NewBag(), eval, &ReportMethodArgs{}); !strings.Contains(out.Message(), "backend down") {
+		t.Errorf("Execute() = %v; wanted adapter error", out.Status)
+	}
+}
@@ -225,6 +225,46 @@ func TestMergeErrors(t *testing.T) {
 	}
 }
 
+func TestToProto(t *testing.T) {
+	parent := GetMutableBag(nil)
+	parent.Set("S", "parent")
+	parent.Set("I", int64(1))
+
+	mb := parent.Child()
+	mb.Set("S", "child")
+	mb.Set("D", 2.0)
+	mb.Set("B", true)
+	mb.Set("T", t9)
+	mb.Set("DUR", d1)
+	mb.Set("BYTES", []byte{1, 2})
+	mb.Set("SM", map[string]string{"S": "x", "K": "y"})
+
+	attrs := mixerpb.Attributes{}
+	mb.ToProto(&attrs)
+
+	if !attrs.ResetContext {
+		t.Error("ToProto() didn't reset the context")
+	}
+
+	// decode into a fresh bag and compare
+	b := GetMutableBag(nil)
+	if err := b.update(attrs.Dictionary, &attrs); err != nil {
+		t.Fatalf("Unable to apply encoded attributes: %v", err)
+	}
+
+	names := mb.Names()
+	if len(b.Names()) != 8 {
+		t.Errorf("Got names %v, expecting the 8 distinct names in %v", b.Names(), names)
+	}
+	for _, n := range names {
+		want, _ := mb.Get(n)
+		got, found := b.Get(n)
+		if !found || !reflect.DeepEqual(got, want) {
+			t.Errorf("Attribute %s: got %v, expecting %v", n, got, want)
+		}
+	}
+}
+
 func TestEmpty(t *testing.T) {
 	b := &emptyBag{}
 
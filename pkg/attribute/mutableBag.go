@@ -17,8 +17,10 @@ package attribute
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	me "github.com/hashicorp/go-multierror"
@@ -171,6 +173,86 @@ func (mb *MutableBag) Child() *MutableBag {
 	return GetMutableBag(mb)
 }
 
+// ToProto encodes the full content of the bag, including the attributes
+// inherited from its parents, into a self-contained Attributes struct.
+//
+// The output carries its own dictionary and resets the context, so it can
+// be applied to an empty bag without any prior stream state.
+func (mb *MutableBag) ToProto(output *mixerpb.Attributes) {
+	names := mb.Names()
+	sort.Strings(names)
+
+	dict := make(map[string]int32, len(names))
+	output.Dictionary = make(map[int32]string, len(names))
+	index := func(name string) int32 {
+		i, found := dict[name]
+		if !found {
+			i = int32(len(dict))
+			dict[name] = i
+			output.Dictionary[i] = name
+		}
+		return i
+	}
+
+	output.ResetContext = true
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			// overridden in a child bag
+			continue
+		}
+
+		v, _ := mb.Get(name)
+		switch t := v.(type) {
+		case string:
+			if output.StringAttributes == nil {
+				output.StringAttributes = make(map[int32]string)
+			}
+			output.StringAttributes[index(name)] = t
+		case int64:
+			if output.Int64Attributes == nil {
+				output.Int64Attributes = make(map[int32]int64)
+			}
+			output.Int64Attributes[index(name)] = t
+		case float64:
+			if output.DoubleAttributes == nil {
+				output.DoubleAttributes = make(map[int32]float64)
+			}
+			output.DoubleAttributes[index(name)] = t
+		case bool:
+			if output.BoolAttributes == nil {
+				output.BoolAttributes = make(map[int32]bool)
+			}
+			output.BoolAttributes[index(name)] = t
+		case time.Time:
+			if output.TimestampAttributes == nil {
+				output.TimestampAttributes = make(map[int32]time.Time)
+			}
+			output.TimestampAttributes[index(name)] = t
+		case time.Duration:
+			if output.DurationAttributes == nil {
+				output.DurationAttributes = make(map[int32]time.Duration)
+			}
+			output.DurationAttributes[index(name)] = t
+		case []byte:
+			if output.BytesAttributes == nil {
+				output.BytesAttributes = make(map[int32][]byte)
+			}
+			output.BytesAttributes[index(name)] = t
+		case map[string]string:
+			if output.StringMapAttributes == nil {
+				output.StringMapAttributes = make(map[int32]mixerpb.StringMap)
+			}
+			sm := mixerpb.StringMap{Map: make(map[int32]string, len(t))}
+			for k, v := range t {
+				sm.Map[index(k)] = v
+			}
+			output.StringMapAttributes[index(name)] = sm
+		default:
+			glog.Warningf("Attribute %s of unsupported type %T can't be encoded", name, v)
+		}
+	}
+}
+
 // Ensure that all dictionary indices are valid and that all values
 // are in range.
 //
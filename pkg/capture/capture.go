@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capture records the mixer's API traffic so it can be replayed later.
+//
+// A capture is a sequence of JSON-encoded entries, one per line. Each entry
+// holds a single request's fully decoded attributes along with the response the
+// mixer produced for it, which makes every entry independent of the stream it
+// was received on.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	mixerpb "istio.io/api/mixer/v1"
+)
+
+// Names of the API methods found in entries.
+const (
+	CheckMethod  = "Check"
+	ReportMethod = "Report"
+	QuotaMethod  = "Quota"
+)
+
+// Entry is a single captured request and its response.
+type Entry struct {
+	// Method is the name of the API method invoked.
+	Method string `json:"method"`
+
+	// Time is when the request was received.
+	Time time.Time `json:"time"`
+
+	// ConfigSHA identifies the config that was in effect when the request was processed.
+	ConfigSHA string `json:"configSHA,omitempty"`
+
+	// Attributes is the complete set of request attributes, independent of any stream state.
+	Attributes mixerpb.Attributes `json:"attributes"`
+
+	// Quota holds the quota specific arguments of Quota requests.
+	Quota *QuotaArgs `json:"quota,omitempty"`
+
+	// Result is the status returned to the caller.
+	Result rpc.Status `json:"result"`
+
+	// Amount is the amount of quota granted by a Quota request.
+	Amount int64 `json:"amount,omitempty"`
+}
+
+// QuotaArgs are the quota specific arguments of a Quota request.
+type QuotaArgs struct {
+	Quota           string `json:"quota"`
+	Amount          int64  `json:"amount"`
+	DeduplicationID string `json:"deduplicationId,omitempty"`
+	BestEffort      bool   `json:"bestEffort,omitempty"`
+}
+
+// Recorder accepts captured entries.
+type Recorder interface {
+	io.Closer
+
+	// Record adds an entry to the capture. Record may be called concurrently.
+	Record(e *Entry)
+}
+
+type recorder struct {
+	sync.Mutex
+	c         io.Closer
+	w         *bufio.Writer
+	enc       *json.Encoder
+	configSHA func() string
+}
+
+// NewRecorder returns a Recorder that writes entries to w. When configSHA is
+// not nil, it is used to stamp each entry with the config currently in effect.
+func NewRecorder(w io.Writer, configSHA func() string) Recorder {
+	r := &recorder{
+		w:         bufio.NewWriter(w),
+		configSHA: configSHA,
+	}
+	r.enc = json.NewEncoder(r.w)
+	if c, ok := w.(io.Closer); ok {
+		r.c = c
+	}
+	return r
+}
+
+// NewFileRecorder returns a Recorder that appends entries to the named file.
+func NewFileRecorder(path string, configSHA func() string) (Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(f, configSHA), nil
+}
+
+func (r *recorder) Record(e *Entry) {
+	if r.configSHA != nil {
+		e.ConfigSHA = r.configSHA()
+	}
+
+	r.Lock()
+	err := r.enc.Encode(e)
+	r.Unlock()
+
+	if err != nil {
+		glog.Warningf("Unable to record %s request: %v", e.Method, err)
+	}
+}
+
+func (r *recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	err := r.w.Flush()
+	if r.c != nil {
+		if cerr := r.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Reader reads entries from a capture.
+type Reader struct {
+	dec *json.Decoder
+}
+
+// NewReader returns a Reader that decodes entries from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next entry of the capture, or io.EOF when there are no more entries.
+func (r *Reader) Next() (*Entry, error) {
+	e := &Entry{}
+	if err := r.dec.Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	mixerpb "istio.io/api/mixer/v1"
+)
+
+func testEntries() []*Entry {
+	now := time.Date(2017, 3, 1, 10, 0, 0, 42, time.UTC)
+	return []*Entry{
+		{
+			Method: CheckMethod,
+			Time:   now,
+			Attributes: mixerpb.Attributes{
+				Dictionary:          map[int32]string{0: "a", 1: "b", 2: "c", 3: "d"},
+				StringAttributes:    map[int32]string{0: "A"},
+				TimestampAttributes: map[int32]time.Time{1: now},
+				DurationAttributes:  map[int32]time.Duration{2: time.Second},
+				StringMapAttributes: map[int32]mixerpb.StringMap{3: {Map: map[int32]string{0: "x"}}},
+				ResetContext:        true,
+			},
+			Result: rpc.Status{Code: int32(rpc.PERMISSION_DENIED), Message: "denied"},
+		},
+		{
+			Method: QuotaMethod,
+			Time:   now.Add(time.Millisecond),
+			Quota:  &QuotaArgs{Quota: "requestCount", Amount: 3, DeduplicationID: "x"},
+			Amount: 2,
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRecorder(&buf, func() string { return "abc:def" })
+
+	entries := testEntries()
+	for _, e := range entries {
+		r.Record(e)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	rd := NewReader(&buf)
+	for i, want := range entries {
+		got, err := rd.Next()
+		if err != nil {
+			t.Fatalf("%d: Next() failed: %v", i, err)
+		}
+		if got.ConfigSHA != "abc:def" {
+			t.Errorf("%d: got config SHA '%s', expecting abc:def", i, got.ConfigSHA)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got %+v, expecting %+v", i, got, want)
+		}
+	}
+
+	if _, err := rd.Next(); err != io.EOF {
+		t.Errorf("Next() returned %v at the end of the capture, expecting EOF", err)
+	}
+}
+
+func TestFileRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := path.Join(dir, "capture.json")
+
+	// two recorders appending to the same file
+	for i := 0; i < 2; i++ {
+		r, err := NewFileRecorder(file, nil)
+		if err != nil {
+			t.Fatalf("NewFileRecorder() failed: %v", err)
+		}
+		r.Record(testEntries()[i])
+		if err = r.Close(); err != nil {
+			t.Fatalf("Close() failed: %v", err)
+		}
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Unable to open capture: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	rd := NewReader(f)
+	for _, m := range []string{CheckMethod, QuotaMethod} {
+		e, err := rd.Next()
+		if err != nil || e.Method != m || e.ConfigSHA != "" {
+			t.Errorf("Next() = %v, %v; expecting a %s entry", e, err, m)
+		}
+	}
+
+	if _, err = NewFileRecorder(path.Join(dir, "missing", "capture.json"), nil); err == nil {
+		t.Error("NewFileRecorder() succeeded in a missing directory, expecting failure")
+	}
+}
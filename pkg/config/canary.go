@@ -0,0 +1,171 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/pkg/attribute"
+	pb "istio.io/mixer/pkg/config/proto"
+)
+
+// Values of the track label of the request counter.
+const (
+	trackStable = "stable"
+	trackCanary = "canary"
+)
+
+// OutcomeRecorder is implemented by resolvers that track the status of the
+// requests they resolved config for.
+type OutcomeRecorder interface {
+	// RecordOutcome records the status code of a request, given its attributes.
+	RecordOutcome(bag attribute.Bag, code int32)
+}
+
+// CanaryPolicy describes how a Manager rolls out new configs. While a new
+// config is a canary, a share of the requests is resolved against it and the
+// rest against the installed config. Once the canary has served enough
+// requests it is either promoted to be the installed config, or rolled back
+// if its error rate is too high compared to the installed config's.
+type CanaryPolicy struct {
+	// Percent is the share of requests resolved against a canary. New configs
+	// are installed for all requests at once when it is 0.
+	Percent uint32
+	// Attribute is hashed to pick the config a request is resolved against, so
+	// that requests with the same value keep using the same config. Requests
+	// without the attribute use the installed config.
+	Attribute string
+	// MinRequests is the number of requests a canary serves before it's judged.
+	MinRequests int64
+	// MaxErrorRateIncrease is how much the fraction of requests failed by a canary
+	// may exceed that of the installed config before the canary is rolled back.
+	// Only internal, unknown, unavailable and deadline exceeded errors count as
+	// failures; denials are the config working as intended.
+	MaxErrorRateIncrease float64
+}
+
+// validate returns an error if the policy doesn't make sense.
+func (p CanaryPolicy) validate() error {
+	if p.Percent > 100 {
+		return fmt.Errorf("canary percent must be <= 100, got %d", p.Percent)
+	}
+	if p.Percent > 0 && p.Attribute == "" {
+		return fmt.Errorf("an attribute to route canary traffic on must be provided")
+	}
+	if p.MinRequests < 0 {
+		return fmt.Errorf("canary min requests must be >= 0, got %d", p.MinRequests)
+	}
+	if p.MaxErrorRateIncrease < 0 || p.MaxErrorRateIncrease > 1 {
+		return fmt.Errorf("canary max error rate increase must be in [0, 1], got %f", p.MaxErrorRateIncrease)
+	}
+	return nil
+}
+
+type (
+	// canary resolves a share of the requests against a new config, and the
+	// rest against the installed one.
+	canary struct {
+		stable    *Runtime
+		candidate *Runtime
+		percent   uint32
+		attribute string
+
+		stableCounts outcomeCounts
+		canaryCounts outcomeCounts
+	}
+
+	// outcomeCounts counts requests and the ones that the mixer failed. It can be used concurrently.
+	outcomeCounts struct {
+		requests int64
+		errors   int64
+	}
+)
+
+func newCanary(stable *Runtime, candidate *Runtime, p CanaryPolicy) *canary {
+	return &canary{
+		stable:    stable,
+		candidate: candidate,
+		percent:   p.Percent,
+		attribute: p.Attribute,
+	}
+}
+
+// route returns the runtime a request resolves against, and whether it's the candidate.
+func (c *canary) route(bag attribute.Bag) (*Runtime, bool) {
+	v, found := bag.Get(c.attribute)
+	if !found {
+		return c.stable, false
+	}
+
+	h := fnv.New32a()
+	_, _ = fmt.Fprint(h, v)
+	if h.Sum32()%100 < c.percent {
+		return c.candidate, true
+	}
+	return c.stable, false
+}
+
+// Resolve resolves config against the runtime the request is routed to.
+func (c *canary) Resolve(bag attribute.Bag, aspectSet AspectSet) ([]*pb.Combined, error) {
+	rt, _ := c.route(bag)
+	return rt.Resolve(bag, aspectSet)
+}
+
+// RecordOutcome records the status of a request against the runtime it was routed to.
+func (c *canary) RecordOutcome(bag attribute.Bag, code int32) {
+	if _, isCanary := c.route(bag); isCanary {
+		c.canaryCounts.record(code)
+		recordRequest(trackCanary, code)
+		return
+	}
+	c.stableCounts.record(code)
+	recordRequest(trackStable, code)
+}
+
+// AdapterPools returns the adapter pools of the candidate, adapters are shared by both configs.
+func (c *canary) AdapterPools() map[string]*PoolConfig {
+	return c.candidate.AdapterPools()
+}
+
+func (o *outcomeCounts) record(code int32) {
+	atomic.AddInt64(&o.requests, 1)
+	if isMixerFailure(code) {
+		atomic.AddInt64(&o.errors, 1)
+	}
+}
+
+// isMixerFailure tells whether a status code reports a failure of the mixer
+// itself. Requests denied by the config, or by quotas, are the config working
+// as intended and don't count against a canary.
+func isMixerFailure(code int32) bool {
+	switch rpc.Code(code) {
+	case rpc.INTERNAL, rpc.UNKNOWN, rpc.UNAVAILABLE, rpc.DEADLINE_EXCEEDED:
+		return true
+	}
+	return false
+}
+
+// load returns the number of requests and the fraction of them that failed.
+func (o *outcomeCounts) load() (requests int64, errorRate float64) {
+	requests = atomic.LoadInt64(&o.requests)
+	if requests == 0 {
+		return 0, 0
+	}
+	return requests, float64(atomic.LoadInt64(&o.errors)) / float64(requests)
+}
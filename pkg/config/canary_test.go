@@ -0,0 +1,230 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/attribute"
+)
+
+func TestCanaryPolicy_Validate(t *testing.T) {
+	cases := []struct {
+		p   CanaryPolicy
+		err string
+	}{
+		{CanaryPolicy{}, ""},
+		{CanaryPolicy{Percent: 10, Attribute: "source.name", MinRequests: 100, MaxErrorRateIncrease: 0.05}, ""},
+		{CanaryPolicy{Percent: 101, Attribute: "source.name"}, "percent"},
+		{CanaryPolicy{Percent: 10}, "attribute"},
+		{CanaryPolicy{Percent: 10, Attribute: "source.name", MinRequests: -1}, "min requests"},
+		{CanaryPolicy{Percent: 10, Attribute: "source.name", MaxErrorRateIncrease: 2}, "error rate"},
+	}
+	for idx, c := range cases {
+		err := c.p.validate()
+		if (err == nil) != (c.err == "") || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("[%d] validate() = %v; wanted err containing '%s'", idx, err, c.err)
+		}
+	}
+}
+
+func TestCanary_Route(t *testing.T) {
+	stable := &Runtime{version: "stable"}
+	candidate := &Runtime{version: "candidate"}
+
+	bag := func(name interface{}) attribute.Bag {
+		b := attribute.GetMutableBag(nil)
+		if name != nil {
+			b.Set("source.name", name)
+		}
+		return b
+	}
+
+	for _, percent := range []uint32{0, 100} {
+		c := newCanary(stable, candidate, CanaryPolicy{Percent: percent, Attribute: "source.name"})
+		for i := 0; i < 10; i++ {
+			if rt, isCanary := c.route(bag(fmt.Sprint(i))); isCanary != (percent == 100) || (rt == candidate) != isCanary {
+				t.Errorf("%d%%: route(%d) = %s, %t", percent, i, rt.version, isCanary)
+			}
+		}
+	}
+
+	c := newCanary(stable, candidate, CanaryPolicy{Percent: 100, Attribute: "source.name"})
+	if rt, _ := c.route(bag(nil)); rt != stable {
+		t.Errorf("route() = %s; wanted requests without the attribute to use the stable config", rt.version)
+	}
+
+	c = newCanary(stable, candidate, CanaryPolicy{Percent: 30, Attribute: "source.name"})
+	canaries := 0
+	for i := 0; i < 1000; i++ {
+		_, first := c.route(bag(fmt.Sprint(i)))
+		if _, again := c.route(bag(fmt.Sprint(i))); again != first {
+			t.Fatalf("route(%d) isn't sticky", i)
+		}
+		if first {
+			canaries++
+		}
+	}
+	if canaries < 200 || canaries > 400 {
+		t.Errorf("%d of 1000 requests were routed to a 30%% canary", canaries)
+	}
+
+	// non-string attributes get routed too
+	if rt, _ := c.route(bag(int64(42))); rt == nil {
+		t.Error("route(42) = nil")
+	}
+}
+
+func TestCanary_RecordOutcome(t *testing.T) {
+	c := newCanary(&Runtime{version: "s"}, &Runtime{version: "c"}, CanaryPolicy{Percent: 100, Attribute: "source.name"})
+	internal := requestCount.WithLabelValues(trackCanary, rpc.INTERNAL.String())
+	before := counterValue(t, internal)
+
+	b := attribute.GetMutableBag(nil)
+	c.RecordOutcome(b, int32(rpc.OK))
+	b.Set("source.name", "client")
+	c.RecordOutcome(b, int32(rpc.OK))
+	c.RecordOutcome(b, int32(rpc.INTERNAL))
+	// denials by the config aren't failures of the canary
+	c.RecordOutcome(b, int32(rpc.PERMISSION_DENIED))
+	c.RecordOutcome(b, int32(rpc.RESOURCE_EXHAUSTED))
+
+	if requests, errorRate := c.stableCounts.load(); requests != 1 || errorRate != 0 {
+		t.Errorf("stable counts = %d, %f; wanted 1, 0", requests, errorRate)
+	}
+	if requests, errorRate := c.canaryCounts.load(); requests != 4 || errorRate != 0.25 {
+		t.Errorf("canary counts = %d, %f; wanted 4, 0.25", requests, errorRate)
+	}
+	if got := counterValue(t, internal) - before; got != 1 {
+		t.Errorf("Request counter for the canary grew by %f; wanted 1", got)
+	}
+}
+
+func TestManager_Canary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "canary")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	gc := dir + "/global.yml"
+	sc := dir + "/service.yml"
+	writeConfig := func(file string, content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to write config: %v", err)
+		}
+	}
+	revision := func(rev string) string {
+		return strings.Replace(sSvcConfig2, `revision: "2022"`, `revision: "`+rev+`"`, 1)
+	}
+
+	writeConfig(gc, sGlobalConfigValid)
+	writeConfig(sc, sSvcConfig2)
+	vf := newVfinder(map[string]adapter.ConfigValidator{"denyChecker": &lc{}, "listchecker": &lc{}},
+		map[string]AspectValidator{"denyChecker": &ac{}, "listchecker": &ac{}})
+	mgr := NewManager(newFakeExpr(), vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, gc, sc, time.Hour)
+	if err = mgr.SetCanaryPolicy(CanaryPolicy{Percent: 50, Attribute: "source.name", MinRequests: 100, MaxErrorRateIncrease: 0.1}); err != nil {
+		t.Fatalf("SetCanaryPolicy() = %v; wanted no err", err)
+	}
+	if err = mgr.SetCanaryPolicy(CanaryPolicy{Percent: 500}); err == nil {
+		t.Error("SetCanaryPolicy() = nil; wanted err for an invalid policy")
+	}
+	fl := &fakelistener{}
+	mgr.Register(fl)
+
+	// the first config is installed right away
+	if err = mgr.fetchAndNotify(); err != nil {
+		t.Fatalf("fetchAndNotify() = %v", err)
+	}
+	installed, ok := fl.rt.(*Runtime)
+	if !ok {
+		t.Fatalf("Listener got %T; wanted the installed runtime", fl.rt)
+	}
+	installedSHA := mgr.ConfigSHA()
+
+	// serve requests with a canary, failing the ones it gets if fail is true
+	serve := func(fail bool) *canary {
+		c, ok := fl.rt.(*canary)
+		if !ok {
+			t.Fatalf("Listener got %T; wanted a canary", fl.rt)
+		}
+		for i := 0; i < 300; i++ {
+			b := attribute.GetMutableBag(nil)
+			b.Set("source.name", fmt.Sprint(i))
+			code := int32(rpc.OK)
+			if _, isCanary := c.route(b); isCanary && fail {
+				code = int32(rpc.INTERNAL)
+			}
+			c.RecordOutcome(b, code)
+		}
+		return c
+	}
+
+	writeConfig(sc, revision("2023"))
+	if err = mgr.fetchAndNotify(); err != nil {
+		t.Fatalf("fetchAndNotify() = %v", err)
+	}
+	if st := mgr.Status(); st.ServiceRevision != "2022" || st.Canary == nil || st.Canary.ServiceRevision != "2023" {
+		t.Fatalf("Status() = %#v; wanted revision 2022 installed and 2023 as a canary", st)
+	}
+
+	// a canary that hasn't served enough requests isn't judged
+	mgr.judgeCanary()
+	if _, ok = fl.rt.(*canary); !ok {
+		t.Fatalf("Listener got %T; wanted the canary to keep running", fl.rt)
+	}
+
+	rollbacks := counterValue(t, canaryCount.WithLabelValues(canaryRolledBack))
+	serve(true)
+	mgr.judgeCanary()
+	if fl.rt != installed || mgr.ConfigSHA() != installedSHA {
+		t.Errorf("Listener got %v with sha %s; wanted the installed config back", fl.rt, mgr.ConfigSHA())
+	}
+	if st := mgr.Status(); st.Canary != nil || !strings.Contains(st.LastError, "rolled back") {
+		t.Errorf("Status() = %#v; wanted no canary and a rollback error", st)
+	}
+	if got := counterValue(t, canaryCount.WithLabelValues(canaryRolledBack)); got != rollbacks+1 {
+		t.Errorf("Rollback counter = %f; wanted %f", got, rollbacks+1)
+	}
+
+	// a rolled back config isn't tried again
+	if err = mgr.fetchAndNotify(); err != nil || fl.rt != installed {
+		t.Errorf("fetchAndNotify() = %v, listener got %v; wanted the installed config", err, fl.rt)
+	}
+
+	promotions := counterValue(t, canaryCount.WithLabelValues(canaryPromoted))
+	writeConfig(sc, revision("2024"))
+	if err = mgr.fetchAndNotify(); err != nil {
+		t.Fatalf("fetchAndNotify() = %v", err)
+	}
+	c := serve(false)
+	mgr.judgeCanary()
+	if fl.rt != c.candidate || mgr.ConfigSHA() != c.candidate.version {
+		t.Errorf("Listener got %v with sha %s; wanted the promoted canary", fl.rt, mgr.ConfigSHA())
+	}
+	if st := mgr.Status(); st.ServiceRevision != "2024" || st.Canary != nil {
+		t.Errorf("Status() = %#v; wanted revision 2024 installed", st)
+	}
+	if got := counterValue(t, canaryCount.WithLabelValues(canaryPromoted)); got != promotions+1 {
+		t.Errorf("Promotion counter = %f; wanted %f", got, promotions+1)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// Named aspect definitions let a service config declare an aspect once and
+// rules refer to it by name, for example:
+//
+//	aspectDefinitions:
+//	  request_count:
+//	    kind: metrics
+//	    adapter: prometheus
+//	    params:
+//	      metrics:
+//	      - descriptorName: request_count
+//	        value: "1"
+//	        labels:
+//	          source: source.name | "unknown"
+//	  request_count_by_method:
+//	    ref: request_count        # definitions may build on each other
+//	    params:
+//	      metrics:
+//	      - descriptorName: request_count
+//	        value: "1"
+//	        labels:
+//	          method: api.method | "unknown"
+//	rules:
+//	- selector: true
+//	  aspects:
+//	  - ref: request_count
+//	  - ref: request_count_by_method
+//	    adapter: statsd            # overrides the definition
+//
+// The fields of a reference override those of the definition as a JSON merge
+// patch (RFC 7386): mappings are merged, other values replaced and null
+// removes a field. Rules referring to the same definition with the same
+// overrides get the same aspect.
+const (
+	definitionsKey = "aspectDefinitions"
+	refKey         = "ref"
+)
+
+// definitions resolves references to the named aspect definitions of a service config.
+type definitions struct {
+	raw      map[string]interface{}
+	resolved map[string]map[string]interface{}
+	// resolving holds the definitions being resolved, to detect cycles.
+	resolving []string
+	src       *sourceMap
+}
+
+// expandAspectRefs returns the service config cfg with the aspects of its rules
+// referring to a named definition replaced by the definition, merged with the
+// fields of the reference. It returns cfg unchanged when it doesn't use definitions.
+func expandAspectRefs(cfg string, src *sourceMap) (string, *adapter.ConfigErrors) {
+	j, err := yaml.YAMLToJSON([]byte(cfg))
+	if err != nil {
+		// reported when the config gets decoded
+		return cfg, nil
+	}
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	// keep numbers as they were written
+	dec.UseNumber()
+	if err = dec.Decode(&doc); err != nil || doc == nil {
+		return cfg, nil
+	}
+
+	d := &definitions{resolved: make(map[string]map[string]interface{}), src: src}
+	var ce *adapter.ConfigErrors
+	if raw, found := doc[definitionsKey]; found {
+		var ok bool
+		if d.raw, ok = raw.(map[string]interface{}); !ok {
+			return cfg, src.append(ce, definitionsKey, "AspectDefinitions",
+				fmt.Errorf("must map names to aspects, got %s", jsonType(raw)))
+		}
+		delete(doc, definitionsKey)
+	}
+
+	expanded, ce := d.expandRules(doc["rules"], "rules")
+	if ce != nil || (!expanded && d.raw == nil) {
+		return cfg, ce
+	}
+
+	if j, err = json.Marshal(doc); err != nil {
+		return cfg, ce.Append("AspectDefinitions", err)
+	}
+	return string(j), nil
+}
+
+// expandRules expands the references of rules, which are at path in the config.
+// It returns true if any aspect was a reference.
+func (d *definitions) expandRules(rules interface{}, path string) (expanded bool, ce *adapter.ConfigErrors) {
+	rs, _ := rules.([]interface{})
+	for ridx, r := range rs {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rulePath := fmt.Sprintf("%s[%d]", path, ridx)
+		as, _ := rule["aspects"].([]interface{})
+		for idx, a := range as {
+			aa, ok := a.(map[string]interface{})
+			if !ok || aa[refKey] == nil {
+				continue
+			}
+			expanded = true
+			aspectPath := fmt.Sprintf("%s.aspects[%d]", rulePath, idx)
+			resolved, err := d.expand(aa)
+			if err != nil {
+				ce = d.src.append(ce, aspectPath+"."+refKey, fmt.Sprintf("AspectRef: %v", aa[refKey]), err)
+				continue
+			}
+			as[idx] = resolved
+		}
+		e, rce := d.expandRules(rule["rules"], rulePath+".rules")
+		expanded = expanded || e
+		if rce != nil {
+			ce = ce.Extend(rce)
+		}
+	}
+	return expanded, ce
+}
+
+// expand returns the definition an aspect refers to, merged with the fields of the aspect.
+func (d *definitions) expand(aa map[string]interface{}) (map[string]interface{}, error) {
+	name, ok := aa[refKey].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s must be the name of an aspect definition, got %s", refKey, jsonType(aa[refKey]))
+	}
+	def, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	patch := make(map[string]interface{}, len(aa))
+	for k, v := range aa {
+		if k != refKey {
+			patch[k] = v
+		}
+	}
+	return mergePatch(def, patch), nil
+}
+
+// resolve returns the definition with the given name, with its own reference expanded.
+func (d *definitions) resolve(name string) (map[string]interface{}, error) {
+	if def, found := d.resolved[name]; found {
+		return def, nil
+	}
+	for i, r := range d.resolving {
+		if r == name {
+			cycle := append(append([]string{}, d.resolving[i:]...), name)
+			return nil, fmt.Errorf("aspect definitions refer to each other: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	raw, found := d.raw[name]
+	if !found {
+		return nil, fmt.Errorf("unknown aspect definition '%s', known definitions are %v", name, d.names())
+	}
+	def, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("aspect definition '%s' must be a mapping, got %s", name, jsonType(raw))
+	}
+
+	if def[refKey] != nil {
+		d.resolving = append(d.resolving, name)
+		var err error
+		def, err = d.expand(def)
+		d.resolving = d.resolving[:len(d.resolving)-1]
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.resolved[name] = def
+	return def, nil
+}
+
+func (d *definitions) names() []string {
+	names := make([]string, 0, len(d.raw))
+	for name := range d.raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mergePatch returns a copy of target with patch applied as a JSON merge patch.
+func mergePatch(target map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(target)+len(patch))
+	for k, v := range target {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		if pm, isMap := v.(map[string]interface{}); isMap {
+			// tm is nil unless the target has a mapping to merge with
+			tm, _ := merged[k].(map[string]interface{})
+			merged[k] = mergePatch(tm, pm)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "a mapping"
+	case []interface{}:
+		return "a sequence"
+	case string:
+		return "a string"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/adapter"
+	listcheckerpb "istio.io/mixer/pkg/aspect/config"
+)
+
+const sDefinitionsConfig = `
+subject: namespace:ns
+revision: "2022"
+aspectDefinitions:
+  blacklist:
+    kind: listchecker
+    adapter: default
+    params:
+      checkAttribute: source.ip
+      blacklist: true
+  shadow_blacklist:
+    ref: blacklist
+    shadow: true
+rules:
+- selector: service.name == "a"
+  aspects:
+  - ref: blacklist
+- selector: service.name == "b"
+  aspects:
+  - ref: blacklist
+  rules:
+  - selector: service.name == "c"
+    aspects:
+    - ref: shadow_blacklist
+      params:
+        checkAttribute: target.ip
+`
+
+func TestExpandAspectRefs(t *testing.T) {
+	cases := []struct {
+		cfg  string
+		want string // JSON of the expanded rules
+		err  string
+	}{
+		{`
+rules:
+- aspects:
+  - kind: metrics
+`, "", ""},
+		{`
+aspectDefinitions:
+  a: {kind: metrics, adapter: prometheus, params: {metrics: [{name: m1}], retries: 3}}
+rules:
+- aspects:
+  - ref: a
+  - ref: a
+    adapter: statsd
+    params: {retries: null, extra: {x: 1}}
+`, `[{"aspects":[{"adapter":"prometheus","kind":"metrics","params":{"metrics":[{"name":"m1"}],"retries":3}},` +
+			`{"adapter":"statsd","kind":"metrics","params":{"extra":{"x":1},"metrics":[{"name":"m1"}]}}]}]`, ""},
+		{`
+aspectDefinitions:
+  a: {ref: b}
+  b: {ref: c}
+  c: {ref: a}
+rules:
+- aspects:
+  - kind: metrics
+  - ref: a
+`, "", "8:5: AspectRef: a: aspect definitions refer to each other: a -> b -> c -> a"},
+		{`
+aspectDefinitions:
+  a: {kind: metrics}
+rules:
+- rules:
+  - aspects:
+    - ref: b
+`, "", "6:7: AspectRef: b: unknown aspect definition 'b', known definitions are [a]"},
+		{`
+rules:
+- aspects:
+  - ref: [a]
+`, "", "3:5: AspectRef: [a]: ref must be the name of an aspect definition, got a sequence"},
+		{`
+aspectDefinitions: [a]
+`, "", "1:1: AspectDefinitions: must map names to aspects, got a sequence"},
+		{`
+aspectDefinitions:
+  a: 42
+rules:
+- aspects:
+  - ref: a
+`, "", "aspect definition 'a' must be a mapping, got 42"},
+	}
+
+	for idx, c := range cases {
+		cfg := strings.TrimPrefix(c.cfg, "\n")
+		got, ce := expandAspectRefs(cfg, newSourceMap("", cfg))
+		if c.err != "" {
+			if ce == nil || !strings.Contains(ce.Error(), c.err) {
+				t.Errorf("[%d] expandAspectRefs() = %v; wanted err containing '%s'", idx, ce, c.err)
+			}
+			continue
+		}
+		if ce != nil {
+			t.Errorf("[%d] expandAspectRefs() = %v; wanted no err", idx, ce)
+			continue
+		}
+		if c.want == "" {
+			if got != cfg {
+				t.Errorf("[%d] expandAspectRefs() = %s; wanted the config unchanged", idx, got)
+			}
+			continue
+		}
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(got), &doc); err != nil {
+			t.Fatalf("[%d] expandAspectRefs() returned invalid JSON %s: %v", idx, got, err)
+		}
+		if _, found := doc[definitionsKey]; found {
+			t.Errorf("[%d] expandAspectRefs() = %s; wanted the definitions removed", idx, got)
+		}
+		if string(doc["rules"]) != c.want {
+			t.Errorf("[%d] expandAspectRefs() rules = %s; wanted %s", idx, doc["rules"], c.want)
+		}
+	}
+}
+
+func TestValidator_AspectDefinitions(t *testing.T) {
+	vf := newVfinder(map[string]adapter.ConfigValidator{"denyChecker": &lc{}, "listchecker": &lc{}},
+		map[string]AspectValidator{"listchecker": &ac{}})
+	p := NewValidator(vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, true, newFakeExpr())
+	v, ce := p.Validate(sDefinitionsConfig, sGlobalConfigValid)
+	if ce != nil {
+		t.Fatalf("Validate() = %v; wanted no err", ce)
+	}
+
+	rules := v.serviceConfig.GetRules()
+	a, b, c := rules[0].GetAspects()[0], rules[1].GetAspects()[0], rules[1].GetRules()[0].GetAspects()[0]
+	if !reflect.DeepEqual(a, b) || a == b {
+		t.Errorf("Rules referring to the same definition got %v and %v; wanted distinct but equal aspects", a, b)
+	}
+	want := &listcheckerpb.ListsParams{CheckAttribute: "target.ip", Blacklist: true}
+	if c.Kind != "listchecker" || !reflect.DeepEqual(c.Params, want) {
+		t.Errorf("Aspect = %v; wanted the definitions merged with the overrides", c)
+	}
+	if v.shadowed[a] || !v.shadowed[c] {
+		t.Errorf("Shadowed aspects = %v; wanted only the aspect referring to shadow_blacklist", v.shadowed)
+	}
+}
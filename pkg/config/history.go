@@ -0,0 +1,269 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// defaultHistorySize is the number of installed configs a Manager retains by default.
+const defaultHistorySize = 10
+
+// Values of the action field of audit records.
+const (
+	auditInstalled        = "installed"
+	auditCanaryStarted    = "canary_started"
+	auditCanaryPromoted   = "canary_promoted"
+	auditCanaryRolledBack = "canary_rolled_back"
+	auditRolledBack       = "rolled_back"
+	auditUnpinned         = "unpinned"
+	auditSecretsRotated   = "secrets_rotated"
+)
+
+type (
+	// HistoryEntry describes a config installed by a Manager.
+	HistoryEntry struct {
+		// ID identifies the installation, IDs increase with each installation.
+		ID              int       `json:"id"`
+		InstalledAt     time.Time `json:"installedAt"`
+		GlobalSHA       string    `json:"globalSHA"`
+		ServiceSHA      string    `json:"serviceSHA"`
+		GlobalRevision  string    `json:"globalRevision"`
+		ServiceRevision string    `json:"serviceRevision"`
+		// Active is true for the config currently installed.
+		Active bool `json:"active"`
+	}
+
+	// installation is a config retained by a Manager, so that it can be installed again.
+	installation struct {
+		HistoryEntry
+		rt     *Runtime
+		status Status
+	}
+
+	// auditRecord is written to the audit log for every change of the installed config.
+	auditRecord struct {
+		Time            time.Time `json:"time"`
+		Action          string    `json:"action"`
+		ID              int       `json:"id,omitempty"`
+		GlobalSHA       string    `json:"globalSHA"`
+		ServiceSHA      string    `json:"serviceSHA"`
+		GlobalRevision  string    `json:"globalRevision"`
+		ServiceRevision string    `json:"serviceRevision"`
+		Pinned          bool      `json:"pinned,omitempty"`
+		Reason          string    `json:"reason,omitempty"`
+	}
+)
+
+// SetHistorySize sets the number of installed configs the manager retains
+// for rollbacks. It must be called before Start.
+func (c *Manager) SetHistorySize(n int) error {
+	if n < 1 {
+		return fmt.Errorf("config history size must be >= 1, got %d", n)
+	}
+	c.historySize = n
+	return nil
+}
+
+// SetAuditLog makes the manager write a JSON record to w for every change
+// of the installed config. It must be called before Start.
+func (c *Manager) SetAuditLog(w io.Writer) {
+	c.auditLog = w
+}
+
+// History returns the retained configs, most recently installed first.
+func (c *Manager) History() []HistoryEntry {
+	c.RLock()
+	defer c.RUnlock()
+
+	h := make([]HistoryEntry, len(c.history))
+	for i, inst := range c.history {
+		e := inst.HistoryEntry
+		e.Active = e.ID == c.active
+		h[len(h)-1-i] = e
+	}
+	return h
+}
+
+// Rollback installs the retained config with the given id for all requests,
+// abandoning any canary. The config stays installed until the config files
+// change or, if pin is true, until Unpin is called. Start must have been called.
+func (c *Manager) Rollback(id int, pin bool) error {
+	return c.do(func() error { return c.rollback(id, pin) })
+}
+
+// Unpin makes the manager install the content of the config files again after a pinned rollback.
+func (c *Manager) Unpin() error {
+	return c.do(c.unpin)
+}
+
+// do runs f on the goroutine fetching config, which owns the installed runtimes.
+func (c *Manager) do(f func() error) error {
+	errc := make(chan error, 1)
+	select {
+	case c.requests <- func() { errc <- f() }:
+		return <-errc
+	case <-c.closing:
+		return errors.New("config manager is closed")
+	}
+}
+
+// record retains an installed runtime, forgetting the oldest one when the history is full.
+func (c *Manager) record(rt *Runtime, st Status) *installation {
+	c.lastID++
+	inst := &installation{
+		HistoryEntry: HistoryEntry{
+			ID:              c.lastID,
+			InstalledAt:     time.Now(),
+			GlobalSHA:       st.GlobalSHA,
+			ServiceSHA:      st.ServiceSHA,
+			GlobalRevision:  st.GlobalRevision,
+			ServiceRevision: st.ServiceRevision,
+		},
+		rt:     rt,
+		status: st,
+	}
+
+	c.Lock()
+	c.history = append(c.history, inst)
+	if len(c.history) > c.historySize {
+		c.history = c.history[len(c.history)-c.historySize:]
+	}
+	c.Unlock()
+	return inst
+}
+
+func (c *Manager) rollback(id int, pin bool) error {
+	c.RLock()
+	var inst *installation
+	for _, i := range c.history {
+		if i.ID == id {
+			inst = i
+		}
+	}
+	c.RUnlock()
+
+	if inst == nil {
+		return fmt.Errorf("config %d is not in the history", id)
+	}
+
+	reason := ""
+	if c.canary != nil {
+		reason = fmt.Sprintf("abandoned canary of config sha=%s", c.canary.candidate.version)
+	}
+	c.Lock()
+	c.pinned = pin
+	c.Unlock()
+	if pin {
+		c.secretFiles = inst.rt.secretFiles
+	}
+	c.activate(inst)
+	c.audit(auditRecord{Action: auditRolledBack, ID: inst.ID, Pinned: pin, Reason: reason}, inst.status)
+	return nil
+}
+
+func (c *Manager) unpin() error {
+	if !c.pinned {
+		return errors.New("the installed config is not pinned")
+	}
+	c.Lock()
+	c.pinned = false
+	c.Unlock()
+	// forget the files were read so that their content gets installed again,
+	// content rejected for regressing may be newer than the rolled back config
+	c.gcSHA = [sha1.Size]byte{}
+	c.scSHA = [sha1.Size]byte{}
+	c.rejectedGcSHA = [sha1.Size]byte{}
+	c.rejectedScSHA = [sha1.Size]byte{}
+
+	c.RLock()
+	st, id := c.installed, c.active
+	c.RUnlock()
+	c.audit(auditRecord{Action: auditUnpinned, ID: id}, st)
+	return nil
+}
+
+// audit logs a change of the installed config.
+func (c *Manager) audit(r auditRecord, st Status) {
+	r.Time = time.Now()
+	r.GlobalSHA = st.GlobalSHA
+	r.ServiceSHA = st.ServiceSHA
+	r.GlobalRevision = st.GlobalRevision
+	r.ServiceRevision = st.ServiceRevision
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		glog.Warningf("Unable to encode config audit record: %v", err)
+		return
+	}
+	glog.Infof("Config audit: %s", b)
+
+	if c.auditLog == nil {
+		return
+	}
+	if _, err = c.auditLog.Write(append(b, '\n')); err != nil {
+		glog.Warningf("Unable to write config audit record: %v", err)
+	}
+}
+
+// rotateSecrets installs the pinned config again, resolving the secrets of its
+// adapter params, once the files they're read from changed.
+func (c *Manager) rotateSecrets() error {
+	c.RLock()
+	st := c.installed
+	c.RUnlock()
+
+	v := NewValidator(c.aspectFinder, c.builderFinder, c.findAspects, true, c.eval)
+	vd, ce := v.Validate(st.ServiceConfig, st.GlobalConfig)
+	var err error
+	var rt *Runtime
+	if ce != nil {
+		err = ce
+	} else {
+		rt = NewRuntime(vd, c.eval)
+		rt.version = c.stable.version
+		err = c.warmUp(rt)
+	}
+	if err != nil {
+		// errors of adapter params may quote their secrets
+		err = redactError(err)
+		// try again once the files change again rather than on every attempt
+		c.secretFiles = secretFileDigests(c.secretFiles, ioutil.ReadFile)
+		reloadCount.WithLabelValues(reloadFailure).Inc()
+		c.Lock()
+		c.lastError = err
+		c.Unlock()
+		return err
+	}
+
+	glog.Infof("Installing pinned config sha=%s again, the secrets of adapters changed", rt.version)
+	reloadCount.WithLabelValues(reloadSuccess).Inc()
+	c.secretFiles = vd.secretFiles
+	c.Lock()
+	c.lastError = nil
+	c.Unlock()
+	inst := c.record(rt, st)
+	c.activate(inst)
+	c.audit(auditRecord{Action: auditSecretsRotated, ID: inst.ID, Pinned: true}, st)
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+func TestManager_History(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	gc := dir + "/global.yml"
+	sc := dir + "/service.yml"
+	writeRevision := func(rev string) {
+		content := strings.Replace(sSvcConfig2, `revision: "2022"`, `revision: "`+rev+`"`, 1)
+		if err := ioutil.WriteFile(sc, []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to write config: %v", err)
+		}
+	}
+	if err = ioutil.WriteFile(gc, []byte(sGlobalConfigValid), 0644); err != nil {
+		t.Fatalf("Unable to write config: %v", err)
+	}
+	writeRevision("1")
+
+	vf := newVfinder(map[string]adapter.ConfigValidator{"denyChecker": &lc{}, "listchecker": &lc{}},
+		map[string]AspectValidator{"denyChecker": &ac{}, "listchecker": &ac{}})
+	mgr := NewManager(newFakeExpr(), vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, gc, sc, time.Hour)
+	if err = mgr.SetHistorySize(0); err == nil {
+		t.Error("SetHistorySize(0) = nil; wanted err")
+	}
+	if err = mgr.SetHistorySize(2); err != nil {
+		t.Fatalf("SetHistorySize(2) = %v", err)
+	}
+	audit := &bytes.Buffer{}
+	mgr.SetAuditLog(audit)
+	fl := &fakelistener{}
+	mgr.Register(fl)
+
+	mgr.Start()
+	closed := false
+	defer func() {
+		if !closed {
+			mgr.Close()
+		}
+	}()
+
+	// fetch runs fetchAndNotify on the manager's goroutine
+	fetch := func() {
+		if err := mgr.do(mgr.fetchAndNotify); err != nil {
+			t.Fatalf("fetchAndNotify() = %v", err)
+		}
+	}
+	revisions := func() string {
+		var revs []string
+		for _, e := range mgr.History() {
+			rev := e.ServiceRevision
+			if e.Active {
+				rev += "*"
+			}
+			revs = append(revs, rev)
+		}
+		return strings.Join(revs, ",")
+	}
+
+	writeRevision("2")
+	fetch()
+	writeRevision("3")
+	fetch()
+	if got := revisions(); got != "3*,2" {
+		t.Fatalf("History() = %s; wanted 3*,2", got)
+	}
+	h := mgr.History()
+	if h[0].ID <= h[1].ID || h[0].InstalledAt.Before(h[1].InstalledAt) || h[0].ServiceSHA == h[1].ServiceSHA {
+		t.Errorf("History() = %v; wanted increasing ids and distinct SHAs", h)
+	}
+	three := fl.rt
+
+	if err = mgr.Rollback(h[1].ID-1, false); err == nil || !strings.Contains(err.Error(), "not in the history") {
+		t.Errorf("Rollback() = %v; wanted err about a forgotten config", err)
+	}
+
+	// a rollback lasts until the files change
+	if err = mgr.Rollback(h[1].ID, false); err != nil {
+		t.Fatalf("Rollback() = %v", err)
+	}
+	if got := revisions(); got != "3,2*" || mgr.Status().ServiceRevision != "2" || fl.rt == three {
+		t.Errorf("History() = %s, status revision %s; wanted revision 2 installed", got, mgr.Status().ServiceRevision)
+	}
+	fetch()
+	if got := revisions(); got != "3,2*" {
+		t.Errorf("History() = %s; wanted the rollback to stick while the files are unchanged", got)
+	}
+	writeRevision("4")
+	fetch()
+	if got := revisions(); got != "4*,3" {
+		t.Errorf("History() = %s; wanted the new config installed", got)
+	}
+
+	// a pinned rollback lasts until it's unpinned
+	if err = mgr.Unpin(); err == nil {
+		t.Error("Unpin() = nil; wanted err for a config that isn't pinned")
+	}
+	if err = mgr.Rollback(h[0].ID, true); err != nil {
+		t.Fatalf("Rollback() = %v", err)
+	}
+	writeRevision("5")
+	fetch()
+	if got := revisions(); got != "4,3*" || !mgr.Status().Pinned || fl.rt != three {
+		t.Errorf("History() = %s, pinned = %t; wanted revision 3 pinned", got, mgr.Status().Pinned)
+	}
+	if err = mgr.Unpin(); err != nil {
+		t.Fatalf("Unpin() = %v", err)
+	}
+	fetch()
+	if got := revisions(); got != "5*,4" || mgr.Status().Pinned {
+		t.Errorf("History() = %s, pinned = %t; wanted revision 5 installed", got, mgr.Status().Pinned)
+	}
+
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		var r auditRecord
+		if err = json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Unable to decode audit record '%s': %v", line, err)
+		}
+		if r.ServiceSHA == "" || r.Time.IsZero() {
+			t.Errorf("Audit record %s lacks the config SHA or time", line)
+		}
+		if r.GlobalRevision != "2022" {
+			t.Errorf("Audit record %s has global revision %s; wanted 2022", line, r.GlobalRevision)
+		}
+		actions = append(actions, r.Action+":"+r.ServiceRevision)
+	}
+	want := "installed:1,installed:2,installed:3,rolled_back:2,installed:4,rolled_back:3,unpinned:3,installed:5"
+	if got := strings.Join(actions, ","); got != want {
+		t.Errorf("Audit log has %s; wanted %s", got, want)
+	}
+
+	mgr.Close()
+	closed = true
+	if err = mgr.Rollback(h[0].ID, false); err == nil {
+		t.Error("Rollback() = nil; wanted err once the manager is closed")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"sort"
+
+	"istio.io/mixer/pkg/attribute"
+	pb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/expr"
+)
+
+// ruleIndex narrows down the sibling rules a request has to evaluate.
+//
+// Most selectors start with an equality between an attribute and a string,
+// such as target.service == "books" && ... A rule whose selector starts
+// with one can only be selected by requests carrying the attribute with that
+// value, or requests without the attribute at all, for which the selector
+// is evaluated as usual to report the same errors. The index maps attribute
+// values to such rules; the other rules are always evaluated.
+type ruleIndex struct {
+	// guards holds the equality guard of each rule, nil for unguarded rules.
+	guards []*expr.EqualityGuard
+	// byValue holds the positions of the guarded rules by attribute and value.
+	byValue map[string]map[string][]int
+	// byAttribute holds the positions of the guarded rules by attribute.
+	byAttribute map[string][]int
+	// unguarded holds the positions of the rules evaluated for every request.
+	unguarded []int
+}
+
+// newRuleIndex returns the index of rules. Rules aren't guarded when the
+// evaluator can't analyze predicates.
+func newRuleIndex(rules []*pb.AspectRule, eval expr.PredicateEvaluator) *ruleIndex {
+	ix := &ruleIndex{
+		guards:      make([]*expr.EqualityGuard, len(rules)),
+		byValue:     make(map[string]map[string][]int),
+		byAttribute: make(map[string][]int),
+	}
+	analyzer, canAnalyze := eval.(expr.PredicateAnalyzer)
+	for i, rule := range rules {
+		if !canAnalyze {
+			ix.unguarded = append(ix.unguarded, i)
+			continue
+		}
+		g, ok := analyzer.EqualityGuard(rule.GetSelector())
+		if !ok {
+			ix.unguarded = append(ix.unguarded, i)
+			continue
+		}
+		ix.guards[i] = &g
+		values := ix.byValue[g.Attribute]
+		if values == nil {
+			values = make(map[string][]int)
+			ix.byValue[g.Attribute] = values
+		}
+		values[g.Value] = append(values[g.Value], i)
+		ix.byAttribute[g.Attribute] = append(ix.byAttribute[g.Attribute], i)
+	}
+	return ix
+}
+
+// indexRules returns the indexes of rules and of their nested rules, by enclosing rule.
+func indexRules(rules []*pb.AspectRule, eval expr.PredicateEvaluator, indexes map[*pb.AspectRule]*ruleIndex) *ruleIndex {
+	for _, rule := range rules {
+		if rs := rule.GetRules(); len(rs) > 0 {
+			indexes[rule] = indexRules(rs, eval, indexes)
+		}
+	}
+	return newRuleIndex(rules, eval)
+}
+
+// candidates returns the positions, in order, of the rules to evaluate for bag.
+func (ix *ruleIndex) candidates(bag attribute.Bag, n int) []int {
+	if ix == nil {
+		all := make([]int, n)
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+	if len(ix.byAttribute) == 0 {
+		return ix.unguarded
+	}
+	c := append([]int(nil), ix.unguarded...)
+	for attr, positions := range ix.byAttribute {
+		v, found := bag.Get(attr)
+		if s, isString := v.(string); found && isString {
+			c = append(c, ix.byValue[attr][s]...)
+		} else {
+			// the selectors of these rules decide how a missing attribute or another type is handled
+			c = append(c, positions...)
+		}
+	}
+	sort.Ints(c)
+	return c
+}
+
+// guard returns the equality guard of the rule at position i, if any.
+func (ix *ruleIndex) guard(i int) *expr.EqualityGuard {
+	if ix == nil {
+		return nil
+	}
+	return ix.guards[i]
+}
+
+// evalSelector evaluates the selector of a rule, only evaluating what follows its guard
+// when the guard holds.
+func (r *Runtime) evalSelector(g *expr.EqualityGuard, selector string, bag attribute.Bag) (bool, error) {
+	if g != nil {
+		if v, found := bag.Get(g.Attribute); found && v == g.Value {
+			return r.evalPredicate(g.Residual, bag)
+		}
+	}
+	return r.evalPredicate(selector, bag)
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/attribute"
+	pb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/expr"
+)
+
+// linearEval hides the predicate analysis of an evaluator, which leaves rules unindexed.
+type linearEval struct {
+	expr.PredicateEvaluator
+}
+
+var (
+	indexAttrs  = []string{"svc", "src", "target.service"}
+	indexValues = []string{"a", "b", "c"}
+)
+
+func randomSelector(rnd *rand.Rand) string {
+	atom := func() string {
+		attr := indexAttrs[rnd.Intn(len(indexAttrs))]
+		value := indexValues[rnd.Intn(len(indexValues))]
+		switch rnd.Intn(8) {
+		case 0:
+			return "true"
+		case 1:
+			return fmt.Sprintf(`%s == "%s*"`, attr, value)
+		case 2:
+			return fmt.Sprintf(`%s != "%s"`, attr, value)
+		case 3:
+			return fmt.Sprintf(`(%s == "%s" || %s == "%s")`, attr, value, attr, indexValues[rnd.Intn(len(indexValues))])
+		default:
+			return fmt.Sprintf(`%s == "%s"`, attr, value)
+		}
+	}
+	switch rnd.Intn(10) {
+	case 0:
+		return ""
+	case 1:
+		return atom() + " || " + atom()
+	}
+	atoms := []string{atom()}
+	for n := rnd.Intn(3); n > 0; n-- {
+		atoms = append(atoms, atom())
+	}
+	return strings.Join(atoms, " && ")
+}
+
+func randomRules(rnd *rand.Rand, depth int) []*pb.AspectRule {
+	rules := make([]*pb.AspectRule, 1+rnd.Intn(6))
+	for i := range rules {
+		rules[i] = &pb.AspectRule{
+			Selector: randomSelector(rnd),
+			Aspects:  []*pb.Aspect{{Kind: "listChecker"}},
+		}
+		if depth > 0 && rnd.Intn(3) == 0 {
+			rules[i].Rules = randomRules(rnd, depth-1)
+		}
+	}
+	return rules
+}
+
+func randomBag(rnd *rand.Rand) attribute.Bag {
+	bag := attribute.GetMutableBag(nil)
+	for _, attr := range indexAttrs {
+		switch rnd.Intn(6) {
+		case 0:
+			// missing
+		case 1:
+			bag.Set(attr, int64(1))
+		case 2:
+			bag.Set(attr, "ab")
+		default:
+			bag.Set(attr, indexValues[rnd.Intn(len(indexValues))])
+		}
+	}
+	return bag
+}
+
+func TestRuntime_IndexedResolve(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	eval := expr.NewCEXLEvaluator()
+	aspects := AspectSet{"listChecker": true}
+
+	for n := 0; n < 100; n++ {
+		v := &Validated{
+			adapterByName: map[adapterKey]*pb.Adapter{},
+			serviceConfig: &pb.ServiceConfig{Rules: randomRules(rnd, 2)},
+		}
+		indexed := NewRuntime(v, eval)
+		linear := NewRuntime(v, linearEval{eval})
+
+		for b := 0; b < 20; b++ {
+			bag := randomBag(rnd)
+			got, gotErr := indexed.Resolve(bag, aspects)
+			want, wantErr := linear.Resolve(bag, aspects)
+			if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+				t.Fatalf("[%d] Resolve() err = %v; wanted %v", n, gotErr, wantErr)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("[%d] Resolve() returned %d aspects; wanted %d", n, len(got), len(want))
+			}
+			for i := range want {
+				if got[i].Aspect != want[i].Aspect || !reflect.DeepEqual(got[i].Selectors, want[i].Selectors) {
+					t.Fatalf("[%d] Resolve()[%d] = %v; wanted %v", n, i, got[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestRuleIndex_Candidates(t *testing.T) {
+	rules := []*pb.AspectRule{
+		{Selector: `svc == "a"`},
+		{Selector: `svc == "b" && src == "a"`},
+		{Selector: `src == "a"`},
+		{Selector: `svc == "a*"`},
+		{Selector: ""},
+		{Selector: `svc == "a" && true`},
+	}
+	ix := newRuleIndex(rules, expr.NewCEXLEvaluator())
+
+	cases := []struct {
+		attrs map[string]interface{}
+		want  []int
+	}{
+		{map[string]interface{}{"svc": "a", "src": "a"}, []int{0, 2, 3, 4, 5}},
+		{map[string]interface{}{"svc": "b", "src": "c"}, []int{1, 3, 4}},
+		{map[string]interface{}{"svc": "c", "src": "c"}, []int{3, 4}},
+		{map[string]interface{}{"src": "c"}, []int{0, 1, 3, 4, 5}},
+		{map[string]interface{}{"svc": int64(1), "src": "a"}, []int{0, 1, 2, 3, 4, 5}},
+	}
+	for idx, c := range cases {
+		bag := attribute.GetMutableBag(nil)
+		for k, v := range c.attrs {
+			bag.Set(k, v)
+		}
+		if got := ix.candidates(bag, len(rules)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("[%d] candidates() = %v; wanted %v", idx, got, c.want)
+		}
+	}
+
+	if got := newRuleIndex(rules, linearEval{expr.NewCEXLEvaluator()}).candidates(attribute.GetMutableBag(nil), len(rules)); len(got) != len(rules) {
+		t.Errorf("candidates() = %v; wanted every rule when predicates can't be analyzed", got)
+	}
+}
+
+func BenchmarkRuntime_Resolve(b *testing.B) {
+	rules := make([]*pb.AspectRule, 1000)
+	for i := range rules {
+		rules[i] = &pb.AspectRule{
+			Selector: fmt.Sprintf(`target.service == "svc%d" && source.name != "blocked"`, i),
+			Aspects:  []*pb.Aspect{{Kind: "listChecker"}},
+		}
+	}
+	v := &Validated{
+		adapterByName: map[adapterKey]*pb.Adapter{},
+		serviceConfig: &pb.ServiceConfig{Rules: rules},
+	}
+	bag := attribute.GetMutableBag(nil)
+	bag.Set("target.service", "svc500")
+	bag.Set("source.name", "client")
+	aspects := AspectSet{"listChecker": true}
+
+	for _, bc := range []struct {
+		name string
+		eval expr.PredicateEvaluator
+	}{
+		{"indexed", expr.NewCEXLEvaluator()},
+		{"linear", linearEval{expr.NewCEXLEvaluator()}},
+	} {
+		rt := NewRuntime(v, bc.eval)
+		b.Run(bc.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := rt.Resolve(bag, aspects); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -16,6 +16,8 @@ package config
 
 import (
 	"crypto/sha1"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"sync"
 	"time"
@@ -40,6 +42,16 @@ type ChangeListener interface {
 	ConfigChange(cfg Resolver)
 }
 
+// Warmer is implemented by change listeners that prepare for a config before
+// it is installed, such as by building the aspects it refers to.
+type Warmer interface {
+	// WarmUp prepares for cfg. An error means cfg can't serve requests and must not be installed.
+	WarmUp(cfg Resolver) error
+}
+
+// maxWarmUpBackoff is the longest delay before retrying to warm up a config.
+const maxWarmUpBackoff = 5 * time.Minute
+
 // Manager represents the config Manager.
 // It is responsible for fetching and receiving configuration changes.
 // It applies validated changes to the registered config change listeners.
@@ -54,13 +66,57 @@ type Manager struct {
 	globalConfig     string
 	serviceConfig    string
 
-	cl      []ChangeListener
-	closing chan bool
-	scSHA   [sha1.Size]byte
-	gcSHA   [sha1.Size]byte
+	cl       []ChangeListener
+	closing  chan bool
+	requests chan func()
+	scSHA    [sha1.Size]byte
+	gcSHA    [sha1.Size]byte
+	sc       string
+	gc       string
+	// digests of the files adapter params of the installed config refer to
+	secretFiles map[string][sha1.Size]byte
+	// digests of the config files last rejected for regressing the revision
+	rejectedScSHA [sha1.Size]byte
+	rejectedGcSHA [sha1.Size]byte
+	// when to warm up the content of the config files again after failing to,
+	// the delay doubling with each failure
+	warmUpRetry   time.Time
+	warmUpBackoff time.Duration
+
+	// runtimes are only used by the goroutine fetching config
+	canaryPolicy CanaryPolicy
+	stable       *Runtime
+	canary       *canary
+	historySize  int
+	lastID       int
+	auditLog     io.Writer
+
+	allowRegression bool
 
 	sync.RWMutex
-	lastError error
+	lastError    error
+	configSHA    string
+	installed    Status
+	canaryStatus *Status
+	history      []*installation
+	active       int
+	// only written by the goroutine fetching config
+	pinned bool
+}
+
+// Status describes the config installed by a Manager.
+type Status struct {
+	GlobalConfig    string `json:"globalConfig"`
+	ServiceConfig   string `json:"serviceConfig"`
+	GlobalSHA       string `json:"globalSHA"`
+	ServiceSHA      string `json:"serviceSHA"`
+	GlobalRevision  string `json:"globalRevision"`
+	ServiceRevision string `json:"serviceRevision"`
+	LastError       string `json:"lastError,omitempty"`
+	// Canary is the config being rolled out, if any.
+	Canary *Status `json:"canary,omitempty"`
+	// Pinned is true when the config was rolled back to and the config files are ignored.
+	Pinned bool `json:"pinned,omitempty"`
 }
 
 // NewManager returns a config.Manager.
@@ -85,10 +141,22 @@ func NewManager(eval expr.Evaluator, aspectFinder AspectValidatorFinder, builder
 		globalConfig:  globalConfig,
 		serviceConfig: serviceConfig,
 		closing:       make(chan bool),
+		requests:      make(chan func()),
+		historySize:   defaultHistorySize,
 	}
 	return m
 }
 
+// SetCanaryPolicy makes the manager roll out new configs as canaries according to p,
+// instead of installing them for all requests at once. It must be called before Start.
+func (c *Manager) SetCanaryPolicy(p CanaryPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	c.canaryPolicy = p
+	return nil
+}
+
 // Register makes the ConfigManager aware of a ConfigChangeListener.
 func (c *Manager) Register(cc ChangeListener) {
 	c.cl = append(c.cl, cc)
@@ -119,25 +187,68 @@ func (c *Manager) fetch() (*Runtime, error) {
 	}
 
 	if gcSHA == c.gcSHA && scSHA == c.scSHA {
+		switch {
+		case !c.warmUpRetry.IsZero() && !time.Now().Before(c.warmUpRetry):
+			glog.Infof("Reloading config, retrying to warm it up")
+		case secretFilesChanged(c.secretFiles, ioutil.ReadFile):
+			glog.Infof("Reloading config, the secrets of adapters changed")
+		default:
+			return nil, nil
+		}
+	} else {
+		c.warmUpBackoff = 0
+	}
+	c.warmUpRetry = time.Time{}
+	if !c.allowRegression && gcSHA == c.rejectedGcSHA && scSHA == c.rejectedScSHA {
+		// the same stale content was already rejected
 		return nil, nil
 	}
 
 	v := NewValidator(c.aspectFinder, c.builderFinder, c.findAspects, true, c.eval)
+	v.SetFileNames(c.globalConfig, c.serviceConfig)
 	if vd, cerr = v.Validate(sc, gc); cerr != nil {
 		return nil, cerr
 	}
 
+	if !c.allowRegression {
+		installed := c.Revisions()
+		err := checkRevision("global", vd.globalConfig.GetRevision(), installed.Global)
+		if err == nil {
+			err = checkRevision("service", vd.serviceConfig.GetRevision(), installed.Service)
+		}
+		if err != nil {
+			c.rejectedGcSHA = gcSHA
+			c.rejectedScSHA = scSHA
+			return nil, err
+		}
+	}
+
 	c.descriptorFinder = descriptors.NewFinder(v.validated.globalConfig)
 
 	c.gcSHA = gcSHA
 	c.scSHA = scSHA
-	return NewRuntime(vd, c.eval), nil
+	c.gc = gc
+	c.sc = sc
+	c.secretFiles = vd.secretFiles
+	rt := NewRuntime(vd, c.eval)
+	rt.version = fmt.Sprintf("%x:%x", gcSHA, scSHA)
+	return rt, nil
 }
 
 // fetchAndNotify fetches a new config and notifies listeners if something has changed
 func (c *Manager) fetchAndNotify() error {
+	if c.pinned {
+		if !secretFilesChanged(c.secretFiles, ioutil.ReadFile) {
+			return nil
+		}
+		return c.rotateSecrets()
+	}
+
 	rt, err := c.fetch()
 	if err != nil {
+		// errors of adapter params may quote their secrets
+		err = redactError(err)
+		reloadCount.WithLabelValues(reloadFailure).Inc()
 		c.Lock()
 		c.lastError = err
 		c.Unlock()
@@ -146,14 +257,124 @@ func (c *Manager) fetchAndNotify() error {
 	if rt == nil {
 		return nil
 	}
+	if err = c.warmUp(rt); err != nil {
+		// warming up may succeed later, the adapters' backends may be unavailable
+		c.warmUpBackoff *= 2
+		if c.warmUpBackoff < c.loopDelay {
+			c.warmUpBackoff = c.loopDelay
+		}
+		if c.warmUpBackoff > maxWarmUpBackoff {
+			c.warmUpBackoff = maxWarmUpBackoff
+		}
+		c.warmUpRetry = time.Now().Add(c.warmUpBackoff)
+		reloadCount.WithLabelValues(reloadFailure).Inc()
+		c.Lock()
+		c.lastError = err
+		c.Unlock()
+		return err
+	}
+
+	c.warmUpBackoff = 0
+	reloadCount.WithLabelValues(reloadSuccess).Inc()
+	st := Status{
+		GlobalConfig:    c.gc,
+		ServiceConfig:   c.sc,
+		GlobalSHA:       fmt.Sprintf("%x", c.gcSHA),
+		ServiceSHA:      fmt.Sprintf("%x", c.scSHA),
+		GlobalRevision:  rt.globalConfig.GetRevision(),
+		ServiceRevision: rt.serviceConfig.GetRevision(),
+	}
+
+	// the first config is always installed right away, there is nothing to compare a canary with
+	if c.canaryPolicy.Percent == 0 || c.stable == nil {
+		inst := c.record(rt, st)
+		c.activate(inst)
+		c.audit(auditRecord{Action: auditInstalled, ID: inst.ID}, st)
+		return nil
+	}
 
-	glog.Infof("Installing new config from %s sha=%x ", c.serviceConfig, c.scSHA)
+	glog.Infof("Starting canary of config from %s sha=%x for %d%% of requests", c.serviceConfig, c.scSHA, c.canaryPolicy.Percent)
+	c.canary = newCanary(c.stable, rt, c.canaryPolicy)
+	c.Lock()
+	c.canaryStatus = &st
+	c.Unlock()
+	c.audit(auditRecord{Action: auditCanaryStarted, Reason: fmt.Sprintf("%d%% of requests", c.canaryPolicy.Percent)}, st)
+	c.notify(c.canary)
+	return nil
+}
+
+// activate makes the runtime of inst the config used by all requests.
+func (c *Manager) activate(inst *installation) {
+	glog.Infof("Installing config sha=%s", inst.rt.version)
+	c.stable = inst.rt
+	c.canary = nil
+	c.Lock()
+	c.configSHA = inst.rt.version
+	c.installed = inst.status
+	c.canaryStatus = nil
+	c.active = inst.ID
+	c.Unlock()
+	lastReload.SetToCurrentTime()
+	c.notify(inst.rt)
+}
+
+// judgeCanary promotes or rolls back the current canary once it has served enough requests.
+func (c *Manager) judgeCanary() {
+	if c.canary == nil {
+		return
+	}
+
+	requests, errorRate := c.canary.canaryCounts.load()
+	if requests < c.canaryPolicy.MinRequests || requests == 0 {
+		return
+	}
+	_, stableErrorRate := c.canary.stableCounts.load()
+
+	if errorRate-stableErrorRate > c.canaryPolicy.MaxErrorRateIncrease {
+		err := fmt.Errorf("rolled back config sha=%s: %.2f%% of its %d requests failed, compared to %.2f%% for the installed config",
+			c.canary.candidate.version, errorRate*100, requests, stableErrorRate*100)
+		glog.Warning(err)
+		canaryCount.WithLabelValues(canaryRolledBack).Inc()
+		c.canary = nil
+		c.Lock()
+		st := *c.canaryStatus
+		c.lastError = err
+		c.canaryStatus = nil
+		c.Unlock()
+		c.audit(auditRecord{Action: auditCanaryRolledBack, Reason: err.Error()}, st)
+		c.notify(c.stable)
+		return
+	}
+
+	canaryCount.WithLabelValues(canaryPromoted).Inc()
+	c.RLock()
+	st := *c.canaryStatus
+	c.RUnlock()
+	inst := c.record(c.canary.candidate, st)
+	c.activate(inst)
+	c.audit(auditRecord{Action: auditCanaryPromoted, ID: inst.ID}, st)
+}
+
+// warmUp lets the listeners prepare for rt before it serves any request.
+func (c *Manager) warmUp(rt *Runtime) error {
+	start := time.Now()
 	for _, cl := range c.cl {
-		cl.ConfigChange(rt)
+		if w, ok := cl.(Warmer); ok {
+			if err := w.WarmUp(rt); err != nil {
+				return fmt.Errorf("unable to warm up config sha=%s: %v", rt.version, err)
+			}
+		}
 	}
+	glog.V(2).Infof("Warmed up config sha=%s in %v", rt.version, time.Since(start))
 	return nil
 }
 
+func (c *Manager) notify(cfg Resolver) {
+	for _, cl := range c.cl {
+		cl.ConfigChange(cfg)
+	}
+}
+
 // LastError returns last error encountered by the manager while processing config.
 func (c *Manager) LastError() (err error) {
 	c.RLock()
@@ -162,6 +383,37 @@ func (c *Manager) LastError() (err error) {
 	return err
 }
 
+// ConfigSHA returns the SHA1 digests of the global and service configs currently
+// installed, separated by a colon. It is empty until a config has been installed.
+func (c *Manager) ConfigSHA() (sha string) {
+	c.RLock()
+	sha = c.configSHA
+	c.RUnlock()
+	return sha
+}
+
+// Ready returns true once a valid config has been installed.
+func (c *Manager) Ready() bool {
+	return c.ConfigSHA() != ""
+}
+
+// Status returns the config currently installed along with the last error
+// encountered while fetching a new one.
+func (c *Manager) Status() Status {
+	c.RLock()
+	st := c.installed
+	if c.lastError != nil {
+		st.LastError = c.lastError.Error()
+	}
+	if c.canaryStatus != nil {
+		cs := *c.canaryStatus
+		st.Canary = &cs
+	}
+	st.Pinned = c.pinned
+	c.RUnlock()
+	return st
+}
+
 // Close stops the config manager go routine.
 func (c *Manager) Close() { close(c.closing) }
 
@@ -172,10 +424,13 @@ func (c *Manager) loop() {
 	for !done {
 		select {
 		case <-ticker.C:
+			c.judgeCanary()
 			err := c.fetchAndNotify()
 			if err != nil {
 				glog.Warning(err)
 			}
+		case f := <-c.requests:
+			f()
 		case <-c.closing:
 			done = true
 		}
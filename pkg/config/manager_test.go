@@ -15,6 +15,7 @@
 package config
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"strconv"
@@ -23,6 +24,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
 	"istio.io/mixer/pkg/adapter"
 )
 
@@ -101,11 +105,21 @@ func testConfigManager(t *testing.T, mgr *Manager, mt mtest, loopDelay time.Dura
 	fl := &fakelistener{}
 	mgr.Register(fl)
 
+	result := reloadSuccess
+	if mt.errStr != "" {
+		result = reloadFailure
+	}
+	reloads := counterValue(t, reloadCount.WithLabelValues(result))
+
 	mgr.Start()
 	defer mgr.Close()
 
 	le := mgr.LastError()
 
+	if got := counterValue(t, reloadCount.WithLabelValues(result)); got <= reloads {
+		t.Errorf("Reload counter for %s was not incremented", result)
+	}
+
 	if mt.errStr != "" && le == nil {
 		t.Fatalf("Expected an error %s Got nothing", mt.errStr)
 	}
@@ -118,6 +132,22 @@ func testConfigManager(t *testing.T, mgr *Manager, mt mtest, loopDelay time.Dura
 		t.Error("Config listener was not notified")
 	}
 
+	if sha := mgr.ConfigSHA(); (sha != "") != (mt.errStr == "") {
+		t.Errorf("ConfigSHA() = '%s' with error '%v'", sha, le)
+	}
+
+	if mgr.Ready() != (mt.errStr == "") {
+		t.Errorf("Ready() = %v with error '%v'", mgr.Ready(), le)
+	}
+
+	st := mgr.Status()
+	if mt.errStr == "" && (st.GlobalConfig != mt.gcContent || st.ServiceConfig != mt.scContent || st.LastError != "") {
+		t.Errorf("Status() = %#v, expecting the installed config and no error", st)
+	}
+	if mt.errStr != "" && !strings.Contains(st.LastError, mt.errStr) {
+		t.Errorf("Status().LastError = '%s', expecting '%s'", st.LastError, mt.errStr)
+	}
+
 	if mt.errStr == "" && le == nil {
 		called := fl.Called()
 		if le == nil && called != 1 {
@@ -138,3 +168,81 @@ func testConfigManager(t *testing.T, mgr *Manager, mt mtest, loopDelay time.Dura
 		t.Fatalf("Unexpected error. Expected %s\nGot: %s\n", mt.errStr, le)
 	}
 }
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		t.Fatalf("Unable to read counter: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+type fakeWarmer struct {
+	fakelistener
+	err     error
+	warmed  int
+	aspects int
+}
+
+func (f *fakeWarmer) WarmUp(cfg Resolver) error {
+	f.warmed++
+	f.aspects = len(cfg.(*Runtime).Aspects())
+	return f.err
+}
+
+func TestManager_WarmUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warmup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	gc := dir + "/global.yml"
+	sc := dir + "/service.yml"
+	if err = ioutil.WriteFile(gc, []byte(sGlobalConfigValid), 0644); err != nil {
+		t.Fatalf("Unable to write config: %v", err)
+	}
+	if err = ioutil.WriteFile(sc, []byte(sSvcConfig2), 0644); err != nil {
+		t.Fatalf("Unable to write config: %v", err)
+	}
+
+	vf := newVfinder(map[string]adapter.ConfigValidator{"denyChecker": &lc{}, "listchecker": &lc{}},
+		map[string]AspectValidator{"denyChecker": &ac{}, "listchecker": &ac{}})
+	mgr := NewManager(newFakeExpr(), vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, gc, sc, time.Second)
+	fw := &fakeWarmer{err: errors.New("unable to build aspect")}
+	mgr.Register(fw)
+
+	if err = mgr.fetchAndNotify(); err == nil || !strings.Contains(err.Error(), "unable to build aspect") {
+		t.Errorf("fetchAndNotify() = %v; wanted the warm up error", err)
+	}
+	if fw.Called() != 0 || mgr.Ready() || mgr.LastError() == nil {
+		t.Errorf("Config installed after failing to warm up: called=%d ready=%t", fw.Called(), mgr.Ready())
+	}
+
+	// the same content isn't warmed up again before the backoff delay
+	if err = mgr.fetchAndNotify(); err != nil || fw.warmed != 1 {
+		t.Errorf("fetchAndNotify() = %v with %d warm ups; wanted no attempt before the delay", err, fw.warmed)
+	}
+	if mgr.warmUpBackoff != time.Second {
+		t.Errorf("warmUpBackoff = %v; wanted the loop delay", mgr.warmUpBackoff)
+	}
+	mgr.warmUpRetry = time.Now()
+	if err = mgr.fetchAndNotify(); err == nil || fw.warmed != 2 {
+		t.Errorf("fetchAndNotify() = %v with %d warm ups; wanted the config warmed up again", err, fw.warmed)
+	}
+	if mgr.warmUpBackoff != 2*time.Second {
+		t.Errorf("warmUpBackoff = %v; wanted the delay doubled", mgr.warmUpBackoff)
+	}
+
+	// the aspects may be built this time
+	fw.err = nil
+	mgr.warmUpRetry = time.Now()
+	if err = mgr.fetchAndNotify(); err != nil {
+		t.Errorf("fetchAndNotify() = %v; wanted the config installed", err)
+	}
+	if fw.warmed != 3 || fw.aspects != 1 || fw.Called() != 1 {
+		t.Errorf("Got %d warm ups of %d aspects and %d notifications, expecting 3, 1 and 1", fw.warmed, fw.aspects, fw.Called())
+	}
+	if mgr.warmUpBackoff != 0 || !mgr.warmUpRetry.IsZero() {
+		t.Errorf("warmUpBackoff = %v, warmUpRetry = %v; wanted them reset", mgr.warmUpBackoff, mgr.warmUpRetry)
+	}
+}
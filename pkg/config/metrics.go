@@ -0,0 +1,72 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	rpc "github.com/googleapis/googleapis/google/rpc"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/mixer/pkg/monitoring"
+)
+
+// Values of the result label of the reload counter.
+const (
+	reloadSuccess = "success"
+	reloadFailure = "failure"
+)
+
+// Values of the result label of the canary counter.
+const (
+	canaryPromoted   = "promoted"
+	canaryRolledBack = "rolled_back"
+)
+
+var (
+	reloadCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "config",
+		Name:      "reloads_total",
+		Help:      "Number of attempts to install a new config, by result.",
+	}, []string{"result"})
+
+	lastReload = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "config",
+		Name:      "last_reload_success_timestamp_seconds",
+		Help:      "Time of the last successful config installation, in seconds since the epoch.",
+	})
+
+	requestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "config",
+		Name:      "requests_total",
+		Help:      "Number of requests resolved against a config, by track (stable or canary) and status code.",
+	}, []string{"track", "code"})
+
+	canaryCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "config",
+		Name:      "canaries_total",
+		Help:      "Number of canary configs judged, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	monitoring.MustRegister(reloadCount, lastReload, requestCount, canaryCount)
+}
+
+func recordRequest(track string, code int32) {
+	requestCount.WithLabelValues(track, rpc.Code(code).String()).Inc()
+}
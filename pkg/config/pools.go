@@ -0,0 +1,91 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// PoolConfig sizes the worker pool dedicated to an adapter implementation.
+// Adapters without a dedicated pool share the mixer's adapter worker pool.
+type PoolConfig struct {
+	// Impl is the name of the adapter implementation.
+	Impl string `json:"impl"`
+
+	// QueueDepth is the number of work items that can wait for a worker.
+	QueueDepth int `json:"queueDepth"`
+
+	// MinWorkers is the number of workers kept around when the adapter is idle.
+	MinWorkers int `json:"minWorkers"`
+
+	// MaxWorkers is the number of workers the pool grows to under load.
+	MaxWorkers int `json:"maxWorkers"`
+}
+
+// adapterPools is the section of the global config sizing the adapter pools.
+// It lives in the same document as the fields of pb.GlobalConfig.
+type adapterPools struct {
+	AdapterPools []*PoolConfig `json:"adapterPools"`
+}
+
+// validateAdapterPools extracts and validates the adapter pool sizes from a global config,
+// locating errors with the config's source map.
+func (p *Validator) validateAdapterPools(cfg string, src *sourceMap) (ce *adapter.ConfigErrors) {
+	m := &adapterPools{}
+	if err := yaml.Unmarshal([]byte(cfg), m); err != nil {
+		return locateLast(ce.Append("AdapterPools", err), src.locateYAMLError(err))
+	}
+
+	pools := make(map[string]*PoolConfig, len(m.AdapterPools))
+	for idx, pc := range m.AdapterPools {
+		field := "AdapterPools: " + pc.Impl
+		path := fmt.Sprintf("adapterPools[%d]", idx)
+		if pc.Impl == "" {
+			ce = src.append(ce, path, "AdapterPools", errors.New("impl must be specified"))
+			continue
+		}
+		if _, found := pools[pc.Impl]; found {
+			ce = src.append(ce, path+".impl", field, errors.New("duplicate pool for adapter"))
+			continue
+		}
+		if p.strict {
+			if _, found := p.adapterFinder(pc.Impl); !found {
+				ce = src.append(ce, path+".impl", field, errors.New("unknown adapter"))
+				continue
+			}
+		}
+		if pc.QueueDepth <= 0 {
+			ce = src.append(ce, path+".queueDepth", field, fmt.Errorf("queueDepth must be > 0, got %d", pc.QueueDepth))
+		}
+		if pc.MinWorkers <= 0 || pc.MaxWorkers < pc.MinWorkers {
+			ce = src.append(ce, path+".minWorkers", field,
+				fmt.Errorf("workers must satisfy 0 < minWorkers <= maxWorkers, got %d and %d", pc.MinWorkers, pc.MaxWorkers))
+		}
+		pools[pc.Impl] = pc
+	}
+
+	p.validated.adapterPools = pools
+	return
+}
+
+// AdapterPools returns the sizes of the pools dedicated to adapters, indexed by adapter implementation.
+func (v *Validated) AdapterPools() map[string]*PoolConfig {
+	return v.adapterPools
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+func TestAdapterPools(t *testing.T) {
+	cases := []struct {
+		cfg       string
+		want      map[string]*PoolConfig
+		errString string
+	}{
+		{"revision: \"1\"", map[string]*PoolConfig{}, ""},
+		{`
+adapterPools:
+- impl: statsd
+  queueDepth: 64
+  minWorkers: 1
+  maxWorkers: 8
+`, map[string]*PoolConfig{"statsd": {Impl: "statsd", QueueDepth: 64, MinWorkers: 1, MaxWorkers: 8}}, ""},
+		{`
+adapterPools:
+- impl: statsd
+  queueDepth: 64
+  minWorkers: 1
+  maxWorkers: 8
+- impl: statsd
+  queueDepth: 64
+  minWorkers: 1
+  maxWorkers: 8
+`, nil, "duplicate pool"},
+		{`
+adapterPools:
+- impl: unknown
+  queueDepth: 64
+  minWorkers: 1
+  maxWorkers: 8
+`, nil, "unknown adapter"},
+		{`
+adapterPools:
+- queueDepth: 64
+`, nil, "impl must be specified"},
+		{`
+adapterPools:
+- impl: statsd
+  minWorkers: 4
+  maxWorkers: 2
+`, nil, "queueDepth must be > 0"},
+		{`
+adapterPools:
+- impl: statsd
+  queueDepth: 64
+  minWorkers: 4
+  maxWorkers: 2
+`, nil, "minWorkers <= maxWorkers"},
+	}
+
+	for idx, c := range cases {
+		vf := newVfinder(map[string]adapter.ConfigValidator{"statsd": &lc{}}, nil)
+		p := NewValidator(vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, true, newFakeExpr())
+
+		ce := p.validateGlobalConfig(c.cfg)
+		if c.errString == "" {
+			if ce != nil {
+				t.Errorf("[%d] Unexpected error: %v", idx, ce)
+			} else if got := p.validated.AdapterPools(); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("[%d] Got pools %v, expecting %v", idx, got, c.want)
+			}
+		} else if ce == nil || !strings.Contains(ce.Error(), c.errString) {
+			t.Errorf("[%d] Got error %v, expecting '%s'", idx, ce, c.errString)
+		}
+	}
+}
@@ -18,6 +18,11 @@ package istio_mixer_v1_config
 type Combined struct {
 	Builder *Adapter
 	Aspect  *Aspect
+	// Shadow is true when the aspect runs in shadow mode: its result
+	// is recorded but never fails the request.
+	Shadow bool
+	// Selectors of the nested rules that selected the aspect, outermost first.
+	Selectors []string
 }
 
 func (c *Combined) String() (ret string) {
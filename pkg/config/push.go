@@ -0,0 +1,152 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/config/descriptors"
+)
+
+const auditPushed = "pushed"
+
+// RevisionConflictError is returned by Push when the installed configs are not
+// the ones the pushed configs are based on, meaning someone else changed them.
+type RevisionConflictError struct {
+	Base      Revisions
+	Installed Revisions
+}
+
+func (e *RevisionConflictError) Error() string {
+	return fmt.Sprintf("the installed config revisions are global=%q service=%q, not global=%q service=%q "+
+		"the pushed config is based on", e.Installed.Global, e.Installed.Service, e.Base.Global, e.Base.Service)
+}
+
+// RevisionRegressionError is returned by Push when a pushed config doesn't have a newer revision
+// than the installed one.
+type RevisionRegressionError struct {
+	err error
+}
+
+func (e *RevisionRegressionError) Error() string { return e.err.Error() }
+
+// Push validates the given configs and installs them for all requests,
+// abandoning any canary. An empty config keeps the installed one.
+//
+// base must be the revisions of the installed configs the pushed ones were
+// derived from, otherwise Push returns a *RevisionConflictError and installs
+// nothing, so that concurrent pushes can't silently undo each other. For the
+// same reason the revision of a pushed config must be newer than the installed
+// one, or only different when revision regressions are allowed.
+// Validation errors, and errors warming up the configs, are returned as
+// ConfigErrors. Pushed configs are pinned: the config files are ignored until
+// Unpin is called, the files their adapter params read secrets from are still
+// watched and the pushed configs installed again when they change. Start must
+// have been called.
+func (c *Manager) Push(globalConfig string, serviceConfig string, base Revisions) (Revisions, *adapter.ConfigErrors, error) {
+	var installed Revisions
+	var ce *adapter.ConfigErrors
+	err := c.do(func() error {
+		var err error
+		installed, ce, err = c.push(globalConfig, serviceConfig, base)
+		return err
+	})
+	return installed, ce, err
+}
+
+func (c *Manager) push(gc string, sc string, base Revisions) (Revisions, *adapter.ConfigErrors, error) {
+	installed := c.Revisions()
+	if base != installed {
+		return installed, nil, &RevisionConflictError{Base: base, Installed: installed}
+	}
+
+	pushedGC, pushedSC := gc != "", sc != ""
+	c.RLock()
+	if !pushedGC {
+		gc = c.installed.GlobalConfig
+	}
+	if !pushedSC {
+		sc = c.installed.ServiceConfig
+	}
+	c.RUnlock()
+
+	v := NewValidator(c.aspectFinder, c.builderFinder, c.findAspects, true, c.eval)
+	vd, ce := v.Validate(sc, gc)
+	if ce != nil {
+		return installed, ce, nil
+	}
+	if pushedGC {
+		if err := c.checkPushedRevision("global", vd.globalConfig.GetRevision(), installed.Global); err != nil {
+			return installed, nil, &RevisionRegressionError{err}
+		}
+	}
+	if pushedSC {
+		if err := c.checkPushedRevision("service", vd.serviceConfig.GetRevision(), installed.Service); err != nil {
+			return installed, nil, &RevisionRegressionError{err}
+		}
+	}
+
+	gcSHA, scSHA := sha1.Sum([]byte(gc)), sha1.Sum([]byte(sc))
+	rt := NewRuntime(vd, c.eval)
+	rt.version = fmt.Sprintf("%x:%x", gcSHA, scSHA)
+	st := Status{
+		GlobalConfig:    gc,
+		ServiceConfig:   sc,
+		GlobalSHA:       fmt.Sprintf("%x", gcSHA),
+		ServiceSHA:      fmt.Sprintf("%x", scSHA),
+		GlobalRevision:  vd.globalConfig.GetRevision(),
+		ServiceRevision: vd.serviceConfig.GetRevision(),
+	}
+
+	if err := c.warmUp(rt); err != nil {
+		return installed, ce.Append("Aspects", err), nil
+	}
+	c.descriptorFinder = descriptors.NewFinder(vd.globalConfig)
+
+	reason := ""
+	if c.canary != nil {
+		reason = fmt.Sprintf("abandoned canary of config sha=%s", c.canary.candidate.version)
+	}
+	glog.Infof("Installing pushed config global revision=%s service revision=%s", st.GlobalRevision, st.ServiceRevision)
+	c.Lock()
+	c.pinned = true
+	c.lastError = nil
+	c.Unlock()
+	c.secretFiles = vd.secretFiles
+	inst := c.record(rt, st)
+	c.activate(inst)
+	c.audit(auditRecord{Action: auditPushed, ID: inst.ID, Pinned: true, Reason: reason}, st)
+	return Revisions{Global: st.GlobalRevision, Service: st.ServiceRevision}, nil, nil
+}
+
+// checkPushedRevision returns an error unless the revision of a pushed config
+// changes the installed one. Revisions tell concurrent pushes apart, a push
+// keeping them would let another one based on the same revisions succeed.
+func (c *Manager) checkPushedRevision(config string, next string, installed string) error {
+	if c.allowRegression {
+		if next == installed {
+			return fmt.Errorf("%s config revision %q is the installed revision, pushed configs must change it", config, next)
+		}
+		return nil
+	}
+	if compareRevisions(next, installed) <= 0 {
+		return fmt.Errorf("%s config revision %q is not newer than the installed revision %q", config, next, installed)
+	}
+	return nil
+}
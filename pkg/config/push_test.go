@@ -0,0 +1,203 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+	listcheckerpb "istio.io/mixer/pkg/aspect/config"
+)
+
+func TestManager_Push(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	gc := dir + "/global.yml"
+	sc := dir + "/service.yml"
+	withRevision := func(rev string) string {
+		return strings.Replace(sSvcConfig2, `revision: "2022"`, `revision: "`+rev+`"`, 1)
+	}
+	if err = ioutil.WriteFile(gc, []byte(sGlobalConfigValid), 0644); err != nil {
+		t.Fatalf("Unable to write config: %v", err)
+	}
+	if err = ioutil.WriteFile(sc, []byte(withRevision("1")), 0644); err != nil {
+		t.Fatalf("Unable to write config: %v", err)
+	}
+
+	vf := newVfinder(map[string]adapter.ConfigValidator{"denyChecker": &lc{}, "listchecker": &lc{}},
+		map[string]AspectValidator{"denyChecker": &ac{}, "listchecker": &ac{}})
+	mgr := NewManager(newFakeExpr(), vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, gc, sc, time.Hour)
+	audit := &bytes.Buffer{}
+	mgr.SetAuditLog(audit)
+	fl := &fakelistener{}
+	mgr.Register(fl)
+	mgr.Start()
+	closed := false
+	defer func() {
+		if !closed {
+			mgr.Close()
+		}
+	}()
+
+	base := Revisions{Global: "2022", Service: "1"}
+
+	// someone else's push based on other revisions
+	_, ce, err := mgr.Push("", withRevision("2"), Revisions{Global: "2022", Service: "0"})
+	if _, ok := err.(*RevisionConflictError); !ok || ce != nil {
+		t.Errorf("Push() = _, %v, %v; wanted a revision conflict", ce, err)
+	}
+
+	_, ce, err = mgr.Push("", "rules: [", base)
+	if err != nil || ce == nil || !strings.Contains(ce.Error(), "ServiceConfig") {
+		t.Errorf("Push() = _, %v, %v; wanted validation errors", ce, err)
+	}
+
+	_, ce, err = mgr.Push("", withRevision("0"), base)
+	if _, ok := err.(*RevisionRegressionError); !ok || ce != nil {
+		t.Errorf("Push() = _, %v, %v; wanted a revision regression", ce, err)
+	}
+
+	// a push keeping the installed revision couldn't be told apart from the one it was based on
+	_, ce, err = mgr.Push("", withRevision("1"), base)
+	if _, ok := err.(*RevisionRegressionError); !ok || ce != nil {
+		t.Errorf("Push() = _, %v, %v; wanted the unchanged revision rejected", ce, err)
+	}
+
+	if fl.Called() != 1 {
+		t.Fatalf("listener called %d times; wanted only for the config files", fl.Called())
+	}
+
+	installed, ce, err := mgr.Push("", withRevision("2"), base)
+	if err != nil || ce != nil {
+		t.Fatalf("Push() = _, %v, %v; wanted the config installed", ce, err)
+	}
+	if want := (Revisions{Global: "2022", Service: "2"}); installed != want || mgr.Revisions() != want {
+		t.Errorf("Push() installed %v, Revisions() = %v; wanted %v", installed, mgr.Revisions(), want)
+	}
+	if fl.Called() != 2 || fl.rt.(*Runtime).serviceConfig.GetRevision() != "2" {
+		t.Errorf("listener called %d times; wanted it to get the pushed config", fl.Called())
+	}
+	st := mgr.Status()
+	if !st.Pinned || st.GlobalConfig != sGlobalConfigValid || st.ServiceConfig != withRevision("2") {
+		t.Errorf("Status() = %#v; wanted the pushed config pinned, with the installed global config", st)
+	}
+	if !strings.Contains(audit.String(), `"action":"pushed"`) {
+		t.Errorf("audit log = %s; wanted the push recorded", audit)
+	}
+
+	// the config files don't replace the pushed config
+	if err = mgr.do(mgr.fetchAndNotify); err != nil || mgr.Revisions().Service != "2" {
+		t.Errorf("fetchAndNotify() = %v installed %v; wanted the pushed config kept", err, mgr.Revisions())
+	}
+
+	// a concurrent push sharing the first one's base loses the race, whatever its revision
+	for _, rev := range []string{"2", "3"} {
+		if _, ce, err = mgr.Push("", withRevision(rev), base); ce != nil {
+			t.Errorf("Push() = _, %v, _; wanted no validation", ce)
+		} else if _, ok := err.(*RevisionConflictError); !ok {
+			t.Errorf("Push(revision %s) = %v; wanted a revision conflict", rev, err)
+		}
+	}
+
+	// a second push based on the first one's revisions
+	if _, ce, err = mgr.Push("", withRevision("3"), installed); err != nil || ce != nil {
+		t.Errorf("Push() = _, %v, %v; wanted the config installed", ce, err)
+	}
+
+	mgr.Close()
+	closed = true
+	if _, _, err = mgr.Push("", withRevision("4"), Revisions{Global: "2022", Service: "3"}); err == nil {
+		t.Error("Push() = nil; wanted err once the manager is closed")
+	}
+}
+
+func TestManager_PushedSecretRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	gc := dir + "/global.yml"
+	sc := dir + "/service.yml"
+	pw := dir + "/pw"
+	write := func(path string, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", path, err)
+		}
+	}
+	write(gc, sGlobalConfigValid)
+	write(sc, sSvcConfig2)
+	write(pw, "first-secret-value")
+
+	vf := newVfinder(map[string]adapter.ConfigValidator{"denyChecker": &lc{}, "listchecker": &lc{}},
+		map[string]AspectValidator{"denyChecker": &ac{}, "listchecker": &ac{}})
+	mgr := NewManager(newFakeExpr(), vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, gc, sc, time.Hour)
+	audit := &bytes.Buffer{}
+	mgr.SetAuditLog(audit)
+	fl := &fakelistener{}
+	mgr.Register(fl)
+	mgr.Start()
+	defer mgr.Close()
+
+	checkAttribute := func() string {
+		return fl.rt.(*Runtime).globalConfig.GetAdapters()[0].Params.(*listcheckerpb.ListsParams).CheckAttribute
+	}
+
+	pushed := strings.Replace(strings.Replace(sGlobalConfigValid, "src.ip", "${file:"+pw+"}", 1), `"2022"`, `"2023"`, 1)
+	if _, ce, err := mgr.Push(pushed, "", Revisions{Global: "2022", Service: "2022"}); err != nil || ce != nil {
+		t.Fatalf("Push() = _, %v, %v; wanted the config installed", ce, err)
+	}
+	if got := checkAttribute(); got != "first-secret-value" {
+		t.Fatalf("CheckAttribute = %s; wanted the secret resolved", got)
+	}
+
+	// nothing changed
+	if err = mgr.do(mgr.fetchAndNotify); err != nil || fl.Called() != 2 {
+		t.Errorf("fetchAndNotify() = %v, listener called %d times; wanted nothing installed", err, fl.Called())
+	}
+
+	write(pw, "second-secret-value")
+	if err = mgr.do(mgr.fetchAndNotify); err != nil {
+		t.Fatalf("fetchAndNotify() = %v; wanted the pushed config installed again", err)
+	}
+	if got := checkAttribute(); got != "second-secret-value" {
+		t.Errorf("CheckAttribute = %s; wanted the rotated secret", got)
+	}
+	if st := mgr.Status(); !st.Pinned || st.GlobalConfig != pushed {
+		t.Errorf("Status() = %#v; wanted the pushed config still pinned", st)
+	}
+	if !strings.Contains(audit.String(), `"action":"secrets_rotated"`) {
+		t.Errorf("audit log = %s; wanted the rotation recorded", audit)
+	}
+
+	// a secret failing validation is reported once, until the file changes again
+	if err = os.Remove(pw); err != nil {
+		t.Fatalf("Unable to remove the secret: %v", err)
+	}
+	if err = mgr.do(mgr.fetchAndNotify); err == nil {
+		t.Error("fetchAndNotify() = nil; wanted the missing secret reported")
+	}
+	if err = mgr.do(mgr.fetchAndNotify); err != nil || checkAttribute() != "second-secret-value" {
+		t.Errorf("fetchAndNotify() = %v; wanted the failed rotation not attempted again", err)
+	}
+}
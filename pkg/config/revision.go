@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Revisions identifies the installed configs by their revision fields.
+type Revisions struct {
+	Global  string `json:"globalRevision"`
+	Service string `json:"serviceRevision"`
+}
+
+// SetAllowRevisionRegression makes the manager install configs whose revision
+// is older than the installed one's, which it refuses to do by default to
+// guard against stale copies of the config files. It must be called before Start.
+func (c *Manager) SetAllowRevisionRegression(allow bool) {
+	c.allowRegression = allow
+}
+
+// Revisions returns the revisions of the installed configs.
+func (c *Manager) Revisions() Revisions {
+	c.RLock()
+	defer c.RUnlock()
+	return Revisions{Global: c.installed.GlobalRevision, Service: c.installed.ServiceRevision}
+}
+
+// checkRevision returns an error if the revision of a config about to be
+// installed is older than the installed one. Configs without a revision are
+// not checked.
+func checkRevision(config string, next string, installed string) error {
+	if next == "" || installed == "" {
+		return nil
+	}
+	if compareRevisions(next, installed) < 0 {
+		return fmt.Errorf("%s config revision %s is older than the installed revision %s", config, next, installed)
+	}
+	return nil
+}
+
+// compareRevisions returns -1, 0 or 1 depending on whether revision a is older
+// than, the same as, or newer than revision b. Revisions are compared segment
+// by segment, segments being separated by dots or dashes. Numeric segments
+// compare numerically, others lexically. A revision extending another one is newer.
+func compareRevisions(a string, b string) int {
+	as := splitRevision(a)
+	bs := splitRevision(b)
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareSegments(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+func splitRevision(r string) []string {
+	return strings.FieldsFunc(r, func(c rune) bool { return c == '.' || c == '-' })
+}
+
+func compareSegments(a string, b string) int {
+	an, aerr := strconv.ParseUint(a, 10, 64)
+	bn, berr := strconv.ParseUint(b, 10, 64)
+	if aerr == nil && berr == nil {
+		switch {
+		case an < bn:
+			return -1
+		case an > bn:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
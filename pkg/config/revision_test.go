@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+func TestCompareRevisions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"2022", "2022", 0},
+		{"2021", "2022", -1},
+		{"10", "9", 1},
+		{"1.10", "1.9", 1},
+		{"1.2", "1.2.1", -1},
+		{"1.2.0", "1.2", 1},
+		{"2017-08-07", "2017-08-10", -1},
+		{"1.0-beta", "1.0-alpha", 1},
+		{"v2", "v10", 1}, // non numeric segments compare lexically
+		{"1.a", "1.2", 1},
+	}
+	for idx, c := range cases {
+		if got := compareRevisions(c.a, c.b); got != c.want {
+			t.Errorf("[%d] compareRevisions(%s, %s) = %d; wanted %d", idx, c.a, c.b, got, c.want)
+		}
+		if got := compareRevisions(c.b, c.a); got != -c.want {
+			t.Errorf("[%d] compareRevisions(%s, %s) = %d; wanted %d", idx, c.b, c.a, got, -c.want)
+		}
+	}
+}
+
+func TestCheckRevision(t *testing.T) {
+	cases := []struct {
+		next, installed string
+		err             string
+	}{
+		{"2", "1", ""},
+		{"1", "1", ""},
+		{"", "1", ""},
+		{"1", "", ""},
+		{"1", "2", "service config revision 1 is older than the installed revision 2"},
+	}
+	for idx, c := range cases {
+		err := checkRevision("service", c.next, c.installed)
+		if (err == nil) != (c.err == "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("[%d] checkRevision(%s, %s) = %v; wanted err '%s'", idx, c.next, c.installed, err, c.err)
+		}
+	}
+}
+
+func TestManager_RevisionRegression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revision")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	gc := dir + "/global.yml"
+	sc := dir + "/service.yml"
+	writeRevision := func(rev string) {
+		content := strings.Replace(sSvcConfig2, `revision: "2022"`, `revision: "`+rev+`"`, 1)
+		if err := ioutil.WriteFile(sc, []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to write config: %v", err)
+		}
+	}
+	if err = ioutil.WriteFile(gc, []byte(sGlobalConfigValid), 0644); err != nil {
+		t.Fatalf("Unable to write config: %v", err)
+	}
+
+	vf := newVfinder(map[string]adapter.ConfigValidator{"denyChecker": &lc{}, "listchecker": &lc{}},
+		map[string]AspectValidator{"denyChecker": &ac{}, "listchecker": &ac{}})
+	mgr := NewManager(newFakeExpr(), vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, gc, sc, time.Hour)
+	fl := &fakelistener{}
+	mgr.Register(fl)
+
+	writeRevision("1.10")
+	if err = mgr.fetchAndNotify(); err != nil {
+		t.Fatalf("fetchAndNotify() = %v", err)
+	}
+	installed := fl.rt
+
+	writeRevision("1.9")
+	err = mgr.fetchAndNotify()
+	if err == nil || !strings.Contains(err.Error(), "older than the installed revision 1.10") {
+		t.Errorf("fetchAndNotify() = %v; wanted err about an older revision", err)
+	}
+	if got := mgr.Revisions(); got.Service != "1.10" || fl.rt != installed {
+		t.Errorf("Revisions() = %v; wanted revision 1.10 to stay installed", got)
+	}
+	if st := mgr.Status(); !strings.Contains(st.LastError, "older") {
+		t.Errorf("Status().LastError = %s; wanted the rejection", st.LastError)
+	}
+	// the same stale content is rejected only once
+	if err = mgr.fetchAndNotify(); err != nil {
+		t.Errorf("fetchAndNotify() = %v; wanted the rejected config skipped", err)
+	}
+	if st := mgr.Status(); !strings.Contains(st.LastError, "older") {
+		t.Errorf("Status().LastError = %s; wanted the rejection kept", st.LastError)
+	}
+
+	mgr.SetAllowRevisionRegression(true)
+	if err = mgr.fetchAndNotify(); err != nil {
+		t.Fatalf("fetchAndNotify() = %v; wanted the older revision installed when allowed", err)
+	}
+	if got := mgr.Revisions(); got.Service != "1.9" || fl.rt == installed {
+		t.Errorf("Revisions() = %v; wanted revision 1.9 installed", got)
+	}
+}
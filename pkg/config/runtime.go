@@ -15,6 +15,9 @@
 package config
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
 
@@ -31,6 +34,14 @@ type (
 		Validated
 		// used to evaluate selectors
 		eval expr.PredicateEvaluator
+		// used to evaluate rule schedules of requests without a request time
+		now func() time.Time
+		// identifies the config in metrics
+		version string
+		// indexes the top level rules
+		rootIndex *ruleIndex
+		// indexes the nested rules by their enclosing rule
+		indexes map[*pb.AspectRule]*ruleIndex
 	}
 
 	// AspectSet is a set of aspects by name.
@@ -39,10 +50,14 @@ type (
 
 // NewRuntime returns a Runtime object given a validated config and a predicate eval.
 func NewRuntime(v *Validated, evaluator expr.PredicateEvaluator) *Runtime {
-	return &Runtime{
+	r := &Runtime{
 		Validated: *v,
 		eval:      evaluator,
+		now:       time.Now,
+		indexes:   make(map[*pb.AspectRule]*ruleIndex),
 	}
+	r.rootIndex = indexRules(r.serviceConfig.GetRules(), evaluator, r.indexes)
+	return r
 }
 
 // Resolve returns a list of CombinedConfig given an attribute bag.
@@ -52,10 +67,32 @@ func NewRuntime(v *Validated, evaluator expr.PredicateEvaluator) *Runtime {
 func (r *Runtime) Resolve(bag attribute.Bag, aspectSet AspectSet) (dlist []*pb.Combined, err error) {
 	if glog.V(2) {
 		glog.Infof("resolving for: %s", aspectSet)
-		defer func() { glog.Infof("resolved (err=%v): %s", err, dlist) }()
+		defer func() { glog.Infof("resolved (err=%v): %s", err, Redact(fmt.Sprint(dlist))) }()
 	}
 	dlist = make([]*pb.Combined, 0, r.numAspects)
-	return r.resolveRules(bag, aspectSet, r.serviceConfig.GetRules(), "/", dlist)
+	return r.resolveRules(bag, aspectSet, r.serviceConfig.GetRules(), r.rootIndex, nil, dlist)
+}
+
+// Aspects returns every aspect the runtime may resolve to, whatever the request.
+func (r *Runtime) Aspects() []*pb.Combined {
+	dlist := make([]*pb.Combined, 0, r.numAspects)
+	var walk func(rules []*pb.AspectRule)
+	walk = func(rules []*pb.AspectRule) {
+		for _, rule := range rules {
+			for _, aa := range rule.GetAspects() {
+				adp := r.adapterByName[adapterKey{aa.Kind, aa.Adapter}]
+				dlist = append(dlist, &pb.Combined{Builder: adp, Aspect: aa, Shadow: r.shadowed[aa]})
+			}
+			walk(rule.GetRules())
+		}
+	}
+	walk(r.serviceConfig.GetRules())
+	return dlist
+}
+
+// RecordOutcome records the status code of a request resolved against the runtime.
+func (r *Runtime) RecordOutcome(bag attribute.Bag, code int32) {
+	recordRequest(trackStable, code)
 }
 
 func (r *Runtime) evalPredicate(selector string, bag attribute.Bag) (bool, error) {
@@ -66,24 +103,34 @@ func (r *Runtime) evalPredicate(selector string, bag attribute.Bag) (bool, error
 	return r.eval.EvalPredicate(selector, bag)
 }
 
-// resolveRules recurses through the config struct and returns a list of combined aspects
-func (r *Runtime) resolveRules(bag attribute.Bag, aspectSet AspectSet, rules []*pb.AspectRule, path string, dlist []*pb.Combined) ([]*pb.Combined, error) {
+// resolveRules recurses through the config struct and returns a list of combined aspects.
+// Only the rules ix finds for bag are evaluated. The selectors of the
+// enclosing rules, outermost first, are given by path.
+func (r *Runtime) resolveRules(bag attribute.Bag, aspectSet AspectSet, rules []*pb.AspectRule, ix *ruleIndex,
+	path []string, dlist []*pb.Combined) ([]*pb.Combined, error) {
 	var selected bool
 	var lerr error
 	var err error
 
-	for _, rule := range rules {
+	for _, i := range ix.candidates(bag, len(rules)) {
+		rule := rules[i]
 		glog.V(3).Infof("resolveRules (%v) ==> %v ", rule, path)
 
+		if s := r.schedules[rule]; s != nil && !s.active(requestTime(bag, r.now)) {
+			glog.V(3).Infof("rule %v is not scheduled", rule)
+			continue
+		}
+
 		sel := rule.GetSelector()
-		if selected, lerr = r.evalPredicate(sel, bag); lerr != nil {
+		if selected, lerr = r.evalSelector(ix.guard(i), sel, bag); lerr != nil {
 			err = multierror.Append(err, lerr)
 			continue
 		}
 		if !selected {
 			continue
 		}
-		path = path + "/" + sel
+		// the full slice expression makes append copy path, so that sibling rules don't share selectors
+		rulePath := append(path[:len(path):len(path)], sel)
 		for _, aa := range rule.GetAspects() {
 			if !aspectSet[aa.Kind] {
 				glog.V(3).Infof("Aspect %s not selected [%v]", aa.Kind, aspectSet)
@@ -91,13 +138,13 @@ func (r *Runtime) resolveRules(bag attribute.Bag, aspectSet AspectSet, rules []*
 			}
 			adp := r.adapterByName[adapterKey{aa.Kind, aa.Adapter}]
 			glog.V(2).Infof("selected aspect %s -> %s", aa.Kind, adp)
-			dlist = append(dlist, &pb.Combined{adp, aa})
+			dlist = append(dlist, &pb.Combined{Builder: adp, Aspect: aa, Shadow: r.shadowed[aa], Selectors: rulePath})
 		}
 		rs := rule.GetRules()
 		if len(rs) == 0 {
 			continue
 		}
-		if dlist, lerr = r.resolveRules(bag, aspectSet, rs, path, dlist); lerr != nil {
+		if dlist, lerr = r.resolveRules(bag, aspectSet, rs, r.indexes[rule], rulePath, dlist); lerr != nil {
 			err = multierror.Append(err, lerr)
 		}
 	}
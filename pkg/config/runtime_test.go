@@ -17,6 +17,7 @@ package config
 import (
 	"errors"
 	"flag"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/go-multierror"
@@ -129,7 +130,70 @@ func TestRuntime(t *testing.T) {
 	}
 }
 
+func TestRuntime_Selectors(t *testing.T) {
+	LC := "listChecker"
+	v := &Validated{
+		adapterByName: map[adapterKey]*pb.Adapter{},
+		serviceConfig: &pb.ServiceConfig{
+			Rules: []*pb.AspectRule{
+				{
+					Selector: "outer",
+					Aspects:  []*pb.Aspect{{Kind: LC}},
+					Rules: []*pb.AspectRule{
+						{Selector: "first", Aspects: []*pb.Aspect{{Kind: LC}}},
+						{Selector: "second", Aspects: []*pb.Aspect{{Kind: LC}}},
+					},
+				},
+				{Selector: "sibling", Aspects: []*pb.Aspect{{Kind: LC}}},
+			},
+		},
+	}
+
+	al, err := NewRuntime(v, &trueEval{ret: true}).Resolve(attribute.GetMutableBag(nil), AspectSet{LC: true})
+	if err != nil {
+		t.Fatalf("Resolve() = _, %v; wanted no err", err)
+	}
+
+	want := [][]string{{"outer"}, {"outer", "first"}, {"outer", "second"}, {"sibling"}}
+	if len(al) != len(want) {
+		t.Fatalf("Resolve() returned %d aspects; wanted %d", len(al), len(want))
+	}
+	for idx, c := range al {
+		if !reflect.DeepEqual(c.Selectors, want[idx]) {
+			t.Errorf("[%d] Selectors = %v; wanted %v", idx, c.Selectors, want[idx])
+		}
+	}
+}
+
 func init() {
 	// bump up the log level so log-only logic runs during the tests, for correctness and coverage.
 	_ = flag.Lookup("v").Value.Set("99")
 }
+
+func TestRuntime_Aspects(t *testing.T) {
+	LC := "listChecker"
+	a1 := &pb.Adapter{Name: "a1", Kind: LC}
+	inner := &pb.Aspect{Kind: LC, Adapter: "a1"}
+	v := &Validated{
+		adapterByName: map[adapterKey]*pb.Adapter{{LC, "a1"}: a1},
+		serviceConfig: &pb.ServiceConfig{
+			Rules: []*pb.AspectRule{
+				{
+					Selector: "never",
+					Aspects:  []*pb.Aspect{{Kind: LC}},
+					Rules:    []*pb.AspectRule{{Selector: "never", Aspects: []*pb.Aspect{inner}}},
+				},
+				{Selector: "never"},
+			},
+		},
+		shadowed: map[*pb.Aspect]bool{inner: true},
+	}
+
+	al := NewRuntime(v, &trueEval{ret: false}).Aspects()
+	if len(al) != 2 {
+		t.Fatalf("Aspects() returned %d aspects; wanted 2 whatever the selectors", len(al))
+	}
+	if al[1].Aspect != inner || al[1].Builder != a1 || !al[1].Shadow || al[0].Shadow {
+		t.Errorf("Aspects() = %v; wanted the nested aspect with its adapter and shadow mode", al)
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/attribute"
+	pb "istio.io/mixer/pkg/config/proto"
+)
+
+// requestTimeAttribute is the attribute rule schedules are evaluated against.
+// The mixer's clock is used when a request doesn't carry it.
+const requestTimeAttribute = "request.time"
+
+type (
+	// scheduleRules mirrors the rules of a service config to pick up their
+	// activation schedules, which pb.AspectRule doesn't carry. A rule with a
+	// schedule is only selected while its schedule is active, for example:
+	//
+	//   rules:
+	//   - selector: target.service == "books"
+	//     schedule:
+	//       start: 2017-06-01T00:00:00Z   # optional, inclusive
+	//       end: 2017-07-01T00:00:00Z     # optional, exclusive
+	//       timeZone: America/Los_Angeles # for windows, defaults to UTC
+	//       windows:                      # optional, active during any of them
+	//       - cron: "0 2 * * SAT"         # minute hour day-of-month month day-of-week
+	//         duration: 4h
+	scheduleRules struct {
+		Schedule *scheduleConfig  `json:"schedule"`
+		Rules    []*scheduleRules `json:"rules"`
+	}
+
+	scheduleConfig struct {
+		Start    string `json:"start"`
+		End      string `json:"end"`
+		TimeZone string `json:"timeZone"`
+		Windows  []struct {
+			Cron     string `json:"cron"`
+			Duration string `json:"duration"`
+		} `json:"windows"`
+	}
+
+	// schedule is the compiled form of a scheduleConfig.
+	schedule struct {
+		start    time.Time // zero when unbounded
+		end      time.Time // zero when unbounded
+		location *time.Location
+		windows  []window
+	}
+
+	// window is a recurring period that opens whenever its cron expression
+	// fires and stays open for duration.
+	window struct {
+		cron     *cronExpr
+		duration time.Duration
+	}
+
+	// cronExpr is a parsed 5-field cron expression. Each field is a bitmask of
+	// the values it matches.
+	cronExpr struct {
+		minute, hour, dom, month, dow uint64
+		// day of month and day of week are or-ed when both are restricted, as in cron
+		domStar, dowStar bool
+	}
+)
+
+// scheduledRules returns the schedules of rules, given the service config
+// cfg the rules were decoded from.
+func scheduledRules(cfg string, rules []*pb.AspectRule) (schedules map[*pb.AspectRule]*schedule, ce *adapter.ConfigErrors) {
+	m := &scheduleRules{}
+	if err := yaml.Unmarshal([]byte(cfg), m); err != nil {
+		return nil, ce.Append("ServiceConfig", err)
+	}
+
+	schedules = make(map[*pb.AspectRule]*schedule)
+	return schedules, collectSchedules(m.Rules, rules, "Rules", schedules)
+}
+
+func collectSchedules(srs []*scheduleRules, rules []*pb.AspectRule, path string, schedules map[*pb.AspectRule]*schedule) (ce *adapter.ConfigErrors) {
+	for i, rule := range rules {
+		if i >= len(srs) || srs[i] == nil {
+			return
+		}
+		rulePath := fmt.Sprintf("%s[%d]", path, i)
+		if srs[i].Schedule != nil {
+			s, err := newSchedule(srs[i].Schedule, rulePath+".Schedule")
+			if err != nil {
+				ce = ce.Extend(err)
+			} else {
+				schedules[rule] = s
+			}
+		}
+		if err := collectSchedules(srs[i].Rules, rule.GetRules(), rulePath+".Rules", schedules); err != nil {
+			ce = ce.Extend(err)
+		}
+	}
+	return
+}
+
+func newSchedule(c *scheduleConfig, path string) (s *schedule, ce *adapter.ConfigErrors) {
+	s = &schedule{location: time.UTC}
+	var err error
+
+	if c.TimeZone != "" {
+		if s.location, err = time.LoadLocation(c.TimeZone); err != nil {
+			ce = ce.Append(path+".TimeZone", err)
+		}
+	}
+	if c.Start != "" {
+		if s.start, err = time.Parse(time.RFC3339, c.Start); err != nil {
+			ce = ce.Append(path+".Start", err)
+		}
+	}
+	if c.End != "" {
+		if s.end, err = time.Parse(time.RFC3339, c.End); err != nil {
+			ce = ce.Append(path+".End", err)
+		}
+	}
+	if !s.start.IsZero() && !s.end.IsZero() && !s.start.Before(s.end) {
+		ce = ce.Appendf(path+".End", "end %s must be after start %s", c.End, c.Start)
+	}
+
+	for idx, w := range c.Windows {
+		wpath := fmt.Sprintf("%s.Windows[%d]", path, idx)
+		var win window
+		if win.cron, err = parseCron(w.Cron); err != nil {
+			ce = ce.Append(wpath+".Cron", err)
+		}
+		if win.duration, err = time.ParseDuration(w.Duration); err != nil {
+			ce = ce.Append(wpath+".Duration", err)
+		} else if win.duration <= 0 {
+			ce = ce.Appendf(wpath+".Duration", "duration must be > 0, got %s", w.Duration)
+		}
+		s.windows = append(s.windows, win)
+	}
+
+	if ce != nil {
+		return nil, ce
+	}
+	return s, nil
+}
+
+// active returns true if the schedule is active at t.
+func (s *schedule) active(t time.Time) bool {
+	if !s.start.IsZero() && t.Before(s.start) {
+		return false
+	}
+	if !s.end.IsZero() && !t.Before(s.end) {
+		return false
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+
+	t = t.In(s.location)
+	for _, w := range s.windows {
+		if w.open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// open returns true if the cron expression of the window fired in (t-duration, t].
+// It walks back from t, skipping over whole days and hours that don't match.
+func (w window) open(t time.Time) bool {
+	earliest := t.Add(-w.duration)
+	loc := t.Location()
+	cur := t.Truncate(time.Minute)
+
+	for cur.After(earliest) {
+		y, mo, d := cur.Date()
+		h := cur.Hour()
+		switch {
+		case !w.cron.matchesDay(cur):
+			cur = time.Date(y, mo, d, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !bitSet(w.cron.hour, h):
+			cur = time.Date(y, mo, d, h, 0, 0, 0, loc).Add(-time.Minute)
+		case !bitSet(w.cron.minute, cur.Minute()):
+			cur = cur.Add(-time.Minute)
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cronExpr) matchesDay(t time.Time) bool {
+	if !bitSet(c.month, int(t.Month())) {
+		return false
+	}
+	dom := bitSet(c.dom, t.Day())
+	dow := bitSet(c.dow, int(t.Weekday()))
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	}
+	return dom || dow
+}
+
+func bitSet(mask uint64, v int) bool {
+	return mask&(1<<uint(v)) != 0
+}
+
+var (
+	monthNames = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+	dayNames = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+)
+
+// parseCron parses a standard 5-field cron expression. Fields accept '*',
+// values, ranges, lists and steps; months and days of week also accept
+// their three letter English names.
+func parseCron(spec string) (*cronExpr, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' must have 5 fields, got %d", spec, len(fields))
+	}
+
+	c := &cronExpr{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute in '%s': %v", spec, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour in '%s': %v", spec, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month in '%s': %v", spec, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid month in '%s': %v", spec, err)
+	}
+	// 7 is accepted as Sunday
+	if c.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("invalid day of week in '%s': %v", spec, err)
+	}
+	if bitSet(c.dow, 7) {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			rng = part[:i]
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step != 1 {
+				// a step applied to a single value runs to the end of the range
+				hi = max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range '%s'", rng)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, found := names[strings.ToUpper(s)]; found {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, min, max)
+	}
+	return v, nil
+}
+
+// requestTime returns the time rule schedules are evaluated against.
+func requestTime(bag attribute.Bag, now func() time.Time) time.Time {
+	if v, found := bag.Get(requestTimeAttribute); found {
+		if t, ok := v.(time.Time); ok {
+			return t
+		}
+	}
+	return now()
+}
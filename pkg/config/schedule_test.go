@@ -0,0 +1,258 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/mixer/pkg/attribute"
+	pb "istio.io/mixer/pkg/config/proto"
+)
+
+const sScheduledSvcConfig = `
+subject: namespace:ns
+revision: "2022"
+rules:
+- selector: true
+  aspects:
+  - kind: denials
+    adapter: always
+  rules:
+  - selector: true
+    schedule:
+      timeZone: America/Los_Angeles
+      windows:
+      - cron: "0 2 * * SAT"
+        duration: 4h
+    aspects:
+    - kind: denials
+      adapter: maintenance
+- selector: true
+  schedule:
+    start: 2017-06-01T00:00:00Z
+    end: 2017-07-01T00:00:00Z
+  aspects:
+  - kind: denials
+    adapter: june
+`
+
+func TestParseCron(t *testing.T) {
+	cases := []struct {
+		spec string
+		err  string
+	}{
+		{"* * * * *", ""},
+		{"*/15 9-17 * * MON-FRI", ""},
+		{"0 0 1,15 jan,jul 7", ""},
+		{"5/10 * * * *", ""},
+		{"* * * *", "5 fields"},
+		{"60 * * * *", "minute"},
+		{"* 24 * * *", "hour"},
+		{"* * 0 * *", "day of month"},
+		{"* * * FOO *", "month"},
+		{"* * * * 8", "day of week"},
+		{"*/0 * * * *", "step"},
+		{"* 5-3 * * *", "range"},
+	}
+	for idx, c := range cases {
+		_, err := parseCron(c.spec)
+		if (err == nil) != (c.err == "") || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("[%d] parseCron(%s) = _, %v; wanted err containing '%s'", idx, c.spec, err, c.err)
+		}
+	}
+}
+
+func TestWindow_Open(t *testing.T) {
+	utc := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatalf("bad time %s: %v", s, err)
+		}
+		return tm
+	}
+
+	cases := []struct {
+		cron     string
+		duration time.Duration
+		at       string
+		want     bool
+	}{
+		// 2017-06-03 is a Saturday
+		{"0 2 * * SAT", 4 * time.Hour, "2017-06-03T02:00:00Z", true},
+		{"0 2 * * SAT", 4 * time.Hour, "2017-06-03T05:59:59Z", true},
+		{"0 2 * * SAT", 4 * time.Hour, "2017-06-03T06:00:00Z", false},
+		{"0 2 * * SAT", 4 * time.Hour, "2017-06-03T01:59:59Z", false},
+		{"0 2 * * SAT", 4 * time.Hour, "2017-06-04T02:00:00Z", false},
+		// windows span midnight and month boundaries
+		{"0 22 * * *", 4 * time.Hour, "2017-07-01T01:30:00Z", true},
+		{"30 23 31 * *", time.Hour, "2017-06-01T00:15:00Z", true},
+		{"30 23 31 * *", time.Hour, "2017-07-01T00:15:00Z", false},
+		// day of month and day of week are or-ed when both are given
+		{"0 0 1 * SUN", time.Hour, "2017-06-04T00:30:00Z", true},
+		{"0 0 1 * SUN", time.Hour, "2017-06-01T00:30:00Z", true},
+		{"0 0 1 * SUN", time.Hour, "2017-06-02T00:30:00Z", false},
+		{"*/15 * * * *", time.Minute, "2017-06-02T10:45:30Z", true},
+		{"*/15 * * * *", time.Minute, "2017-06-02T10:46:30Z", false},
+	}
+
+	for idx, c := range cases {
+		cron, err := parseCron(c.cron)
+		if err != nil {
+			t.Fatalf("[%d] parseCron(%s) = _, %v", idx, c.cron, err)
+		}
+		w := window{cron: cron, duration: c.duration}
+		if got := w.open(utc(c.at)); got != c.want {
+			t.Errorf("[%d] window{%s, %v}.open(%s) = %t; wanted %t", idx, c.cron, c.duration, c.at, got, c.want)
+		}
+	}
+}
+
+func TestScheduledRules(t *testing.T) {
+	m := &pb.ServiceConfig{}
+	if err := yaml.Unmarshal([]byte(sScheduledSvcConfig), m); err != nil {
+		t.Fatalf("Unable to unmarshal service config: %v", err)
+	}
+
+	schedules, ce := scheduledRules(sScheduledSvcConfig, m.Rules)
+	if ce != nil {
+		t.Fatalf("scheduledRules() = _, %v; wanted no err", ce)
+	}
+	maintenance := schedules[m.Rules[0].Rules[0]]
+	june := schedules[m.Rules[1]]
+	if len(schedules) != 2 || maintenance == nil || june == nil {
+		t.Fatalf("scheduledRules() = %v; wanted the maintenance and june rules", schedules)
+	}
+
+	cases := []struct {
+		s    *schedule
+		at   string
+		want bool
+	}{
+		// Saturday 2am in Los Angeles is 9am UTC during daylight saving time
+		{maintenance, "2017-06-03T09:30:00Z", true},
+		{maintenance, "2017-06-03T02:30:00Z", false},
+		{june, "2017-05-31T23:59:59Z", false},
+		{june, "2017-06-01T00:00:00Z", true},
+		{june, "2017-07-01T00:00:00Z", false},
+	}
+	for idx, c := range cases {
+		at, _ := time.Parse(time.RFC3339, c.at)
+		if got := c.s.active(at); got != c.want {
+			t.Errorf("[%d] active(%s) = %t; wanted %t", idx, c.at, got, c.want)
+		}
+	}
+}
+
+func TestScheduledRules_Errors(t *testing.T) {
+	cases := []struct {
+		cfg string
+		err []string
+	}{
+		{"not: [yaml", []string{"ServiceConfig"}},
+		{`
+rules:
+- selector: true
+  schedule:
+    start: yesterday
+    end: 2017-07-01T00:00:00Z
+    timeZone: Mars/Olympus_Mons
+`, []string{"Rules[0].Schedule.Start", "Rules[0].Schedule.TimeZone"}},
+		{`
+rules:
+- selector: true
+  schedule:
+    start: 2017-07-01T00:00:00Z
+    end: 2017-06-01T00:00:00Z
+`, []string{"Rules[0].Schedule.End"}},
+		{`
+rules:
+- selector: true
+- selector: true
+  rules:
+  - selector: true
+    schedule:
+      windows:
+      - cron: "0 2 * *"
+        duration: -1h
+      - cron: "0 2 * * *"
+`, []string{"Rules[1].Rules[0].Schedule.Windows[0].Cron", "Rules[1].Rules[0].Schedule.Windows[0].Duration",
+			"Rules[1].Rules[0].Schedule.Windows[1].Duration"}},
+	}
+
+	for idx, c := range cases {
+		m := &pb.ServiceConfig{}
+		_ = yaml.Unmarshal([]byte(c.cfg), m)
+		_, ce := scheduledRules(c.cfg, m.Rules)
+		if ce == nil {
+			t.Errorf("[%d] scheduledRules() = _, nil; wanted errors about %v", idx, c.err)
+			continue
+		}
+		for _, field := range c.err {
+			if !strings.Contains(ce.Error(), field) {
+				t.Errorf("[%d] scheduledRules() = _, %v; wanted error about %s", idx, ce, field)
+			}
+		}
+	}
+}
+
+func TestRuntime_Schedule(t *testing.T) {
+	m := &pb.ServiceConfig{}
+	if err := yaml.Unmarshal([]byte(sScheduledSvcConfig), m); err != nil {
+		t.Fatalf("Unable to unmarshal service config: %v", err)
+	}
+	schedules, _ := scheduledRules(sScheduledSvcConfig, m.Rules)
+
+	v := &Validated{
+		adapterByName: map[adapterKey]*pb.Adapter{},
+		serviceConfig: m,
+		schedules:     schedules,
+	}
+	rt := NewRuntime(v, &trueEval{ret: true})
+	rt.now = func() time.Time { return time.Date(2017, 6, 3, 10, 0, 0, 0, time.UTC) }
+
+	cases := []struct {
+		requestTime interface{}
+		want        []string
+	}{
+		{nil, []string{"always", "maintenance", "june"}},
+		{time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC), []string{"always", "june"}},
+		{time.Date(2017, 8, 7, 10, 0, 0, 0, time.UTC), []string{"always"}},
+		// request times of the wrong type are ignored
+		{"2017-08-07T10:00:00Z", []string{"always", "maintenance", "june"}},
+	}
+
+	for idx, c := range cases {
+		bag := attribute.GetMutableBag(nil)
+		if c.requestTime != nil {
+			bag.Set(requestTimeAttribute, c.requestTime)
+		}
+
+		al, err := rt.Resolve(bag, AspectSet{"denials": true})
+		if err != nil {
+			t.Fatalf("[%d] Resolve() = _, %v; wanted no err", idx, err)
+		}
+		var got []string
+		for _, c := range al {
+			got = append(got, c.Aspect.Adapter)
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("[%d] Resolve() selected %v; wanted %v", idx, got, c.want)
+		}
+	}
+}
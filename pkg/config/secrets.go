@@ -0,0 +1,216 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// The string values of adapter params may refer to secrets kept out of the
+// global config, which are resolved before the params are converted:
+//
+//	adapters:
+//	- name: default
+//	  kind: metrics
+//	  impl: statsd
+//	  params:
+//	    apiKey: ${env:STATSD_API_KEY}           # an environment variable
+//	    password: ${file:/etc/mixer/statsd.pw}  # the content of a file
+//	    url: https://mixer:${env:PW}@host/      # references may be part of a string
+//
+// Trailing newlines are removed from the content of files. Files are watched
+// for changes, which reload the config. Resolved values are redacted from
+// the errors reported by the config manager and from the logs of configs,
+// unless they are shorter than 4 characters.
+const (
+	secretEnv  = "env"
+	secretFile = "file"
+
+	redacted = "<redacted>"
+
+	// minSecretLen is the length below which values aren't redacted, replacing
+	// them would mangle the output without protecting anything.
+	minSecretLen = 4
+)
+
+var secretRef = regexp.MustCompile(`\$\{([A-Za-z]+):([^}]*)\}`)
+
+// secretResolver resolves the secret references of adapter params.
+type secretResolver struct {
+	lookupEnv func(name string) (string, bool)
+	readFile  func(path string) ([]byte, error)
+	// files holds the digests of the files read, by path.
+	files map[string][sha1.Size]byte
+}
+
+func newSecretResolver() *secretResolver {
+	return &secretResolver{
+		lookupEnv: os.LookupEnv,
+		readFile:  ioutil.ReadFile,
+		files:     make(map[string][sha1.Size]byte),
+	}
+}
+
+// resolve returns a copy of params with the secret references of its strings replaced
+// by their values. Errors are reported against the path of the strings within params.
+func (s *secretResolver) resolve(params interface{}) (interface{}, *adapter.ConfigErrors) {
+	var ce *adapter.ConfigErrors
+	v := s.resolveValue(params, "", &ce)
+	return v, ce
+}
+
+func (s *secretResolver) resolveValue(v interface{}, path string, ce **adapter.ConfigErrors) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			m[k] = s.resolveValue(e, joinField(path, k), ce)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(vv))
+		for i, e := range vv {
+			l[i] = s.resolveValue(e, fmt.Sprintf("%s[%d]", path, i), ce)
+		}
+		return l
+	case string:
+		if !strings.Contains(vv, "${") {
+			return vv
+		}
+		return secretRef.ReplaceAllStringFunc(vv, func(ref string) string {
+			m := secretRef.FindStringSubmatch(ref)
+			val, err := s.lookup(m[1], m[2])
+			if err != nil {
+				*ce = (*ce).Append(path, err)
+				return ref
+			}
+			registerSecret(val)
+			return val
+		})
+	}
+	return v
+}
+
+// lookup returns the value of the secret of the given kind and name.
+func (s *secretResolver) lookup(kind string, name string) (string, error) {
+	switch kind {
+	case secretEnv:
+		val, found := s.lookupEnv(name)
+		if !found {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return val, nil
+	case secretFile:
+		data, err := s.readFile(name)
+		if err != nil {
+			return "", err
+		}
+		s.files[name] = sha1.Sum(data)
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return "", fmt.Errorf("unknown kind of secret '%s' in ${%s:%s}, supported kinds are %s and %s",
+		kind, kind, name, secretEnv, secretFile)
+}
+
+func joinField(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// secretFilesChanged returns true if any of files no longer has the digest it had when read.
+func secretFilesChanged(files map[string][sha1.Size]byte, readFile func(string) ([]byte, error)) bool {
+	for path, sha := range secretFileDigests(files, readFile) {
+		if sha != files[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// secretFileDigests returns the current digests of files, unreadable files having an empty one.
+func secretFileDigests(files map[string][sha1.Size]byte, readFile func(string) ([]byte, error)) map[string][sha1.Size]byte {
+	digests := make(map[string][sha1.Size]byte, len(files))
+	for path := range files {
+		var sha [sha1.Size]byte
+		if data, err := readFile(path); err == nil {
+			sha = sha1.Sum(data)
+		}
+		digests[path] = sha
+	}
+	return digests
+}
+
+// secrets holds every secret value resolved by the process. Values are kept
+// once their config is replaced, they may still be valid credentials.
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+func registerSecret(val string) {
+	if len(val) < minSecretLen {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range secrets.values {
+		if v == val {
+			return
+		}
+	}
+	// longest first, so that secrets containing others are redacted whole
+	i := 0
+	for i < len(secrets.values) && len(secrets.values[i]) >= len(val) {
+		i++
+	}
+	secrets.values = append(secrets.values, "")
+	copy(secrets.values[i+1:], secrets.values[i:])
+	secrets.values[i] = val
+}
+
+// Redact returns s with the values of the secrets referred to by adapter params
+// replaced. It should be applied to anything logged or served that may include
+// adapter params.
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, v := range secrets.values {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	return s
+}
+
+// redactError returns err, or an error with its message redacted if it includes a secret.
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if r := Redact(msg); r != msg {
+		return errors.New(r)
+	}
+	return err
+}
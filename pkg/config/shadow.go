@@ -0,0 +1,58 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"github.com/ghodss/yaml"
+
+	pb "istio.io/mixer/pkg/config/proto"
+)
+
+// shadowRules mirrors the rules of a service config to pick up the shadow
+// setting of aspects, which pb.Aspect doesn't carry. An aspect in shadow mode
+// runs as usual but its result never fails the request.
+type shadowRules struct {
+	Aspects []struct {
+		Shadow bool `json:"shadow"`
+	} `json:"aspects"`
+	Rules []*shadowRules `json:"rules"`
+}
+
+// shadowedAspects returns the aspects of rules in shadow mode, given the
+// service config cfg the rules were decoded from.
+func shadowedAspects(cfg string, rules []*pb.AspectRule) (map[*pb.Aspect]bool, error) {
+	m := &shadowRules{}
+	if err := yaml.Unmarshal([]byte(cfg), m); err != nil {
+		return nil, err
+	}
+
+	shadowed := make(map[*pb.Aspect]bool)
+	collectShadowed(m.Rules, rules, shadowed)
+	return shadowed, nil
+}
+
+func collectShadowed(srs []*shadowRules, rules []*pb.AspectRule, shadowed map[*pb.Aspect]bool) {
+	for i, rule := range rules {
+		if i >= len(srs) || srs[i] == nil {
+			return
+		}
+		for j, aa := range rule.GetAspects() {
+			if j < len(srs[i].Aspects) && srs[i].Aspects[j].Shadow {
+				shadowed[aa] = true
+			}
+		}
+		collectShadowed(srs[i].Rules, rule.GetRules(), shadowed)
+	}
+}
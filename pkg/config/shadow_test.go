@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/mixer/pkg/attribute"
+	pb "istio.io/mixer/pkg/config/proto"
+)
+
+const sShadowSvcConfig = `
+subject: namespace:ns
+revision: "2022"
+rules:
+- selector: true
+  aspects:
+  - kind: lists
+    adapter: enforced
+  - kind: lists
+    adapter: candidate
+    shadow: true
+  rules:
+  - selector: true
+    aspects:
+    - kind: denials
+      shadow: true
+`
+
+func TestShadowedAspects(t *testing.T) {
+	m := &pb.ServiceConfig{}
+	if err := yaml.Unmarshal([]byte(sShadowSvcConfig), m); err != nil {
+		t.Fatalf("Unable to unmarshal service config: %v", err)
+	}
+
+	shadowed, err := shadowedAspects(sShadowSvcConfig, m.Rules)
+	if err != nil {
+		t.Fatalf("shadowedAspects() = _, %v; wanted no err", err)
+	}
+
+	aspects := m.Rules[0].Aspects
+	nested := m.Rules[0].Rules[0].Aspects
+	if len(shadowed) != 2 || shadowed[aspects[0]] || !shadowed[aspects[1]] || !shadowed[nested[0]] {
+		t.Errorf("shadowedAspects() = %v; wanted the candidate list checker and the nested denier", shadowed)
+	}
+
+	if _, err = shadowedAspects("not: [yaml", m.Rules); err == nil {
+		t.Error("shadowedAspects() = _, nil; wanted err for invalid yaml")
+	}
+}
+
+func TestRuntime_Shadow(t *testing.T) {
+	m := &pb.ServiceConfig{}
+	if err := yaml.Unmarshal([]byte(sShadowSvcConfig), m); err != nil {
+		t.Fatalf("Unable to unmarshal service config: %v", err)
+	}
+	shadowed, _ := shadowedAspects(sShadowSvcConfig, m.Rules)
+
+	v := &Validated{
+		adapterByName: map[adapterKey]*pb.Adapter{},
+		serviceConfig: m,
+		shadowed:      shadowed,
+	}
+	rt := NewRuntime(v, &trueEval{ret: true})
+
+	al, err := rt.Resolve(attribute.GetMutableBag(nil), AspectSet{"lists": true, "denials": true})
+	if err != nil {
+		t.Fatalf("Resolve() = _, %v; wanted no err", err)
+	}
+
+	want := []bool{false, true, true}
+	if len(al) != len(want) {
+		t.Fatalf("Resolve() returned %d aspects; wanted %d", len(al), len(want))
+	}
+	for idx, c := range al {
+		if c.Shadow != want[idx] {
+			t.Errorf("[%d] %s: Shadow = %t; wanted %t", idx, c, c.Shadow, want[idx])
+		}
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+type (
+	// sourceMap records where the nodes of a YAML config are, so that config
+	// errors can point at the offending line. The YAML decoder doesn't report
+	// positions, so the block structure of the document is scanned line by line.
+	// Nodes are keyed by paths such as rules[0].aspects[1].params.url, with keys
+	// lower cased and stripped of underscores so that Go field names, JSON names
+	// and proto field names of a node all find it. Nodes within flow collections
+	// ({...} and [...]) aren't recorded, errors about them are located at the
+	// closest recorded ancestor.
+	sourceMap struct {
+		file  string
+		nodes map[string]position
+	}
+
+	position struct {
+		line, column int
+	}
+
+	// yamlScanner builds a sourceMap from the lines of a document.
+	yamlScanner struct {
+		nodes map[string]position
+		// stack holds the mappings and sequences enclosing the current line.
+		stack []*container
+		// pending is the node whose value starts on the next line, if any.
+		pending *pendingNode
+		// lines indented past scalarColumn belong to a block scalar, when >= 0.
+		scalarColumn int
+		// flowDepth is the nesting of the multi-line flow collection being skipped.
+		flowDepth int
+	}
+
+	container struct {
+		column int // column of the keys or dashes, -1 until known
+		path   string
+		seq    bool
+		items  int
+	}
+
+	pendingNode struct {
+		path   string
+		column int
+		// a key's sequence value may start at the key's column
+		key bool
+	}
+)
+
+var (
+	yamlErrorLine    = regexp.MustCompile(`line (\d+)`)
+	unknownFieldName = regexp.MustCompile(`unknown field "([^"]+)"`)
+)
+
+// newSourceMap scans the YAML document src read from file.
+func newSourceMap(file string, src string) *sourceMap {
+	sc := &yamlScanner{
+		nodes:        make(map[string]position),
+		stack:        []*container{{column: -1}},
+		scalarColumn: -1,
+	}
+	for i, line := range strings.Split(src, "\n") {
+		sc.scan(i+1, strings.TrimRight(line, " \t\r"))
+	}
+	return &sourceMap{file: file, nodes: sc.nodes}
+}
+
+func (sc *yamlScanner) scan(line int, text string) {
+	trimmed := strings.TrimLeft(text, " ")
+	column := len(text) - len(trimmed)
+
+	if sc.flowDepth > 0 {
+		sc.flowDepth += flowBalance(trimmed)
+		return
+	}
+	if trimmed == "" || trimmed[0] == '#' {
+		return
+	}
+	if sc.scalarColumn >= 0 {
+		if column > sc.scalarColumn {
+			return
+		}
+		sc.scalarColumn = -1
+	}
+	if trimmed == "---" || trimmed == "..." {
+		return
+	}
+
+	if p := sc.pending; p != nil {
+		sc.pending = nil
+		if column > p.column || (column == p.column && p.key && isSeqItem(trimmed)) {
+			sc.stack = append(sc.stack, &container{column: column, path: p.path, seq: isSeqItem(trimmed)})
+		}
+	}
+	for len(sc.stack) > 1 && (sc.top().column > column ||
+		// a sequence may be indented as much as the key it's the value of
+		(sc.top().column == column && sc.top().seq && !isSeqItem(trimmed))) {
+		sc.stack = sc.stack[:len(sc.stack)-1]
+	}
+	top := sc.top()
+	if top.column < 0 {
+		top.column = column
+		top.seq = isSeqItem(trimmed)
+	}
+	if top.column != column {
+		// continuation of a multi-line scalar
+		return
+	}
+
+	// a line may hold several nodes, as in "- name: value"
+	for {
+		if isSeqItem(trimmed) {
+			if !top.seq {
+				return
+			}
+			path := fmt.Sprintf("%s[%d]", top.path, top.items)
+			top.items++
+			rest := skipProperties(strings.TrimLeft(trimmed[1:], " "))
+			if rest == "" || rest[0] == '#' {
+				sc.nodes[path] = position{line, column + 1}
+				sc.pending = &pendingNode{path: path, column: column}
+				return
+			}
+			column += len(trimmed) - len(rest)
+			sc.nodes[path] = position{line, column + 1}
+			if _, _, isKey := splitKey(rest); !isKey && !isSeqItem(rest) {
+				sc.value(rest, column)
+				return
+			}
+			top = &container{column: column, path: path, seq: isSeqItem(rest)}
+			sc.stack = append(sc.stack, top)
+			trimmed = rest
+			continue
+		}
+
+		key, rest, isKey := splitKey(trimmed)
+		if !isKey || top.seq {
+			return
+		}
+		path := joinPath(top.path, key)
+		sc.nodes[path] = position{line, column + 1}
+		if rest = skipProperties(rest); rest == "" || rest[0] == '#' {
+			sc.pending = &pendingNode{path: path, column: column, key: true}
+			return
+		}
+		sc.value(rest, column)
+		return
+	}
+}
+
+func (sc *yamlScanner) top() *container {
+	return sc.stack[len(sc.stack)-1]
+}
+
+// value notes the scalars and flow collections spanning the lines that follow
+// a node at column, so that they don't get mistaken for nodes.
+func (sc *yamlScanner) value(v string, column int) {
+	switch v[0] {
+	case '|', '>':
+		sc.scalarColumn = column
+	case '{', '[':
+		if d := flowBalance(v); d > 0 {
+			sc.flowDepth = d
+		}
+	}
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// skipProperties skips the anchor and tag a node's value may start with.
+func skipProperties(v string) string {
+	for len(v) > 0 && (v[0] == '&' || v[0] == '!') {
+		i := strings.IndexByte(v, ' ')
+		if i < 0 {
+			return ""
+		}
+		v = strings.TrimLeft(v[i:], " ")
+	}
+	return v
+}
+
+// splitKey splits a mapping entry into its key and value.
+func splitKey(text string) (key string, value string, ok bool) {
+	end := -1
+	switch text[0] {
+	case '"', '\'':
+		for i := 1; i < len(text); i++ {
+			if text[i] == '\\' && text[0] == '"' {
+				i++
+			} else if text[i] == text[0] {
+				key, end = text[1:i], i+1
+				break
+			}
+		}
+		if end < 0 {
+			return "", "", false
+		}
+		rest := strings.TrimLeft(text[end:], " ")
+		if rest == "" || rest[0] != ':' || (len(rest) > 1 && rest[1] != ' ') {
+			return "", "", false
+		}
+		return key, strings.TrimLeft(rest[1:], " "), true
+	case '{', '[', '#', '?', '|', '>', '&', '!', '*':
+		return "", "", false
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == ' ' && i+1 < len(text) && text[i+1] == '#':
+			return "", "", false
+		case text[i] == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			return strings.TrimRight(text[:i], " "), strings.TrimLeft(text[i+1:], " "), true
+		}
+	}
+	return "", "", false
+}
+
+// flowBalance returns the number of flow collections text opens but doesn't close.
+func flowBalance(text string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return depth
+		}
+	}
+	return depth
+}
+
+func joinPath(parent string, key string) string {
+	key = normalizePath(key)
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func normalizePath(path string) string {
+	return strings.ToLower(strings.Replace(path, "_", "", -1))
+}
+
+// locate returns the location of the node at path, or of its closest recorded ancestor.
+func (s *sourceMap) locate(path string) string {
+	p := normalizePath(path)
+	for {
+		if pos, found := s.nodes[p]; found {
+			if s.file == "" {
+				return fmt.Sprintf("%d:%d", pos.line, pos.column)
+			}
+			return fmt.Sprintf("%s:%d:%d", s.file, pos.line, pos.column)
+		}
+		i := strings.LastIndexAny(p, ".[")
+		if i < 0 {
+			return s.file
+		}
+		p = p[:i]
+	}
+}
+
+// locateYAMLError returns the location of a YAML syntax error.
+func (s *sourceMap) locateYAMLError(err error) string {
+	m := yamlErrorLine.FindStringSubmatch(err.Error())
+	if m == nil {
+		return s.file
+	}
+	if s.file == "" {
+		return m[1]
+	}
+	return s.file + ":" + m[1]
+}
+
+// append appends an error about field to ce, located at the node at path.
+// The errors adapters and aspects report about their params are located
+// relative to path too.
+func (s *sourceMap) append(ce *adapter.ConfigErrors, path string, field string, err error) *adapter.ConfigErrors {
+	at := path
+	if verr, ok := err.(*adapter.ConfigErrors); ok {
+		s.locateFields(verr, path)
+	} else if m := unknownFieldName.FindStringSubmatch(err.Error()); m != nil {
+		at = joinPath(path, m[1])
+	}
+	return locateLast(ce.Append(field, err), s.locate(at))
+}
+
+// locateFields sets the location of the errors of ce lacking one, taking their
+// field to be the path of a node relative to path.
+func (s *sourceMap) locateFields(ce *adapter.ConfigErrors, path string) {
+	if ce == nil || ce.Multi == nil {
+		return
+	}
+	for i, err := range ce.Multi.Errors {
+		if e, ok := err.(adapter.ConfigError); ok && e.Location == "" {
+			e.Location = s.locate(joinPath(path, e.Field))
+			ce.Multi.Errors[i] = e
+		}
+	}
+}
+
+// locateLast sets the location of the error last appended to ce.
+func locateLast(ce *adapter.ConfigErrors, location string) *adapter.ConfigErrors {
+	last := len(ce.Multi.Errors) - 1
+	if e, ok := ce.Multi.Errors[last].(adapter.ConfigError); ok {
+		e.Location = location
+		ce.Multi.Errors[last] = e
+	}
+	return ce
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+const sourceMapConfig = `# leading comment
+subject: namespace:ns
+revision: "2022"
+rules:
+- selector: service.name == "*"
+  aspects:
+  - kind: metrics
+    adapter: prometheus
+    params:
+      metrics:
+      - name: request_count
+        labels: {source: source.name,
+          target: target.name}
+        description: |
+          requests: counted
+          by: source
+      - name: "response_time"
+        value_type: DURATION
+    - kind: ignored
+  rules:
+    - selector: true
+      "quoted key": &anchor
+        nested: 1
+      aspects:
+      -
+        kind: lists
+      - - inner
+        - second
+  schedule:
+    timeZone: UTC
+`
+
+func TestSourceMap_Locate(t *testing.T) {
+	s := newSourceMap("service.yml", sourceMapConfig)
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"subject", "service.yml:2:1"},
+		{"rules", "service.yml:4:1"},
+		{"rules[0]", "service.yml:5:3"},
+		{"rules[0].selector", "service.yml:5:3"},
+		{"Rules[0].Aspects[0].Kind", "service.yml:7:5"},
+		{"rules[0].aspects[0].adapter", "service.yml:8:5"},
+		{"rules[0].aspects[0].params.metrics[0].name", "service.yml:11:9"},
+		// flow collections and block scalars are located at their key
+		{"rules[0].aspects[0].params.metrics[0].labels.target", "service.yml:12:9"},
+		{"rules[0].aspects[0].params.metrics[0].description", "service.yml:14:9"},
+		{"rules[0].aspects[0].params.metrics[1].name", "service.yml:17:9"},
+		{"rules[0].aspects[0].params.metrics[1].valueType", "service.yml:18:9"},
+		{"rules[0].aspects[0].params.metrics[1].value_type", "service.yml:18:9"},
+		{"rules[0].aspects[0].params.metrics[2]", "service.yml:10:7"},
+		{"rules[0].rules[0].selector", "service.yml:21:7"},
+		{"rules[0].rules[0].quoted key.nested", "service.yml:23:9"},
+		{"rules[0].rules[0].aspects[0]", "service.yml:25:7"},
+		{"rules[0].rules[0].aspects[0].kind", "service.yml:26:9"},
+		{"rules[0].rules[0].aspects[1][1]", "service.yml:28:11"},
+		{"Rules[0].Schedule.TimeZone", "service.yml:30:5"},
+		{"unknown.path", "service.yml"},
+	}
+	for idx, c := range cases {
+		if got := s.locate(c.path); got != c.want {
+			t.Errorf("[%d] locate(%s) = %s; wanted %s", idx, c.path, got, c.want)
+		}
+	}
+
+	if got := newSourceMap("", sourceMapConfig).locate("rules[0]"); got != "5:3" {
+		t.Errorf("locate() = %s; wanted line and column only without a file name", got)
+	}
+}
+
+func TestSourceMap_Append(t *testing.T) {
+	s := newSourceMap("service.yml", sourceMapConfig)
+	params := "rules[0].aspects[0].params"
+
+	var ce *adapter.ConfigErrors
+	ce = s.append(ce, "rules[0].selector", "Selector", errors.New("invalid expression"))
+	ce = s.append(ce, params, "metrics[0]", errors.New(`unknown field "metrics" in config.Params`))
+	var nested *adapter.ConfigErrors
+	nested = nested.Appendf("Metrics[1].Name", "bad name")
+	nested = nested.Appendf("Retries", "no such field in the source")
+	ce = s.append(ce, params, "metrics[0]", nested)
+
+	want := []string{
+		"service.yml:5:3: Selector: invalid expression",
+		`service.yml:10:7: metrics[0]: unknown field "metrics" in config.Params`,
+		"service.yml:9:5: metrics[0]: ",
+	}
+	for idx, w := range want {
+		if got := ce.Multi.Errors[idx].Error(); !strings.HasPrefix(got, w) {
+			t.Errorf("[%d] Error() = %s; wanted %s", idx, got, w)
+		}
+	}
+	// errors reported by validators are located within the params
+	if got := nested.Multi.Errors[0].Error(); got != "service.yml:17:9: Metrics[1].Name: bad name" {
+		t.Errorf("Error() = %s; wanted the field located", got)
+	}
+	if got := nested.Multi.Errors[1].(adapter.ConfigError).Location; got != "service.yml:9:5" {
+		t.Errorf("Location = %s; wanted unknown fields located at their params", got)
+	}
+}
+
+func TestValidator_ErrorLocations(t *testing.T) {
+	vf := newVfinder(map[string]adapter.ConfigValidator{"denyChecker": &lc{}},
+		map[string]AspectValidator{"listchecker": &ac{}})
+	fe := newFakeExpr()
+	fe.err = errors.New("invalid expression")
+	p := NewValidator(vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, true, fe)
+	p.SetFileNames("global.yml", "service.yml")
+
+	// global config errors stop validation, so the service config is validated on its own
+	ce := p.validateGlobalConfig(sGlobalConfig)
+	if ce == nil || !strings.HasPrefix(ce.Multi.Errors[0].Error(), "global.yml:12:7: Adapter: denyChecker:") {
+		t.Errorf("validateGlobalConfig() = %v; wanted the unknown field located", ce)
+	}
+
+	ce = p.validateServiceConfig(sSvcConfig3, true)
+	if ce == nil {
+		t.Fatal("validateServiceConfig() = nil; wanted errors")
+	}
+	want := []string{
+		"service.yml:5:3: :Selector service.name == “*”: invalid expression",
+		"service.yml:10:5: NamedAdapter: listchecker//denychecker.2 not available",
+	}
+	for idx, w := range want {
+		if got := ce.Multi.Errors[idx].Error(); got != w {
+			t.Errorf("[%d] Error() = %s; wanted %s", idx, got, w)
+		}
+	}
+
+	ce = p.validateServiceConfig("rules:\n- selector: [\n", true)
+	if ce == nil || ce.Multi.Errors[0].(adapter.ConfigError).Location != "service.yml:2" {
+		t.Errorf("validateServiceConfig() = %v; wanted the syntax error located at line 2", ce)
+	}
+
+	// pushed configs have no file name, their syntax errors are located by line only
+	pushed := NewValidator(vf.FindAspectValidator, vf.FindAdapterValidator, vf.AdapterToAspectMapperFunc, true, fe)
+	ce = pushed.validateServiceConfig("rules:\n- selector: [\n", true)
+	if ce == nil || ce.Multi.Errors[0].(adapter.ConfigError).Location != "2" {
+		t.Errorf("validateServiceConfig() = %v; wanted the syntax error located at line 2", ce)
+	}
+
+	_, ce = p.Validate(sSvcConfig2, sGlobalConfig)
+	if ce == nil || ce.Multi.Errors[0].Error() != "global.yml: GlobalConfig: failed validation" {
+		t.Errorf("Validate() = %v; wanted the failing file named", ce)
+	}
+}
@@ -26,6 +26,7 @@ package config
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 
@@ -80,6 +81,7 @@ func NewValidator(managerFinder AspectValidatorFinder, adapterFinder AdapterVali
 		strict:        strict,
 		exprValidator: exprValidator,
 		validated:     &Validated{},
+		secrets:       newSecretResolver(),
 	}
 }
 
@@ -92,6 +94,9 @@ type (
 		strict        bool
 		exprValidator expr.Validator
 		validated     *Validated
+		globalFile    string
+		serviceFile   string
+		secrets       *secretResolver
 	}
 
 	adapterKey struct {
@@ -105,6 +110,11 @@ type (
 		globalConfig  *pb.GlobalConfig
 		serviceConfig *pb.ServiceConfig
 		numAspects    int
+		adapterPools  map[string]*PoolConfig
+		shadowed      map[*pb.Aspect]bool
+		schedules     map[*pb.AspectRule]*schedule
+		// digests of the files adapter params refer to, by path
+		secretFiles map[string][sha1.Size]byte
 	}
 )
 
@@ -112,21 +122,36 @@ func (a adapterKey) String() string {
 	return fmt.Sprintf("%s//%s", a.kind, a.name)
 }
 
+// SetFileNames names the files the configs passed to Validate were read from.
+// Errors are located by file, line and column once they are named, and by
+// line and column only otherwise.
+func (p *Validator) SetFileNames(globalFile string, serviceFile string) {
+	p.globalFile = globalFile
+	p.serviceFile = serviceFile
+}
+
 // validateGlobalConfig consumes a yml config string with adapter config.
 // It is validated in presence of validators.
 func (p *Validator) validateGlobalConfig(cfg string) (ce *adapter.ConfigErrors) {
 	var err error
 	m := &pb.GlobalConfig{}
+	src := newSourceMap(p.globalFile, cfg)
 
 	if err = yaml.Unmarshal([]byte(cfg), m); err != nil {
-		ce = ce.Append("AdapterConfig", err)
+		ce = locateLast(ce.Append("AdapterConfig", err), src.locateYAMLError(err))
 		return
 	}
 	p.validated.adapterByName = make(map[adapterKey]*pb.Adapter)
 	var acfg adapter.Config
-	for _, aa := range m.GetAdapters() {
-		if acfg, err = ConvertAdapterParams(p.adapterFinder, aa.Impl, aa.Params, p.strict); err != nil {
-			ce = ce.Append("Adapter: "+aa.Impl, err)
+	for idx, aa := range m.GetAdapters() {
+		paramsPath := fmt.Sprintf("adapters[%d].params", idx)
+		params, se := p.secrets.resolve(aa.Params)
+		if se != nil {
+			ce = src.append(ce, paramsPath, "Adapter: "+aa.Impl, se)
+			continue
+		}
+		if acfg, err = ConvertAdapterParams(p.adapterFinder, aa.Impl, params, p.strict); err != nil {
+			ce = src.append(ce, paramsPath, "Adapter: "+aa.Impl, err)
 			continue
 		}
 		aa.Params = acfg
@@ -137,6 +162,10 @@ func (p *Validator) validateGlobalConfig(cfg string) (ce *adapter.ConfigErrors)
 		}
 	}
 	p.validated.globalConfig = m
+	p.validated.secretFiles = p.secrets.files
+	if pe := p.validateAdapterPools(cfg, src); pe != nil {
+		ce = ce.Extend(pe)
+	}
 	return
 }
 
@@ -150,18 +179,22 @@ func (p *Validator) validateSelector(selector string) (err error) {
 }
 
 // validateAspectRules validates the recursive configuration data structure.
-// It is primarily used by validate ServiceConfig.
-func (p *Validator) validateAspectRules(rules []*pb.AspectRule, path string, validatePresence bool) (ce *adapter.ConfigErrors) {
+// It is primarily used by validate ServiceConfig. The rules are located at
+// srcPath in the source of the config.
+func (p *Validator) validateAspectRules(rules []*pb.AspectRule, path string, validatePresence bool,
+	src *sourceMap, srcPath string) (ce *adapter.ConfigErrors) {
 	var acfg adapter.Config
 	var err error
-	for _, rule := range rules {
+	for ridx, rule := range rules {
+		rulePath := fmt.Sprintf("%s[%d]", srcPath, ridx)
 		if err = p.validateSelector(rule.GetSelector()); err != nil {
-			ce = ce.Append(path+":Selector "+rule.GetSelector(), err)
+			ce = src.append(ce, rulePath+".selector", path+":Selector "+rule.GetSelector(), err)
 		}
 		path = path + "/" + rule.GetSelector()
 		for idx, aa := range rule.GetAspects() {
+			aspectPath := fmt.Sprintf("%s.aspects[%d]", rulePath, idx)
 			if acfg, err = ConvertAspectParams(p.managerFinder, aa.Kind, aa.GetParams(), p.strict); err != nil {
-				ce = ce.Append(fmt.Sprintf("%s:%s[%d]", path, aa.Kind, idx), err)
+				ce = src.append(ce, aspectPath+".params", fmt.Sprintf("%s:%s[%d]", path, aa.Kind, idx), err)
 				continue
 			}
 			aa.Params = acfg
@@ -173,7 +206,7 @@ func (p *Validator) validateAspectRules(rules []*pb.AspectRule, path string, val
 				// ensure that aa.Kind has a registered adapter
 				ak := adapterKey{aa.Kind, aa.Adapter}
 				if p.validated.adapterByName[ak] == nil {
-					ce = ce.Appendf("NamedAdapter", "%s not available", ak)
+					ce = src.append(ce, aspectPath+".adapter", "NamedAdapter", fmt.Errorf("%s not available", ak))
 				}
 			}
 		}
@@ -181,7 +214,7 @@ func (p *Validator) validateAspectRules(rules []*pb.AspectRule, path string, val
 		if len(rs) == 0 {
 			continue
 		}
-		if verr := p.validateAspectRules(rs, path, validatePresence); verr != nil {
+		if verr := p.validateAspectRules(rs, path, validatePresence, src, rulePath+".rules"); verr != nil {
 			ce = ce.Extend(verr)
 		}
 	}
@@ -193,13 +226,13 @@ func (p *Validator) validateAspectRules(rules []*pb.AspectRule, path string, val
 func (p *Validator) Validate(serviceCfg string, globalCfg string) (rt *Validated, ce *adapter.ConfigErrors) {
 	var cerr *adapter.ConfigErrors
 	if re := p.validateGlobalConfig(globalCfg); re != nil {
-		cerr = ce.Appendf("GlobalConfig", "failed validation")
+		cerr = locateLast(ce.Appendf("GlobalConfig", "failed validation"), p.globalFile)
 		return rt, cerr.Extend(re)
 	}
 	// The order is important here, because serviceConfig refers to global config
 
 	if re := p.validateServiceConfig(serviceCfg, true); re != nil {
-		cerr = ce.Appendf("ServiceConfig", "failed validation")
+		cerr = locateLast(ce.Appendf("ServiceConfig", "failed validation"), p.serviceFile)
 		return rt, cerr.Extend(re)
 	}
 
@@ -212,11 +245,24 @@ func (p *Validator) Validate(serviceCfg string, globalCfg string) (rt *Validated
 func (p *Validator) validateServiceConfig(cfg string, validatePresence bool) (ce *adapter.ConfigErrors) {
 	var err error
 	m := &pb.ServiceConfig{}
+	src := newSourceMap(p.serviceFile, cfg)
+	// the rest of the validation sees the definitions rules refer to in place of the references
+	if cfg, ce = expandAspectRefs(cfg, src); ce != nil {
+		return ce
+	}
 	if err = yaml.Unmarshal([]byte(cfg), m); err != nil {
-		ce = ce.Append("ServiceConfig", err)
+		ce = locateLast(ce.Append("ServiceConfig", err), src.locateYAMLError(err))
 		return
 	}
-	if ce = p.validateAspectRules(m.GetRules(), "", validatePresence); ce != nil {
+	if ce = p.validateAspectRules(m.GetRules(), "", validatePresence, src, "rules"); ce != nil {
+		return ce
+	}
+	if p.validated.shadowed, err = shadowedAspects(cfg, m.GetRules()); err != nil {
+		return locateLast(ce.Append("ServiceConfig", err), src.locateYAMLError(err))
+	}
+	if p.validated.schedules, ce = scheduledRules(cfg, m.GetRules()); ce != nil {
+		// schedule errors are reported against the paths of their fields
+		src.locateFields(ce, "")
 		return ce
 	}
 	p.validated.serviceConfig = m
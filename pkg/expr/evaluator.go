@@ -42,4 +42,11 @@ type (
 		// Validate ensures that the given expression is syntactically correct
 		Validate(expr string) error
 	}
+
+	// PredicateAnalyzer is implemented by evaluators able to tell which
+	// attribute value a predicate requires, so that callers can index predicates.
+	PredicateAnalyzer interface {
+		// EqualityGuard returns the equality guard of a predicate, if it has one.
+		EqualityGuard(expr string) (EqualityGuard, bool)
+	}
 )
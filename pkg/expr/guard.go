@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expr
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// EqualityGuard is an equality between an attribute and a string constant
+// that a predicate evaluates before anything else, such as target.service == "a"
+// in target.service == "a" && source.name == "b".
+//
+// When the attribute is in a bag, the predicate evaluates to false if the
+// attribute isn't the string Value, and to what Residual evaluates to
+// otherwise. When the attribute isn't in a bag, the predicate has to be
+// evaluated as usual.
+type EqualityGuard struct {
+	Attribute string
+	Value     string
+	// Residual is empty when the predicate is the equality alone.
+	Residual string
+}
+
+// EqualityGuard returns the equality guard of a predicate, if it has one.
+func (e *cexl) EqualityGuard(src string) (EqualityGuard, bool) {
+	// predicates that don't parse fail on every evaluation, they aren't guarded
+	if _, err := Parse(src); err != nil {
+		return EqualityGuard{}, false
+	}
+	fset := token.NewFileSet()
+	ex, err := parser.ParseExprFrom(fset, "", src, 0)
+	if err != nil {
+		return EqualityGuard{}, false
+	}
+
+	// the first operand evaluated is the leftmost one of a chain of &&
+	var first ast.Expr = ex
+	var rest ast.Expr
+	for {
+		b, isAnd := first.(*ast.BinaryExpr)
+		if !isAnd || b.Op != token.LAND {
+			break
+		}
+		first, rest = b.X, b.Y
+	}
+
+	eq, isEq := first.(*ast.BinaryExpr)
+	if !isEq || eq.Op != token.EQL {
+		return EqualityGuard{}, false
+	}
+	attr, isAttr := attributeName(eq.X)
+	lit, isLit := eq.Y.(*ast.BasicLit)
+	if !isAttr || !isLit || lit.Kind != token.STRING {
+		return EqualityGuard{}, false
+	}
+	value, err := strconv.Unquote(lit.Value)
+	// the equality of strings matches prefixes and suffixes when they start or end with *
+	if err != nil || strings.HasPrefix(value, "*") || strings.HasSuffix(value, "*") {
+		return EqualityGuard{}, false
+	}
+
+	g := EqualityGuard{Attribute: attr, Value: value}
+	if rest != nil {
+		// Residual keeps the operands of the chain following the equality. The
+		// leading true preserves how && treats an operand that isn't a bool.
+		g.Residual = "true && " + src[fset.Position(rest.Pos()).Offset:]
+	}
+	return g, true
+}
+
+// attributeName returns the name of the attribute ex refers to, if it is an attribute.
+func attributeName(ex ast.Expr) (string, bool) {
+	switch v := ex.(type) {
+	case *ast.Ident:
+		lv := strings.ToLower(v.Name)
+		return v.Name, lv != "true" && lv != "false"
+	case *ast.SelectorExpr:
+		var w []string
+		if err := processSelectorExpr(v, &w); err != nil {
+			return "", false
+		}
+		for i, j := 0, len(w)-1; i < j; i, j = i+1, j-1 {
+			w[i], w[j] = w[j], w[i]
+		}
+		return strings.Join(w, "."), true
+	}
+	return "", false
+}
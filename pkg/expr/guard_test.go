@@ -0,0 +1,93 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expr
+
+import (
+	"fmt"
+	"testing"
+
+	"istio.io/mixer/pkg/attribute"
+)
+
+func TestEqualityGuard(tt *testing.T) {
+	cases := []struct {
+		src  string
+		ok   bool
+		want EqualityGuard
+	}{
+		{`target.service == "a"`, true, EqualityGuard{"target.service", "a", ""}},
+		{`svc == "a" && src == "b"`, true, EqualityGuard{"svc", "a", `true && src == "b"`}},
+		{`svc == "a" && src == "b" && (n == "c" || n == "d")`, true,
+			EqualityGuard{"svc", "a", `true && src == "b" && (n == "c" || n == "d")`}},
+		{`svc == "a" && name`, true, EqualityGuard{"svc", "a", `true && name`}},
+		{`svc == "a" || src == "b"`, false, EqualityGuard{}},
+		{`(svc == "a" && src == "b") && n == "c"`, false, EqualityGuard{}},
+		{`src == "b" && svc == "a"`, true, EqualityGuard{"src", "b", `true && svc == "a"`}},
+		{`"a" == svc`, false, EqualityGuard{}},
+		{`svc == "a*"`, false, EqualityGuard{}},
+		{`svc == "*.a"`, false, EqualityGuard{}},
+		{`svc == 2`, false, EqualityGuard{}},
+		{`svc != "a"`, false, EqualityGuard{}},
+		{`true == "a"`, false, EqualityGuard{}},
+		{`svc == "a" && `, false, EqualityGuard{}},
+		{`true`, false, EqualityGuard{}},
+		{`svc == "a" && x["k"]`, true, EqualityGuard{"svc", "a", `true && x["k"]`}},
+	}
+
+	bags := []map[string]interface{}{
+		{"svc": "a", "src": "b", "n": "c", "name": "x", "target.service": "a"},
+		{"svc": "a", "src": "x", "n": "d", "name": false, "target.service": "b"},
+		{"svc": "b", "src": "b", "n": "c", "name": "x", "target.service": "*"},
+		{"svc": int64(1), "src": "b", "target.service": "a*"},
+		{"svc": "a", "target.service": "a"},
+	}
+
+	ev := NewCEXLEvaluator()
+	for idx, c := range cases {
+		tt.Run(fmt.Sprintf("[%d] %s", idx, c.src), func(t *testing.T) {
+			g, ok := ev.(PredicateAnalyzer).EqualityGuard(c.src)
+			if ok != c.ok || g != c.want {
+				t.Fatalf("EqualityGuard() = %#v, %t; wanted %#v, %t", g, ok, c.want, c.ok)
+			}
+			if !ok {
+				return
+			}
+			// the guard and its residual evaluate as the predicate does
+			for bidx, values := range bags {
+				bag := attribute.GetMutableBag(nil)
+				for k, v := range values {
+					bag.Set(k, v)
+				}
+				want, wantErr := ev.EvalPredicate(c.src, bag)
+
+				var got bool
+				var err error
+				if v, found := bag.Get(g.Attribute); found {
+					if s, isString := v.(string); isString && s == g.Value {
+						got = true
+						if g.Residual != "" {
+							got, err = ev.EvalPredicate(g.Residual, bag)
+						}
+					}
+				} else {
+					got, err = ev.EvalPredicate(c.src, bag)
+				}
+				if got != want || (err == nil) != (wantErr == nil) {
+					t.Errorf("bag %d: guarded evaluation = %t, %v; wanted %t, %v", bidx, got, err, want, wantErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package monitoring holds the registry of the metrics the mixer reports about
+// its own operation.
+//
+// These metrics are kept apart from the metrics reported on behalf of users
+// by the prometheus adapter, which uses the default prometheus registry.
+package monitoring
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"istio.io/mixer/pkg/pool"
+)
+
+// Namespace is the prefix shared by the names of all of the mixer's own metrics.
+const Namespace = "mixer"
+
+// MetricsPath is the HTTP path on which the metrics are served.
+const MetricsPath = "/metrics"
+
+// Registry holds the mixer's own metrics.
+var Registry = prometheus.NewRegistry()
+
+// MustRegister adds collectors to the registry, panicking on failure.
+// It is meant to be called from package init functions.
+func MustRegister(cs ...prometheus.Collector) {
+	Registry.MustRegister(cs...)
+}
+
+// Handler returns an HTTP handler serving the content of the registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RegisterPool exposes the queue length, worker count and utilization of a goroutine pool under the given name.
+func RegisterPool(name string, gp *pool.GoroutinePool) error {
+	labels := prometheus.Labels{"pool": name}
+
+	queue := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Subsystem:   "pool",
+		Name:        "queue_length",
+		Help:        "Number of work items waiting for a worker.",
+		ConstLabels: labels,
+	}, func() float64 { return float64(gp.QueueLength()) })
+
+	workers := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Subsystem:   "pool",
+		Name:        "workers",
+		Help:        "Number of goroutines in the worker pool.",
+		ConstLabels: labels,
+	}, func() float64 { return float64(gp.Workers()) })
+
+	utilization := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Subsystem:   "pool",
+		Name:        "utilization",
+		Help:        "Fraction of the goroutines in the worker pool that are running work.",
+		ConstLabels: labels,
+	}, func() float64 {
+		w := gp.Workers()
+		if w == 0 {
+			return 0
+		}
+		return float64(gp.Busy()) / float64(w)
+	})
+
+	var registered []prometheus.Collector
+	for _, c := range []prometheus.Collector{queue, workers, utilization} {
+		if err := Registry.Register(c); err != nil {
+			for _, r := range registered {
+				Registry.Unregister(r)
+			}
+			return err
+		}
+		registered = append(registered, c)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitoring
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/pool"
+)
+
+func TestRegisterPool(t *testing.T) {
+	gp := pool.NewGoroutinePool(8, false)
+	gp.AddWorkers(3)
+	defer gp.Close()
+
+	if err := RegisterPool("test", gp); err != nil {
+		t.Fatalf("RegisterPool() failed: %v", err)
+	}
+
+	// the same name can't be registered twice
+	if err := RegisterPool("test", gp); err == nil {
+		t.Error("RegisterPool() succeeded with a duplicate name, expecting failure")
+	}
+
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + MetricsPath)
+	if err != nil {
+		t.Fatalf("Unable to fetch metrics: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, want := range []string{
+		`mixer_pool_workers{pool="test"} 4`,
+		`mixer_pool_queue_length{pool="test"} 0`,
+		`mixer_pool_utilization{pool="test"} 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Metrics don't include '%s':\n%s", want, body)
+		}
+	}
+}
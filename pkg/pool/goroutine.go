@@ -16,16 +16,28 @@ package pool
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // WorkFunc represents a function to invoke from a worker.
 type WorkFunc func()
 
 // GoroutinePool represents a set of reusable goroutines onto which work can be scheduled.
+//
+// The number of goroutines of an adaptive pool varies between a minimum and a maximum: workers
+// are added when work gets queued while none is idle, and retire after being idle for a while.
 type GoroutinePool struct {
 	queue          chan WorkFunc  // Channel providing the work that needs to be executed
 	wg             sync.WaitGroup // Used to block shutdown until all workers complete
 	singleThreaded bool           // Whether to actually use goroutines or not
+	workers        int32          // Number of live worker goroutines, accessed atomically
+	busy           int32          // Number of workers running a function, accessed atomically
+
+	adaptive    bool          // Whether the number of workers adapts to the load
+	minWorkers  int32         // Lower bound on the workers of an adaptive pool, accessed atomically
+	maxWorkers  int32         // Upper bound on the workers of an adaptive pool, accessed atomically
+	idleTimeout time.Duration // How long the workers of an adaptive pool wait for work before retiring
 }
 
 // NewGoroutinePool creates a new pool of goroutines to schedule async work.
@@ -39,6 +51,54 @@ func NewGoroutinePool(queueDepth int, singleThreaded bool) *GoroutinePool {
 	return gp
 }
 
+// NewAdaptiveGoroutinePool creates a new pool of goroutines whose size varies between minWorkers
+// and maxWorkers. Workers beyond the minimum retire after idleTimeout without work, a zero
+// idleTimeout keeps them around forever.
+func NewAdaptiveGoroutinePool(queueDepth int, minWorkers int, maxWorkers int, idleTimeout time.Duration,
+	singleThreaded bool) *GoroutinePool {
+	gp := &GoroutinePool{
+		queue:          make(chan WorkFunc, queueDepth),
+		singleThreaded: singleThreaded,
+		adaptive:       true,
+		idleTimeout:    idleTimeout,
+	}
+
+	gp.SetWorkerLimits(minWorkers, maxWorkers)
+	return gp
+}
+
+// SetWorkerLimits changes the bounds on the number of workers of an adaptive pool, starting
+// workers as needed to reach the new minimum. It has no effect on other pools.
+func (gp *GoroutinePool) SetWorkerLimits(minWorkers int, maxWorkers int) {
+	if !gp.adaptive {
+		return
+	}
+
+	if minWorkers < 1 {
+		minWorkers = 1
+	}
+	if maxWorkers < minWorkers {
+		maxWorkers = minWorkers
+	}
+
+	atomic.StoreInt32(&gp.minWorkers, int32(minWorkers))
+	atomic.StoreInt32(&gp.maxWorkers, int32(maxWorkers))
+
+	if gp.singleThreaded {
+		return
+	}
+
+	for {
+		w := atomic.LoadInt32(&gp.workers)
+		if w >= int32(minWorkers) {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&gp.workers, w, w+1) {
+			gp.startWorker()
+		}
+	}
+}
+
 // Close waits for all goroutines to terminate
 func (gp *GoroutinePool) Close() {
 	if !gp.singleThreaded {
@@ -53,21 +113,131 @@ func (gp *GoroutinePool) ScheduleWork(fn WorkFunc) {
 		fn()
 	} else {
 		gp.queue <- fn
+		gp.grow()
+	}
+}
+
+// TrySchedule registers the given function to be executed at some point, unless the
+// work queue is full in which case it returns false without blocking.
+func (gp *GoroutinePool) TrySchedule(fn WorkFunc) bool {
+	if gp.singleThreaded {
+		fn()
+		return true
+	}
+
+	select {
+	case gp.queue <- fn:
+		gp.grow()
+		return true
+	default:
+		return false
 	}
 }
 
 // AddWorkers introduces more goroutines in the worker pool, increasing potential parallelism.
 func (gp *GoroutinePool) AddWorkers(numWorkers int) {
 	if !gp.singleThreaded {
-		gp.wg.Add(numWorkers)
+		atomic.AddInt32(&gp.workers, int32(numWorkers))
 		for i := 0; i < numWorkers; i++ {
-			go func() {
-				for fn := range gp.queue {
-					fn()
-				}
+			gp.startWorker()
+		}
+	}
+}
+
+// grow adds a worker to an adaptive pool when there is more queued work than idle workers.
+func (gp *GoroutinePool) grow() {
+	if !gp.adaptive {
+		return
+	}
+
+	for {
+		w := atomic.LoadInt32(&gp.workers)
+		if w >= atomic.LoadInt32(&gp.maxWorkers) || w-atomic.LoadInt32(&gp.busy) >= int32(len(gp.queue)) {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&gp.workers, w, w+1) {
+			gp.startWorker()
+			return
+		}
+	}
+}
 
-				gp.wg.Done()
-			}()
+// retire accounts for an idle worker leaving an adaptive pool, unless the pool is at its minimum size.
+func (gp *GoroutinePool) retire() bool {
+	for {
+		w := atomic.LoadInt32(&gp.workers)
+		if w <= atomic.LoadInt32(&gp.minWorkers) {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&gp.workers, w, w-1) {
+			return true
 		}
 	}
 }
+
+// startWorker starts a worker goroutine, which must already be accounted for in gp.workers.
+func (gp *GoroutinePool) startWorker() {
+	gp.wg.Add(1)
+	go func() {
+		defer gp.wg.Done()
+
+		// only the workers of adaptive pools ever time out
+		var timer *time.Timer
+		var idle <-chan time.Time
+		if gp.adaptive && gp.idleTimeout > 0 {
+			timer = time.NewTimer(gp.idleTimeout)
+			defer timer.Stop()
+			idle = timer.C
+		}
+
+		for {
+			select {
+			case fn, ok := <-gp.queue:
+				if !ok {
+					atomic.AddInt32(&gp.workers, -1)
+					return
+				}
+
+				atomic.AddInt32(&gp.busy, 1)
+				fn()
+				atomic.AddInt32(&gp.busy, -1)
+
+				if timer != nil {
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(gp.idleTimeout)
+				}
+
+			case <-idle:
+				if gp.retire() {
+					return
+				}
+				timer.Reset(gp.idleTimeout)
+			}
+		}
+	}()
+}
+
+// SingleThreaded returns true if the pool runs work inline rather than on goroutines.
+func (gp *GoroutinePool) SingleThreaded() bool {
+	return gp.singleThreaded
+}
+
+// QueueLength returns the number of work items waiting for a worker.
+func (gp *GoroutinePool) QueueLength() int {
+	return len(gp.queue)
+}
+
+// Workers returns the number of goroutines currently in the worker pool.
+func (gp *GoroutinePool) Workers() int {
+	return int(atomic.LoadInt32(&gp.workers))
+}
+
+// Busy returns the number of goroutines currently running work.
+func (gp *GoroutinePool) Busy() int {
+	return int(atomic.LoadInt32(&gp.busy))
+}
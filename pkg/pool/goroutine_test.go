@@ -17,6 +17,7 @@ package pool
 import (
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestWorkerPool(t *testing.T) {
@@ -43,3 +44,135 @@ func TestWorkerPool(t *testing.T) {
 		gp.Close()
 	}
 }
+
+func TestPoolStats(t *testing.T) {
+	gp := NewGoroutinePool(16, false)
+	gp.AddWorkers(2)
+
+	if gp.Workers() != 3 {
+		t.Errorf("Got %d workers, expecting 3", gp.Workers())
+	}
+
+	// block all the workers, then queue up some more work
+	block := make(chan bool)
+	started := &sync.WaitGroup{}
+	started.Add(3)
+	for i := 0; i < 3; i++ {
+		gp.ScheduleWork(func() {
+			started.Done()
+			<-block
+		})
+	}
+	started.Wait()
+
+	for i := 0; i < 5; i++ {
+		gp.ScheduleWork(func() {})
+	}
+
+	if gp.QueueLength() != 5 {
+		t.Errorf("Got queue length %d, expecting 5", gp.QueueLength())
+	}
+
+	close(block)
+	gp.Close()
+
+	if gp.Workers() != 0 {
+		t.Errorf("Got %d workers after Close, expecting 0", gp.Workers())
+	}
+}
+
+func TestTrySchedule(t *testing.T) {
+	gp := NewGoroutinePool(2, false)
+
+	// block the single worker, then fill up the queue
+	block := make(chan bool)
+	started := make(chan bool)
+	if !gp.TrySchedule(func() {
+		close(started)
+		<-block
+	}) {
+		t.Fatal("TrySchedule() failed on an empty pool")
+	}
+	<-started
+
+	for i := 0; i < 2; i++ {
+		if !gp.TrySchedule(func() {}) {
+			t.Errorf("TrySchedule() failed with %d queued items, expecting success", i)
+		}
+	}
+
+	if gp.TrySchedule(func() {}) {
+		t.Error("TrySchedule() succeeded on a full queue, expecting failure")
+	}
+
+	close(block)
+	gp.Close()
+
+	// single-threaded pools run the work inline
+	gp = NewGoroutinePool(0, true)
+	ran := false
+	if !gp.TrySchedule(func() { ran = true }) || !ran {
+		t.Error("TrySchedule() didn't run the work on a single-threaded pool")
+	}
+	gp.Close()
+}
+
+func TestAdaptivePool(t *testing.T) {
+	gp := NewAdaptiveGoroutinePool(16, 2, 4, 10*time.Millisecond, false)
+	if gp.Workers() != 2 {
+		t.Errorf("Got %d workers, expecting the minimum of 2", gp.Workers())
+	}
+
+	// keep more work busy than the pool's maximum
+	block := make(chan bool)
+	started := make(chan bool, 6)
+	for i := 0; i < 6; i++ {
+		gp.ScheduleWork(func() {
+			started <- true
+			<-block
+		})
+	}
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+
+	if gp.Workers() != 4 || gp.Busy() != 4 {
+		t.Errorf("Got %d workers, %d busy, expecting the maximum of 4", gp.Workers(), gp.Busy())
+	}
+	if gp.QueueLength() != 2 {
+		t.Errorf("Got queue length %d, expecting 2", gp.QueueLength())
+	}
+
+	// once idle, the pool shrinks back to its minimum
+	close(block)
+	waitFor(t, func() bool { return gp.Workers() == 2 })
+
+	// raising the minimum starts workers right away
+	gp.SetWorkerLimits(3, 4)
+	if gp.Workers() != 3 {
+		t.Errorf("Got %d workers, expecting the new minimum of 3", gp.Workers())
+	}
+
+	gp.Close()
+	if gp.Workers() != 0 {
+		t.Errorf("Got %d workers after Close, expecting 0", gp.Workers())
+	}
+
+	// limits don't apply to fixed pools
+	gp = NewGoroutinePool(16, false)
+	gp.SetWorkerLimits(3, 4)
+	if gp.Workers() != 1 {
+		t.Errorf("Got %d workers in a fixed pool, expecting 1", gp.Workers())
+	}
+	gp.Close()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Condition not met after 1s")
+}
@@ -16,6 +16,8 @@
 package status
 
 import (
+	"time"
+
 	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
 	rpc "github.com/googleapis/googleapis/google/rpc"
@@ -87,6 +89,33 @@ func InvalidWithDetails(msg string, pb proto.Message) rpc.Status {
 	return invalid
 }
 
+// ResourceExhaustedWithDetails builds a google.rpc.Status proto with the RESOURCE_EXHAUSTED
+// code, the provided message and the `details` field populated with the supplied proto message.
+func ResourceExhaustedWithDetails(msg string, pb proto.Message) rpc.Status {
+	exhausted := WithResourceExhausted(msg)
+	if any, err := types.MarshalAny(pb); err == nil {
+		exhausted.Details = []*types.Any{any}
+	}
+	return exhausted
+}
+
+// WithDetails returns a copy of status with the supplied proto message appended to its `details` field.
+// NOTE: if there is an issue marshaling the proto to a google.protobuf.Any, the status is returned as is.
+func WithDetails(status rpc.Status, pb proto.Message) rpc.Status {
+	if any, err := types.MarshalAny(pb); err == nil {
+		details := make([]*types.Any, len(status.Details), len(status.Details)+1)
+		copy(details, status.Details)
+		status.Details = append(details, any)
+	}
+	return status
+}
+
+// NewRetryInfo builds a google.rpc.RetryInfo proto telling clients how long to wait
+// before retrying a request.
+func NewRetryInfo(delay time.Duration) *rpc.RetryInfo {
+	return &rpc.RetryInfo{RetryDelay: types.DurationProto(delay)}
+}
+
 // NewBadRequest builds a google.rpc.BadRequest proto. BadRequest proto messages
 // can be used to populate the `details` field in a google.rpc.Status message.
 func NewBadRequest(field string, err error) *rpc.BadRequest {
@@ -17,8 +17,10 @@ package status
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
 	rpc "github.com/googleapis/googleapis/google/rpc"
 	"github.com/hashicorp/go-multierror"
 )
@@ -77,6 +79,27 @@ func TestStatus(t *testing.T) {
 	if s.Code != int32(rpc.INVALID_ARGUMENT) && s.Message != "Invalid" && len(s.Details) != 1 {
 		t.Errorf("Got %v, expected status with code = rpc.INVALID_ARGUMENT and populated details", s)
 	}
+
+	s = ResourceExhaustedWithDetails("Overloaded", NewRetryInfo(time.Second))
+	if s.Code != int32(rpc.RESOURCE_EXHAUSTED) || s.Message != "Overloaded" || len(s.Details) != 1 {
+		t.Errorf("Got %v, expected status with code = rpc.RESOURCE_EXHAUSTED and populated details", s)
+	}
+
+	s = WithDetails(s, NewBadRequest("test", errors.New("error")))
+	if s.Code != int32(rpc.RESOURCE_EXHAUSTED) || len(s.Details) != 2 {
+		t.Errorf("Got %v, expected status with code = rpc.RESOURCE_EXHAUSTED and two details", s)
+	}
+	var br rpc.BadRequest
+	if err := types.UnmarshalAny(s.Details[1], &br); err != nil || len(br.FieldViolations) != 1 {
+		t.Errorf("Got details %v (err %v), expected a BadRequest", s.Details[1], err)
+	}
+}
+
+func TestNewRetryInfo(t *testing.T) {
+	ri := NewRetryInfo(1500 * time.Millisecond)
+	if d, err := types.DurationFromProto(ri.RetryDelay); err != nil || d != 1500*time.Millisecond {
+		t.Errorf("Got retry delay %v (err %v), expecting 1.5s", d, err)
+	}
 }
 
 func TestNewBadRequest(t *testing.T) {
@@ -0,0 +1,259 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	bt "github.com/opentracing/basictracer-go"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/mixer/pkg/monitoring"
+)
+
+// Exporter is a span recorder that sends the sampled spans it records to a tracing backend.
+type Exporter interface {
+	bt.SpanRecorder
+
+	// Close flushes the spans recorded so far and stops the exporter.
+	io.Closer
+}
+
+// ExporterOptions controls how spans are batched and identified by exporters.
+type ExporterOptions struct {
+	// ServiceName identifies the mixer in the exported spans.
+	ServiceName string
+
+	// BatchSize is the max number of spans sent to the backend at once.
+	BatchSize int
+
+	// FlushInterval is the max time spans are buffered before being sent.
+	FlushInterval time.Duration
+
+	// QueueSize is the max number of spans waiting to be sent. Spans recorded
+	// while the queue is full are dropped.
+	QueueSize int
+}
+
+// DefaultExporterOptions returns the options used by the exporters unless overridden.
+func DefaultExporterOptions() ExporterOptions {
+	return ExporterOptions{
+		ServiceName:   "istio-mixer",
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		QueueSize:     10000,
+	}
+}
+
+var (
+	exportedSpans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "tracing",
+		Name:      "exported_spans_total",
+		Help:      "Number of spans sent to tracing backends, by exporter.",
+	}, []string{"exporter"})
+
+	droppedSpans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: monitoring.Namespace,
+		Subsystem: "tracing",
+		Name:      "dropped_spans_total",
+		Help:      "Number of spans that couldn't be sent to tracing backends, by exporter.",
+	}, []string{"exporter"})
+)
+
+func init() {
+	monitoring.MustRegister(exportedSpans, droppedSpans)
+}
+
+// batchExporter buffers sampled spans and hands them over in batches to a send function
+// running on a background goroutine, keeping backend latency off the request path.
+type batchExporter struct {
+	name          string
+	send          func([]bt.RawSpan) error
+	batchSize     int
+	flushInterval time.Duration
+
+	spans     chan bt.RawSpan
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newBatchExporter(name string, o ExporterOptions, send func([]bt.RawSpan) error) *batchExporter {
+	d := DefaultExporterOptions()
+	if o.BatchSize <= 0 {
+		o.BatchSize = d.BatchSize
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = d.FlushInterval
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = d.QueueSize
+	}
+
+	e := &batchExporter{
+		name:          name,
+		send:          send,
+		batchSize:     o.BatchSize,
+		flushInterval: o.FlushInterval,
+		spans:         make(chan bt.RawSpan, o.QueueSize),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// RecordSpan queues sampled spans for export, dropping them if the queue is full.
+func (e *batchExporter) RecordSpan(span bt.RawSpan) {
+	if !span.Context.Sampled {
+		return
+	}
+
+	select {
+	case e.spans <- span:
+	default:
+		droppedSpans.WithLabelValues(e.name).Inc()
+	}
+}
+
+// Close sends the queued spans and stops the background goroutine.
+func (e *batchExporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.stop)
+	})
+	<-e.done
+	return nil
+}
+
+func (e *batchExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]bt.RawSpan, 0, e.batchSize)
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				batch = e.flush(batch)
+			}
+		case <-ticker.C:
+			batch = e.flush(batch)
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.spans:
+					batch = append(batch, span)
+					if len(batch) >= e.batchSize {
+						batch = e.flush(batch)
+					}
+				default:
+					e.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush sends batch and returns it emptied, ready for reuse.
+func (e *batchExporter) flush(batch []bt.RawSpan) []bt.RawSpan {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := e.send(batch); err != nil {
+		glog.Warningf("Unable to export %d spans with the %s exporter: %v", len(batch), e.name, err)
+		droppedSpans.WithLabelValues(e.name).Add(float64(len(batch)))
+	} else {
+		exportedSpans.WithLabelValues(e.name).Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
+
+type multiRecorder []bt.SpanRecorder
+
+// MultiRecorder returns a recorder handing each span to all of the given recorders.
+func MultiRecorder(recorders ...bt.SpanRecorder) bt.SpanRecorder {
+	return multiRecorder(recorders)
+}
+
+func (m multiRecorder) RecordSpan(span bt.RawSpan) {
+	for _, r := range m {
+		r.RecordSpan(span)
+	}
+}
+
+// RateSampler returns a sampling function for basictracer that samples the given fraction of traces.
+// The decision only depends on the trace ID, so every mixer makes the same decision for a trace.
+func RateSampler(rate float64) func(traceID uint64) bool {
+	switch {
+	case rate >= 1:
+		return func(uint64) bool { return true }
+	case rate <= 0:
+		return func(uint64) bool { return false }
+	}
+
+	bound := uint64(rate * math.MaxUint64)
+	return func(traceID uint64) bool { return traceID < bound }
+}
+
+// NewRecordingTracer returns an enabled Tracer that samples the given fraction of new traces and hands
+// all spans to recorder. Tags and logs are discarded for the spans that aren't sampled.
+func NewRecordingTracer(recorder bt.SpanRecorder, sampleRate float64) Tracer {
+	opts := bt.DefaultOptions()
+	opts.Recorder = recorder
+	opts.ShouldSample = RateSampler(sampleRate)
+	opts.TrimUnsampledSpans = true
+	return NewTracer(bt.NewWithOptions(opts))
+}
+
+// tagValue converts a tag or log field value to a string, for backends only supporting string values.
+func tagValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// logMessage flattens the fields of a log record into a single annotation.
+func logMessage(fields []log.Field) string {
+	msg := ""
+	for i, f := range fields {
+		if i > 0 {
+			msg += " "
+		}
+		msg += f.Key() + "=" + tagValue(f.Value())
+	}
+	return msg
+}
+
+// spanKind returns the kind of span as set through ext.SpanKind, or "" if unset.
+func spanKind(tags ot.Tags) string {
+	if k, ok := tags[spanKindTag]; ok {
+		return tagValue(k)
+	}
+	return ""
+}
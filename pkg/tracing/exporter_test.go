@@ -0,0 +1,187 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	bt "github.com/opentracing/basictracer-go"
+)
+
+type sendRecorder struct {
+	sync.Mutex
+	batches [][]bt.RawSpan
+	err     error
+}
+
+func (s *sendRecorder) send(spans []bt.RawSpan) error {
+	s.Lock()
+	defer s.Unlock()
+	s.batches = append(s.batches, append([]bt.RawSpan(nil), spans...))
+	return s.err
+}
+
+func (s *sendRecorder) count() (batches int, spans int) {
+	s.Lock()
+	defer s.Unlock()
+	for _, b := range s.batches {
+		spans += len(b)
+	}
+	return len(s.batches), spans
+}
+
+func sampledSpan(op string) bt.RawSpan {
+	return bt.RawSpan{
+		Context:   bt.SpanContext{TraceID: 1, SpanID: 2, Sampled: true},
+		Operation: op,
+		Start:     time.Now(),
+		Duration:  time.Millisecond,
+	}
+}
+
+func TestBatchExporter(t *testing.T) {
+	sr := &sendRecorder{}
+	e := newBatchExporter("test", ExporterOptions{BatchSize: 2, FlushInterval: time.Hour}, sr.send)
+
+	e.RecordSpan(sampledSpan("a"))
+	e.RecordSpan(sampledSpan("b"))
+	e.RecordSpan(sampledSpan("c"))
+
+	unsampled := sampledSpan("unsampled")
+	unsampled.Context.Sampled = false
+	e.RecordSpan(unsampled)
+
+	if err := e.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+
+	// a full batch is sent right away, the rest on close
+	if batches, spans := sr.count(); batches != 2 || spans != 3 {
+		t.Errorf("Got %d batches with %d spans; wanted 2 batches with 3 spans", batches, spans)
+	}
+
+	// spans recorded after close are ignored
+	e.RecordSpan(sampledSpan("late"))
+	if err := e.Close(); err != nil {
+		t.Errorf("second Close() failed: %v", err)
+	}
+}
+
+func TestBatchExporter_FlushInterval(t *testing.T) {
+	sr := &sendRecorder{err: errors.New("unavailable")}
+	e := newBatchExporter("test", ExporterOptions{BatchSize: 100, FlushInterval: time.Millisecond}, sr.send)
+	defer func() { _ = e.Close() }()
+
+	e.RecordSpan(sampledSpan("a"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, spans := sr.count(); spans == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Span not sent after the flush interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchExporter_QueueFull(t *testing.T) {
+	block := make(chan struct{})
+	sent := 0
+	e := newBatchExporter("test", ExporterOptions{BatchSize: 1, FlushInterval: time.Hour, QueueSize: 1}, func(spans []bt.RawSpan) error {
+		<-block
+		sent += len(spans)
+		return nil
+	})
+
+	// one span being sent, one queued, the rest dropped without blocking
+	for i := 0; i < 10; i++ {
+		e.RecordSpan(sampledSpan("a"))
+	}
+	close(block)
+	_ = e.Close()
+
+	if sent < 1 || sent > 2 {
+		t.Errorf("Sent %d spans; wanted 1 or 2", sent)
+	}
+}
+
+func TestMultiRecorder(t *testing.T) {
+	r1 := bt.NewInMemoryRecorder()
+	r2 := bt.NewInMemoryRecorder()
+
+	MultiRecorder(r1, r2).RecordSpan(span)
+
+	if len(r1.GetSpans()) != 1 || len(r2.GetSpans()) != 1 {
+		t.Errorf("MultiRecorder recorded %d and %d spans; wanted 1 each", len(r1.GetSpans()), len(r2.GetSpans()))
+	}
+}
+
+func TestRateSampler(t *testing.T) {
+	cases := []struct {
+		rate    float64
+		traceID uint64
+		sampled bool
+	}{
+		{1, math.MaxUint64, true},
+		{2, 0, true},
+		{0, 0, false},
+		{-1, 0, false},
+		{0.5, 0, true},
+		{0.5, math.MaxUint64 / 4, true},
+		{0.5, math.MaxUint64 / 4 * 3, false},
+	}
+
+	for i, c := range cases {
+		if sampled := RateSampler(c.rate)(c.traceID); sampled != c.sampled {
+			t.Errorf("[%d] RateSampler(%v)(%d) = %v; wanted %v", i, c.rate, c.traceID, sampled, c.sampled)
+		}
+	}
+}
+
+func TestNewRecordingTracer(t *testing.T) {
+	cases := []struct {
+		rate    float64
+		sampled bool
+	}{
+		{1, true},
+		{0, false},
+	}
+
+	for i, c := range cases {
+		recorder := bt.NewInMemoryRecorder()
+		tracer := NewRecordingTracer(recorder, c.rate)
+
+		s := tracer.StartSpan("op")
+		s.SetTag("key", "value")
+		s.Finish()
+
+		spans := recorder.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("[%d] Got %d spans; wanted 1", i, len(spans))
+		}
+		if spans[0].Context.Sampled != c.sampled {
+			t.Errorf("[%d] Sampled = %v; wanted %v", i, spans[0].Context.Sampled, c.sampled)
+		}
+		if _, found := spans[0].Tags["key"]; found != c.sampled {
+			t.Errorf("[%d] Tags = %v; wanted tags only on sampled spans", i, spans[0].Tags)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/go-multierror"
+	bt "github.com/opentracing/basictracer-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// The Jaeger agent receives batches of spans over UDP as oneway Agent.emitBatch calls,
+// encoded with the Thrift binary protocol (by default on port 6832). The structures
+// below follow jaeger.thrift and agent.thrift from the jaeger-idl repository.
+
+// maxPacketSize is the largest UDP packet accepted by the Jaeger agent.
+const maxPacketSize = 65000
+
+// Thrift binary protocol constants.
+const (
+	thriftVersion1 = 0x80010000
+	thriftOneway   = 4
+
+	thriftStop   = 0
+	thriftBool   = 2
+	thriftDouble = 4
+	thriftI32    = 8
+	thriftI64    = 10
+	thriftString = 11
+	thriftStruct = 12
+	thriftList   = 15
+)
+
+// Jaeger tag value types.
+const (
+	jaegerString = 0
+	jaegerDouble = 1
+	jaegerBool   = 2
+	jaegerLong   = 3
+)
+
+// jaegerSampled is the span flag marking sampled spans.
+const jaegerSampled = 1
+
+type jaegerExporter struct {
+	*batchExporter
+
+	conn        net.Conn
+	serviceName string
+}
+
+// NewJaegerExporter returns an exporter sending spans to the Jaeger agent listening on agentAddr,
+// in the Jaeger Thrift format over UDP. The agent must accept the binary protocol, typically on port 6832.
+func NewJaegerExporter(agentAddr string, o ExporterOptions) (Exporter, error) {
+	if o.ServiceName == "" {
+		o.ServiceName = DefaultExporterOptions().ServiceName
+	}
+
+	conn, err := net.Dial("udp", agentAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach jaeger agent at %s: %v", agentAddr, err)
+	}
+
+	j := &jaegerExporter{
+		conn:        conn,
+		serviceName: o.ServiceName,
+	}
+	j.batchExporter = newBatchExporter("jaeger", o, j.send)
+	return j, nil
+}
+
+// Close flushes the pending spans and closes the connection to the agent.
+func (j *jaegerExporter) Close() error {
+	_ = j.batchExporter.Close()
+	return j.conn.Close()
+}
+
+// send splits spans into as many packets as needed to stay below the agent's packet size limit.
+func (j *jaegerExporter) send(spans []bt.RawSpan) error {
+	var result *multierror.Error
+
+	header := jaegerBatchHeader(j.serviceName)
+	var packet [][]byte
+	size := 0
+	for _, s := range spans {
+		encoded := encodeJaegerSpan(s)
+		if len(header)+len(encoded)+jaegerBatchTrailerSize > maxPacketSize {
+			glog.Warningf("Dropping span %s, too large to be sent to the jaeger agent (%d bytes)", s.Operation, len(encoded))
+			droppedSpans.WithLabelValues(j.name).Inc()
+			continue
+		}
+
+		if len(header)+size+len(encoded)+jaegerBatchTrailerSize > maxPacketSize {
+			if err := j.write(header, packet); err != nil {
+				result = multierror.Append(result, err)
+			}
+			packet, size = nil, 0
+		}
+		packet = append(packet, encoded)
+		size += len(encoded)
+	}
+
+	if len(packet) > 0 {
+		if err := j.write(header, packet); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func (j *jaegerExporter) write(header []byte, spans [][]byte) error {
+	var buf bytes.Buffer
+	buf.Write(header)
+	w := thriftWriter{&buf}
+	w.listBegin(thriftStruct, len(spans))
+	for _, s := range spans {
+		buf.Write(s)
+	}
+	w.stop() // Batch
+	w.stop() // emitBatch args
+
+	_, err := j.conn.Write(buf.Bytes())
+	return err
+}
+
+// jaegerBatchTrailerSize is the number of bytes written after the spans of a batch: the list header and two stops.
+const jaegerBatchTrailerSize = 1 + 4 + 2
+
+// jaegerBatchHeader encodes the start of an emitBatch call, up to the field holding the spans of the batch.
+func jaegerBatchHeader(serviceName string) []byte {
+	var buf bytes.Buffer
+	w := thriftWriter{&buf}
+
+	version := uint32(thriftVersion1 | thriftOneway)
+	w.i32(int32(version))
+	w.str("emitBatch")
+	w.i32(0) // seqid
+
+	w.fieldBegin(thriftStruct, 1) // emitBatch.batch
+	w.fieldBegin(thriftStruct, 1) // Batch.process
+	w.fieldBegin(thriftString, 1) // Process.serviceName
+	w.str(serviceName)
+	w.stop()
+	w.fieldBegin(thriftList, 2) // Batch.spans
+
+	return buf.Bytes()
+}
+
+func encodeJaegerSpan(s bt.RawSpan) []byte {
+	var buf bytes.Buffer
+	w := thriftWriter{&buf}
+
+	w.fieldBegin(thriftI64, 1) // traceIdLow
+	w.i64(int64(s.Context.TraceID))
+	w.fieldBegin(thriftI64, 2) // traceIdHigh
+	w.i64(0)
+	w.fieldBegin(thriftI64, 3) // spanId
+	w.i64(int64(s.Context.SpanID))
+	w.fieldBegin(thriftI64, 4) // parentSpanId
+	w.i64(int64(s.ParentSpanID))
+	w.fieldBegin(thriftString, 5) // operationName
+	w.str(s.Operation)
+	w.fieldBegin(thriftI32, 7) // flags
+	var flags int32
+	if s.Context.Sampled {
+		flags = jaegerSampled
+	}
+	w.i32(flags)
+	w.fieldBegin(thriftI64, 8) // startTime
+	w.i64(micros(s.Start))
+	w.fieldBegin(thriftI64, 9) // duration
+	w.i64(int64(s.Duration / time.Microsecond))
+
+	if len(s.Tags) > 0 {
+		w.fieldBegin(thriftList, 10) // tags
+		w.listBegin(thriftStruct, len(s.Tags))
+		for k, v := range s.Tags {
+			w.tag(k, v)
+		}
+	}
+
+	if len(s.Logs) > 0 {
+		w.fieldBegin(thriftList, 11) // logs
+		w.listBegin(thriftStruct, len(s.Logs))
+		for _, l := range s.Logs {
+			w.fieldBegin(thriftI64, 1) // timestamp
+			w.i64(micros(l.Timestamp))
+			w.fieldBegin(thriftList, 2) // fields
+			w.listBegin(thriftStruct, len(l.Fields))
+			for _, f := range l.Fields {
+				w.field(f)
+			}
+			w.stop()
+		}
+	}
+
+	w.stop()
+	return buf.Bytes()
+}
+
+// thriftWriter writes values with the Thrift binary protocol.
+type thriftWriter struct {
+	buf *bytes.Buffer
+}
+
+func (w thriftWriter) fieldBegin(typ byte, id int16) {
+	w.buf.WriteByte(typ)
+	w.i16(id)
+}
+
+func (w thriftWriter) listBegin(elemType byte, size int) {
+	w.buf.WriteByte(elemType)
+	w.i32(int32(size))
+}
+
+func (w thriftWriter) stop() {
+	w.buf.WriteByte(thriftStop)
+}
+
+func (w thriftWriter) i16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	w.buf.Write(b[:])
+}
+
+func (w thriftWriter) i32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	w.buf.Write(b[:])
+}
+
+func (w thriftWriter) i64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	w.buf.Write(b[:])
+}
+
+func (w thriftWriter) str(s string) {
+	w.i32(int32(len(s)))
+	w.buf.WriteString(s)
+}
+
+// tag writes a jaeger Tag struct, keeping the type of numeric and boolean values.
+func (w thriftWriter) tag(key string, value interface{}) {
+	w.fieldBegin(thriftString, 1) // key
+	w.str(key)
+
+	switch v := value.(type) {
+	case bool:
+		w.tagType(jaegerBool)
+		w.fieldBegin(thriftBool, 5)
+		if v {
+			w.buf.WriteByte(1)
+		} else {
+			w.buf.WriteByte(0)
+		}
+	case float32:
+		w.tagDouble(float64(v))
+	case float64:
+		w.tagDouble(v)
+	case int:
+		w.tagLong(int64(v))
+	case int8:
+		w.tagLong(int64(v))
+	case int16:
+		w.tagLong(int64(v))
+	case int32:
+		w.tagLong(int64(v))
+	case int64:
+		w.tagLong(v)
+	case uint8:
+		w.tagLong(int64(v))
+	case uint16:
+		w.tagLong(int64(v))
+	case uint32:
+		w.tagLong(int64(v))
+	default:
+		w.tagType(jaegerString)
+		w.fieldBegin(thriftString, 3)
+		w.str(tagValue(v))
+	}
+
+	w.stop()
+}
+
+func (w thriftWriter) field(f log.Field) {
+	w.tag(f.Key(), f.Value())
+}
+
+func (w thriftWriter) tagType(t int32) {
+	w.fieldBegin(thriftI32, 2) // vType
+	w.i32(t)
+}
+
+func (w thriftWriter) tagDouble(v float64) {
+	w.tagType(jaegerDouble)
+	w.fieldBegin(thriftDouble, 4)
+	w.i64(int64(math.Float64bits(v)))
+}
+
+func (w thriftWriter) tagLong(v int64) {
+	w.tagType(jaegerLong)
+	w.fieldBegin(thriftI64, 6)
+	w.i64(v)
+}
@@ -0,0 +1,256 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	bt "github.com/opentracing/basictracer-go"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// thriftReader decodes Thrift binary structs into maps keyed by field id, lists into slices and
+// scalars into their Go equivalent. It is just enough of a Thrift decoder to play a fake Jaeger agent.
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t thriftReader) i16() int16 {
+	var v int16
+	_ = binary.Read(t.r, binary.BigEndian, &v)
+	return v
+}
+
+func (t thriftReader) i32() int32 {
+	var v int32
+	_ = binary.Read(t.r, binary.BigEndian, &v)
+	return v
+}
+
+func (t thriftReader) i64() int64 {
+	var v int64
+	_ = binary.Read(t.r, binary.BigEndian, &v)
+	return v
+}
+
+func (t thriftReader) str() string {
+	b := make([]byte, t.i32())
+	_, _ = t.r.Read(b)
+	return string(b)
+}
+
+func (t thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftBool:
+		b, _ := t.r.ReadByte()
+		return b != 0
+	case thriftDouble:
+		return math.Float64frombits(uint64(t.i64()))
+	case thriftI32:
+		return t.i32()
+	case thriftI64:
+		return t.i64()
+	case thriftString:
+		return t.str()
+	case thriftStruct:
+		s := map[int16]interface{}{}
+		for {
+			ft, _ := t.r.ReadByte()
+			if ft == thriftStop {
+				return s
+			}
+			id := t.i16()
+			s[id] = t.value(ft)
+		}
+	case thriftList:
+		et, _ := t.r.ReadByte()
+		l := make([]interface{}, t.i32())
+		for i := range l {
+			l[i] = t.value(et)
+		}
+		return l
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", typ))
+}
+
+// fakeJaegerAgent receives emitBatch calls over UDP.
+type fakeJaegerAgent struct {
+	conn *net.UDPConn
+}
+
+func newFakeJaegerAgent(t *testing.T) *fakeJaegerAgent {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Unable to start fake jaeger agent: %v", err)
+	}
+	return &fakeJaegerAgent{conn}
+}
+
+// receive reads an emitBatch call and returns its batch.
+func (a *fakeJaegerAgent) receive(t *testing.T) map[int16]interface{} {
+	buf := make([]byte, maxPacketSize)
+	_ = a.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := a.conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Fake jaeger agent didn't receive a batch: %v", err)
+	}
+
+	r := thriftReader{bytes.NewReader(buf[:n])}
+	if v := uint32(r.i32()); v != thriftVersion1|thriftOneway {
+		t.Fatalf("Got message header %x; wanted a oneway call", v)
+	}
+	if name := r.str(); name != "emitBatch" {
+		t.Fatalf("Got call to %s; wanted emitBatch", name)
+	}
+	r.i32() // seqid
+
+	args := r.value(thriftStruct).(map[int16]interface{})
+	if r.r.Len() != 0 {
+		t.Errorf("%d trailing bytes after emitBatch args", r.r.Len())
+	}
+	return args[1].(map[int16]interface{})
+}
+
+func tags(list interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	if list == nil {
+		return result
+	}
+	for _, tag := range list.([]interface{}) {
+		tm := tag.(map[int16]interface{})
+		key := tm[1].(string)
+		switch tm[2].(int32) {
+		case jaegerString:
+			result[key] = tm[3]
+		case jaegerDouble:
+			result[key] = tm[4]
+		case jaegerBool:
+			result[key] = tm[5]
+		case jaegerLong:
+			result[key] = tm[6]
+		}
+	}
+	return result
+}
+
+func TestJaegerExporter(t *testing.T) {
+	agent := newFakeJaegerAgent(t)
+	defer func() { _ = agent.conn.Close() }()
+
+	e, err := NewJaegerExporter(agent.conn.LocalAddr().String(), ExporterOptions{ServiceName: "mixer-test"})
+	if err != nil {
+		t.Fatalf("NewJaegerExporter() failed: %v", err)
+	}
+	tracer := NewRecordingTracer(e, 1)
+
+	parent := tracer.StartSpan("parent")
+	child := tracer.StartSpan("child", ot.ChildOf(parent.Context()))
+	child.SetTag("adapter", "denyChecker")
+	child.SetTag("error", true)
+	child.SetTag("count", 3)
+	child.SetTag("ratio", 0.5)
+	child.LogFields(log.String("event", "denied"))
+	child.Finish()
+	parent.Finish()
+
+	if err = e.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	batch := agent.receive(t)
+
+	process := batch[1].(map[int16]interface{})
+	if process[1] != "mixer-test" {
+		t.Errorf("Got service name %v; wanted mixer-test", process[1])
+	}
+
+	spans := batch[2].([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("Got %d spans; wanted 2", len(spans))
+	}
+
+	c := spans[0].(map[int16]interface{})
+	p := spans[1].(map[int16]interface{})
+	if c[5] != "child" || p[5] != "parent" {
+		t.Fatalf("Got spans %v, %v; wanted child, parent", c[5], p[5])
+	}
+	if c[1] != p[1] || c[4] != p[3] || p[4] != int64(0) {
+		t.Errorf("Broken span hierarchy: parent %v, child %v", p, c)
+	}
+	if c[7] != int32(jaegerSampled) {
+		t.Errorf("Got flags %v; wanted sampled", c[7])
+	}
+	if c[8].(int64) <= 0 || c[9].(int64) < 0 {
+		t.Errorf("Got start time %v and duration %v", c[8], c[9])
+	}
+
+	want := map[string]interface{}{"adapter": "denyChecker", "error": true, "count": int64(3), "ratio": 0.5}
+	got := tags(c[10])
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Tag %s = %v (%T); wanted %v (%T)", k, got[k], got[k], v, v)
+		}
+	}
+
+	logs := c[11].([]interface{})
+	if len(logs) != 1 {
+		t.Fatalf("Got %d logs; wanted 1", len(logs))
+	}
+	if fields := tags(logs[0].(map[int16]interface{})[2]); fields["event"] != "denied" {
+		t.Errorf("Got log fields %v; wanted event=denied", fields)
+	}
+}
+
+func TestJaegerExporter_Split(t *testing.T) {
+	agent := newFakeJaegerAgent(t)
+	defer func() { _ = agent.conn.Close() }()
+
+	e, err := NewJaegerExporter(agent.conn.LocalAddr().String(), ExporterOptions{})
+	if err != nil {
+		t.Fatalf("NewJaegerExporter() failed: %v", err)
+	}
+	defer func() { _ = e.Close() }()
+
+	big := sampledSpan("big")
+	big.Tags = ot.Tags{"payload": strings.Repeat("x", maxPacketSize/3)}
+	huge := sampledSpan("huge")
+	huge.Tags = ot.Tags{"payload": strings.Repeat("x", maxPacketSize)}
+
+	// two big spans per packet, the huge one is dropped
+	if err = e.(*jaegerExporter).send([]bt.RawSpan{big, huge, big, big}); err != nil {
+		t.Fatalf("send() failed: %v", err)
+	}
+
+	if n := len(agent.receive(t)[2].([]interface{})); n != 2 {
+		t.Errorf("Got %d spans in the first packet; wanted 2", n)
+	}
+	if n := len(agent.receive(t)[2].([]interface{})); n != 1 {
+		t.Errorf("Got %d spans in the second packet; wanted 1", n)
+	}
+}
+
+func TestNewJaegerExporter_Error(t *testing.T) {
+	if _, err := NewJaegerExporter("not an address", ExporterOptions{}); err == nil {
+		t.Error("NewJaegerExporter() succeeded with an invalid address")
+	}
+}
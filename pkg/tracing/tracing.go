@@ -121,6 +121,20 @@ func (t *Tracer) StartSpanFromContext(ctx context.Context, operationName string,
 	return span, ot.ContextWithSpan(ctx, span)
 }
 
+// StartChildSpan starts a span that is a child of the current span in ctx and propagates it in the returned context.
+// The child is created by the tracer of its parent, so code that doesn't have access to the Tracer can still
+// contribute spans to a trace. A no-op span is returned when ctx carries no span.
+func StartChildSpan(ctx context.Context, operationName string, opts ...ot.StartSpanOption) (ot.Span, context.Context) {
+	parent := ot.SpanFromContext(ctx)
+	if parent == nil {
+		return noopSpan, ctx
+	}
+
+	opts = append(opts, ot.ChildOf(parent.Context()))
+	span := parent.Tracer().StartSpan(operationName, opts...)
+	return span, ot.ContextWithSpan(ctx, span)
+}
+
 // PropagateSpan inserts metadata about the span into the context's metadata so that the span is propagated to the receiver.
 // This should be used to prepare the context for outgoing calls.
 func (t *Tracer) PropagateSpan(ctx context.Context, span ot.Span) (metadata.MD, context.Context) {
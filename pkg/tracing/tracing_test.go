@@ -54,6 +54,32 @@ func TestStartSpanFromContext(t *testing.T) {
 	}
 }
 
+func TestStartChildSpan(t *testing.T) {
+	tracer := mocktracer.New()
+
+	span := tracer.StartSpan("parent")
+	ctx := ot.ContextWithSpan(context.Background(), span)
+
+	childSpan, ctx := StartChildSpan(ctx, "child")
+	childSpan.Finish()
+
+	mockSpan, _ := span.(*mocktracer.MockSpan)
+	mockChild, _ := childSpan.(*mocktracer.MockSpan)
+	if mockChild == nil || mockChild.ParentID != mockSpan.SpanContext.SpanID {
+		t.Fatalf("StartChildSpan(ctx) = %v; wanted a child of %v", childSpan, span)
+	}
+	if ot.SpanFromContext(ctx) != childSpan {
+		t.Errorf("StartChildSpan(ctx) didn't propagate the child span in the returned context")
+	}
+	if len(tracer.FinishedSpans()) != 1 {
+		t.Errorf("Child span not recorded by the parent's tracer, got %v", tracer.FinishedSpans())
+	}
+
+	if s, _ := StartChildSpan(context.Background(), "orphan"); s != noopSpan {
+		t.Errorf("StartChildSpan(context.Background()) = %v; wanted noop span", s)
+	}
+}
+
 func TestStartRootSpan(t *testing.T) {
 	tracer := NewTracer(mocktracer.New())
 	ctx := context.Background()
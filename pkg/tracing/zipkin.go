@@ -0,0 +1,140 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	bt "github.com/opentracing/basictracer-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+var spanKindTag = string(ext.SpanKind)
+
+// zipkinSpan is a span in the Zipkin v2 JSON format.
+type zipkinSpan struct {
+	TraceID       string             `json:"traceId"`
+	ID            string             `json:"id"`
+	ParentID      string             `json:"parentId,omitempty"`
+	Name          string             `json:"name"`
+	Kind          string             `json:"kind,omitempty"`
+	Timestamp     int64              `json:"timestamp"`
+	Duration      int64              `json:"duration"`
+	LocalEndpoint zipkinEndpoint     `json:"localEndpoint"`
+	Tags          map[string]string  `json:"tags,omitempty"`
+	Annotations   []zipkinAnnotation `json:"annotations,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+type zipkinExporter struct {
+	*batchExporter
+
+	url         string
+	serviceName string
+	client      *http.Client
+}
+
+// NewZipkinExporter returns an exporter posting spans in the Zipkin v2 JSON format to url,
+// typically http://<zipkin>:9411/api/v2/spans.
+func NewZipkinExporter(url string, o ExporterOptions) Exporter {
+	if o.ServiceName == "" {
+		o.ServiceName = DefaultExporterOptions().ServiceName
+	}
+
+	z := &zipkinExporter{
+		url:         url,
+		serviceName: o.ServiceName,
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+	z.batchExporter = newBatchExporter("zipkin", o, z.send)
+	return z
+}
+
+func (z *zipkinExporter) send(spans []bt.RawSpan) error {
+	zspans := make([]zipkinSpan, len(spans))
+	for i, s := range spans {
+		zspans[i] = toZipkin(s, z.serviceName)
+	}
+
+	body, err := json.Marshal(zspans)
+	if err != nil {
+		return err
+	}
+
+	resp, err := z.client.Post(z.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("zipkin collector at %s returned %s", z.url, resp.Status)
+	}
+	return nil
+}
+
+func toZipkin(s bt.RawSpan, serviceName string) zipkinSpan {
+	zs := zipkinSpan{
+		TraceID:       zipkinID(s.Context.TraceID),
+		ID:            zipkinID(s.Context.SpanID),
+		Name:          s.Operation,
+		Kind:          strings.ToUpper(spanKind(s.Tags)),
+		Timestamp:     micros(s.Start),
+		Duration:      int64(s.Duration / time.Microsecond),
+		LocalEndpoint: zipkinEndpoint{ServiceName: serviceName},
+	}
+	if s.ParentSpanID != 0 {
+		zs.ParentID = zipkinID(s.ParentSpanID)
+	}
+
+	for k, v := range s.Tags {
+		if k == spanKindTag {
+			continue
+		}
+		if zs.Tags == nil {
+			zs.Tags = make(map[string]string, len(s.Tags))
+		}
+		zs.Tags[k] = tagValue(v)
+	}
+
+	for _, l := range s.Logs {
+		zs.Annotations = append(zs.Annotations, zipkinAnnotation{Timestamp: micros(l.Timestamp), Value: logMessage(l.Fields)})
+	}
+	return zs
+}
+
+func zipkinID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+func micros(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bt "github.com/opentracing/basictracer-go"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// fakeZipkin is a fake Zipkin collector recording the spans posted to it.
+type fakeZipkin struct {
+	sync.Mutex
+	spans []zipkinSpan
+	code  int
+}
+
+func (f *fakeZipkin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.Method != "POST" || r.URL.Path != "/api/v2/spans" || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var spans []zipkinSpan
+	if err := json.NewDecoder(r.Body).Decode(&spans); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.spans = append(f.spans, spans...)
+
+	if f.code != 0 {
+		w.WriteHeader(f.code)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestZipkinExporter(t *testing.T) {
+	collector := &fakeZipkin{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	e := NewZipkinExporter(srv.URL+"/api/v2/spans", ExporterOptions{ServiceName: "mixer-test"})
+	tracer := NewRecordingTracer(e, 1)
+
+	parent := tracer.StartSpan("parent", ext.SpanKindRPCServer)
+	child := tracer.StartSpan("child", ot.ChildOf(parent.Context()))
+	child.SetTag("adapter", "denyChecker")
+	child.SetTag("error", true)
+	child.LogFields(log.String("event", "denied"))
+	child.Finish()
+	parent.Finish()
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	collector.Lock()
+	defer collector.Unlock()
+	if len(collector.spans) != 2 {
+		t.Fatalf("Collector got %d spans; wanted 2: %v", len(collector.spans), collector.spans)
+	}
+
+	c, p := collector.spans[0], collector.spans[1]
+	if p.Name != "parent" || c.Name != "child" {
+		t.Fatalf("Got spans %s, %s; wanted child, parent", c.Name, p.Name)
+	}
+	if c.TraceID != p.TraceID || c.ParentID != p.ID || p.ParentID != "" {
+		t.Errorf("Broken span hierarchy: parent %v, child %v", p, c)
+	}
+	if len(p.ID) != 16 || len(p.TraceID) != 16 {
+		t.Errorf("Got ids %s/%s; wanted 16 hex digits", p.TraceID, p.ID)
+	}
+	if p.Kind != "SERVER" || c.Kind != "" {
+		t.Errorf("Got kinds %s, %s; wanted SERVER and none", p.Kind, c.Kind)
+	}
+	if _, found := p.Tags[spanKindTag]; found {
+		t.Errorf("Span kind exported as a tag: %v", p.Tags)
+	}
+	if c.LocalEndpoint.ServiceName != "mixer-test" {
+		t.Errorf("Got service name %s; wanted mixer-test", c.LocalEndpoint.ServiceName)
+	}
+	if c.Tags["adapter"] != "denyChecker" || c.Tags["error"] != "true" {
+		t.Errorf("Got tags %v", c.Tags)
+	}
+	if len(c.Annotations) != 1 || c.Annotations[0].Value != "event=denied" {
+		t.Errorf("Got annotations %v; wanted event=denied", c.Annotations)
+	}
+	if c.Timestamp <= 0 || c.Timestamp < p.Timestamp {
+		t.Errorf("Got timestamps %d, %d", p.Timestamp, c.Timestamp)
+	}
+}
+
+func TestZipkinExporter_Errors(t *testing.T) {
+	collector := &fakeZipkin{code: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	cases := []struct {
+		url string
+		err string
+	}{
+		{srv.URL + "/api/v2/spans", "503"},
+		{"http://127.0.0.1:0/api/v2/spans", "127.0.0.1"},
+	}
+
+	for i, c := range cases {
+		z := NewZipkinExporter(c.url, ExporterOptions{}).(*zipkinExporter)
+		err := z.send([]bt.RawSpan{sampledSpan("a")})
+		_ = z.Close()
+
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("[%d] send() = %v; wanted error containing %s", i, err, c.err)
+		}
+	}
+}
+
+func TestToZipkin(t *testing.T) {
+	s := bt.RawSpan{
+		Context:      bt.SpanContext{TraceID: 0xabc, SpanID: 0x1},
+		ParentSpanID: 0,
+		Operation:    "op",
+		Start:        time.Unix(1, 500000),
+		Duration:     1500 * time.Microsecond,
+	}
+
+	z := toZipkin(s, "svc")
+	if z.TraceID != "0000000000000abc" || z.ID != "0000000000000001" || z.ParentID != "" {
+		t.Errorf("Got ids %s/%s/%s", z.TraceID, z.ID, z.ParentID)
+	}
+	if z.Timestamp != 1000500 || z.Duration != 1500 {
+		t.Errorf("Got timestamp %d and duration %d; wanted 1000500 and 1500", z.Timestamp, z.Duration)
+	}
+	if z.Tags != nil || z.Annotations != nil {
+		t.Errorf("Got tags %v and annotations %v; wanted none", z.Tags, z.Annotations)
+	}
+}